@@ -0,0 +1,198 @@
+package collector
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/wunderlist/hamustro/src/logging"
+	"io"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Supported access log formats
+const ACCESS_LOG_COMBINED = "combined"
+const ACCESS_LOG_JSON = "json"
+
+// A single request in the access log
+type AccessLogEntry struct {
+	Time         time.Time     `json:"time"`
+	RemoteIP     string        `json:"remote_ip"`
+	Method       string        `json:"method"`
+	Path         string        `json:"path"`
+	Protocol     string        `json:"protocol"`
+	Status       int           `json:"status"`
+	BytesIn      int64         `json:"bytes_in"`
+	BytesOut     int64         `json:"bytes_out"`
+	Latency      time.Duration `json:"-"`
+	ContentType  string        `json:"content_type"`
+	Referer      string        `json:"referer"`
+	UserAgent    string        `json:"user_agent"`
+	ClientId     string        `json:"client_id"`
+	PayloadCount int           `json:"payload_count"`
+}
+
+// Writes the requests into the access log, successful requests
+// are sampled (every Nth is written), the others are always written
+type AccessLogger struct {
+	sync.Mutex
+	Output io.Writer
+	Format string
+	Sample int
+	Logger *logging.Logger // Logs the failed writes
+	count  uint64
+}
+
+// Creates a new access logger
+func NewAccessLogger(output io.Writer, format string, sample int) *AccessLogger {
+	return &AccessLogger{Output: output, Format: format, Sample: sample, Logger: logging.New(nil, logging.FORMAT_TEXT, logging.INFO)}
+}
+
+// Checks the access log format
+func IsValidAccessLogFormat(format string) bool {
+	return format == ACCESS_LOG_COMBINED || format == ACCESS_LOG_JSON
+}
+
+// Should we write the entry into the access log
+func (a *AccessLogger) IsSampled(e *AccessLogEntry) bool {
+	if e.Status < 200 || e.Status >= 300 || a.Sample <= 1 {
+		return true
+	}
+	return atomic.AddUint64(&a.count, 1)%uint64(a.Sample) == 1
+}
+
+// Returns `-` for the empty values
+func accessLogValue(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
+
+// Formats a single access log entry
+func (a *AccessLogger) FormatEntry(e *AccessLogEntry) string {
+	switch a.Format {
+	case ACCESS_LOG_JSON:
+		b, err := json.Marshal(struct {
+			*AccessLogEntry
+			LatencyMs float64 `json:"latency_ms"`
+		}{e, e.Latency.Seconds() * 1000})
+		if err != nil {
+			return fmt.Sprintf(`{"error":%q}`, err.Error())
+		}
+		return string(b)
+	default:
+		return fmt.Sprintf("%s - - [%s] \"%s %s %s\" %d %d %q %q %d %.3f %q %q %d",
+			accessLogValue(e.RemoteIP),
+			e.Time.Format("02/Jan/2006:15:04:05 -0700"),
+			e.Method, e.Path, e.Protocol,
+			e.Status, e.BytesOut,
+			accessLogValue(e.Referer), accessLogValue(e.UserAgent),
+			e.BytesIn, e.Latency.Seconds()*1000,
+			accessLogValue(e.ContentType), accessLogValue(e.ClientId), e.PayloadCount)
+	}
+}
+
+// Writes the entry into the access log if it's sampled
+func (a *AccessLogger) Write(e *AccessLogEntry) {
+	if !a.IsSampled(e) {
+		return
+	}
+	line := a.FormatEntry(e) + "\n"
+	a.Lock()
+	defer a.Unlock()
+	if _, err := io.WriteString(a.Output, line); err != nil {
+		a.Logger.Component("http").Errorf("Writing the access log is failed: %s", err.Error())
+	}
+}
+
+// Wraps the handler and writes every request into the access log
+func (a *AccessLogger) Handler(h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		entry := &AccessLogEntry{
+			Time:        time.Now(),
+			RemoteIP:    GetRemoteAddress(r),
+			Method:      r.Method,
+			Path:        r.URL.Path,
+			Protocol:    r.Proto,
+			ContentType: r.Header.Get("Content-Type"),
+			Referer:     r.Referer(),
+			UserAgent:   r.UserAgent()}
+		if r.Body != nil {
+			r.Body = &accessLogBody{r.Body, entry}
+		}
+		rw := &AccessLogResponseWriter{ResponseWriter: w, Entry: entry}
+		h(rw, r)
+		if entry.Status == 0 {
+			entry.Status = http.StatusOK
+		}
+		entry.Latency = time.Since(entry.Time)
+		a.Write(entry)
+	}
+}
+
+// Wraps the handler with the access log if it's enabled
+func (c *Collector) WithAccessLog(h http.HandlerFunc) http.HandlerFunc {
+	if c.accessLogger == nil {
+		return h
+	}
+	return c.accessLogger.Handler(h)
+}
+
+// Counts the bytes read from the request's body
+type accessLogBody struct {
+	io.ReadCloser
+	Entry *AccessLogEntry
+}
+
+func (b *accessLogBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	b.Entry.BytesIn += int64(n)
+	return n, err
+}
+
+// Records the status and the written bytes of the response
+type AccessLogResponseWriter struct {
+	http.ResponseWriter
+	Entry *AccessLogEntry
+}
+
+func (w *AccessLogResponseWriter) WriteHeader(code int) {
+	if w.Entry.Status == 0 {
+		w.Entry.Status = code
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *AccessLogResponseWriter) Write(b []byte) (int, error) {
+	if w.Entry.Status == 0 {
+		w.Entry.Status = http.StatusOK
+	}
+	n, err := w.ResponseWriter.Write(b)
+	w.Entry.BytesOut += int64(n)
+	return n, err
+}
+
+// Returns the wrapped response writer
+func (w *AccessLogResponseWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// Adds the collection's details to the request's access log entry
+func AnnotateAccessLog(w http.ResponseWriter, clientId string, payloadCount int) {
+	for {
+		if rw, ok := w.(*AccessLogResponseWriter); ok {
+			rw.Entry.ClientId = clientId
+			rw.Entry.PayloadCount = payloadCount
+			return
+		}
+		wrapper, ok := w.(interface {
+			Unwrap() http.ResponseWriter
+		})
+		if !ok {
+			return
+		}
+		w = wrapper.Unwrap()
+	}
+}
@@ -0,0 +1,97 @@
+package collector
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Tests the access log's formats
+func TestFunctionAccessLogFormat(t *testing.T) {
+	entry := &AccessLogEntry{
+		Time:         time.Date(2016, 2, 5, 14, 5, 4, 0, time.UTC),
+		RemoteIP:     "10.0.0.1",
+		Method:       "POST",
+		Path:         "/api/v1/track",
+		Protocol:     "HTTP/1.1",
+		Status:       200,
+		BytesIn:      512,
+		Latency:      1500 * time.Microsecond,
+		ContentType:  "application/json",
+		UserAgent:    "hamustro-client",
+		ClientId:     "bce44f67",
+		PayloadCount: 3}
+
+	t.Log("Testing the combined format")
+	a := NewAccessLogger(nil, ACCESS_LOG_COMBINED, 1)
+	exp := `10.0.0.1 - - [05/Feb/2016:14:05:04 +0000] "POST /api/v1/track HTTP/1.1" 200 0 "-" "hamustro-client" 512 1.500 "application/json" "bce44f67" 3`
+	if line := a.FormatEntry(entry); line != exp {
+		t.Errorf("Expected combined entry was `%s` but it was `%s` instead", exp, line)
+	}
+
+	t.Log("Testing the json format")
+	a = NewAccessLogger(nil, ACCESS_LOG_JSON, 1)
+	var fields map[string]interface{}
+	if err := json.Unmarshal([]byte(a.FormatEntry(entry)), &fields); err != nil {
+		t.Errorf("JSON entry is invalid: %s", err.Error())
+	}
+	expFields := map[string]interface{}{"remote_ip": "10.0.0.1", "status": 200.0, "bytes_in": 512.0, "latency_ms": 1.5, "client_id": "bce44f67", "payload_count": 3.0}
+	for k, v := range expFields {
+		if fields[k] != v {
+			t.Errorf("Expected JSON field %s was %v but it was %v instead", k, v, fields[k])
+		}
+	}
+}
+
+// Tests the sampling of the successful requests
+func TestFunctionAccessLogSampling(t *testing.T) {
+	b := new(bytes.Buffer)
+	a := NewAccessLogger(b, ACCESS_LOG_COMBINED, 3)
+	for i := 0; i < 6; i++ {
+		a.Write(&AccessLogEntry{Status: http.StatusOK})
+	}
+	a.Write(&AccessLogEntry{Status: http.StatusBadRequest})
+	a.Write(&AccessLogEntry{Status: http.StatusServiceUnavailable})
+	if exp, n := 4, strings.Count(b.String(), "\n"); n != exp {
+		t.Errorf("Expected access log's length was %d but it was %d instead", exp, n)
+	}
+}
+
+// Tests the access log middleware
+func TestAccessLogHandler(t *testing.T) {
+	b := new(bytes.Buffer)
+	a := NewAccessLogger(b, ACCESS_LOG_JSON, 1)
+	handler := a.Handler(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		AnnotateAccessLog(w, "bce44f67", len(body))
+		w.WriteHeader(http.StatusAccepted)
+		w.Write([]byte("done"))
+	})
+
+	req, _ := http.NewRequest("POST", "/api/v1/track", strings.NewReader("payload"))
+	req.Header.Set("Content-Type", "application/protobuf")
+	resp := httptest.NewRecorder()
+	handler(resp, req)
+
+	var entry AccessLogEntry
+	if err := json.Unmarshal(b.Bytes(), &entry); err != nil {
+		t.Fatalf("Access log entry is invalid: %s", err.Error())
+	}
+	if exp := http.StatusAccepted; entry.Status != exp || resp.Code != exp {
+		t.Errorf("Expected status was %d but it was %d instead", exp, entry.Status)
+	}
+	if exp := int64(7); entry.BytesIn != exp {
+		t.Errorf("Expected bytes in was %d but it was %d instead", exp, entry.BytesIn)
+	}
+	if exp := int64(4); entry.BytesOut != exp {
+		t.Errorf("Expected bytes out was %d but it was %d instead", exp, entry.BytesOut)
+	}
+	if entry.ClientId != "bce44f67" || entry.PayloadCount != 7 || entry.ContentType != "application/protobuf" {
+		t.Errorf("Access log entry has unexpected values: %s", b.String())
+	}
+}
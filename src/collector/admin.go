@@ -0,0 +1,74 @@
+package collector
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+)
+
+// Controller for `/api/ingest`, sets the ingestion state
+// with the `state` parameter (accepting, paused or draining)
+func (c *Collector) IngestHandler(w http.ResponseWriter, r *http.Request) {
+	if !c.AuthorizeMaintenance(w, r, "ingest") {
+		return
+	}
+
+	state, ok := ParseIngestionState(r.FormValue("state"))
+	if !ok {
+		c.BroadcastError(w, r, fmt.Sprintf("Unknown `%s` ingestion state", r.FormValue("state")), http.StatusBadRequest)
+		return
+	}
+
+	for _, d := range c.GetDispatchers() {
+		switch state {
+		case INGESTION_ACCEPTING:
+			d.ResumeIngest()
+		case INGESTION_PAUSED:
+			d.PauseIngest()
+		case INGESTION_DRAINING:
+			d.Drain()
+		}
+	}
+	c.WriteHealth(w, r)
+}
+
+// Controller for `/api/upload`, sets the upload state
+// with the `state` parameter (active or paused)
+func (c *Collector) UploadHandler(w http.ResponseWriter, r *http.Request) {
+	if !c.AuthorizeMaintenance(w, r, "upload") {
+		return
+	}
+
+	state, ok := ParseUploadState(r.FormValue("state"))
+	if !ok {
+		c.BroadcastError(w, r, fmt.Sprintf("Unknown `%s` upload state", r.FormValue("state")), http.StatusBadRequest)
+		return
+	}
+
+	for _, d := range c.GetDispatchers() {
+		switch state {
+		case UPLOAD_ACTIVE:
+			d.ResumeUpload()
+		case UPLOAD_PAUSED:
+			d.PauseUpload()
+		}
+	}
+	c.WriteHealth(w, r)
+}
+
+// Controller for `/api/reload`, reloads the configuration file and
+// returns the changed properties (or the reason of the rejection)
+func (c *Collector) ReloadHandler(w http.ResponseWriter, r *http.Request) {
+	if !c.AuthorizeMaintenance(w, r, "reload") {
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	changed, err := c.ReloadFile(c.configFile)
+	if err != nil {
+		w.WriteHeader(http.StatusConflict)
+		json.NewEncoder(w).Encode(map[string]string{"error": err.Error()})
+		return
+	}
+	json.NewEncoder(w).Encode(map[string][]string{"changed": changed})
+}
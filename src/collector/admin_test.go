@@ -0,0 +1,133 @@
+package collector
+
+import (
+	"encoding/json"
+	"github.com/wunderlist/hamustro/src/dialects"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// Calls an admin handler and returns the response
+func CallAdminHandler(handler http.HandlerFunc, method string, url string, header map[string]string) *httptest.ResponseRecorder {
+	req, _ := http.NewRequest(method, url, nil)
+	for key, value := range header {
+		req.Header.Set(key, value)
+	}
+	resp := httptest.NewRecorder()
+	handler(resp, req)
+	return resp
+}
+
+// Tests the authentication and the parameters of the admin handlers
+func TestAdminHandlers(t *testing.T) {
+	config = &Config{MaintenanceKey: "maintancekey"}                  // Creates a config
+	storageClient = &BufferedStorageClient{}                          // Define the Buffered Storage as a storage
+	jobQueue = make(chan Job, 10)                                     // Creates a jobQueue
+	log.SetOutput(ioutil.Discard)                                     // Disable the logger
+	T, response, catched = t, nil, false                              // Set properties for the BufferedStorageClient
+	dispatcher := NewTestDispatcher(1, &WorkerOptions{BufferSize: 5}) // Creates a dispatcher
+	dispatcher.Start()                                                // Flush jobs stay in the jobQueue
+	defer dispatcher.Stop()
+	collector := dispatcher.GetCollector()
+
+	cases := []struct {
+		Handler           http.HandlerFunc
+		Method            string
+		URL               string
+		GetHeader         FlushHeaderFunction
+		ExpectedCode      int
+		ExpectedIngestion string
+		ExpectedUpload    string
+	}{
+		{collector.IngestHandler, "GET", "/api/ingest?state=paused", GetValidFlushHeader, http.StatusMethodNotAllowed, "accepting", "active"},
+		{collector.IngestHandler, "POST", "/api/ingest?state=paused", GetMissingFlushHeader, http.StatusMethodNotAllowed, "accepting", "active"},
+		{collector.IngestHandler, "POST", "/api/ingest?state=paused", GetFlushHeaderWithInvalidMaintenanceKey, http.StatusUnauthorized, "accepting", "active"},
+		{collector.IngestHandler, "POST", "/api/ingest?state=sleeping", GetValidFlushHeader, http.StatusBadRequest, "accepting", "active"},
+		{collector.IngestHandler, "POST", "/api/ingest?state=paused", GetValidFlushHeader, http.StatusOK, "paused", "active"},
+		{collector.IngestHandler, "POST", "/api/ingest?state=draining", GetValidFlushHeader, http.StatusOK, "draining", "active"},
+		{collector.IngestHandler, "POST", "/api/ingest?state=accepting", GetValidFlushHeader, http.StatusOK, "accepting", "active"},
+		{collector.UploadHandler, "POST", "/api/upload?state=draining", GetValidFlushHeader, http.StatusBadRequest, "accepting", "active"},
+		{collector.UploadHandler, "POST", "/api/upload?state=paused", GetValidFlushHeader, http.StatusOK, "accepting", "paused"},
+		{collector.UploadHandler, "POST", "/api/upload?state=active", GetValidFlushHeader, http.StatusOK, "accepting", "active"},
+	}
+
+	for i, c := range cases {
+		t.Logf("Working on %d. admin test case", i+1)
+		resp := CallAdminHandler(c.Handler, c.Method, c.URL, c.GetHeader(c.Method, c.URL))
+		if resp.Code != c.ExpectedCode {
+			t.Errorf("Non-expected status code %d with the following body `%s`, it should be %d", resp.Code, resp.Body, c.ExpectedCode)
+		}
+		if collector.GetIngestionStateName() != c.ExpectedIngestion {
+			t.Errorf("Expected ingestion state was %s but it was %s instead", c.ExpectedIngestion, collector.GetIngestionStateName())
+		}
+		if collector.GetUploadStateName() != c.ExpectedUpload {
+			t.Errorf("Expected upload state was %s but it was %s instead", c.ExpectedUpload, collector.GetUploadStateName())
+		}
+		if c.ExpectedCode == http.StatusOK {
+			var health Health
+			if err := json.Unmarshal(resp.Body.Bytes(), &health); err != nil {
+				t.Errorf("Response should be a valid health status: %s", err.Error())
+			}
+			if health.Ingestion != c.ExpectedIngestion || health.Upload != c.ExpectedUpload {
+				t.Errorf("Response should contain the current states but it was %s/%s instead", health.Ingestion, health.Upload)
+			}
+		}
+	}
+}
+
+// Tests that the track handler rejects events while the ingestion is not accepting
+func TestTrackHandlerPausedIngestion(t *testing.T) {
+	config = &Config{SharedSecret: "ultrasafesecret"}
+	collector := NewTestCollector()
+
+	for _, state := range []int32{INGESTION_PAUSED, INGESTION_DRAINING} {
+		collector.SetIngestionState(state)
+		req, _ := http.NewRequest("POST", "/api/v1/track", nil)
+		resp := httptest.NewRecorder()
+		collector.TrackHandler(resp, req)
+		if exp := http.StatusServiceUnavailable; resp.Code != exp {
+			t.Errorf("Expected status code was %d in %s state but it was %d instead", exp, collector.GetIngestionStateName(), resp.Code)
+		}
+	}
+}
+
+// Tests that the dispatcher keeps the events while the uploads are paused
+func TestDispatcherPausedUpload(t *testing.T) {
+	config = &Config{}                       // Define an empty config
+	storageClient = &BufferedStorageClient{} // Define the Buffered Storage as a storage
+	jobQueue = make(chan Job, 10)            // Define the job Queue
+	log.SetOutput(ioutil.Discard)            // Disable the logger
+	T, response, catched = t, nil, false     // Set properties
+
+	t.Log("Creates the dispatcher with a single worker and pause the uploads")
+	dispatcher := NewTestDispatcher(1, &WorkerOptions{BufferSize: 1})
+	dispatcher.Run()
+	dispatcher.PauseUpload()
+
+	t.Log("Send two jobs that would fill the buffer")
+	actions := []*EventAction{&EventAction{Event: GetTestEvent(5432), Attempt: 1}, &EventAction{Event: GetTestEvent(98765), Attempt: 1}}
+	buffer, _ := dialects.ConvertBatchJSON([]*dialects.Event{actions[0].Event, actions[1].Event})
+	exp = map[string]struct{}{buffer.String(): {}}
+	for _, action := range actions {
+		jobQueue <- action
+	}
+
+	t.Log("Flush the workers, nothing should be saved")
+	dispatcher.Flush(&FlushOptions{Automatic: false})
+	time.Sleep(150 * time.Millisecond)
+	if catched {
+		t.Errorf("Worker shouldn't save anything while the uploads are paused")
+	}
+	CheckResultsForBufferedStorage(dispatcher.Workers[0], 2, 1.0, 1)
+
+	t.Log("Resume the uploads and every buffered event should be saved")
+	dispatcher.ResumeUpload()
+	time.Sleep(250 * time.Millisecond)
+	ValidateSending()
+	CheckResultsForBufferedStorage(dispatcher.Workers[0], 0, 1.0, 1)
+	dispatcher.Stop()
+}
@@ -0,0 +1,49 @@
+package collector
+
+import (
+	"github.com/wunderlist/hamustro/src/dialects"
+	"hash/fnv"
+)
+
+// Define the worker affinities of the events
+const AFFINITY_NONE = "none"        // The first free worker gets the event
+const AFFINITY_SESSION = "session"  // The events of a session go to the same worker
+const AFFINITY_DEVICE = "device_id" // The events of a device go to the same worker
+
+// Number of events waiting for a single worker with affinity, the
+// events are given to the first free worker beyond it (so a hot
+// session or device doesn't starve the others of the worker)
+const AFFINITY_CHANNEL_SIZE = 64
+
+// Is the affinity supported
+func IsValidAffinity(affinity string) bool {
+	return affinity == AFFINITY_NONE || affinity == AFFINITY_SESSION || affinity == AFFINITY_DEVICE
+}
+
+// Returns the key of the event that selects its worker,
+// it's empty if the event has no such property
+func GetAffinityKey(event *dialects.Event, affinity string) string {
+	switch affinity {
+	case AFFINITY_SESSION:
+		return event.Session
+	case AFFINITY_DEVICE:
+		return event.DeviceID
+	}
+	return ""
+}
+
+// Returns the worker's index (between 0 and n-1) of the key with jump
+// consistent hashing, only 1/n of the keys are moved when the nth
+// worker is added or removed
+func GetAffinityWorker(key string, n int) int {
+	h := fnv.New64a()
+	h.Write([]byte(key))
+	hash := h.Sum64()
+	b, j := int64(-1), int64(0)
+	for j < int64(n) {
+		b = j
+		hash = hash*2862933555777941757 + 1
+		j = int64(float64(b+1) * (float64(int64(1)<<31) / float64((hash>>33)+1)))
+	}
+	return int(b)
+}
@@ -0,0 +1,127 @@
+package collector
+
+import (
+	"fmt"
+	"github.com/wunderlist/hamustro/src/tracing"
+	"io/ioutil"
+	"log"
+	"testing"
+)
+
+// Tests that the keys keep their workers when a worker is added
+func TestFunctionGetAffinityWorker(t *testing.T) {
+	counts := make([]int, 4)
+	moved := 0
+	for i := 0; i < 1000; i++ {
+		key := fmt.Sprintf("session-%d", i)
+		n := GetAffinityWorker(key, 4)
+		if n != GetAffinityWorker(key, 4) {
+			t.Fatalf("The same key should get the same worker")
+		}
+		counts[n]++
+		if m := GetAffinityWorker(key, 5); m != n {
+			if m != 4 {
+				t.Errorf("Key should be moved to the new worker only but it was moved from %d to %d", n, m)
+			}
+			moved++
+		}
+	}
+	for i, count := range counts {
+		if count < 150 || count > 350 {
+			t.Errorf("Expected number of keys of %d. worker was around 250 but it was %d instead", i, count)
+		}
+	}
+	if moved < 100 || moved > 300 {
+		t.Errorf("Expected number of moved keys was around 200 but it was %d instead", moved)
+	}
+}
+
+// Tests that the events of a session are sent to the same worker in
+// order, and the busy worker's events are given to the free workers
+func TestDispatcherSendByAffinity(t *testing.T) {
+	config = &Config{}
+	storageClient = &BufferedStorageClientWithoutExpected{}
+	jobQueue = make(chan Job, 10)
+	log.SetOutput(ioutil.Discard)
+
+	t.Log("Creates the dispatcher with 3 workers (not started) and session affinity")
+	dispatcher := NewTestDispatcher(3, &WorkerOptions{BufferSize: 100, Affinity: AFFINITY_SESSION})
+	for i := 0; i < 3; i++ {
+		dispatcher.Workers = append(dispatcher.Workers, NewWorker(i, dispatcher.WorkerOptions, dispatcher.WorkerPool))
+	}
+
+	t.Log("Sending the events of two sessions")
+	for i := 0; i < 10; i++ {
+		event := GetTestEvent(uint32(i))
+		event.Session, event.Nr = fmt.Sprintf("session-%d", i%2), uint32(i)
+		if !dispatcher.SendByAffinity(&EventAction{Event: event, Attempt: 1}) {
+			t.Errorf("Event of %s should be sent to its worker", event.Session)
+		}
+	}
+	for i := 0; i < 2; i++ {
+		worker := dispatcher.Workers[GetAffinityWorker(fmt.Sprintf("session-%d", i), 3)]
+		last := -1
+		for j := 0; j < len(worker.AffinityChannel); j++ {
+			action := <-worker.AffinityChannel
+			worker.AffinityChannel <- action
+			if event := action.(*EventAction).Event; event.Session == fmt.Sprintf("session-%d", i) {
+				if int(event.Nr) <= last {
+					t.Errorf("Events of session-%d should be in order but %d came after %d", i, event.Nr, last)
+				}
+				last = int(event.Nr)
+			}
+		}
+		if last == -1 {
+			t.Errorf("Events of session-%d are not sent to its worker", i)
+		}
+	}
+	if exp := 10; dispatcher.GetAffinityLength() != exp {
+		t.Errorf("Expected number of events waiting for the workers was %d but it was %d instead", exp, dispatcher.GetAffinityLength())
+	}
+
+	t.Log("Filling the worker of a hot session, the next event is rebalanced")
+	event := GetTestEvent(100)
+	event.Session = "hot"
+	worker := dispatcher.Workers[GetAffinityWorker("hot", 3)]
+	for len(worker.AffinityChannel) != cap(worker.AffinityChannel) {
+		worker.AffinityChannel <- &EventAction{Event: event, Attempt: 1}
+	}
+	if dispatcher.SendByAffinity(&EventAction{Event: event, Attempt: 1}) {
+		t.Errorf("Event should not be sent to the busy worker")
+	}
+	if exp := int64(1); dispatcher.WorkerOptions.Sink.Stats.Rebalanced != exp {
+		t.Errorf("Expected number of rebalanced events was %d but it was %d instead", exp, dispatcher.WorkerOptions.Sink.Stats.Rebalanced)
+	}
+
+	t.Log("Events without a session are sent to the first free worker")
+	event = GetTestEvent(101)
+	event.Session = ""
+	if dispatcher.SendByAffinity(&EventAction{Event: event, Attempt: 1}) {
+		t.Errorf("Event without a session should not have an affinity")
+	}
+}
+
+// Tests that the events waiting for the workers with affinity
+// are saved before the workers stop
+func TestSinkAffinityShutdown(t *testing.T) {
+	config = &Config{}
+	collector := NewTestCollector()
+	log.SetOutput(ioutil.Discard)
+	client := &CountingStorageClient{Buffered: true}
+	sink := collector.NewSinkWithClient(&SinkConfig{Name: "sessions", MaxWorkerSize: 3, MaxQueueSize: 100, BufferSize: 1000, WorkerAffinity: AFFINITY_DEVICE, Overflow: OVERFLOW_BLOCK}, client)
+	collector.sinks = []*Sink{sink}
+	sink.Dispatcher.Run()
+
+	for i := 0; i < 50; i++ {
+		event := GetTestEvent(uint32(1200 + i))
+		event.DeviceID = fmt.Sprintf("device-%d", i%7)
+		collector.PublishEvent(event, tracing.SpanContext{})
+	}
+	collector.StopSinks()
+	if exp := 50; client.GetSaved() != exp {
+		t.Errorf("Expected number of saved events was %d but it was %d instead", exp, client.GetSaved())
+	}
+	if unsaved := collector.ReportUnsaved(); unsaved != 0 {
+		t.Errorf("Expected number of unsaved events was 0 but it was %d instead", unsaved)
+	}
+}
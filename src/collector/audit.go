@@ -0,0 +1,25 @@
+package collector
+
+import (
+	"github.com/wunderlist/hamustro/src/logging"
+	"net/http"
+)
+
+// Writes a single audit entry about an admin action
+func (c *Collector) Audit(r *http.Request, keyName string, action string, result string) {
+	remoteAddress := GetRemoteAddress(r)
+	if keyName == "" {
+		keyName = "-"
+	}
+	if c.auditLogger == nil {
+		c.logger.Component("audit").With(logging.Fields{
+			"key":    keyName,
+			"remote": remoteAddress,
+			"action": action,
+			"method": r.Method,
+			"uri":    r.URL.RequestURI(),
+			"result": result}).Infof("Admin action %s", action)
+		return
+	}
+	c.auditLogger.Printf("key=%s remote=%s action=%s method=%s uri=%q result=%q", keyName, remoteAddress, action, r.Method, r.URL.RequestURI(), result)
+}
@@ -0,0 +1,581 @@
+package collector
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/wunderlist/hamustro/src/dialects"
+	"github.com/wunderlist/hamustro/src/logging"
+	"github.com/wunderlist/hamustro/src/retry"
+	"github.com/wunderlist/hamustro/src/tracing"
+	"github.com/wunderlist/hamustro/src/wal"
+	"sort"
+	"time"
+)
+
+// Buffered events taken from a worker to be uploaded
+type Batch struct {
+	Events     []*dialects.Event
+	WAL        []uint64 // Write-ahead log segments of the events
+	Bytes      int64
+	BufferedAt time.Time
+	TraceLinks []tracing.SpanContext
+	Message    *bytes.Buffer // Converted events (after a conversion)
+	Sequence   uint64        // Sequence of the batch within the worker, it's kept on retries
+	TakenAt    time.Time     // Time when the batch was taken first
+	Path       string        // Path of the partition instead of the sink's path (optional)
+}
+
+// Result of an asynchronous upload
+type UploadResult struct {
+	Batch *Batch
+	Err   error
+}
+
+// Acknowledges the batch's events in the write-ahead log
+func (b *Batch) Ack(log *wal.Log) {
+	if log == nil {
+		return
+	}
+	counts := map[uint64]int{}
+	for _, segment := range b.WAL {
+		counts[segment]++
+	}
+	for segment, n := range counts {
+		log.Ack(segment, n)
+	}
+}
+
+// Sorts the batch's events by event time, session and number, the
+// events with the same properties keep their arrival order
+func (b *Batch) Sort() {
+	sort.Stable(batchByEventTime{b})
+}
+
+// Orders the events of the batch with their write-ahead log segments
+type batchByEventTime struct {
+	*Batch
+}
+
+func (b batchByEventTime) Len() int {
+	return len(b.Events)
+}
+
+func (b batchByEventTime) Less(i, j int) bool {
+	x, y := b.Events[i], b.Events[j]
+	if x.At != y.At {
+		return x.At < y.At
+	}
+	if x.Session != y.Session {
+		return x.Session < y.Session
+	}
+	return x.Nr < y.Nr
+}
+
+func (b batchByEventTime) Swap(i, j int) {
+	b.Events[i], b.Events[j] = b.Events[j], b.Events[i]
+	if len(b.WAL) == len(b.Events) {
+		b.WAL[i], b.WAL[j] = b.WAL[j], b.WAL[i]
+	}
+}
+
+// Takes the buffered events as a batch and starts a new buffer,
+// the penalty is kept until the batch is saved. The batch takes over
+// the failed batches in the buffer with the time of the earliest one.
+func (w *Worker) TakeBatch() *Batch {
+	w.Sequence++
+	batch := &Batch{
+		Events:     w.BufferedEvents,
+		WAL:        w.BufferedWAL,
+		Bytes:      w.BufferedBytes,
+		BufferedAt: w.BufferedAt,
+		TraceLinks: w.TraceLinks,
+		Sequence:   w.Sequence,
+		TakenAt:    time.Now()}
+	failed := append(w.Restored, w.Released...)
+	for _, f := range failed {
+		if f.TakenAt.Before(batch.TakenAt) {
+			batch.TakenAt = f.TakenAt
+		}
+	}
+	w.GetStats().AddBuffered(-len(batch.Events))
+	w.BufferedEvents = []*dialects.Event{}
+	w.BufferedWAL = []uint64{}
+	w.BufferedBytes = 0
+	w.TraceLinks = nil
+	w.Restored, w.Released = nil, nil
+	w.GetManifest().Begin(batch)
+	for _, f := range failed {
+		w.GetManifest().End(f)
+	}
+	w.GetManifest().Release(w)
+	return batch
+}
+
+// Takes the last restored batch from the front of the buffer with
+// the same events and sequence, it's nil if there is no such batch
+func (w *Worker) TakeRestoredBatch() *Batch {
+	if len(w.Restored) == 0 {
+		return nil
+	}
+	batch := w.Restored[len(w.Restored)-1]
+	w.Restored = w.Restored[:len(w.Restored)-1]
+	n := len(batch.Events)
+	w.GetStats().AddBuffered(-n)
+	w.BufferedEvents = append([]*dialects.Event{}, w.BufferedEvents[n:]...)
+	w.BufferedWAL = append([]uint64{}, w.BufferedWAL[n:]...)
+	w.BufferedBytes -= batch.Bytes
+	if len(w.BufferedEvents) == 0 {
+		w.GetManifest().Release(w)
+	}
+	batch.Message = nil
+	return batch
+}
+
+// Puts the failed batch back before the buffered events, with the
+// deterministic names the batch is kept to be retried alone. The
+// batch is pending until it's saved or taken over by the next batch.
+func (w *Worker) RestoreBatch(batch *Batch) {
+	if len(batch.Events) == 0 {
+		w.GetManifest().End(batch)
+		return
+	}
+	if w.Sink.Deterministic {
+		w.Restored = append(w.Restored, batch)
+	} else {
+		w.Released = append(w.Released, batch)
+	}
+	if len(w.BufferedEvents) == 0 || batch.BufferedAt.Before(w.BufferedAt) {
+		w.BufferedAt = batch.BufferedAt
+		w.GetManifest().Hold(w, w.BufferedAt)
+	}
+	w.GetStats().AddBuffered(len(batch.Events))
+	w.BufferedEvents = append(batch.Events, w.BufferedEvents...)
+	w.BufferedWAL = append(batch.WAL, w.BufferedWAL...)
+	w.BufferedBytes += batch.Bytes
+	links := w.TraceLinks
+	w.TraceLinks = batch.TraceLinks
+	for _, link := range links {
+		w.AddTraceLink(link)
+	}
+}
+
+// Save Buffered messages, the restored batches are saved alone first
+// (and the others are kept while they fail)
+func (w *Worker) SaveBatch() error {
+	restored := len(w.Restored)
+	for n := restored; n != 0; n-- {
+		batch := w.TakeRestoredBatch()
+		if err := w.FinishBatch(batch, w.NewUpload().UploadBatch(batch)); err != nil || len(w.Restored) == n {
+			return err
+		}
+	}
+	if restored != 0 && len(w.BufferedEvents) == 0 {
+		return nil
+	}
+	batch := w.TakeBatch()
+	return w.FinishBatch(batch, w.NewUpload().UploadBatch(batch))
+}
+
+// Saves the buffered messages based on the worker's upload mode
+func (w *Worker) Upload() error {
+	if w.MaxInFlight > 0 {
+		w.SaveBatchAsync()
+		return nil
+	}
+	return w.SaveBatch()
+}
+
+// Hands the buffered messages to an uploader and starts a new buffer,
+// it waits for an in-flight batch if the worker reached its limit
+// and for a free uploader if the sink reached its limit
+func (w *Worker) SaveBatchAsync() {
+	for w.InFlight >= w.MaxInFlight {
+		w.WaitForUpload()
+	}
+	batch := w.TakeRestoredBatch()
+	if batch == nil {
+		batch = w.TakeBatch()
+	}
+	w.InFlight++
+	w.GetStats().AddUploading(1)
+	slots := w.GetUploadSlots()
+	if slots != nil {
+		slots <- struct{}{}
+	}
+	upload, uploads := w.NewUpload(), w.uploads
+	go func() {
+		err := upload.UploadBatch(batch)
+		if slots != nil {
+			<-slots
+		}
+		upload.Stats.AddUploading(-1)
+		uploads <- &UploadResult{batch, err}
+	}()
+}
+
+// Waits for a single in-flight batch and handles its result
+func (w *Worker) WaitForUpload() {
+	w.HandleUploadResult(<-w.uploads)
+}
+
+// Waits for every in-flight batch
+func (w *Worker) WaitForUploads() {
+	for w.InFlight != 0 {
+		w.WaitForUpload()
+	}
+}
+
+// Handles the result of an asynchronous upload
+func (w *Worker) HandleUploadResult(result *UploadResult) {
+	w.InFlight--
+	if err := w.FinishBatch(result.Batch, result.Err); err != nil {
+		w.GetLogger().Errorf("%s", err)
+	}
+}
+
+// Properties of the worker and its sink that the upload of a batch
+// uses, they are taken on the worker's goroutine so the upload never
+// reads the worker while it runs in parallel with it
+type Upload struct {
+	WorkerID      int
+	SinkName      string
+	Dialect       string
+	Client        dialects.StorageClient
+	PathTemplate  string
+	SortBatch     bool
+	BatchMetadata bool
+	Deterministic bool
+	InstanceID    string
+	Tracer        *tracing.Tracer
+	Breaker       *retry.Breaker
+	Stats         *SinkStats
+	Manifest      *Manifest
+	WAL           *wal.Log
+	DeadLetter    *DeadLetter
+	Logger        *logging.Logger
+}
+
+// Returns the properties of the worker's next upload
+func (w *Worker) NewUpload() *Upload {
+	collector := w.GetCollector()
+	upload := &Upload{
+		WorkerID:      w.ID,
+		SinkName:      w.Sink.Name,
+		Dialect:       w.Sink.Dialect,
+		Client:        w.GetStorageClient(),
+		PathTemplate:  w.Sink.PathTemplate,
+		SortBatch:     w.Sink.SortBatch,
+		BatchMetadata: w.Sink.BatchMetadata,
+		Deterministic: w.Sink.Deterministic,
+		Tracer:        collector.GetTracer(),
+		Breaker:       w.GetBreaker(),
+		Stats:         w.GetStats(),
+		Manifest:      w.GetManifest(),
+		WAL:           w.GetWAL(),
+		DeadLetter:    collector.GetDeadLetter(),
+		Logger:        w.GetLogger()}
+	if upload.Deterministic {
+		upload.InstanceID = collector.GetConfig().GetInstanceID()
+	}
+	return upload
+}
+
+// Converts and saves the batch, it only uses the upload's properties
+// so it can run in parallel with the worker. The worker's state is
+// changed by the result's handler.
+func (u *Upload) UploadBatch(batch *Batch) (err error) {
+	// Trace the batch and link it to the requests of the events
+	span := u.Tracer.Start("Worker.SaveBatch", tracing.SPAN_KIND_INTERNAL, tracing.SpanContext{})
+	span.SetAttribute("worker_id", u.WorkerID)
+	span.SetAttribute("batch_size", len(batch.Events))
+	for _, link := range batch.TraceLinks {
+		span.AddLink(link)
+	}
+	defer func() { span.FinishWithError(err) }()
+
+	// Sort the events by event time for the downstream loaders
+	if u.SortBatch {
+		batch.Sort()
+	}
+
+	// Save every partition of the path as a separate object
+	partitions := u.SplitBatch(batch)
+	if len(partitions) <= 1 {
+		return u.UploadPartition(batch, span)
+	}
+	span.SetAttribute("partitions", len(partitions))
+
+	// The saved partitions are acknowledged, only the events of the
+	// failed ones are kept in the batch so they are not saved twice
+	batch.Events, batch.WAL, batch.Bytes, batch.Message = []*dialects.Event{}, []uint64{}, 0, nil
+	for _, partition := range partitions {
+		perr := u.UploadPartition(partition, span)
+		if perr == nil {
+			partition.Ack(u.WAL)
+			continue
+		}
+		if err == nil {
+			err = perr
+		}
+		batch.Events = append(batch.Events, partition.Events...)
+		batch.WAL = append(batch.WAL, partition.WAL...)
+		batch.Bytes += partition.Bytes
+	}
+	if err != nil && len(batch.Events) != 0 {
+		// Convert the failed partitions together for the spool
+		if msg, cerr := u.Client.GetBatchConverter()(batch.Events); cerr == nil {
+			batch.Message = msg
+		}
+	}
+	return err
+}
+
+// Splits the batch by the event placeholders of the sink's (or the
+// storage's) path and by the events' own paths (e.g. late events),
+// the events keep their order within their partition. The batch of
+// a single partition is returned with the partition's path.
+func (u *Upload) SplitBatch(batch *Batch) []*Batch {
+	template := u.PathTemplate
+	if template == "" {
+		template = dialects.GetPathTemplate(u.Client)
+	}
+	routed := false
+	for _, event := range batch.Events {
+		routed = routed || event.Path != ""
+	}
+	if !routed && !dialects.HasEventPlaceholders(template) {
+		return []*Batch{batch}
+	}
+	partitions := []*Batch{}
+	index := map[string]*Batch{}
+	for i, event := range batch.Events {
+		path := template
+		if event.Path != "" {
+			path = event.Path
+		}
+		key := event.Path + "\n" + dialects.ResolveEventPath(path, event)
+		partition, ok := index[key]
+		if !ok {
+			partition = &Batch{BufferedAt: batch.BufferedAt, TraceLinks: batch.TraceLinks, Sequence: batch.Sequence, TakenAt: batch.TakenAt, Path: event.Path}
+			index[key] = partition
+			partitions = append(partitions, partition)
+		}
+		partition.Events = append(partition.Events, event)
+		partition.WAL = append(partition.WAL, batch.WAL[i])
+		partition.Bytes += int64(event.Size())
+	}
+	if len(partitions) == 1 {
+		batch.Path = partitions[0].Path
+		return []*Batch{batch}
+	}
+	return partitions
+}
+
+// Converts and saves the events of a single partition
+func (u *Upload) UploadPartition(batch *Batch, span *tracing.Span) (err error) {
+	// Convert messages to string
+	convert := span.Child("Worker.SaveBatch.convert", tracing.SPAN_KIND_INTERNAL)
+	msg, err := u.Client.GetBatchConverter()(batch.Events)
+	convert.FinishWithError(err)
+	if err != nil && u.IsolatePoisonEvents(batch) != 0 {
+		// Retry the batch without the events that can't be converted
+		if len(batch.Events) == 0 {
+			return nil
+		}
+		msg, err = u.Client.GetBatchConverter()(batch.Events)
+	}
+	if err != nil {
+		u.Stats.AddFailed(len(batch.Events), err)
+		return fmt.Errorf("(%d worker) Batch converting buffered messages is failed with %d records: %s", u.WorkerID, len(batch.Events), err.Error())
+	}
+	batch.Message = msg
+
+	// Do not call the storage while the circuit is open
+	if !u.Breaker.Allow() {
+		return retry.ErrOpen
+	}
+
+	// Save messages with the batch's properties
+	info := u.NewBatchInfo(batch, msg)
+	save := span.Child("StorageClient.Save", tracing.SPAN_KIND_INTERNAL)
+	err = dialects.SaveBatchWithSpan(u.Client, msg, info, save)
+	save.FinishWithError(err)
+	u.Breaker.Record(err)
+	if err != nil {
+		u.Stats.AddFailed(len(batch.Events), err)
+		return fmt.Errorf("(%d worker) Saving buffered messages is failed with %d records: %s", u.WorkerID, len(batch.Events), err.Error())
+	}
+	u.Stats.AddSaved(len(batch.Events))
+	u.Manifest.Add(info, batch.TakenAt)
+	return nil
+}
+
+// Returns the properties of the partition's object
+func (u *Upload) NewBatchInfo(batch *Batch, msg *bytes.Buffer) *dialects.BatchInfo {
+	info := dialects.NewBatchInfo(u.WorkerID, batch.Events)
+	info.Metadata = u.BatchMetadata
+	info.BasePath = u.PathTemplate
+	if batch.Path != "" {
+		info.BasePath = batch.Path
+	}
+	if u.Deterministic {
+		// Retries of the same batch are saved with the same name
+		info.Instance = u.InstanceID
+		info.Sequence = batch.Sequence
+		info.Hash = dialects.GetContentHash(msg)
+		info.CreatedAt = batch.TakenAt
+	}
+	return info
+}
+
+// Removes the events from the batch that can't be converted alone and
+// sends them to the dead-letter, returns the number of removed events
+func (u *Upload) IsolatePoisonEvents(batch *Batch) int {
+	converter := u.Client.GetBatchConverter()
+	events := make([]*dialects.Event, 0, len(batch.Events))
+	segments := make([]uint64, 0, len(batch.WAL))
+	removed := 0
+	for i, event := range batch.Events {
+		if _, err := converter([]*dialects.Event{event}); err != nil {
+			u.SendToDeadLetter(event, err)
+			u.WAL.Ack(batch.WAL[i], 1)
+			batch.Bytes -= int64(event.Size())
+			removed++
+			continue
+		}
+		events = append(events, event)
+		segments = append(segments, batch.WAL[i])
+	}
+	if removed != 0 {
+		u.Logger.Warnf("Isolated %d events from the batch that can't be converted", removed)
+		batch.Events = events
+		batch.WAL = segments
+	}
+	return removed
+}
+
+// Sends the event that can't be converted to the dead-letter
+func (u *Upload) SendToDeadLetter(event *dialects.Event, reason error) {
+	u.Stats.AddDeadLettered()
+	record := NewDeadLetterRecord(event, 1, time.Time{}, nil, reason)
+	record.Sink, record.Dialect = u.SinkName, u.Dialect
+	if !u.DeadLetter.Add(record) {
+		u.Logger.RateLimit("dropped").Warnf("Event is dropped: %s", reason.Error())
+	}
+}
+
+// Applies the result of the batch's upload: the saved batch is
+// acknowledged, the failed one is put back into the buffer with an
+// increased penalty (or spilled to the spool)
+func (w *Worker) FinishBatch(batch *Batch, err error) error {
+	if err == nil {
+		batch.Ack(w.GetWAL())
+		w.GetManifest().End(batch)
+		w.Penalty = 1.0
+		w.UpdateLastSave()
+		return nil
+	}
+	w.IncreasePenalty()
+
+	// Keep the batch (or spill it) while the circuit is open
+	if err == retry.ErrOpen {
+		w.GetLogger().RateLimit("circuit_open").Warnf("Saving buffered messages is postponed: %s", err.Error())
+		if w.GetSpool() != nil && w.SpillBatch(batch) == nil {
+			return nil
+		}
+		w.RestoreBatch(batch)
+		return nil
+	}
+
+	// Spill the failed batch beyond the memory ceiling
+	if w.IsOverMemoryLimit(batch.Bytes) {
+		if serr := w.SpillBatch(batch); serr != nil {
+			w.GetLogger().Errorf("Spilling buffered messages is failed: %s", serr.Error())
+		} else {
+			return err
+		}
+	}
+	w.RestoreBatch(batch)
+	return err
+}
+
+// Writes the failed batch into the sink's spool, a record with the
+// properties of its object per partition, the background uploader
+// saves them later so the batch can be released. The spilled
+// partitions are removed from the batch if a partition can't be
+// spilled, so only the remaining events are kept.
+func (w *Worker) SpillBatch(batch *Batch) error {
+	events, segments, size := []*dialects.Event{}, []uint64{}, int64(0)
+	var err error
+	for _, partition := range w.NewUpload().SplitBatch(batch) {
+		if err == nil {
+			if err = w.SpillPartition(partition); err == nil {
+				continue
+			}
+		}
+		events = append(events, partition.Events...)
+		segments = append(segments, partition.WAL...)
+		size += partition.Bytes
+	}
+	if err != nil {
+		batch.Events, batch.WAL, batch.Bytes, batch.Message = events, segments, size, nil
+		return err
+	}
+	w.GetManifest().End(batch)
+	w.Penalty = 1.0
+	return nil
+}
+
+// Writes a single partition into the sink's spool with the properties
+// of its object, the partition is converted again if it has no
+// converted events (e.g. the failed partitions of a batch)
+func (w *Worker) SpillPartition(partition *Batch) error {
+	msg := partition.Message
+	if msg == nil {
+		var err error
+		if msg, err = w.GetStorageClient().GetBatchConverter()(partition.Events); err != nil {
+			return err
+		}
+	}
+	// The spooled batch holds its window until it's uploaded
+	hold := w.GetManifest().NewHoldKey()
+	record, err := (&SpooledBatch{Info: w.NewUpload().NewBatchInfo(partition, msg), TakenAt: partition.TakenAt, Hold: hold, Message: msg.Bytes()}).Marshal()
+	if err != nil {
+		return err
+	}
+	w.GetManifest().Hold(hold, partition.TakenAt)
+	if err := w.GetSpool().Write(record); err != nil {
+		w.GetManifest().Release(hold)
+		return err
+	}
+	w.GetLogger().With(logging.Fields{"batch_size": len(partition.Events)}).Warnf("Spilled %d buffered messages (%d bytes) to the spool", len(partition.Events), msg.Len())
+	partition.Ack(w.GetWAL())
+	return nil
+}
+
+// Converts the buffered messages and writes them into the spool, the
+// restored batches are spilled alone first, so they keep their names
+func (w *Worker) SpillBuffer() error {
+	for batch := w.TakeRestoredBatch(); batch != nil; batch = w.TakeRestoredBatch() {
+		if err := w.SpillBatch(batch); err != nil {
+			w.RestoreBatch(batch)
+			return err
+		}
+	}
+	if len(w.BufferedEvents) == 0 {
+		return nil
+	}
+	batch := w.TakeBatch()
+	err := w.SpillBatch(batch)
+	if err != nil {
+		w.RestoreBatch(batch)
+	}
+	return err
+}
+
+// Is the memory usage of the buffer and the failed batch
+// over the worker's memory ceiling
+func (w *Worker) IsOverMemoryLimit(pending int64) bool {
+	limit := w.GetMemoryLimit()
+	return w.GetSpool() != nil && limit != 0 && w.BufferedBytes+pending >= limit
+}
@@ -0,0 +1,394 @@
+package collector
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"github.com/wunderlist/hamustro/src/dialects"
+	"github.com/wunderlist/hamustro/src/tracing"
+	"io/ioutil"
+	"log"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Tests that the worker keeps buffering while its batches are uploading
+// and the flush waits for the in-flight batches
+func TestAsyncBatchUpload(t *testing.T) {
+	config = &Config{}
+	collector := NewTestCollector()
+	log.SetOutput(ioutil.Discard)
+
+	client := &CountingStorageClient{Buffered: true, Block: make(chan struct{})}
+	sink := collector.NewSinkWithClient(&SinkConfig{Name: "archive", MaxWorkerSize: 1, MaxQueueSize: 10, BufferSize: 2, MaxInflightBatches: 2, MaxInflightUploads: 2, Overflow: OVERFLOW_BLOCK}, client)
+	collector.sinks = []*Sink{sink}
+	sink.Dispatcher.Run()
+
+	t.Log("Publishing two full batches and an extra event while the uploads are blocked")
+	for i := 0; i < 5; i++ {
+		collector.PublishEvent(GetTestEvent(uint32(600+i)), tracing.SpanContext{})
+	}
+	for i := 0; i < 100 && (len(sink.JobQueue) != 0 || sink.GetStatus().Uploading != 2); i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if exp := int64(2); sink.GetStatus().Uploading != exp {
+		t.Errorf("Expected number of in-flight batches was %d but it was %d instead", exp, sink.GetStatus().Uploading)
+	}
+	if len(sink.JobQueue) != 0 {
+		t.Errorf("Worker should take new events while the batches are uploading")
+	}
+
+	t.Log("Flushing the worker waits for the in-flight batches")
+	done := make(chan struct{})
+	sink.Dispatcher.Send(&FlushAction{TargetWorkerID: 0, Done: done})
+	close(client.Block)
+	<-done
+	if exp := 5; client.GetSaved() != exp {
+		t.Errorf("Expected number of saved events was %d but it was %d instead", exp, client.GetSaved())
+	}
+	collector.StopSinks()
+}
+
+// Tests that a failed asynchronous batch is put back into the buffer with penalty
+func TestAsyncBatchUploadFailure(t *testing.T) {
+	config = &Config{}
+	collector := NewTestCollector()
+	log.SetOutput(ioutil.Discard)
+
+	client := &CountingStorageClient{Buffered: true, Response: fmt.Errorf("ABS is not available")}
+	sink := collector.NewSinkWithClient(&SinkConfig{Name: "archive", MaxWorkerSize: 1, MaxQueueSize: 10, BufferSize: 2, MaxInflightBatches: 1, Overflow: OVERFLOW_BLOCK}, client)
+	collector.sinks = []*Sink{sink}
+	sink.Dispatcher.Run()
+
+	for i := 0; i < 2; i++ {
+		collector.PublishEvent(GetTestEvent(uint32(610+i)), tracing.SpanContext{})
+	}
+	for i := 0; i < 100 && sink.GetStatus().Failed != 2; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if exp := int64(2); sink.GetStatus().Failed != exp {
+		t.Errorf("Expected number of failed events was %d but it was %d instead", exp, sink.GetStatus().Failed)
+	}
+
+	t.Log("Flushing the restored batch after the storage recovered")
+	client.SetResponse(nil)
+	done := make(chan struct{})
+	sink.Dispatcher.Send(&FlushAction{TargetWorkerID: 0, Done: done})
+	<-done
+	if exp := 2; client.GetSaved() != exp {
+		t.Errorf("Expected number of saved events was %d but it was %d instead", exp, client.GetSaved())
+	}
+	if worker := sink.Dispatcher.Workers[0]; worker.Penalty != 1.0 {
+		t.Errorf("Penalty should be reset after the successful save but it was %f", worker.Penalty)
+	}
+	collector.StopSinks()
+}
+
+// Tests that the in-flight batch is uploaded with the properties taken
+// when it was handed over, while the worker and its sink are changed
+func TestAsyncBatchUploadSnapshot(t *testing.T) {
+	config = &Config{}
+	collector := NewTestCollector()
+	log.SetOutput(ioutil.Discard)
+	deadLetter, dir := GetTestDeadLetter(t)
+	collector.SetDeadLetter(deadLetter)
+	defer os.RemoveAll(dir)
+	deadLetter.Run(10 * time.Millisecond)
+
+	client := &PoisonStorageClient{CountingStorageClient{Buffered: true, Block: make(chan struct{})}}
+	sink := collector.NewSinkWithClient(&SinkConfig{Name: "archive", Dialect: "s3", MaxWorkerSize: 1, MaxQueueSize: 10, BufferSize: 3, Overflow: OVERFLOW_BLOCK}, client)
+	worker := NewTestWorker(0, &WorkerOptions{BufferSize: 3, MaxInFlight: 1, Sink: sink}, nil)
+
+	t.Log("Handing over a batch with a poison event while the upload is blocked")
+	poison := GetTestEvent(621)
+	poison.Event = "Poison"
+	for _, event := range []*dialects.Event{GetTestEvent(620), poison, GetTestEvent(622)} {
+		worker.AddEventToBuffer(event)
+	}
+	worker.SaveBatchAsync()
+
+	t.Log("Replacing the storage client and reconfiguring the worker during the upload")
+	replacement := &CountingStorageClient{Buffered: true}
+	sink.SetStorageClient(replacement)
+	worker.Reconfigure(&ReconfigureAction{BufferSize: 4, MaxInFlight: 1})
+	worker.AddEventToBuffer(GetTestEvent(623))
+	close(client.Block)
+	worker.WaitForUploads()
+
+	if exp := 2; client.GetSaved() != exp {
+		t.Errorf("Expected number of events saved by the previous client was %d but it was %d instead", exp, client.GetSaved())
+	}
+	if exp := 0; replacement.GetSaved() != exp {
+		t.Errorf("Expected number of events saved by the replacement client was %d but it was %d instead", exp, replacement.GetSaved())
+	}
+	if exp := int64(1); sink.GetStatus().DeadLettered != exp {
+		t.Errorf("Expected number of dead-lettered events was %d but it was %d instead", exp, sink.GetStatus().DeadLettered)
+	}
+	if exp := 1; len(worker.BufferedEvents) != exp || worker.InFlight != 0 {
+		t.Errorf("Expected number of buffered events was %d but it was %d instead (%d in-flight)", exp, len(worker.BufferedEvents), worker.InFlight)
+	}
+	deadLetter.Stop()
+}
+
+// Storage client that keeps the saved batches and their properties
+type BatchInfoStorageClient struct {
+	CountingStorageClient
+	Infos    []*dialects.BatchInfo
+	Messages []string
+}
+
+func (c *BatchInfoStorageClient) SaveBatch(msg *bytes.Buffer, info *dialects.BatchInfo, span *tracing.Span) error {
+	c.Lock()
+	c.Infos = append(c.Infos, info)
+	c.Messages = append(c.Messages, msg.String())
+	c.Unlock()
+	return c.Save(msg)
+}
+
+// Tests that the batch is sorted by event time, session and number and
+// it's saved with its properties
+func TestSortedBatchWithMetadata(t *testing.T) {
+	config = &Config{}
+	collector := NewTestCollector()
+	log.SetOutput(ioutil.Discard)
+
+	client := &BatchInfoStorageClient{CountingStorageClient: CountingStorageClient{Buffered: true}}
+	sink := collector.NewSinkWithClient(&SinkConfig{Name: "archive", MaxWorkerSize: 1, MaxQueueSize: 10, BufferSize: 4, SortBatch: true, BatchMetadata: true, Overflow: OVERFLOW_BLOCK}, client)
+	collector.sinks = []*Sink{sink}
+	sink.Dispatcher.Run()
+
+	t.Log("Publishing the events of two sessions out of order")
+	cases := []struct {
+		At      string
+		Session string
+		Nr      uint32
+	}{
+		{"2016-02-05 15:05:04", "b", 2},
+		{"2016-02-05 15:05:04", "a", 7},
+		{"2016-02-05 15:05:04", "b", 1},
+		{"2016-02-05 14:00:00", "b", 3}}
+	for _, c := range cases {
+		event := GetTestEvent(620)
+		event.At, event.Session, event.Nr = c.At, c.Session, c.Nr
+		collector.PublishEvent(event, tracing.SpanContext{})
+	}
+	collector.StopSinks()
+
+	if exp := 1; len(client.Infos) != exp {
+		t.Fatalf("Expected number of batches was %d but it was %d instead", exp, len(client.Infos))
+	}
+	if info := client.Infos[0]; info.MinAt != "2016-02-05 14:00:00" || info.MaxAt != "2016-02-05 15:05:04" || info.Events != 4 || info.WorkerID != 0 {
+		t.Errorf("Batch has unexpected properties: %+v", info)
+	}
+	var events []dialects.Event
+	for _, line := range strings.Split(strings.TrimSpace(client.Messages[0]), "\n") {
+		var event dialects.Event
+		json.Unmarshal([]byte(line), &event)
+		events = append(events, event)
+	}
+	for i, exp := range []string{"b/3", "a/7", "b/1", "b/2"} {
+		if got := fmt.Sprintf("%s/%d", events[i].Session, events[i].Nr); got != exp {
+			t.Errorf("Expected %d. event was %s but it was %s instead", i+1, exp, got)
+		}
+	}
+}
+
+// Storage client with a partitioned path that fails to save a partition
+type PartitionedStorageClient struct {
+	BatchInfoStorageClient
+	Path string
+	Fail string // Partition that can't be saved
+}
+
+func (c *PartitionedStorageClient) GetPathTemplate() string {
+	return c.Path
+}
+
+func (c *PartitionedStorageClient) SaveBatch(msg *bytes.Buffer, info *dialects.BatchInfo, span *tracing.Span) error {
+	c.Lock()
+	fail := c.Fail
+	c.Unlock()
+	if dialects.ResolveEventPath(c.Path, info.Partition) == fail {
+		return fmt.Errorf("%s is not available", fail)
+	}
+	return c.BatchInfoStorageClient.SaveBatch(msg, info, span)
+}
+
+// Tests that the batch is saved per partition and only the events of
+// the failed partition are kept in the buffer
+func TestPartitionedBatchUpload(t *testing.T) {
+	config = &Config{}
+	collector := NewTestCollector()
+	log.SetOutput(ioutil.Discard)
+
+	client := &PartitionedStorageClient{Path: "events/env={env}/dt={event_date}", Fail: "events/env=dev/dt=2016-02-05"}
+	client.Buffered = true
+	sink := collector.NewSinkWithClient(&SinkConfig{Name: "archive", MaxWorkerSize: 1, MaxQueueSize: 10, BufferSize: 6, Overflow: OVERFLOW_BLOCK}, client)
+	collector.sinks = []*Sink{sink}
+	sink.Dispatcher.Run()
+
+	t.Log("Publishing the events of three partitions while a partition is not available")
+	cases := []struct {
+		Env string
+		At  string
+	}{
+		{"production", "2016-02-05 15:05:04"},
+		{"dev", "2016-02-05 15:05:04"},
+		{"production", "2016-02-04 23:59:59"},
+		{"dev", "2016-02-05 00:00:00"},
+		{"production", "2016-02-05 10:00:00"},
+		{"", "2016-02-05 10:00:00"}}
+	for i, c := range cases {
+		event := GetTestEvent(uint32(630 + i))
+		event.Env, event.At = c.Env, c.At
+		collector.PublishEvent(event, tracing.SpanContext{})
+	}
+	for i := 0; i < 100 && sink.GetStatus().Failed != 2; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if exp := int64(2); sink.GetStatus().Failed != exp {
+		t.Errorf("Expected number of failed events was %d but it was %d instead", exp, sink.GetStatus().Failed)
+	}
+	if exp := 4; client.GetSaved() != exp {
+		t.Errorf("Expected number of saved events was %d but it was %d instead", exp, client.GetSaved())
+	}
+	if exp := int64(2); sink.GetStatus().Buffered != exp {
+		t.Errorf("Expected number of buffered events was %d but it was %d instead", exp, sink.GetStatus().Buffered)
+	}
+
+	t.Log("Flushing the failed partition after the storage recovered")
+	client.Lock()
+	client.Fail = ""
+	client.Unlock()
+	done := make(chan struct{})
+	sink.Dispatcher.Send(&FlushAction{TargetWorkerID: 0, Done: done})
+	<-done
+	collector.StopSinks()
+
+	if exp := 6; client.GetSaved() != exp {
+		t.Errorf("Expected number of saved events was %d but it was %d instead", exp, client.GetSaved())
+	}
+	saved := map[string]int{}
+	for _, info := range client.Infos {
+		saved[dialects.ResolveEventPath(client.Path, info.Partition)] += info.Events
+	}
+	expected := map[string]int{
+		"events/env=production/dt=2016-02-05": 2,
+		"events/env=production/dt=2016-02-04": 1,
+		"events/env=dev/dt=2016-02-05":        2,
+		"events/env=unknown/dt=2016-02-05":    1}
+	for partition, exp := range expected {
+		if saved[partition] != exp {
+			t.Errorf("Expected number of saved events in %s was %d but it was %d instead", partition, exp, saved[partition])
+		}
+	}
+	if exp := 4; len(client.Infos) != exp {
+		t.Errorf("Expected number of saved objects was %d but it was %d instead", exp, len(client.Infos))
+	}
+}
+
+// Tests that a failed batch is retried alone with the same name
+// and the new events are saved in the next batch
+func TestDeterministicBatchRetry(t *testing.T) {
+	config = &Config{InstanceID: "host/1"}
+	collector := NewTestCollector()
+	log.SetOutput(ioutil.Discard)
+
+	client := &BatchInfoStorageClient{CountingStorageClient: CountingStorageClient{Buffered: true, Response: fmt.Errorf("S3 timed out")}}
+	sink := collector.NewSinkWithClient(&SinkConfig{Name: "archive", MaxWorkerSize: 1, MaxQueueSize: 10, BufferSize: 2, DeterministicNaming: true, Overflow: OVERFLOW_BLOCK}, client)
+	collector.sinks = []*Sink{sink}
+	sink.Dispatcher.Run()
+
+	t.Log("Publishing a batch while the storage times out")
+	for i := 0; i < 2; i++ {
+		collector.PublishEvent(GetTestEvent(uint32(640+i)), tracing.SpanContext{})
+	}
+	for i := 0; i < 100 && sink.GetStatus().Failed != 2; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	t.Log("Publishing a new event after the storage recovered")
+	client.SetResponse(nil)
+	collector.PublishEvent(GetTestEvent(642), tracing.SpanContext{})
+	for i := 0; i < 100 && client.GetSaved() != 3; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	collector.StopSinks()
+
+	if exp := 3; len(client.Infos) != exp {
+		t.Fatalf("Expected number of save attempts was %d but it was %d instead", exp, len(client.Infos))
+	}
+	first, retry, next := client.Infos[0], client.Infos[1], client.Infos[2]
+	if dialects.GetBatchFileName("json", true, first) != dialects.GetBatchFileName("json", true, retry) {
+		t.Errorf("Retry of the batch should have the same name but it was %s and %s", dialects.GetBatchFileName("json", true, first), dialects.GetBatchFileName("json", true, retry))
+	}
+	if first.Instance != "host_1" || first.Sequence != 1 || retry.Events != 2 {
+		t.Errorf("Retried batch has unexpected properties: %+v", retry)
+	}
+	if next.Sequence != 2 || next.Events != 1 || next.Hash == first.Hash {
+		t.Errorf("Next batch has unexpected properties: %+v", next)
+	}
+}
+
+// Tests that the workers started by a resize and the priority lane's
+// worker never repeat the names of the earlier batches
+func TestDeterministicNamesAfterResize(t *testing.T) {
+	config = &Config{InstanceID: "host-1"}
+	collector := NewTestCollector()
+	log.SetOutput(ioutil.Discard)
+
+	client := &BatchInfoStorageClient{CountingStorageClient: CountingStorageClient{Buffered: true}}
+	sc := &SinkConfig{Name: "archive", MaxWorkerSize: 2, MaxQueueSize: 10, BufferSize: 1, DeterministicNaming: true, WorkerAffinity: AFFINITY_SESSION, PriorityEvents: []string{"Purchase.*"}, PriorityBufferSize: 1, Overflow: OVERFLOW_BLOCK}
+	sink := collector.NewSinkWithClient(sc, client)
+	collector.sinks = []*Sink{sink}
+	sink.Run()
+
+	// Sessions of the first and the second worker
+	sessions := []string{}
+	for i := 0; len(sessions) != 2; i++ {
+		if session := fmt.Sprintf("session-%d", i); GetAffinityWorker(session, 2) == len(sessions) {
+			sessions = append(sessions, session)
+		}
+	}
+	publish := func(saved int) {
+		for _, session := range sessions {
+			event := GetTestEvent(650)
+			event.Session = session
+			collector.PublishEvent(event, tracing.SpanContext{})
+		}
+		purchase := GetTestEvent(651)
+		purchase.Event = "Purchase.Completed"
+		collector.PublishEvent(purchase, tracing.SpanContext{})
+		for i := 0; i < 100 && client.GetSaved() != saved; i++ {
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+
+	t.Log("Saving the same events before and after the second worker is replaced")
+	publish(3)
+	resized := *sc
+	resized.MaxWorkerSize = 1
+	sink.Reconfigure(&resized, 0)
+	sink.Reconfigure(sc, 0)
+	publish(6)
+	collector.StopSinks()
+
+	if exp := 6; len(client.Infos) != exp {
+		t.Fatalf("Expected number of saved objects was %d but it was %d instead", exp, len(client.Infos))
+	}
+	names := map[string]bool{}
+	batches := map[string]bool{}
+	for _, info := range client.Infos {
+		name := dialects.GetBatchFileName("json", true, info)
+		batch := fmt.Sprintf("w%d-%d", info.WorkerID, info.Sequence)
+		if names[name] || batches[batch] {
+			t.Errorf("Batch of %d worker with %d sequence repeated an earlier name: %s", info.WorkerID, info.Sequence, name)
+		}
+		names[name], batches[batch] = true, true
+	}
+	if ids := []int{sink.Dispatcher.GetWorkers()[1].ID, sink.Priority.Dispatcher.GetWorkers()[0].ID}; ids[0] != 2 || ids[1] != PRIORITY_FIRST_WORKER_ID {
+		t.Errorf("Expected IDs of the new and the priority worker were %d and %d but they were %d and %d instead", 2, PRIORITY_FIRST_WORKER_ID, ids[0], ids[1])
+	}
+}
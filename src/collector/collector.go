@@ -0,0 +1,316 @@
+package collector
+
+import (
+	"context"
+	"fmt"
+	"github.com/wunderlist/hamustro/src/dialects"
+	"github.com/wunderlist/hamustro/src/logging"
+	"github.com/wunderlist/hamustro/src/payload"
+	"github.com/wunderlist/hamustro/src/tracing"
+	"github.com/wunderlist/hamustro/src/wal"
+	"log"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Changes the event before it's masked and published (e.g. adds
+// the tenant's ID or normalizes the values)
+type Enricher func(event *dialects.Event)
+
+// Options for collector creation
+type Options struct {
+	Config        *Config                // Application configuration (required)
+	ConfigFile    string                 // Configuration file used by the reload endpoint (optional)
+	StorageClient dialects.StorageClient // Saves every event into a single `default` sink instead of the configured dialects (optional)
+	WorkerOptions *WorkerOptions         // Worker limits of the storage client's sink (optional)
+	Signature     string                 // Overrides the configured signature policy, `required` or `optional` (optional)
+	Enrichers     []Enricher             // Applied in order on every tracked event (optional)
+	Logger        *logging.Logger        // Leveled logger, writes into the standard logger if not set
+	AccessLogger  *AccessLogger          // Access log of the HTTP requests (optional)
+	AuditLogger   *log.Logger            // Audit log of the admin actions, the leveled logger is used if not set
+	Tracer        *tracing.Tracer        // Exports the traces, it's created from the configuration if not set
+	Verbose       bool                   // Returns the error messages to the clients
+}
+
+// An event collector with its own sinks, workers and state, more
+// collectors can run in the same process
+type Collector struct {
+	config                *Config
+	configFile            string
+	signature             string       // Signature policy of the options, it's kept on reload
+	liveConfig            atomic.Value // The configuration of the last reload
+	reloadLock            sync.Mutex   // Only one reload runs at the same time
+	sinks                 []*Sink
+	router                *Router
+	eventTime             *EventTimePolicy
+	deadLetter            *DeadLetter
+	enrichers             []Enricher
+	logger                *logging.Logger
+	accessLogger          *AccessLogger
+	auditLogger           *log.Logger
+	tracer                *tracing.Tracer
+	verbose               bool
+	maintenanceSignatures *SignatureCache
+	ingestionState        int32
+	uploadState           int32
+	terminating           int32
+	signatureRequired     int32
+}
+
+// Creates a new collector with its sinks, dead-letter and routing
+// table, nothing is running until the collector is started
+func New(o *Options) (*Collector, error) {
+	if o.Config == nil {
+		return nil, fmt.Errorf("Config is missing")
+	}
+	config := *o.Config
+	if o.Signature != "" {
+		config.Signature = o.Signature
+	}
+	if config.SharedSecret == "" || (o.StorageClient == nil && !config.IsValid()) {
+		return nil, fmt.Errorf("Config is incomplete, please define `dialect` (or `sinks`) and `shared_secret` property")
+	}
+	if !config.IsValidMaintenanceKeys() {
+		return nil, fmt.Errorf("The `maintenance_key` and the `default` key of `maintenance_keys` can't be set at the same time")
+	}
+	if config.WALDir != "" && !wal.IsValidSync(config.GetWALSync()) {
+		return nil, fmt.Errorf("Not supported `%s` wal_sync policy (use `always`, `interval` or `never`)", config.GetWALSync())
+	}
+
+	c := &Collector{
+		config:                &config,
+		configFile:            o.ConfigFile,
+		signature:             o.Signature,
+		enrichers:             o.Enrichers,
+		logger:                o.Logger,
+		accessLogger:          o.AccessLogger,
+		auditLogger:           o.AuditLogger,
+		tracer:                o.Tracer,
+		verbose:               o.Verbose,
+		maintenanceSignatures: NewSignatureCache()}
+	if c.logger == nil {
+		c.logger = logging.New(nil, logging.FORMAT_TEXT, logging.INFO)
+	}
+	if c.accessLogger != nil {
+		c.accessLogger.Logger = c.logger
+	}
+	c.SetSignatureRequired(config.IsSignatureRequired())
+
+	// Export the traces to the OTLP/HTTP collector
+	if c.tracer == nil && config.TracingEndpoint != "" {
+		c.tracer = tracing.NewTracer(config.TracingEndpoint, config.GetTracingServiceName(), config.GetTracingBatchSize())
+	}
+	if c.tracer != nil && c.tracer.OnError == nil {
+		c.tracer.OnError = func(err error) {
+			c.logger.Component("tracing").RateLimit("export_failed").Warnf("%s", err.Error())
+		}
+	}
+
+	// Keeps the permanently failed events in the dead-letter storage
+	if dc := config.GetDeadLetter(); dc != nil {
+		deadLetter, err := NewDeadLetterFromConfig(dc)
+		if err != nil {
+			return nil, err
+		}
+		c.SetDeadLetter(deadLetter)
+	}
+
+	// Creates the sinks with their own clients and workers
+	if o.StorageClient != nil {
+		sc := config.GetDefaultSink()
+		if o.WorkerOptions != nil {
+			sc.BufferSize = o.WorkerOptions.BufferSize
+			sc.BatchMaxBytes = int(o.WorkerOptions.MaxBytes)
+			sc.BatchMaxAge = int(o.WorkerOptions.MaxAge / time.Second)
+			sc.MaxInflightBatches = o.WorkerOptions.MaxInFlight
+			sc.RetryAttempt = o.WorkerOptions.RetryAttempt
+			sc.SpreadBufferSize = o.WorkerOptions.SpreadBuffer
+		}
+		c.sinks = append(c.sinks, c.NewSinkWithClient(sc, o.StorageClient))
+	} else {
+		sinkConfigs, err := config.GetSinks()
+		if err != nil {
+			return nil, fmt.Errorf("Loading sink configuration is failed: %s", err.Error())
+		}
+		for _, sc := range sinkConfigs {
+			sink, err := c.NewSink(sc)
+			if err != nil {
+				return nil, err
+			}
+			c.sinks = append(c.sinks, sink)
+		}
+	}
+
+	// Compiles the routing table between the sinks
+	if len(config.Routes) != 0 || len(config.DefaultRoute) != 0 {
+		router, err := NewRouter(config.Routes, config.DefaultRoute, c.sinks)
+		if err != nil {
+			return nil, fmt.Errorf("Loading routing table is failed: %s", err.Error())
+		}
+		c.router = router
+	}
+
+	// Compiles the policy of the late and the future events
+	eventTime, err := NewEventTimePolicy(&config, c.sinks)
+	if err != nil {
+		return nil, fmt.Errorf("Loading event time policy is failed: %s", err.Error())
+	}
+	c.eventTime = eventTime
+	return c, nil
+}
+
+// Returns the collector's leveled logger
+func (c *Collector) GetLogger() *logging.Logger {
+	return c.logger
+}
+
+// Returns the collector's tracer, it's nil if the tracing is disabled
+func (c *Collector) GetTracer() *tracing.Tracer {
+	return c.tracer
+}
+
+// Returns the collector's dead-letter, it's nil if it's not configured
+func (c *Collector) GetDeadLetter() *DeadLetter {
+	return c.deadLetter
+}
+
+// Sets the dead-letter storage of the permanently failed events
+func (c *Collector) SetDeadLetter(d *DeadLetter) {
+	if d != nil {
+		d.Logger = c.logger
+	}
+	c.deadLetter = d
+}
+
+// Returns the collector's sinks
+func (c *Collector) GetSinks() []*Sink {
+	return c.sinks
+}
+
+// Opens the write-ahead logs and the spools, starts the workers and
+// the background writers, then replays the unsaved events
+func (c *Collector) Start() error {
+	config := c.GetConfig()
+	for _, sink := range c.sinks {
+		// Opens the write-ahead log and replays the unsaved events
+		var records []*wal.Record
+		if config.WALDir != "" {
+			var err error
+			if records, err = sink.OpenWAL(config.GetWALOptions(sink.Name)); err != nil {
+				return err
+			}
+			l, name := sink.GetLogger("dispatcher"), sink.Name
+			sink.WAL.Run(func(err error) {
+				l.RateLimit("wal_sync|"+name).Errorf("Syncing the write-ahead log is failed: %s", err.Error())
+			})
+		}
+		// Spills the failed batches beyond the memory ceiling to the disk
+		if config.SpoolDir != "" {
+			sp, err := config.NewSpool(sink.Name)
+			if err != nil {
+				return fmt.Errorf("Opening `%s` sink's spool is failed: %s", sink.Name, err.Error())
+			}
+			sink.OpenSpool(sp, int64(config.GetSpoolMemoryLimit())*1024*1024, time.Duration(config.GetSpoolRetryInterval())*time.Second)
+		}
+		sink.Run()
+		if len(records) != 0 {
+			c.logger.Infof("Replaying %d unsaved events into `%s` sink", sink.Replay(records), sink.Name)
+		}
+	}
+	if c.deadLetter != nil {
+		c.deadLetter.Run(time.Second)
+	}
+	if c.tracer != nil {
+		c.tracer.Run(5 * time.Second)
+		c.logger.Infof("Exporting traces to %s", c.tracer.Endpoint)
+	}
+	return nil
+}
+
+// Returns the HTTP handler of the tracking, health and maintenance
+// endpoints (under `/api/`) wrapped with the access log
+func (c *Collector) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/v1/track", c.WithAccessLog(c.TrackHandler))
+	mux.HandleFunc("/api/health", c.WithAccessLog(c.HealthHandler))
+	mux.HandleFunc("/api/stats", c.WithAccessLog(c.StatsHandler))
+	mux.HandleFunc("/api/flush", c.WithAccessLog(c.FlushHandler))
+	mux.HandleFunc("/api/ingest", c.WithAccessLog(c.IngestHandler))
+	mux.HandleFunc("/api/upload", c.WithAccessLog(c.UploadHandler))
+	mux.HandleFunc("/api/reload", c.WithAccessLog(c.ReloadHandler))
+	return mux
+}
+
+// Creates the events of the collection, the enrichers run before the
+// IP address is masked. The remote address is used for the events
+// without an IP address.
+func (c *Collector) NewEvents(collection *payload.Collection, remoteAddress string) []*dialects.Event {
+	events := []*dialects.Event{}
+	for _, payload := range collection.GetPayloads() {
+		event := dialects.NewEvent(collection, payload)
+		if event.IP == "" && remoteAddress != "" {
+			event.SetIPAddress(remoteAddress)
+		}
+		for _, enrich := range c.enrichers {
+			enrich(event)
+		}
+		if c.GetConfig().IsMaskedIP() {
+			event.TruncateIPv4LastOctet()
+		}
+		events = append(events, event)
+	}
+	return events
+}
+
+// Publishes the collection's events like the tracking endpoint without
+// the signature check (e.g. for the embedding applications)
+func (c *Collector) Track(collection *payload.Collection) error {
+	if c.IsTerminating() {
+		return fmt.Errorf("Server is currenly shutting down")
+	}
+	if !c.IsAcceptingEvents() {
+		return fmt.Errorf("Server is not accepting new events (%s)", c.GetIngestionStateName())
+	}
+	if GetSession(collection) != collection.GetSession() {
+		return fmt.Errorf("Collection's session attribute is invalid")
+	}
+	if !collection.HasPayloads() {
+		return nil
+	}
+	return c.PublishEvents(c.NewEvents(collection, ""), tracing.SpanContext{})
+}
+
+// Stops the collector: the new events are refused, the queued events
+// and the retries are saved, then the dead-letter and the spans are
+// written. It returns an error if the context is done earlier or some
+// events could not be saved.
+func (c *Collector) Shutdown(ctx context.Context) error {
+	c.SetTerminating(true)
+	done := make(chan struct{})
+	go func() {
+		// Deliver the queued events and the retries, then stop every worker
+		c.StopSinks()
+
+		// Write the remaining failed events
+		c.deadLetter.Stop()
+
+		// Export the remaining spans
+		if err := c.tracer.Shutdown(); err != nil {
+			c.logger.Component("tracing").Errorf("%s", err.Error())
+		}
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-ctx.Done():
+		c.ReportUnsaved()
+		return ctx.Err()
+	}
+	if unsaved := c.ReportUnsaved(); unsaved != 0 {
+		return fmt.Errorf("%d events are not saved", unsaved)
+	}
+	return nil
+}
@@ -0,0 +1,153 @@
+package collector
+
+import (
+	"context"
+	"fmt"
+	"github.com/wunderlist/hamustro/src/dialects"
+	"github.com/wunderlist/hamustro/src/logging"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// Global variables of the collector for testing (hacky)
+var config *Config
+var storageClient dialects.StorageClient
+var jobQueue chan Job
+
+// Creates a collector for the testing config without any sink
+func NewTestCollector() *Collector {
+	c := config
+	if c == nil {
+		c = &Config{}
+	}
+	return &Collector{
+		config:                c,
+		logger:                logging.New(nil, logging.FORMAT_TEXT, logging.INFO),
+		maintenanceSignatures: NewSignatureCache()}
+}
+
+// Creates a sink within the collector for the testing storage client
+// and job queue, it has no retry queue and circuit breaker
+func NewTestSink(c *Collector) *Sink {
+	s := &Sink{
+		Name:      "test",
+		Overflow:  OVERFLOW_BLOCK,
+		JobQueue:  jobQueue,
+		Stats:     &SinkStats{},
+		Backoff:   c.GetConfig().GetRetryBackoff(),
+		Collector: c,
+		client:    storageClient}
+	c.sinks = append(c.sinks, s)
+	return s
+}
+
+// Creates a worker for the testing sink if the options have no sink
+func NewTestWorker(id int, options *WorkerOptions, workerPool chan *Worker) *Worker {
+	if options.Sink == nil {
+		options.Sink = NewTestSink(NewTestCollector())
+	}
+	return NewWorker(id, options, workerPool)
+}
+
+// Creates a dispatcher for the testing sink if the options have no sink
+func NewTestDispatcher(maxWorkers int, options *WorkerOptions) *Dispatcher {
+	if options.Sink == nil {
+		options.Sink = NewTestSink(NewTestCollector())
+	}
+	options.Sink.Dispatcher = NewDispatcher(maxWorkers, options)
+	return options.Sink.Dispatcher
+}
+
+// Tests that more collectors run in the same process with their own
+// storage, state and configuration
+func TestCollectorsRunSideBySide(t *testing.T) {
+	log.SetOutput(ioutil.Discard)
+
+	t.Log("Creating two collectors with their own storage client")
+	clients := []*CountingStorageClient{&CountingStorageClient{}, &CountingStorageClient{}}
+	collectors := []*Collector{}
+	for i, client := range clients {
+		tenant := fmt.Sprintf("tenant-%d", i)
+		c, err := New(&Options{
+			Config:        &Config{SharedSecret: "ultrasafesecret", MaxWorkerSize: 1, RetryAttempt: 1},
+			StorageClient: client,
+			Enrichers:     []Enricher{func(e *dialects.Event) { e.UserID = tenant }}})
+		if err != nil {
+			t.Fatalf("Creating the collector is failed: %s", err.Error())
+		}
+		if err := c.Start(); err != nil {
+			t.Fatalf("Starting the collector is failed: %s", err.Error())
+		}
+		collectors = append(collectors, c)
+	}
+
+	t.Log("Pausing the ingestion of the first collector only")
+	collectors[0].SetIngestionState(INGESTION_PAUSED)
+	if err := collectors[0].Track(GetTestPayloadCollection(97421193, 1)); err == nil {
+		t.Errorf("Paused collector should not accept new events")
+	}
+	collectors[0].SetIngestionState(INGESTION_ACCEPTING)
+
+	t.Log("Tracking events through the programmatic interface and the handler")
+	if err := collectors[0].Track(GetTestPayloadCollection(97421193, 3)); err != nil {
+		t.Fatalf("Tracking is failed: %s", err.Error())
+	}
+	server := httptest.NewServer(collectors[1].Handler())
+	defer server.Close()
+	resp, err := http.Get(server.URL + "/api/health")
+	if err != nil || resp.StatusCode != http.StatusOK {
+		t.Fatalf("Health endpoint of the second collector is not available")
+	}
+	if err := collectors[1].Track(GetTestPayloadCollection(97421193, 2)); err != nil {
+		t.Fatalf("Tracking is failed: %s", err.Error())
+	}
+
+	t.Log("Shutting down the collectors, every event is saved by its own client")
+	for i, c := range collectors {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		if err := c.Shutdown(ctx); err != nil {
+			t.Errorf("Shutting down the collector is failed: %s", err.Error())
+		}
+		cancel()
+		if exp := 3 - i; clients[i].GetSaved() != exp {
+			t.Errorf("Expected number of saved events was %d but it was %d instead", exp, clients[i].GetSaved())
+		}
+	}
+
+	t.Log("Stopped collector should not accept new events")
+	if err := collectors[0].Track(GetTestPayloadCollection(97421193, 1)); err == nil {
+		t.Errorf("Stopped collector should not accept new events")
+	}
+}
+
+// Tests that the enrichers run before the IP address is masked
+func TestCollectorNewEvents(t *testing.T) {
+	c, err := New(&Options{
+		Config:        &Config{SharedSecret: "ultrasafesecret", MaskedIP: true},
+		StorageClient: &CountingStorageClient{},
+		Enrichers:     []Enricher{func(e *dialects.Event) { e.Parameters = e.IP }}})
+	if err != nil {
+		t.Fatalf("Creating the collector is failed: %s", err.Error())
+	}
+	collection := GetTestPayloadCollection(97421193, 2)
+	collection.Payloads[1].Ip = nil
+	events := c.NewEvents(collection, "10.0.0.12")
+	if exp := 2; len(events) != exp {
+		t.Fatalf("Expected number of events was %d but it was %d instead", exp, len(events))
+	}
+	if events[0].Parameters != "214.160.227.22" || events[0].IP != "214.160.227.0" {
+		t.Errorf("Event should be enriched with the original and masked afterwards: %s and %s", events[0].Parameters, events[0].IP)
+	}
+	if events[1].Parameters != "10.0.0.12" || events[1].IP != "10.0.0.0" {
+		t.Errorf("Event without an IP address should get the remote address: %s and %s", events[1].Parameters, events[1].IP)
+	}
+
+	t.Log("Creating a collector without a storage")
+	if _, err := New(&Options{Config: &Config{SharedSecret: "ultrasafesecret"}}); err == nil {
+		t.Errorf("Collector should not be created without a dialect or a storage client")
+	}
+}
@@ -0,0 +1,798 @@
+package collector
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/wunderlist/hamustro/src/dialects"
+	"github.com/wunderlist/hamustro/src/dialects/abs"
+	"github.com/wunderlist/hamustro/src/dialects/aqs"
+	"github.com/wunderlist/hamustro/src/dialects/file"
+	"github.com/wunderlist/hamustro/src/dialects/s3"
+	"github.com/wunderlist/hamustro/src/dialects/sns"
+	"github.com/wunderlist/hamustro/src/retry"
+	"github.com/wunderlist/hamustro/src/spool"
+	"github.com/wunderlist/hamustro/src/wal"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Application configuration
+type Config struct {
+	LogFile             string            `json:"logfile"`
+	LogLevel            string            `json:"log_level"`
+	LogFormat           string            `json:"log_format"`
+	LogLevels           map[string]string `json:"log_levels"`
+	ClientErrorLogLimit int               `json:"client_error_log_limit"`
+	Dialect             string            `json:"dialect"`
+	MaxWorkerSize       int               `json:"max_worker_size"`
+	MinWorkerSize       int               `json:"min_worker_size"`
+	ScaleQueueSize      int               `json:"scale_queue_size"`
+	ScaleIdleTime       int               `json:"scale_idle_time"`
+	ScaleCooldown       int               `json:"scale_cooldown"`
+	WorkerAffinity      string            `json:"worker_affinity"`
+	MaxQueueSize        int               `json:"max_queue_size"`
+	RetryAttempt        int               `json:"retry_attempt"`
+	RetryBackoff        int               `json:"retry_backoff"`
+	RetryMaxBackoff     int               `json:"retry_max_backoff"`
+	RetryQueueSize      int               `json:"retry_queue_size"`
+	BreakerThreshold    int               `json:"breaker_threshold"`
+	BreakerCooldown     int               `json:"breaker_cooldown"`
+	BufferSize          int               `json:"buffer_size"`
+	BatchMaxBytes       int               `json:"batch_max_bytes"`
+	BatchMaxAge         int               `json:"batch_max_age"`
+	MaxInflightBatches  int               `json:"max_inflight_batches"`
+	MaxInflightUploads  int               `json:"max_inflight_uploads"`
+	MaskedIP            bool              `json:"masked_ip"`
+	SpreadBufferSize    bool              `json:"spread_buffer_size"`
+	SortBatch           bool              `json:"sort_batch"`
+	BatchMetadata       bool              `json:"batch_metadata"`
+	DeterministicNaming bool              `json:"deterministic_naming"`
+	InstanceID          string            `json:"instance_id"`
+	ManifestInterval    int               `json:"manifest_interval"`
+	PriorityEvents      []string          `json:"priority_events"`
+	PriorityBufferSize  int               `json:"priority_buffer_size"`
+	PriorityMaxLatency  int               `json:"priority_max_latency"`
+	PriorityPath        string            `json:"priority_path"`
+	Signature           string            `json:"signature"`
+	SharedSecret        string            `json:"shared_secret"`
+	MaintenanceKey      string            `json:"maintenance_key"`
+	MaintenanceKeys     map[string]string `json:"maintenance_keys"`
+	MaintenanceWindow   int               `json:"maintenance_window"`
+	AuditLogFile        string            `json:"audit_logfile"`
+	AccessLogFile       string            `json:"access_logfile"`
+	AccessLogFormat     string            `json:"access_log_format"`
+	AccessLogMaxSize    int               `json:"access_log_max_size"`
+	AccessLogMaxBackups int               `json:"access_log_max_backups"`
+	AccessLogSample     int               `json:"access_log_sample"`
+	TracingEndpoint     string            `json:"tracing_endpoint"`
+	TracingServiceName  string            `json:"tracing_service_name"`
+	TracingBatchSize    int               `json:"tracing_batch_size"`
+	WALDir              string            `json:"wal_dir"`
+	WALSync             string            `json:"wal_sync"`
+	WALSyncInterval     int               `json:"wal_sync_interval"`
+	WALSegmentSize      int               `json:"wal_segment_size"`
+	SpoolDir            string            `json:"spool_dir"`
+	SpoolMemoryLimit    int               `json:"spool_memory_limit"`
+	SpoolMaxSize        int               `json:"spool_max_size"`
+	SpoolMaxAge         int               `json:"spool_max_age"`
+	SpoolRetryInterval  int               `json:"spool_retry_interval"`
+	LateEventPolicy     string            `json:"late_event_policy"`
+	LateEventAge        int               `json:"late_event_age"`
+	FutureEventPolicy   string            `json:"future_event_policy"`
+	FutureEventSkew     int               `json:"future_event_skew"`
+	LateEventSinks      []string          `json:"late_event_sinks"`
+	LateEventPath       string            `json:"late_event_path"`
+	AutoFlushInterval   int               `json:"auto_flush_interval"`
+	ShutdownTimeout     int               `json:"shutdown_timeout"`
+	Sinks               []*SinkConfig     `json:"sinks"`
+	DeadLetter          *SinkConfig       `json:"dead_letter"`
+	Routes              []*RouteConfig    `json:"routes"`
+	DefaultRoute        []string          `json:"default_route"`
+	AQS                 aqs.Config        `json:"aqs"`
+	SNS                 sns.Config        `json:"sns"`
+	ABS                 abs.Config        `json:"abs"`
+	S3                  s3.Config         `json:"s3"`
+	File                file.Config       `json:"file"`
+}
+
+// Configuration of a single named sink, the sizes and the retry
+// attempt are inherited from the application configuration if not set
+type SinkConfig struct {
+	Name                string      `json:"name"`
+	Dialect             string      `json:"dialect"`
+	MaxWorkerSize       int         `json:"max_worker_size"`
+	MinWorkerSize       int         `json:"min_worker_size"`
+	ScaleQueueSize      int         `json:"scale_queue_size"`
+	WorkerAffinity      string      `json:"worker_affinity"`
+	MaxQueueSize        int         `json:"max_queue_size"`
+	RetryAttempt        int         `json:"retry_attempt"`
+	BufferSize          int         `json:"buffer_size"`
+	BatchMaxBytes       int         `json:"batch_max_bytes"`
+	BatchMaxAge         int         `json:"batch_max_age"`
+	MaxInflightBatches  int         `json:"max_inflight_batches"`
+	MaxInflightUploads  int         `json:"max_inflight_uploads"`
+	SpreadBufferSize    bool        `json:"spread_buffer_size"`
+	SortBatch           bool        `json:"sort_batch"`
+	BatchMetadata       bool        `json:"batch_metadata"`
+	DeterministicNaming bool        `json:"deterministic_naming"`
+	ManifestInterval    int         `json:"manifest_interval"`
+	PriorityEvents      []string    `json:"priority_events"`
+	PriorityBufferSize  int         `json:"priority_buffer_size"`
+	PriorityMaxLatency  int         `json:"priority_max_latency"`
+	PriorityPath        string      `json:"priority_path"`
+	Overflow            string      `json:"overflow"`
+	AQS                 aqs.Config  `json:"aqs"`
+	SNS                 sns.Config  `json:"sns"`
+	ABS                 abs.Config  `json:"abs"`
+	S3                  s3.Config   `json:"s3"`
+	File                file.Config `json:"file"`
+}
+
+// Configuration of a routing rule, it matches the event's field
+// (e.g. `env`, `event`, `tenant_id`) with the operator
+type RouteConfig struct {
+	Name     string   `json:"name"`
+	Field    string   `json:"field"`
+	Operator string   `json:"operator"`
+	Value    string   `json:"value"`
+	Values   []string `json:"values"`
+	Sinks    []string `json:"sinks"`
+	Continue bool     `json:"continue"`
+}
+
+// Creates a new configuration object
+func NewConfig(filename string) *Config {
+	config, err := LoadConfig(filename)
+	if err != nil {
+		log.Fatal(err)
+	}
+	return config
+}
+
+// Reads and parses the configuration file
+func LoadConfig(filename string) (*Config, error) {
+	file, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	var config Config
+	if err := json.Unmarshal(file, &config); err != nil {
+		return nil, err
+	}
+	config.UpdateAutoFlushIntervalToSeconds()
+	return &config, nil
+}
+
+// Configuration validation
+func (c *Config) IsValid() bool {
+	return (c.Dialect != "" || len(c.Sinks) != 0) && c.SharedSecret != ""
+}
+
+// Get Signature's status
+func (c *Config) IsSignatureRequired() bool {
+	switch c.Signature {
+	case "required":
+		return true
+	case "optional":
+		return false
+	default:
+		return true
+	}
+}
+
+// Returns the maximum worker size
+func (c *Config) GetMaxWorkerSize() int {
+	size, _ := strconv.ParseInt(os.Getenv("HAMUSTRO_MAX_WORKER_SIZE"), 10, 0)
+	if size != 0 {
+		return int(size)
+	}
+	if c.MaxWorkerSize != 0 {
+		return c.MaxWorkerSize
+	}
+	return runtime.NumCPU() + 1
+}
+
+// Returns the seconds while some workers must be idle before one
+// of the scaled workers is retired
+func (c *Config) GetScaleIdleTime() int {
+	if c.ScaleIdleTime != 0 {
+		return c.ScaleIdleTime
+	}
+	return 60
+}
+
+// Returns the minimum seconds between two scaling steps
+func (c *Config) GetScaleCooldown() int {
+	if c.ScaleCooldown != 0 {
+		return c.ScaleCooldown
+	}
+	return 30
+}
+
+// Returns the property of the events that selects their worker
+func (c *Config) GetWorkerAffinity() string {
+	if c.WorkerAffinity != "" {
+		return c.WorkerAffinity
+	}
+	return AFFINITY_NONE
+}
+
+// Returns the maximum queue size
+func (c *Config) GetMaxQueueSize() int {
+	size, _ := strconv.ParseInt(os.Getenv("HAMUSTRO_MAX_QUEUE_SIZE"), 10, 0)
+	if size != 0 {
+		return int(size)
+	}
+	if c.MaxQueueSize != 0 {
+		return c.MaxQueueSize
+	}
+	return c.GetMaxWorkerSize() * 20
+}
+
+// Returns the port of the application
+func (c *Config) GetPort() string {
+	if port := os.Getenv("HAMUSTRO_PORT"); port != "" {
+		return port
+	}
+	return "8080"
+}
+
+// Returns the host of the application
+func (c *Config) GetHost() string {
+	if port := os.Getenv("HAMUSTRO_HOST"); port != "" {
+		return port
+	}
+	return "localhost"
+}
+
+// Returns the address of the application
+func (c *Config) GetAddress() string {
+	host := c.GetHost()
+	if host == "localhost" {
+		return ":" + c.GetPort()
+	}
+	return host + ":" + c.GetPort()
+}
+
+// Returns the default buffer size for Buffered Storage.
+func (c *Config) GetBufferSize() int {
+	if c.BufferSize != 0 {
+		return c.BufferSize
+	}
+	return (c.GetMaxWorkerSize() * c.GetMaxQueueSize()) * 10
+}
+
+// Update automatic flush interval to seconds
+func (c *Config) UpdateAutoFlushIntervalToSeconds() {
+	c.AutoFlushInterval = c.AutoFlushInterval * 60
+}
+
+// Returns the default spreding property
+func (c *Config) IsSpreadBuffer() bool {
+	return c.SpreadBufferSize
+}
+
+// Should we truncate the IP address
+func (c *Config) IsMaskedIP() bool {
+	return c.MaskedIP
+}
+
+// Returns the retry attempt number
+func (c *Config) GetRetryAttempt() int {
+	if c.RetryAttempt != 0 {
+		return c.RetryAttempt
+	}
+	return 3
+}
+
+// Returns the default log level
+func (c *Config) GetLogLevel() string {
+	if c.LogLevel != "" {
+		return c.LogLevel
+	}
+	return "info"
+}
+
+// Returns the log format (text, logfmt or json)
+func (c *Config) GetLogFormat() string {
+	if c.LogFormat != "" {
+		return c.LogFormat
+	}
+	return "text"
+}
+
+// Returns how many times the same client error is logged per minute
+func (c *Config) GetClientErrorLogLimit() int {
+	if c.ClientErrorLogLimit != 0 {
+		return c.ClientErrorLogLimit
+	}
+	return 10
+}
+
+// Returns the named maintenance keys, `maintenance_key` is named as `default`
+func (c *Config) GetMaintenanceKeys() map[string]string {
+	keys := map[string]string{}
+	for name, key := range c.MaintenanceKeys {
+		if key != "" {
+			keys[name] = key
+		}
+	}
+	if c.MaintenanceKey != "" {
+		keys["default"] = c.MaintenanceKey
+	}
+	return keys
+}
+
+// Checks that the legacy `maintenance_key` doesn't conflict with
+// the `default` key of the named keys
+func (c *Config) IsValidMaintenanceKeys() bool {
+	return c.MaintenanceKey == "" || c.MaintenanceKeys["default"] == ""
+}
+
+// Returns the accepted clock skew for maintenance requests in seconds
+func (c *Config) GetMaintenanceWindow() int {
+	if c.MaintenanceWindow != 0 {
+		return c.MaintenanceWindow
+	}
+	return 300
+}
+
+// Returns the access log format (combined or json)
+func (c *Config) GetAccessLogFormat() string {
+	if c.AccessLogFormat != "" {
+		return c.AccessLogFormat
+	}
+	return "combined"
+}
+
+// Returns the access log's maximum size in megabytes before rotation
+func (c *Config) GetAccessLogMaxSize() int {
+	if c.AccessLogMaxSize != 0 {
+		return c.AccessLogMaxSize
+	}
+	return 100
+}
+
+// Returns how many rotated access logs are kept
+func (c *Config) GetAccessLogMaxBackups() int {
+	if c.AccessLogMaxBackups != 0 {
+		return c.AccessLogMaxBackups
+	}
+	return 5
+}
+
+// Returns the sampling of the successful requests, every Nth is written
+func (c *Config) GetAccessLogSample() int {
+	if c.AccessLogSample > 1 {
+		return c.AccessLogSample
+	}
+	return 1
+}
+
+// Returns the service name of the exported traces
+func (c *Config) GetTracingServiceName() string {
+	if c.TracingServiceName != "" {
+		return c.TracingServiceName
+	}
+	return "hamustro"
+}
+
+// Returns the ID of the collector's instance in the object names,
+// it's the host name by default
+func (c *Config) GetInstanceID() string {
+	if c.InstanceID != "" {
+		return dialects.GetPartitionValue(c.InstanceID)
+	}
+	hostname, err := os.Hostname()
+	if err != nil || hostname == "" {
+		return "hamustro"
+	}
+	return dialects.GetPartitionValue(hostname)
+}
+
+// Returns the number of spans exported at once
+func (c *Config) GetTracingBatchSize() int {
+	if c.TracingBatchSize != 0 {
+		return c.TracingBatchSize
+	}
+	return 512
+}
+
+// Returns the write-ahead log's fsync policy (always, interval or never)
+func (c *Config) GetWALSync() string {
+	if c.WALSync != "" {
+		return c.WALSync
+	}
+	return "interval"
+}
+
+// Returns the write-ahead log's fsync interval in milliseconds
+func (c *Config) GetWALSyncInterval() int {
+	if c.WALSyncInterval != 0 {
+		return c.WALSyncInterval
+	}
+	return 1000
+}
+
+// Returns the write-ahead log's segment size in megabytes
+func (c *Config) GetWALSegmentSize() int {
+	if c.WALSegmentSize != 0 {
+		return c.WALSegmentSize
+	}
+	return 64
+}
+
+// Returns the write-ahead log options of a sink
+func (c *Config) GetWALOptions(sink string) *wal.Options {
+	return &wal.Options{
+		Dir:          filepath.Join(c.WALDir, sink),
+		Sync:         c.GetWALSync(),
+		SyncInterval: time.Duration(c.GetWALSyncInterval()) * time.Millisecond,
+		SegmentSize:  int64(c.GetWALSegmentSize()) * 1024 * 1024}
+}
+
+// Returns the retry's backoff options, the first retry waits
+// `retry_backoff` milliseconds (default: 100) that is doubled
+// after every attempt up to `retry_max_backoff` (default: 30000)
+func (c *Config) GetRetryBackoff() *retry.Backoff {
+	backoff := &retry.Backoff{Min: 100 * time.Millisecond, Max: 30 * time.Second}
+	if c.RetryBackoff != 0 {
+		backoff.Min = time.Duration(c.RetryBackoff) * time.Millisecond
+	}
+	if c.RetryMaxBackoff != 0 {
+		backoff.Max = time.Duration(c.RetryMaxBackoff) * time.Millisecond
+	}
+	if backoff.Max < backoff.Min {
+		backoff.Max = backoff.Min
+	}
+	return backoff
+}
+
+// Returns the maximum number of the scheduled retries of a sink
+func (c *Config) GetRetryQueueSize() int {
+	if c.RetryQueueSize != 0 {
+		return c.RetryQueueSize
+	}
+	return c.GetMaxQueueSize()
+}
+
+// Returns the policy of the events older than the late event age
+func (c *Config) GetLateEventPolicy() string {
+	if c.LateEventPolicy != "" {
+		return c.LateEventPolicy
+	}
+	return EVENT_TIME_ACCEPT
+}
+
+// Returns the age of the late events in minutes
+func (c *Config) GetLateEventAge() int {
+	if c.LateEventAge != 0 {
+		return c.LateEventAge
+	}
+	return 1440
+}
+
+// Returns the policy of the events ahead of the server time
+// more than the future event skew
+func (c *Config) GetFutureEventPolicy() string {
+	if c.FutureEventPolicy != "" {
+		return c.FutureEventPolicy
+	}
+	return EVENT_TIME_ACCEPT
+}
+
+// Returns the allowed skew of the events' time in minutes
+func (c *Config) GetFutureEventSkew() int {
+	if c.FutureEventSkew != 0 {
+		return c.FutureEventSkew
+	}
+	return 10
+}
+
+// Returns the number of consecutive failures that opens the circuit breaker
+func (c *Config) GetBreakerThreshold() int {
+	if c.BreakerThreshold != 0 {
+		return c.BreakerThreshold
+	}
+	return 5
+}
+
+// Returns the circuit breaker's cooldown in seconds before probing the storage
+func (c *Config) GetBreakerCooldown() int {
+	if c.BreakerCooldown != 0 {
+		return c.BreakerCooldown
+	}
+	return 30
+}
+
+// Returns the deadline of the graceful shutdown in seconds
+func (c *Config) GetShutdownTimeout() int {
+	if c.ShutdownTimeout != 0 {
+		return c.ShutdownTimeout
+	}
+	return 90
+}
+
+// Returns the buffered events' memory ceiling of a sink in megabytes,
+// the failed batches are spilled to the spool beyond it
+func (c *Config) GetSpoolMemoryLimit() int {
+	if c.SpoolMemoryLimit != 0 {
+		return c.SpoolMemoryLimit
+	}
+	return 256
+}
+
+// Returns the spool's maximum size of a sink in megabytes
+func (c *Config) GetSpoolMaxSize() int {
+	if c.SpoolMaxSize != 0 {
+		return c.SpoolMaxSize
+	}
+	return 1024
+}
+
+// Returns the spooled batches' maximum age in minutes
+func (c *Config) GetSpoolMaxAge() int {
+	if c.SpoolMaxAge != 0 {
+		return c.SpoolMaxAge
+	}
+	return 1440
+}
+
+// Returns the spool's first retry interval in seconds
+func (c *Config) GetSpoolRetryInterval() int {
+	if c.SpoolRetryInterval != 0 {
+		return c.SpoolRetryInterval
+	}
+	return 5
+}
+
+// Creates the spool of a sink
+func (c *Config) NewSpool(sink string) (*spool.Spool, error) {
+	return spool.New(
+		filepath.Join(c.SpoolDir, sink),
+		int64(c.GetSpoolMaxSize())*1024*1024,
+		time.Duration(c.GetSpoolMaxAge())*time.Minute)
+}
+
+// Returns the selected dialect's configuration object
+func (c *Config) DialectConfig() (dialects.Dialect, error) {
+	return c.GetDefaultSink().DialectConfig()
+}
+
+// Returns the sink defined by the application configuration's dialect
+func (c *Config) GetDefaultSink() *SinkConfig {
+	return &SinkConfig{
+		Name:                "default",
+		Dialect:             c.Dialect,
+		MaxWorkerSize:       c.GetMaxWorkerSize(),
+		MinWorkerSize:       c.MinWorkerSize,
+		ScaleQueueSize:      c.ScaleQueueSize,
+		WorkerAffinity:      c.GetWorkerAffinity(),
+		MaxQueueSize:        c.GetMaxQueueSize(),
+		RetryAttempt:        c.GetRetryAttempt(),
+		BufferSize:          c.GetBufferSize(),
+		BatchMaxBytes:       c.BatchMaxBytes,
+		BatchMaxAge:         c.BatchMaxAge,
+		MaxInflightBatches:  c.MaxInflightBatches,
+		MaxInflightUploads:  c.MaxInflightUploads,
+		SpreadBufferSize:    c.IsSpreadBuffer(),
+		SortBatch:           c.SortBatch,
+		BatchMetadata:       c.BatchMetadata,
+		DeterministicNaming: c.DeterministicNaming,
+		ManifestInterval:    c.ManifestInterval,
+		PriorityEvents:      c.PriorityEvents,
+		PriorityBufferSize:  c.PriorityBufferSize,
+		PriorityMaxLatency:  c.PriorityMaxLatency,
+		PriorityPath:        c.PriorityPath,
+		Overflow:            OVERFLOW_BLOCK,
+		AQS:                 c.AQS,
+		SNS:                 c.SNS,
+		ABS:                 c.ABS,
+		S3:                  c.S3,
+		File:                c.File}
+}
+
+// Returns the sinks with the inherited properties, it's the
+// default sink if no sinks are defined
+func (c *Config) GetSinks() ([]*SinkConfig, error) {
+	if len(c.Sinks) == 0 {
+		sink := c.GetDefaultSink()
+		if sink.MinWorkerSize > sink.MaxWorkerSize {
+			return nil, fmt.Errorf("The `min_worker_size` is larger than the `max_worker_size`.")
+		}
+		if !IsValidAffinity(sink.WorkerAffinity) {
+			return nil, fmt.Errorf("Not supported `%s` worker affinity (use `none`, `session` or `device_id`).", sink.WorkerAffinity)
+		}
+		for _, pattern := range sink.PriorityEvents {
+			if !IsValidPattern(pattern) {
+				return nil, fmt.Errorf("Malformed `%s` priority event pattern.", pattern)
+			}
+		}
+		return []*SinkConfig{sink}, nil
+	}
+	names := map[string]bool{}
+	sinks := []*SinkConfig{}
+	for _, s := range c.Sinks {
+		if s.Name == "" {
+			return nil, fmt.Errorf("Every sink must have a `name` in the configuration file.")
+		}
+		if names[s.Name] {
+			return nil, fmt.Errorf("Sink `%s` is defined more than once in the configuration file.", s.Name)
+		}
+		names[s.Name] = true
+
+		sink := *s
+		if sink.MaxWorkerSize == 0 {
+			sink.MaxWorkerSize = c.GetMaxWorkerSize()
+		}
+		if sink.MinWorkerSize == 0 {
+			sink.MinWorkerSize = c.MinWorkerSize
+		}
+		if sink.ScaleQueueSize == 0 {
+			sink.ScaleQueueSize = c.ScaleQueueSize
+		}
+		if sink.WorkerAffinity == "" {
+			sink.WorkerAffinity = c.GetWorkerAffinity()
+		}
+		if sink.MaxQueueSize == 0 {
+			sink.MaxQueueSize = c.GetMaxQueueSize()
+		}
+		if sink.RetryAttempt == 0 {
+			sink.RetryAttempt = c.GetRetryAttempt()
+		}
+		if sink.BufferSize == 0 {
+			sink.BufferSize = c.GetBufferSize()
+		}
+		if sink.BatchMaxBytes == 0 {
+			sink.BatchMaxBytes = c.BatchMaxBytes
+		}
+		if sink.BatchMaxAge == 0 {
+			sink.BatchMaxAge = c.BatchMaxAge
+		}
+		if sink.MaxInflightBatches == 0 {
+			sink.MaxInflightBatches = c.MaxInflightBatches
+		}
+		if sink.MaxInflightUploads == 0 {
+			sink.MaxInflightUploads = c.MaxInflightUploads
+		}
+		if !sink.SortBatch {
+			sink.SortBatch = c.SortBatch
+		}
+		if !sink.BatchMetadata {
+			sink.BatchMetadata = c.BatchMetadata
+		}
+		if !sink.DeterministicNaming {
+			sink.DeterministicNaming = c.DeterministicNaming
+		}
+		if sink.ManifestInterval == 0 {
+			sink.ManifestInterval = c.ManifestInterval
+		}
+		if len(sink.PriorityEvents) == 0 {
+			sink.PriorityEvents = c.PriorityEvents
+		}
+		if sink.PriorityBufferSize == 0 {
+			sink.PriorityBufferSize = c.PriorityBufferSize
+		}
+		if sink.PriorityMaxLatency == 0 {
+			sink.PriorityMaxLatency = c.PriorityMaxLatency
+		}
+		if sink.PriorityPath == "" {
+			sink.PriorityPath = c.PriorityPath
+		}
+		if sink.Overflow == "" {
+			sink.Overflow = OVERFLOW_DROP
+		}
+		if sink.Overflow != OVERFLOW_DROP && sink.Overflow != OVERFLOW_BLOCK {
+			return nil, fmt.Errorf("Not supported `%s` overflow for `%s` sink (use `drop` or `block`).", sink.Overflow, sink.Name)
+		}
+		if sink.MinWorkerSize > sink.MaxWorkerSize {
+			return nil, fmt.Errorf("The `min_worker_size` of `%s` sink is larger than its `max_worker_size`.", sink.Name)
+		}
+		if !IsValidAffinity(sink.WorkerAffinity) {
+			return nil, fmt.Errorf("Not supported `%s` worker affinity for `%s` sink (use `none`, `session` or `device_id`).", sink.WorkerAffinity, sink.Name)
+		}
+		for _, pattern := range sink.PriorityEvents {
+			if !IsValidPattern(pattern) {
+				return nil, fmt.Errorf("Malformed `%s` priority event pattern for `%s` sink.", pattern, sink.Name)
+			}
+		}
+		sinks = append(sinks, &sink)
+	}
+	return sinks, nil
+}
+
+// Returns the dead-letter storage's configuration with the defaults,
+// it's a local file if no dialect is set. Returns nil if the
+// dead-letter is not configured.
+func (c *Config) GetDeadLetter() *SinkConfig {
+	if c.DeadLetter == nil {
+		return nil
+	}
+	deadLetter := *c.DeadLetter
+	deadLetter.Name = "dead_letter"
+	if deadLetter.Dialect == "" {
+		deadLetter.Dialect = "file"
+	}
+	if deadLetter.File.FilePath == "" {
+		deadLetter.File.FilePath = "dead-letter"
+	}
+	if deadLetter.File.FileFormat == "" {
+		deadLetter.File.FileFormat = "json"
+	}
+	if deadLetter.BufferSize == 0 {
+		deadLetter.BufferSize = 100
+	}
+	if deadLetter.MaxQueueSize == 0 {
+		deadLetter.MaxQueueSize = c.GetMaxQueueSize()
+	}
+	return &deadLetter
+}
+
+// Are the sink's workers scaled between the minimum and the maximum
+func (s *SinkConfig) IsScaling() bool {
+	return s.MinWorkerSize > 0 && s.MinWorkerSize < s.MaxWorkerSize
+}
+
+// Returns the queue length above which the workers are added,
+// it's the half of the queue by default
+func (s *SinkConfig) GetScaleQueueSize() int {
+	if s.ScaleQueueSize != 0 {
+		return s.ScaleQueueSize
+	}
+	return s.MaxQueueSize / 2
+}
+
+// Returns the buffer size of the sink's priority events
+func (s *SinkConfig) GetPriorityBufferSize() int {
+	if s.PriorityBufferSize != 0 {
+		return s.PriorityBufferSize
+	}
+	return 10
+}
+
+// Returns the maximum seconds a priority event waits in the buffer
+func (s *SinkConfig) GetPriorityMaxLatency() int {
+	if s.PriorityMaxLatency != 0 {
+		return s.PriorityMaxLatency
+	}
+	return 5
+}
+
+// Returns the configuration of the sink's priority lane: a single
+// worker with a small buffer and a short maximum age
+func (s *SinkConfig) GetPriorityConfig() *SinkConfig {
+	pc := *s
+	pc.MaxWorkerSize = 1
+	pc.MinWorkerSize = 0
+	pc.WorkerAffinity = AFFINITY_NONE
+	pc.BufferSize = s.GetPriorityBufferSize()
+	pc.BatchMaxBytes = 0
+	pc.BatchMaxAge = s.GetPriorityMaxLatency()
+	pc.SpreadBufferSize = false
+	pc.ManifestInterval = 0
+	pc.PriorityEvents = nil
+	return &pc
+}
+
+// Returns the sink's dialect configuration object
+func (s *SinkConfig) DialectConfig() (dialects.Dialect, error) {
+	switch strings.ToLower(s.Dialect) {
+	case "aqs":
+		return &s.AQS, nil
+	case "sns":
+		return &s.SNS, nil
+	case "abs":
+		return &s.ABS, nil
+	case "s3":
+		return &s.S3, nil
+	case "file":
+		return &s.File, nil
+	}
+	return nil, fmt.Errorf("Not supported `%s` dialect in the configuration file.", s.Dialect)
+}
@@ -1,4 +1,4 @@
-package main
+package collector
 
 import (
 	"os"
@@ -216,6 +216,51 @@ func TestFunctionMaintanceKeyIsEmpty(t *testing.T) {
 	}
 }
 
+// Testing the logging properties
+func TestFunctionLoggingProperties(t *testing.T) {
+	t.Log("Testing the logging properties when not defined")
+	config := &Config{}
+	if config.GetLogLevel() != "info" || config.GetLogFormat() != "text" || config.GetClientErrorLogLimit() != 10 {
+		t.Errorf("Expected default logging properties were info, text and 10 but it was %s, %s and %d instead", config.GetLogLevel(), config.GetLogFormat(), config.GetClientErrorLogLimit())
+	}
+	config = &Config{LogLevel: "warn", LogFormat: "json", ClientErrorLogLimit: 3}
+	if config.GetLogLevel() != "warn" || config.GetLogFormat() != "json" || config.GetClientErrorLogLimit() != 3 {
+		t.Errorf("Expected logging properties were warn, json and 3 but it was %s, %s and %d instead", config.GetLogLevel(), config.GetLogFormat(), config.GetClientErrorLogLimit())
+	}
+}
+
+// Testing the named maintenance keys
+func TestFunctionGetMaintenanceKeys(t *testing.T) {
+	t.Log("Testing the maintenance keys when not defined")
+	config := &Config{}
+	if exp := 0; len(config.GetMaintenanceKeys()) != exp {
+		t.Errorf("Expected maintenance keys count was %d but it was %d instead", exp, len(config.GetMaintenanceKeys()))
+	}
+
+	t.Log("Testing the maintenance keys with the default and named keys")
+	config = &Config{MaintenanceKey: "mk", MaintenanceKeys: map[string]string{"ops": "ok", "empty": ""}}
+	keys := config.GetMaintenanceKeys()
+	if exp := 2; len(keys) != exp {
+		t.Errorf("Expected maintenance keys count was %d but it was %d instead", exp, len(keys))
+	}
+	if keys["default"] != "mk" || keys["ops"] != "ok" {
+		t.Errorf("Expected maintenance keys were not found")
+	}
+}
+
+// Testing the maintenance window
+func TestFunctionGetMaintenanceWindow(t *testing.T) {
+	t.Log("Testing the maintenance window property")
+	config := &Config{}
+	if exp := 300; config.GetMaintenanceWindow() != exp {
+		t.Errorf("Expected maintenance window was %d but it was %d instead", exp, config.GetMaintenanceWindow())
+	}
+	config = &Config{MaintenanceWindow: 30}
+	if exp := 30; config.GetMaintenanceWindow() != exp {
+		t.Errorf("Expected maintenance window was %d but it was %d instead", exp, config.GetMaintenanceWindow())
+	}
+}
+
 // Testing the auto flush interval update function
 func TestFunctionUpdateAutoFlushIntervalToSeconds(t *testing.T) {
 	t.Log("Testing the auto flush interval property")
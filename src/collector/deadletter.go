@@ -0,0 +1,203 @@
+package collector
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"github.com/wunderlist/hamustro/src/dialects"
+	"github.com/wunderlist/hamustro/src/logging"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// A permanently failed event with the reason of the failure
+type DeadLetterRecord struct {
+	Event      *dialects.Event `json:"event"`
+	Reason     string          `json:"reason"`
+	Attempts   int             `json:"attempts"`
+	Sink       string          `json:"sink,omitempty"`
+	Dialect    string          `json:"dialect"`
+	ReceivedAt string          `json:"received_at,omitempty"`
+	FailedAt   string          `json:"failed_at"`
+	Path       string          `json:"path,omitempty"` // Event's own path (e.g. late events)
+}
+
+// Creates a new record of a failed event
+func NewDeadLetterRecord(event *dialects.Event, attempts int, receivedAt time.Time, sink *Sink, reason error) *DeadLetterRecord {
+	record := &DeadLetterRecord{
+		Event:    event,
+		Reason:   reason.Error(),
+		Attempts: attempts,
+		FailedAt: time.Now().UTC().Format(time.RFC3339),
+		Path:     event.Path}
+	if sink != nil {
+		record.Sink = sink.Name
+		record.Dialect = sink.Dialect
+	}
+	if !receivedAt.IsZero() {
+		record.ReceivedAt = receivedAt.UTC().Format(time.RFC3339)
+	}
+	return record
+}
+
+// Storage of the permanently failed events, the records are written
+// as JSON lines in batches (or one by one for a not buffered storage)
+type DeadLetter struct {
+	StorageClient dialects.StorageClient
+	Records       chan *DeadLetterRecord
+	BatchSize     int
+	Written       int64
+	Lost          int64
+	Logger        *logging.Logger
+	quit          chan *sync.WaitGroup
+}
+
+// Creates a new dead-letter storage
+func NewDeadLetter(client dialects.StorageClient, batchSize int, queueSize int) *DeadLetter {
+	return &DeadLetter{
+		StorageClient: client,
+		Records:       make(chan *DeadLetterRecord, queueSize),
+		BatchSize:     batchSize,
+		Logger:        logging.New(nil, logging.FORMAT_TEXT, logging.INFO),
+		quit:          make(chan *sync.WaitGroup)}
+}
+
+// Returns the dead-letter's logger
+func (d *DeadLetter) GetLogger() *logging.Logger {
+	return d.Logger.Component("dead_letter")
+}
+
+// Puts the record into the dead-letter's queue, the record
+// is lost if the queue is full
+func (d *DeadLetter) Add(record *DeadLetterRecord) bool {
+	if d == nil {
+		return false
+	}
+	select {
+	case d.Records <- record:
+		return true
+	default:
+		atomic.AddInt64(&d.Lost, 1)
+		d.GetLogger().RateLimit("queue_full").Errorf("Queue is full, the failed event is lost: %s", record.Reason)
+		return false
+	}
+}
+
+// Writes the records into the dead-letter's storage
+func (d *DeadLetter) Write(records []*DeadLetterRecord) error {
+	if len(records) == 0 {
+		return nil
+	}
+	lines := make([][]byte, len(records))
+	for i, record := range records {
+		b, err := json.Marshal(record)
+		if err != nil {
+			return err
+		}
+		lines[i] = b
+	}
+	if d.StorageClient.IsBufferedStorage() {
+		buffer := bytes.NewBuffer(bytes.Join(lines, []byte("\n")))
+		buffer.WriteString("\n")
+		if err := d.StorageClient.Save(buffer); err != nil {
+			return err
+		}
+	} else {
+		for _, line := range lines {
+			if err := d.StorageClient.Save(bytes.NewBuffer(line)); err != nil {
+				return err
+			}
+		}
+	}
+	atomic.AddInt64(&d.Written, int64(len(records)))
+	return nil
+}
+
+// Writes the buffered records and logs the failure
+func (d *DeadLetter) flush(records []*DeadLetterRecord) {
+	if err := d.Write(records); err != nil {
+		atomic.AddInt64(&d.Lost, int64(len(records)))
+		d.GetLogger().Errorf("Writing %d failed events is failed: %s", len(records), err.Error())
+	}
+}
+
+// Collects the records and writes them in batches in the background,
+// the batch is written after the interval even if it's not full
+func (d *DeadLetter) Run(interval time.Duration) {
+	go func() {
+		records := []*DeadLetterRecord{}
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case record := <-d.Records:
+				records = append(records, record)
+				if len(records) >= d.BatchSize {
+					d.flush(records)
+					records = []*DeadLetterRecord{}
+				}
+			case <-ticker.C:
+				d.flush(records)
+				records = []*DeadLetterRecord{}
+			case wg := <-d.quit:
+				for len(d.Records) != 0 {
+					records = append(records, <-d.Records)
+				}
+				d.flush(records)
+				wg.Done()
+				return
+			}
+		}
+	}()
+}
+
+// Writes the remaining records and stops the background writer
+func (d *DeadLetter) Stop() {
+	if d == nil {
+		return
+	}
+	var wg sync.WaitGroup
+	wg.Add(1)
+	d.quit <- &wg
+	wg.Wait()
+}
+
+// Returns the dead-letter's counters
+func (d *DeadLetter) GetStatus() *DeadLetterStatus {
+	return &DeadLetterStatus{
+		QueueLength: len(d.Records),
+		Written:     atomic.LoadInt64(&d.Written),
+		Lost:        atomic.LoadInt64(&d.Lost)}
+}
+
+// Status of the dead-letter storage for the health and stats endpoints
+type DeadLetterStatus struct {
+	QueueLength int   `json:"queue_length"`
+	Written     int64 `json:"written"`
+	Lost        int64 `json:"lost"`
+}
+
+// Returns the dead-letter's status if it's configured
+func (c *Collector) GetDeadLetterStatus() *DeadLetterStatus {
+	if c.deadLetter == nil {
+		return nil
+	}
+	return c.deadLetter.GetStatus()
+}
+
+// Creates the dead-letter storage based on the configuration
+func NewDeadLetterFromConfig(c *SinkConfig) (*DeadLetter, error) {
+	dialect, err := c.DialectConfig()
+	if err != nil {
+		return nil, fmt.Errorf("Loading dead-letter's dialect configuration is failed: %s", err.Error())
+	}
+	if !dialect.IsValid() {
+		return nil, fmt.Errorf("Dialect configuration of the dead-letter is incorrect or incomplete")
+	}
+	client, err := dialect.NewClient()
+	if err != nil {
+		return nil, fmt.Errorf("Client initialization of the dead-letter is failed: %s", err.Error())
+	}
+	return NewDeadLetter(client, c.BufferSize, c.MaxQueueSize), nil
+}
@@ -0,0 +1,138 @@
+package collector
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/wunderlist/hamustro/src/dialects"
+	"github.com/wunderlist/hamustro/src/dialects/file"
+	"github.com/wunderlist/hamustro/src/tracing"
+	"io/ioutil"
+	"log"
+	"os"
+	"testing"
+	"time"
+)
+
+// Storage Client for the dead-letter tests that can't convert the poison events
+type PoisonStorageClient struct {
+	CountingStorageClient
+}
+
+func (c *PoisonStorageClient) GetBatchConverter() dialects.BatchConverter {
+	return func(events []*dialects.Event) (*bytes.Buffer, error) {
+		for _, event := range events {
+			if event.Event == "Poison" {
+				return nil, fmt.Errorf("Event can't be converted")
+			}
+		}
+		return dialects.ConvertBatchJSON(events)
+	}
+}
+
+// Returns a dead-letter that writes local files into a temporary directory
+func GetTestDeadLetter(t *testing.T) (*DeadLetter, string) {
+	dir, err := ioutil.TempDir("", "hamustro-dead-letter")
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := &Config{MaxQueueSize: 10, DeadLetter: &SinkConfig{File: file.Config{FilePath: dir}}}
+	d, err := NewDeadLetterFromConfig(c.GetDeadLetter())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return d, dir
+}
+
+// Tests that the permanently failed events are written into the
+// dead-letter and they can be re-driven later
+func TestDeadLetterAndRedrive(t *testing.T) {
+	config = &Config{}
+	collector := NewTestCollector()
+	log.SetOutput(ioutil.Discard)
+	deadLetter, dir := GetTestDeadLetter(t)
+	collector.SetDeadLetter(deadLetter)
+	defer os.RemoveAll(dir)
+	deadLetter.Run(time.Hour)
+
+	client := &CountingStorageClient{Response: fmt.Errorf("SNS is not available")}
+	sink := collector.NewSinkWithClient(&SinkConfig{Name: "notifications", Dialect: "sns", MaxWorkerSize: 1, MaxQueueSize: 10, RetryAttempt: 2, Overflow: OVERFLOW_BLOCK}, client)
+	collector.sinks = []*Sink{sink}
+	sink.Dispatcher.Run()
+
+	t.Log("Publishing an event while the storage is down")
+	collector.PublishEvent(GetTestEvent(900), tracing.SpanContext{})
+	for i := 0; i < 100 && sink.GetStatus().DeadLettered != 1; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	deadLetter.Stop()
+	if exp := int64(1); deadLetter.GetStatus().Written != exp {
+		t.Fatalf("Expected number of dead-letter records was %d but it was %d instead", exp, deadLetter.GetStatus().Written)
+	}
+
+	files, err := GetDeadLetterFiles(dir)
+	if err != nil || len(files) != 1 {
+		t.Fatalf("Expected a single dead-letter file but it was %d (%v)", len(files), err)
+	}
+	records, err := ReadDeadLetterFile(files[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := records[0]
+	if r.Reason != "SNS is not available" || r.Attempts != 2 || r.Sink != "notifications" || r.Dialect != "sns" {
+		t.Errorf("Dead-letter record has unexpected values: %+v", r)
+	}
+	if r.Event.UserID != "900" || r.ReceivedAt == "" || r.FailedAt == "" {
+		t.Errorf("Dead-letter record should contain the event and the timestamps: %+v", r)
+	}
+
+	t.Log("Re-driving the event after the storage recovered")
+	client.SetResponse(nil)
+	n, err := collector.Redrive(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if exp := 1; n != exp {
+		t.Errorf("Expected number of re-driven events was %d but it was %d instead", exp, n)
+	}
+	for i := 0; i < 100 && client.GetSaved() != 1; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if exp := 1; client.GetSaved() != exp {
+		t.Errorf("Expected number of saved events was %d but it was %d instead", exp, client.GetSaved())
+	}
+	if files, _ := GetDeadLetterFiles(dir); len(files) != 0 {
+		t.Errorf("Re-driven files should not be re-driven again")
+	}
+	collector.StopSinks()
+}
+
+// Tests that a poison event doesn't block the other events of the batch
+func TestPoisonEventIsolation(t *testing.T) {
+	config = &Config{}
+	collector := NewTestCollector()
+	log.SetOutput(ioutil.Discard)
+	deadLetter, dir := GetTestDeadLetter(t)
+	collector.SetDeadLetter(deadLetter)
+	defer os.RemoveAll(dir)
+	deadLetter.Run(10 * time.Millisecond)
+
+	client := &PoisonStorageClient{CountingStorageClient{Buffered: true}}
+	sink := collector.NewSinkWithClient(&SinkConfig{Name: "archive", Dialect: "s3", MaxWorkerSize: 1, MaxQueueSize: 10, BufferSize: 3, Overflow: OVERFLOW_BLOCK}, client)
+	collector.sinks = []*Sink{sink}
+	sink.Dispatcher.Run()
+
+	poison := GetTestEvent(901)
+	poison.Event = "Poison"
+	collector.PublishEvents([]*dialects.Event{GetTestEvent(900), poison, GetTestEvent(902)}, tracing.SpanContext{})
+	for i := 0; i < 100 && deadLetter.GetStatus().Written != 1; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if exp := 2; client.GetSaved() != exp {
+		t.Errorf("Expected number of saved events was %d but it was %d instead", exp, client.GetSaved())
+	}
+	if exp := int64(1); deadLetter.GetStatus().Written != exp {
+		t.Errorf("Expected number of dead-letter records was %d but it was %d instead", exp, deadLetter.GetStatus().Written)
+	}
+	collector.StopSinks()
+	deadLetter.Stop()
+}
@@ -0,0 +1,564 @@
+package collector
+
+import (
+	"github.com/wunderlist/hamustro/src/dialects"
+	"github.com/wunderlist/hamustro/src/logging"
+	"github.com/wunderlist/hamustro/src/tracing"
+	"sync"
+	"time"
+)
+
+// Interval of the automatic scaling checks
+const SCALE_INTERVAL = time.Second
+
+// Time while the queue must stay above the threshold before adding workers
+const SCALE_UP_PERIOD = 5 * time.Second
+
+// A pool of workers channels that are registered with the dispatcher.
+type Dispatcher struct {
+	sync.Mutex               // Guards the workers and the options on reconfiguration
+	control       sync.Mutex // Keeps the order of the reconfigurations sent to the workers
+	WorkerPool    chan *Worker
+	Workers       []*Worker
+	MaxWorkers    int
+	WorkerOptions *WorkerOptions
+	Scaling       *ScalingOptions // Scales the workers between the minimum and MaxWorkers (optional)
+	FirstWorkerID int             // ID of the first worker, the IDs of the removed workers are not reused
+	started       int             // Number of the workers started so far
+	dispatching   bool
+	stopped       bool
+	scaledAt      time.Time     // Time of the last scaling step
+	busySince     time.Time     // The queue is above the threshold since
+	idleSince     time.Time     // Some workers are waiting for jobs since
+	flushStop     chan struct{} // Stops the automatic flushes
+}
+
+// Options of the automatic worker scaling
+type ScalingOptions struct {
+	MinWorkers     int           // Number of workers on start and after the idle periods
+	QueueThreshold int           // Workers are added when the queue stays above it
+	IdleTime       time.Duration // A worker is retired when some workers are idle for this long
+	Cooldown       time.Duration // Minimum time between two scaling steps
+}
+
+// Options for worker creation
+type FlushOptions struct {
+	Automatic bool
+}
+
+// Creates a new dispatcher to handle new job requests
+func NewDispatcher(maxWorkers int, options *WorkerOptions) *Dispatcher {
+	pool := make(chan *Worker, maxWorkers)
+	return &Dispatcher{
+		WorkerPool:    pool,
+		WorkerOptions: options,
+		MaxWorkers:    maxWorkers}
+}
+
+// Returns the buffer size for a single worker
+func (d *Dispatcher) GetBufferSize(n int) int {
+	return d.GetSpreadBufferSize(n, d.MaxWorkers)
+}
+
+// Returns the buffer size of the nth worker when the buffers are
+// spread over the given number of workers
+func (d *Dispatcher) GetSpreadBufferSize(n int, workers int) int {
+	if !d.WorkerOptions.SpreadBuffer || workers < 2 {
+		return d.WorkerOptions.BufferSize
+	}
+	slizeSize := int(d.WorkerOptions.BufferSize / (2 * (workers - 1)))
+	return int(float32(d.WorkerOptions.BufferSize)*0.75) + (n * slizeSize)
+}
+
+// Returns the memory ceiling of a single worker when the sink's
+// ceiling is shared by the given number of workers
+func (d *Dispatcher) GetWorkerMemoryLimit(workers int) int64 {
+	if workers < 2 {
+		return d.WorkerOptions.MemoryLimit
+	}
+	return d.WorkerOptions.MemoryLimit / int64(workers)
+}
+
+// Returns the dispatcher's logger
+func (d *Dispatcher) GetLogger() *logging.Logger {
+	return d.WorkerOptions.Sink.GetLogger("dispatcher")
+}
+
+// Returns the collector of the dispatcher's sink
+func (d *Dispatcher) GetCollector() *Collector {
+	return d.WorkerOptions.Sink.Collector
+}
+
+// Returns the storage client of the dispatcher's sink
+func (d *Dispatcher) GetStorageClient() dialects.StorageClient {
+	return d.WorkerOptions.Sink.GetStorageClient()
+}
+
+// Returns the job queue of the dispatcher's sink
+func (d *Dispatcher) GetJobQueue() chan Job {
+	return d.WorkerOptions.Sink.JobQueue
+}
+
+// Creates and starts the workers, it's the minimum number
+// of workers if they are scaled
+func (d *Dispatcher) Start() {
+	d.Lock()
+	defer d.Unlock()
+	if d.Scaling != nil {
+		d.startWorkers(d.Scaling.MinWorkers)
+		return
+	}
+	d.startWorkers(d.MaxWorkers)
+}
+
+// Creates and starts the workers up to the given number,
+// their buffers are spread over the given number of workers
+func (d *Dispatcher) startWorkers(n int) {
+	for i := len(d.Workers); i < n; i++ {
+		options := &WorkerOptions{
+			BufferSize:    d.GetSpreadBufferSize(i, n),
+			MaxBytes:      d.WorkerOptions.MaxBytes,
+			MaxAge:        d.WorkerOptions.MaxAge,
+			MaxInFlight:   d.WorkerOptions.MaxInFlight,
+			RetryAttempt:  d.WorkerOptions.RetryAttempt,
+			FlushInterval: d.WorkerOptions.FlushInterval,
+			MemoryLimit:   d.GetWorkerMemoryLimit(n),
+			Affinity:      d.WorkerOptions.Affinity,
+			Sink:          d.WorkerOptions.Sink}
+
+		// Create a new worker with a new ID, so its batches never get
+		// the names of a removed worker's batches
+		worker := NewWorker(d.FirstWorkerID+d.started, options, d.WorkerPool)
+		d.started++
+		worker.Start()
+
+		// Add the worker into the list
+		d.Workers = append(d.Workers, worker)
+	}
+}
+
+// Removes the workers above the given number and starts the new ones
+// up to it, returns the removed workers that have to be stopped
+func (d *Dispatcher) resize(n int) []*Worker {
+	removed := []*Worker{}
+	for len(d.Workers) > n {
+		removed = append(removed, d.Workers[len(d.Workers)-1])
+		d.Workers = d.Workers[:len(d.Workers)-1]
+	}
+	d.startWorkers(n)
+	return removed
+}
+
+// Returns the current limits of every worker, the buffers and the
+// memory ceiling are spread over the running workers
+func (d *Dispatcher) reconfigureWorkers() []*ReconfigureAction {
+	actions := []*ReconfigureAction{}
+	for i, worker := range d.Workers {
+		actions = append(actions, &ReconfigureAction{
+			TargetWorkerID: worker.ID,
+			BufferSize:     d.GetSpreadBufferSize(i, len(d.Workers)),
+			MaxBytes:       d.WorkerOptions.MaxBytes,
+			MaxAge:         d.WorkerOptions.MaxAge,
+			MaxInFlight:    d.WorkerOptions.MaxInFlight,
+			RetryAttempt:   d.WorkerOptions.RetryAttempt,
+			FlushInterval:  d.WorkerOptions.FlushInterval,
+			MemoryLimit:    d.GetWorkerMemoryLimit(len(d.Workers)),
+			worker:         worker})
+	}
+	return actions
+}
+
+// Sends the new limits to the workers and stops the removed ones, it
+// has to be called without the dispatcher's lock (a worker may be busy
+// with an upload) and the wait group is done after the removed workers
+// saved their buffers
+func (d *Dispatcher) applyWorkers(actions []*ReconfigureAction, removed []*Worker, wg *sync.WaitGroup) {
+	for _, worker := range removed {
+		wg.Add(1)
+		worker.Stop(wg)
+	}
+	for _, action := range actions {
+		action.worker.Deliver(action)
+	}
+}
+
+// Changes the sink's memory ceiling, it's shared by the running workers
+func (d *Dispatcher) SetMemoryLimit(limit int64) {
+	d.control.Lock()
+	defer d.control.Unlock()
+	d.Lock()
+	d.WorkerOptions.MemoryLimit = limit
+	actions := d.reconfigureWorkers()
+	d.Unlock()
+	d.applyWorkers(actions, nil, nil)
+}
+
+// Returns the running workers
+func (d *Dispatcher) GetWorkers() []*Worker {
+	d.Lock()
+	defer d.Unlock()
+	workers := make([]*Worker, len(d.Workers))
+	copy(workers, d.Workers)
+	return workers
+}
+
+// Returns the maximum number of workers
+func (d *Dispatcher) GetMaxWorkers() int {
+	d.Lock()
+	defer d.Unlock()
+	return d.MaxWorkers
+}
+
+// Returns the interval of the automatic flushes
+func (d *Dispatcher) GetFlushInterval() time.Duration {
+	d.Lock()
+	defer d.Unlock()
+	return d.WorkerOptions.FlushInterval
+}
+
+// Start automatic flush process, it follows the changes of the interval
+// and it stops with the workers
+func (d *Dispatcher) TickAutomaticFlush() {
+	d.Lock()
+	d.flushStop = make(chan struct{})
+	stop := d.flushStop
+	d.Unlock()
+	go func() {
+		for {
+			tickerInterval := 60 * time.Second
+			if interval := d.GetFlushInterval(); interval != 0 && interval < tickerInterval {
+				tickerInterval = interval
+			}
+			timer := time.NewTimer(tickerInterval)
+			select {
+			case <-timer.C:
+			case <-stop:
+				timer.Stop()
+				return
+			}
+			if d.GetFlushInterval() != 0 {
+				d.Flush(&FlushOptions{Automatic: true})
+			}
+		}
+	}()
+}
+
+// Stops the automatic flush process
+func (d *Dispatcher) StopAutomaticFlush() {
+	d.Lock()
+	defer d.Unlock()
+	if d.flushStop != nil {
+		close(d.flushStop)
+		d.flushStop = nil
+	}
+}
+
+// Flush all the workers, the automatic flush is skipped by the
+// workers that were saved within the flush interval
+func (d *Dispatcher) Flush(o *FlushOptions) {
+	// Buffers are kept until the uploads are resumed
+	if d.GetCollector().IsUploadPaused() {
+		return
+	}
+	for _, worker := range d.GetWorkers() {
+		// The worker has pending flushes if its control channel is full
+		if !worker.Control(&FlushAction{TargetWorkerID: worker.ID, Automatic: o.Automatic}) {
+			d.GetLogger().Debugf("Flush is skipped for %d worker because its control channel is full", worker.ID)
+		}
+	}
+}
+
+// Changes the number of workers and the limits of the running workers,
+// the removed workers save their buffers before they stop
+func (d *Dispatcher) Reconfigure(maxWorkers int, options *WorkerOptions) {
+	d.control.Lock()
+	defer d.control.Unlock()
+	d.Lock()
+	d.WorkerOptions.BufferSize = options.BufferSize
+	d.WorkerOptions.MaxBytes = options.MaxBytes
+	d.WorkerOptions.MaxAge = options.MaxAge
+	d.WorkerOptions.MaxInFlight = options.MaxInFlight
+	d.WorkerOptions.RetryAttempt = options.RetryAttempt
+	d.WorkerOptions.FlushInterval = options.FlushInterval
+	d.WorkerOptions.SpreadBuffer = options.SpreadBuffer
+
+	// The scaled workers stay between the minimum and the new maximum
+	n := maxWorkers
+	if d.Scaling != nil {
+		n = len(d.Workers)
+		if n > maxWorkers {
+			n = maxWorkers
+		}
+		if n < d.Scaling.MinWorkers {
+			n = d.Scaling.MinWorkers
+		}
+	}
+
+	// Stops the removed workers and starts the new ones
+	var wg sync.WaitGroup
+	if maxWorkers != d.MaxWorkers {
+		d.GetLogger().Infof("Changing the number of workers from %d to %d", d.MaxWorkers, maxWorkers)
+	}
+	d.MaxWorkers = maxWorkers
+	removed := d.resize(n)
+	actions := d.reconfigureWorkers()
+	d.Unlock()
+	d.applyWorkers(actions, removed, &wg)
+	wg.Wait()
+}
+
+// Adds or retires workers based on the queue, returns the change of
+// the number of workers. The workers are doubled (up to MaxWorkers)
+// when the queue stays above the threshold, and a worker is retired
+// (after it saved its buffer) when some of the workers are idle and
+// the queue is empty for the idle time. Nothing is changed within the
+// cooldown of the last step.
+func (d *Dispatcher) Scale(now time.Time) int {
+	d.control.Lock()
+	defer d.control.Unlock()
+	d.Lock()
+	if d.Scaling == nil || d.stopped {
+		d.Unlock()
+		return 0
+	}
+	queued, idle := len(d.GetJobQueue())+d.getAffinityLength(), len(d.WorkerPool)
+	if queued <= d.Scaling.QueueThreshold {
+		d.busySince = time.Time{}
+	} else if d.busySince.IsZero() {
+		d.busySince = now
+	}
+	if queued != 0 || idle == 0 {
+		d.idleSince = time.Time{}
+	} else if d.idleSince.IsZero() {
+		d.idleSince = now
+	}
+	if now.Sub(d.scaledAt) < d.Scaling.Cooldown {
+		d.Unlock()
+		return 0
+	}
+
+	current := len(d.Workers)
+	n := current
+	if !d.busySince.IsZero() && now.Sub(d.busySince) >= SCALE_UP_PERIOD && current < d.MaxWorkers {
+		n = current * 2
+		if n == 0 {
+			n = 1
+		}
+		if n > d.MaxWorkers {
+			n = d.MaxWorkers
+		}
+	} else if !d.idleSince.IsZero() && now.Sub(d.idleSince) >= d.Scaling.IdleTime && current > d.Scaling.MinWorkers {
+		n = current - 1
+	}
+	if n == current {
+		d.Unlock()
+		return 0
+	}
+
+	d.GetLogger().Infof("Scaling the workers from %d to %d (%d queued events, %d idle workers)", current, n, queued, idle)
+	var wg sync.WaitGroup
+	removed := d.resize(n)
+	actions := []*ReconfigureAction{}
+	if d.WorkerOptions.SpreadBuffer || d.WorkerOptions.MemoryLimit != 0 {
+		actions = d.reconfigureWorkers()
+	}
+	d.scaledAt, d.busySince, d.idleSince = now, time.Time{}, time.Time{}
+	d.Unlock()
+	d.applyWorkers(actions, removed, &wg)
+	wg.Wait()
+	return n - current
+}
+
+// Start automatic scaling process, it stops with the workers
+func (d *Dispatcher) TickScaling() {
+	go func() {
+		ticker := time.NewTicker(SCALE_INTERVAL)
+		defer ticker.Stop()
+		for now := range ticker.C {
+			if d.IsStopped() {
+				return
+			}
+			d.Scale(now)
+		}
+	}()
+}
+
+// Returns true if the workers are stopped
+func (d *Dispatcher) IsStopped() bool {
+	d.Lock()
+	defer d.Unlock()
+	return d.stopped
+}
+
+// Returns the number of events waiting for the workers because of
+// their affinity
+func (d *Dispatcher) GetAffinityLength() int {
+	d.Lock()
+	defer d.Unlock()
+	return d.getAffinityLength()
+}
+
+func (d *Dispatcher) getAffinityLength() int {
+	n := 0
+	for _, worker := range d.Workers {
+		n += len(worker.AffinityChannel)
+	}
+	return n
+}
+
+// Returns the worker with the given ID
+func (d *Dispatcher) GetWorker(id int) *Worker {
+	for _, worker := range d.GetWorkers() {
+		if worker.ID == id {
+			return worker
+		}
+	}
+	return nil
+}
+
+// Creates and starts the workers and listen for new job requests
+func (d *Dispatcher) Run() {
+	d.Start()
+	if d.GetStorageClient().IsBufferedStorage() {
+		d.TickAutomaticFlush()
+	}
+	if d.Scaling != nil {
+		d.TickScaling()
+	}
+	d.dispatching = true
+	go d.dispatch()
+}
+
+// Stops accepting new events but keeps the buffered ones
+func (d *Dispatcher) PauseIngest() {
+	d.GetCollector().SetIngestionState(INGESTION_PAUSED)
+	d.GetLogger().Infof("Ingestion is paused")
+}
+
+// Starts accepting new events again
+func (d *Dispatcher) ResumeIngest() {
+	d.GetCollector().SetIngestionState(INGESTION_ACCEPTING)
+	d.GetLogger().Infof("Ingestion is resumed")
+}
+
+// Stops accepting new events and flushes everything we have
+func (d *Dispatcher) Drain() {
+	d.GetCollector().SetIngestionState(INGESTION_DRAINING)
+	d.GetLogger().Infof("Draining all workers ...")
+	d.Flush(&FlushOptions{Automatic: false})
+}
+
+// Stops uploading, the workers keep buffering the events
+func (d *Dispatcher) PauseUpload() {
+	d.GetCollector().SetUploadState(UPLOAD_PAUSED)
+	d.GetLogger().Infof("Uploads are paused")
+}
+
+// Starts uploading again and flushes everything that was held back
+func (d *Dispatcher) ResumeUpload() {
+	d.GetCollector().SetUploadState(UPLOAD_ACTIVE)
+	d.GetLogger().Infof("Uploads are resumed, flush all workers ...")
+	d.Flush(&FlushOptions{Automatic: false})
+}
+
+// Stops all the workers, they are not scaled or flushed afterwards
+func (d *Dispatcher) Stop() {
+	d.Lock()
+	d.stopped = true
+	d.Unlock()
+	d.StopAutomaticFlush()
+	var wg sync.WaitGroup
+	for _, worker := range d.GetWorkers() {
+		wg.Add(1)
+		worker.Stop(&wg)
+	}
+	wg.Wait()
+}
+
+// Stops the workers after every job in the queue is delivered to them,
+// the workers save their buffers before they stop
+func (d *Dispatcher) Shutdown() {
+	if !d.dispatching {
+		d.Stop()
+		return
+	}
+	done := make(chan struct{})
+	d.GetJobQueue() <- &ShutdownAction{Done: done}
+	<-done
+}
+
+// Send the selected job to the worker, the targeted jobs go to the
+// worker's control channel and the others to the first free worker
+func (d *Dispatcher) Send(job Job) {
+	if job.IsTargeted() {
+		if worker := d.GetWorker(job.GetTargetWorkerID()); worker != nil {
+			worker.Deliver(job)
+		} else {
+			d.GetLogger().Warnf("Targeted job is dropped because %d worker doesn't exist", job.GetTargetWorkerID())
+		}
+		return
+	}
+	if d.SendByAffinity(job) {
+		return
+	}
+	// The stopped workers can be still registered in the pool
+	for {
+		worker := <-d.WorkerPool
+		select {
+		case worker.JobChannel <- job:
+			return
+		case <-worker.done:
+		}
+	}
+}
+
+// Sends the event to the worker of its session or device, returns
+// false if it has no affinity or the worker's channel is full (the
+// first free worker gets it then)
+func (d *Dispatcher) SendByAffinity(job Job) bool {
+	action, ok := job.(*EventAction)
+	if !ok {
+		return false
+	}
+	d.Lock()
+	defer d.Unlock()
+	key := GetAffinityKey(action.GetEvent(), d.WorkerOptions.Affinity)
+	if key == "" || len(d.Workers) == 0 {
+		return false
+	}
+	worker := d.Workers[GetAffinityWorker(key, len(d.Workers))]
+	select {
+	case worker.AffinityChannel <- job:
+		return true
+	default:
+		d.WorkerOptions.Sink.Stats.AddRebalanced()
+		return false
+	}
+}
+
+// Records the time the event spent in the job queue
+func (d *Dispatcher) TraceQueueWait(job Job) {
+	action, ok := job.(*EventAction)
+	if !ok || !action.Trace.IsValid() {
+		return
+	}
+	span := d.GetCollector().GetTracer().StartAt("Dispatcher.queue_wait", tracing.SPAN_KIND_INTERNAL, action.Trace, action.EnqueuedAt)
+	span.SetAttribute("attempt", action.Attempt)
+	span.Finish()
+}
+
+// Listening for new job requests
+func (d *Dispatcher) dispatch() {
+	for {
+		select {
+		case job := <-d.GetJobQueue():
+			if shutdown, ok := job.(*ShutdownAction); ok {
+				d.Stop()
+				close(shutdown.Done)
+				return
+			}
+			d.TraceQueueWait(job)
+			d.Send(job)
+		}
+	}
+}
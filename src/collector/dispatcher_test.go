@@ -1,6 +1,7 @@
-package main
+package collector
 
 import (
+	"bytes"
 	"github.com/wunderlist/hamustro/src/dialects"
 	"io/ioutil"
 	"log"
@@ -16,7 +17,7 @@ func TestRetryAttemptNewDispatcher(t *testing.T) {
 	log.SetOutput(ioutil.Discard)
 
 	options := &WorkerOptions{RetryAttempt: 5}
-	dispatcher := NewDispatcher(4, options)
+	dispatcher := NewTestDispatcher(4, options)
 	dispatcher.Start()
 	if exp := 4; len(dispatcher.Workers) != exp {
 		t.Errorf("Expected worker's count was %d but it was %d instead", exp, len(dispatcher.Workers))
@@ -35,7 +36,7 @@ func TestNotSpreadBufferNewDispatcher(t *testing.T) {
 	log.SetOutput(ioutil.Discard)
 
 	options := &WorkerOptions{SpreadBuffer: false, BufferSize: 10000}
-	dispatcher := NewDispatcher(4, options)
+	dispatcher := NewTestDispatcher(4, options)
 	dispatcher.Start()
 	if exp := 4; len(dispatcher.Workers) != exp {
 		t.Errorf("Expected worker's count was %d but it was %d instead", exp, len(dispatcher.Workers))
@@ -54,7 +55,7 @@ func TestSpreadBufferNewDispatcher(t *testing.T) {
 	log.SetOutput(ioutil.Discard)
 
 	options := &WorkerOptions{SpreadBuffer: true, BufferSize: 10000}
-	dispatcher := NewDispatcher(3, options)
+	dispatcher := NewTestDispatcher(3, options)
 	dispatcher.Start()
 	if exp := 3; len(dispatcher.Workers) != exp {
 		t.Errorf("Expected worker's count was %d but it was %d instead", exp, len(dispatcher.Workers))
@@ -96,7 +97,7 @@ func TestDispatcherListen(t *testing.T) {
 
 	t.Log("Creates the dispatcher and listen for new jobs")
 	options := &WorkerOptions{RetryAttempt: 5}
-	dispatcher := NewDispatcher(2, options)
+	dispatcher := NewTestDispatcher(2, options)
 	dispatcher.Run()
 
 	if exp := 2; len(dispatcher.Workers) != exp {
@@ -104,10 +105,10 @@ func TestDispatcherListen(t *testing.T) {
 	}
 
 	t.Log("Creating two jobs and put it into the job queue")
-	job1 := EventAction{GetTestEvent(423432), 1}
+	job1 := EventAction{Event: GetTestEvent(423432), Attempt: 1}
 	expBuffer1, _ := dialects.ConvertJSON(job1.Event)
 
-	job2 := EventAction{GetTestEvent(7643329), 1}
+	job2 := EventAction{Event: GetTestEvent(7643329), Attempt: 1}
 	expBuffer2, _ := dialects.ConvertJSON(job2.Event)
 
 	t.Log("It should catch a different worker with the expected results")
@@ -135,7 +136,7 @@ func TestDispatcherFlush(t *testing.T) {
 
 	t.Log("Creates the dispatcher with a single worker and listen for new jobs")
 	options := &WorkerOptions{BufferSize: 3}
-	dispatcher := NewDispatcher(1, options)
+	dispatcher := NewTestDispatcher(1, options)
 	dispatcher.Run()
 
 	if exp := 1; len(dispatcher.Workers) != exp {
@@ -143,7 +144,7 @@ func TestDispatcherFlush(t *testing.T) {
 	}
 
 	t.Log("Creating a job and put it into the job queue")
-	job := EventAction{GetTestEvent(636284), 1}
+	job := EventAction{Event: GetTestEvent(636284), Attempt: 1}
 	expBuffer, _ := dialects.ConvertJSON(job.Event)
 
 	exp = map[string]struct{}{expBuffer.String(): {}}
@@ -167,15 +168,15 @@ func TestDispatcherFlush(t *testing.T) {
 
 // Testing the dispatcher listen function
 func TestDispatcherAutomaticFlush(t *testing.T) {
-	config = &Config{AutoFlushInterval: 3}   // Define the config
+	config = &Config{}                       // Define the config
 	storageClient = &BufferedStorageClient{} // Define the Buffered Storage as a storage
 	jobQueue = make(chan Job, 10)            //Define the job Queue
 	log.SetOutput(ioutil.Discard)            // Disable the logger
 	T, response, catched = t, nil, false     // Set properties
 
 	t.Log("Creates the dispatcher with a single worker and listen for new jobs")
-	options := &WorkerOptions{BufferSize: 3}
-	dispatcher := NewDispatcher(1, options)
+	options := &WorkerOptions{BufferSize: 3, FlushInterval: 3 * time.Second}
+	dispatcher := NewTestDispatcher(1, options)
 	dispatcher.Run()
 
 	if exp := 1; len(dispatcher.Workers) != exp {
@@ -183,7 +184,7 @@ func TestDispatcherAutomaticFlush(t *testing.T) {
 	}
 
 	t.Log("Creating a job and put it into the job queue")
-	job := EventAction{GetTestEvent(636284), 1}
+	job := EventAction{Event: GetTestEvent(636284), Attempt: 1}
 	expBuffer, _ := dialects.ConvertJSON(job.Event)
 
 	exp = map[string]struct{}{expBuffer.String(): {}}
@@ -209,6 +210,15 @@ func TestDispatcherAutomaticFlush(t *testing.T) {
 	if !catched {
 		t.Errorf("Worker didn't catch the job")
 	}
+
+	t.Log("Stopping the automatic flushes with the workers")
+	stop := dispatcher.flushStop
+	dispatcher.Stop()
+	select {
+	case <-stop:
+	default:
+		t.Errorf("Automatic flushes should be stopped with the workers")
+	}
 }
 
 // Testing the dispatcher listen function
@@ -229,10 +239,10 @@ func TestDispatcherWaitingForFlush(t *testing.T) {
 		MaxWorkers:    2}
 
 	t.Log("Create two workers and start them")
-	worker1 := NewWorker(1, workerOptions, dispatcher.WorkerPool)
+	worker1 := NewTestWorker(1, workerOptions, dispatcher.WorkerPool)
 	worker1.Start()
 
-	worker2 := NewWorker(2, workerOptions, dispatcher.WorkerPool)
+	worker2 := NewTestWorker(2, workerOptions, dispatcher.WorkerPool)
 	worker2.Start()
 
 	t.Log("Append workers to the dispatcher and start the dispatcher")
@@ -242,8 +252,8 @@ func TestDispatcherWaitingForFlush(t *testing.T) {
 	go dispatcher.dispatch()
 
 	t.Log("Create two events, and send these to the workers")
-	action1 := EventAction{GetTestEvent(33344), 1}
-	action2 := EventAction{GetTestEvent(88829), 1}
+	action1 := EventAction{Event: GetTestEvent(33344), Attempt: 1}
+	action2 := EventAction{Event: GetTestEvent(88829), Attempt: 1}
 
 	<-dispatcher.WorkerPool
 	<-dispatcher.WorkerPool
@@ -256,26 +266,26 @@ func TestDispatcherWaitingForFlush(t *testing.T) {
 	CheckResultsForBufferedStorage(worker1, 1, 1.0, 10)
 	CheckResultsForBufferedStorage(worker2, 1, 1.0, 10)
 
-	t.Log("Stop one of the workers randomly")
-	stopped_worker := <-dispatcher.WorkerPool
+	t.Log("Take one of the workers out of the pool (as if it was busy)")
+	busy_worker := <-dispatcher.WorkerPool
 	running_worker := worker2
-	if worker1.ID != stopped_worker.ID {
+	if worker1.ID != busy_worker.ID {
 		running_worker = worker1
 	}
 
 	t.Log("Flush all of the workers")
 	dispatcher.Flush(&FlushOptions{Automatic: false})
 
-	t.Log("Wait until the flush finish for the active worker")
+	t.Log("Wait until the flush finish, the targeted flush doesn't need the worker pool")
 	time.Sleep(150 * time.Millisecond)
 
 	ValidateSending()
 	CheckResultsForBufferedStorage(running_worker, 0, 1.0, 10)
-	CheckResultsForBufferedStorage(stopped_worker, 1, 1.0, 10)
+	CheckResultsForBufferedStorage(busy_worker, 0, 1.0, 10)
 
 	t.Log("Create two new event, and send these to the active worker")
-	action3 := EventAction{GetTestEvent(11122), 1}
-	action4 := EventAction{GetTestEvent(88765), 1}
+	action3 := EventAction{Event: GetTestEvent(11122), Attempt: 1}
+	action4 := EventAction{Event: GetTestEvent(88765), Attempt: 1}
 
 	jobQueue <- &action3
 	jobQueue <- &action4
@@ -287,16 +297,10 @@ func TestDispatcherWaitingForFlush(t *testing.T) {
 	}
 
 	CheckResultsForBufferedStorage(running_worker, 2, 1.0, 10)
-	CheckResultsForBufferedStorage(stopped_worker, 1, 1.0, 10)
+	CheckResultsForBufferedStorage(busy_worker, 0, 1.0, 10)
 
-	t.Log("Start the previously stopped worker")
-	dispatcher.WorkerPool <- stopped_worker
-
-	t.Log("This worker should catch the forgotten flush job")
-	time.Sleep(1 * time.Second)
-	ValidateSending()
-	CheckResultsForBufferedStorage(running_worker, 2, 1.0, 10)
-	CheckResultsForBufferedStorage(stopped_worker, 0, 1.0, 10)
+	t.Log("Put the busy worker back into the pool")
+	dispatcher.WorkerPool <- busy_worker
 
 	t.Log("Stop the workers and flush the events from the active worker")
 	var wg sync.WaitGroup
@@ -308,3 +312,180 @@ func TestDispatcherWaitingForFlush(t *testing.T) {
 	CheckResultsForBufferedStorage(worker1, 0, 1.0, 10)
 	CheckResultsForBufferedStorage(worker2, 0, 1.0, 10)
 }
+
+// Testing the reconfiguration of the running workers
+func TestDispatcherReconfigure(t *testing.T) {
+	config = &Config{}
+	storageClient = &BufferedStorageClientWithoutExpected{}
+	jobQueue = make(chan Job, 10)
+	log.SetOutput(ioutil.Discard)
+
+	t.Log("Creates the dispatcher with two workers and spread buffer")
+	dispatcher := NewTestDispatcher(2, &WorkerOptions{BufferSize: 100, SpreadBuffer: true, RetryAttempt: 1})
+	dispatcher.Run()
+
+	t.Log("Reconfigure the workers and wait until the workers are finished with it")
+	dispatcher.Reconfigure(2, &WorkerOptions{BufferSize: 200, SpreadBuffer: true, RetryAttempt: 3})
+	for _, w := range dispatcher.Workers {
+		done := make(chan struct{})
+		dispatcher.Send(&FlushAction{TargetWorkerID: w.ID, Done: done})
+		<-done
+	}
+	for i, exp := range []int{150, 250} {
+		if w := dispatcher.Workers[i]; w.BufferSize != exp || w.RetryAttempt != 3 {
+			t.Errorf("Expected %d worker's buffer size was %d and it was %d instead (retry attempt: %d)", w.ID, exp, w.BufferSize, w.RetryAttempt)
+		}
+	}
+
+	t.Log("Share the memory ceiling between the running workers")
+	dispatcher.SetMemoryLimit(1000)
+	for _, workers := range []int{2, 1} {
+		dispatcher.Reconfigure(workers, &WorkerOptions{BufferSize: 200, RetryAttempt: 3})
+		for _, w := range dispatcher.GetWorkers() {
+			done := make(chan struct{})
+			dispatcher.Send(&FlushAction{TargetWorkerID: w.ID, Done: done})
+			<-done
+			if exp := int64(1000 / workers); w.GetMemoryLimit() != exp {
+				t.Errorf("Expected %d worker's memory limit was %d with %d workers but it was %d instead", w.ID, exp, workers, w.GetMemoryLimit())
+			}
+		}
+	}
+	dispatcher.Stop()
+}
+
+// Tests that the workers are added when the queue stays above the
+// threshold and the idle workers are retired down to the minimum
+func TestDispatcherScaling(t *testing.T) {
+	config = &Config{}
+	storageClient = &BufferedStorageClientWithoutExpected{}
+	jobQueue = make(chan Job, 10)
+	log.SetOutput(ioutil.Discard)
+
+	t.Log("Creates the dispatcher with a single worker, it can be scaled up to 4 workers")
+	dispatcher := NewTestDispatcher(4, &WorkerOptions{BufferSize: 100, SpreadBuffer: true, RetryAttempt: 1})
+	dispatcher.Scaling = &ScalingOptions{MinWorkers: 1, QueueThreshold: 2, IdleTime: 10 * time.Second, Cooldown: 5 * time.Second}
+	dispatcher.Start()
+	if exp := 1; len(dispatcher.GetWorkers()) != exp {
+		t.Fatalf("Expected number of workers was %d but it was %d instead", exp, len(dispatcher.GetWorkers()))
+	}
+
+	t.Log("Filling the queue above the threshold")
+	for i := 0; i < 5; i++ {
+		jobQueue <- &EventAction{Event: GetTestEvent(uint32(1100 + i)), Attempt: 1}
+	}
+	start := time.Now()
+	cases := []struct {
+		After           time.Duration
+		ExpectedChange  int
+		ExpectedWorkers int
+	}{
+		{0, 0, 1},                // The queue is above the threshold from now
+		{5 * time.Second, 1, 2},  // It stayed above the threshold
+		{6 * time.Second, 0, 2},  // Cooldown
+		{11 * time.Second, 2, 4}, // Doubled again
+		{30 * time.Second, 0, 4}} // Maximum is reached
+	for i, c := range cases {
+		if change := dispatcher.Scale(start.Add(c.After)); change != c.ExpectedChange || len(dispatcher.GetWorkers()) != c.ExpectedWorkers {
+			t.Errorf("Expected %d. change was %d (%d workers) but it was %d (%d workers) instead", i+1, c.ExpectedChange, c.ExpectedWorkers, change, len(dispatcher.GetWorkers()))
+		}
+	}
+
+	t.Log("The buffers are spread over the running workers")
+	for _, w := range dispatcher.GetWorkers() {
+		done := make(chan struct{})
+		dispatcher.Send(&FlushAction{TargetWorkerID: w.ID, Done: done})
+		<-done
+	}
+	for i, exp := range []int{75, 91, 107, 123} {
+		if w := dispatcher.GetWorkers()[i]; w.BufferSize != exp {
+			t.Errorf("Expected %d worker's buffer size was %d but it was %d instead", w.ID, exp, w.BufferSize)
+		}
+	}
+
+	t.Log("Emptying the queue, the idle workers are retired one by one")
+	for len(jobQueue) != 0 {
+		<-jobQueue
+	}
+	for i := 0; i < 100 && len(dispatcher.WorkerPool) == 0; i++ {
+		time.Sleep(time.Millisecond)
+	}
+	cases = []struct {
+		After           time.Duration
+		ExpectedChange  int
+		ExpectedWorkers int
+	}{
+		{40 * time.Second, 0, 4},  // The workers are idle from now
+		{45 * time.Second, 0, 4},  // Within the idle time
+		{50 * time.Second, -1, 3}, // Idle for 10 seconds
+		{80 * time.Second, 0, 3},  // Idle again from now
+		{90 * time.Second, -1, 2}}
+	for i, c := range cases {
+		if change := dispatcher.Scale(start.Add(c.After)); change != c.ExpectedChange || len(dispatcher.GetWorkers()) != c.ExpectedWorkers {
+			t.Errorf("Expected %d. change was %d (%d workers) but it was %d (%d workers) instead", i+1, c.ExpectedChange, c.ExpectedWorkers, change, len(dispatcher.GetWorkers()))
+		}
+	}
+
+	t.Log("Stopped workers are not scaled")
+	dispatcher.Stop()
+	if change := dispatcher.Scale(start.Add(time.Hour)); change != 0 {
+		t.Errorf("Stopped dispatcher should not be scaled but it was changed by %d", change)
+	}
+}
+
+// Storage Client for the benchmarks that keeps the workers busy
+type SlowStorageClient struct {
+	CountingStorageClient
+}
+
+func (c *SlowStorageClient) Save(msg *bytes.Buffer) error {
+	time.Sleep(time.Millisecond)
+	return c.CountingStorageClient.Save(msg)
+}
+
+// Measures the latency of the targeted flushes, the workers are
+// busy with events and the job queue is full if it's saturated
+func RunFlushLatencyBenchmark(b *testing.B, saturated bool) {
+	config = &Config{}
+	collector := NewTestCollector()
+	log.SetOutput(ioutil.Discard)
+	sink := collector.NewSinkWithClient(&SinkConfig{Name: "benchmark", MaxWorkerSize: 4, MaxQueueSize: 100, RetryAttempt: 1, Overflow: OVERFLOW_BLOCK}, &SlowStorageClient{})
+	collector.sinks = []*Sink{sink}
+	sink.Dispatcher.Run()
+
+	stop := make(chan struct{})
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		for saturated {
+			select {
+			case sink.JobQueue <- &EventAction{Event: GetTestEvent(1), Attempt: 1}:
+			case <-stop:
+				return
+			}
+		}
+	}()
+	time.Sleep(10 * time.Millisecond)
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		worker := sink.Dispatcher.Workers[i%len(sink.Dispatcher.Workers)]
+		done := make(chan struct{})
+		sink.Dispatcher.Send(&FlushAction{TargetWorkerID: worker.ID, Done: done})
+		<-done
+	}
+	b.StopTimer()
+
+	close(stop)
+	<-stopped
+	collector.StopSinks()
+}
+
+// Flush latency while every worker is waiting for events
+func BenchmarkFlushLatencyIdle(b *testing.B) {
+	RunFlushLatencyBenchmark(b, false)
+}
+
+// Flush latency while every worker is busy and the job queue is full
+func BenchmarkFlushLatencySaturated(b *testing.B) {
+	RunFlushLatencyBenchmark(b, true)
+}
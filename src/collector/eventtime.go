@@ -0,0 +1,141 @@
+package collector
+
+import (
+	"fmt"
+	"github.com/wunderlist/hamustro/src/dialects"
+	"sync/atomic"
+	"time"
+)
+
+// Define the policies of the late and the future events
+const EVENT_TIME_ACCEPT = "accept" // The event is saved with its own time
+const EVENT_TIME_CLAMP = "clamp"   // The event time is replaced with the server time
+const EVENT_TIME_ROUTE = "route"   // The event is sent to the late event sinks (or path) only
+const EVENT_TIME_REJECT = "reject" // The event is dropped
+
+// Format of the event time
+const EVENT_TIME_FORMAT = "2006-01-02 15:04:05"
+
+// Is the event time policy supported
+func IsValidEventTimePolicy(policy string) bool {
+	return policy == EVENT_TIME_ACCEPT || policy == EVENT_TIME_CLAMP || policy == EVENT_TIME_ROUTE || policy == EVENT_TIME_REJECT
+}
+
+// Counters of the late or the future events by their outcome
+type EventTimeCounters struct {
+	Accepted int64 `json:"accepted"`
+	Clamped  int64 `json:"clamped"`
+	Routed   int64 `json:"routed"`
+	Rejected int64 `json:"rejected"`
+}
+
+// Counts an event with the policy's outcome
+func (c *EventTimeCounters) Add(policy string) {
+	switch policy {
+	case EVENT_TIME_ACCEPT:
+		atomic.AddInt64(&c.Accepted, 1)
+	case EVENT_TIME_CLAMP:
+		atomic.AddInt64(&c.Clamped, 1)
+	case EVENT_TIME_ROUTE:
+		atomic.AddInt64(&c.Routed, 1)
+	case EVENT_TIME_REJECT:
+		atomic.AddInt64(&c.Rejected, 1)
+	}
+}
+
+// Returns a copy of the counters
+func (c *EventTimeCounters) Get() *EventTimeCounters {
+	return &EventTimeCounters{
+		Accepted: atomic.LoadInt64(&c.Accepted),
+		Clamped:  atomic.LoadInt64(&c.Clamped),
+		Routed:   atomic.LoadInt64(&c.Routed),
+		Rejected: atomic.LoadInt64(&c.Rejected)}
+}
+
+// Compares the events' time with the server time, the events older
+// than the late age or newer than the future skew are handled with
+// their own policy
+type EventTimePolicy struct {
+	Late           string
+	Future         string
+	LateAge        time.Duration
+	FutureSkew     time.Duration
+	Sinks          []*Sink // Sinks of the routed events (the routing table's sinks without them)
+	Path           string  // Path of the routed events within their sinks (optional)
+	LateCounters   EventTimeCounters
+	FutureCounters EventTimeCounters
+}
+
+// Creates the event time policy based on the configuration
+func NewEventTimePolicy(config *Config, available []*Sink) (*EventTimePolicy, error) {
+	p := &EventTimePolicy{
+		Late:       config.GetLateEventPolicy(),
+		Future:     config.GetFutureEventPolicy(),
+		LateAge:    time.Duration(config.GetLateEventAge()) * time.Minute,
+		FutureSkew: time.Duration(config.GetFutureEventSkew()) * time.Minute}
+	if !IsValidEventTimePolicy(p.Late) {
+		return nil, fmt.Errorf("Not supported `%s` late_event_policy (use `accept`, `clamp`, `route` or `reject`).", p.Late)
+	}
+	if !IsValidEventTimePolicy(p.Future) {
+		return nil, fmt.Errorf("Not supported `%s` future_event_policy (use `accept`, `clamp`, `route` or `reject`).", p.Future)
+	}
+	if p.Late == EVENT_TIME_ROUTE || p.Future == EVENT_TIME_ROUTE {
+		sinks, err := GetSinksByName(config.LateEventSinks, available)
+		if err != nil {
+			return nil, err
+		}
+		if len(sinks) == 0 && config.LateEventPath == "" {
+			return nil, fmt.Errorf("The routed late and future events have no `late_event_sinks` or `late_event_path`.")
+		}
+		p.Sinks = sinks
+		p.Path = config.LateEventPath
+	}
+	return p, nil
+}
+
+// Applies the policy on the event at the given server time and
+// returns the outcome. The clamped event gets the server time and
+// keeps its own time in `original_at`, the routed event gets the
+// late event path. The events with invalid time are accepted.
+func (p *EventTimePolicy) Apply(event *dialects.Event, now time.Time) string {
+	if p == nil {
+		return EVENT_TIME_ACCEPT
+	}
+	at, err := time.Parse(EVENT_TIME_FORMAT, event.At)
+	if err != nil {
+		return EVENT_TIME_ACCEPT
+	}
+	var policy string
+	var counters *EventTimeCounters
+	switch {
+	case at.Before(now.Add(-p.LateAge)):
+		policy, counters = p.Late, &p.LateCounters
+	case at.After(now.Add(p.FutureSkew)):
+		policy, counters = p.Future, &p.FutureCounters
+	default:
+		return EVENT_TIME_ACCEPT
+	}
+	switch policy {
+	case EVENT_TIME_CLAMP:
+		event.OriginalAt = event.At
+		event.At = now.UTC().Format(EVENT_TIME_FORMAT)
+	case EVENT_TIME_ROUTE:
+		event.Path = p.Path
+	}
+	counters.Add(policy)
+	return policy
+}
+
+// Counters of the late and the future events for the stats endpoint
+type EventTimeStatus struct {
+	Late   *EventTimeCounters `json:"late"`
+	Future *EventTimeCounters `json:"future"`
+}
+
+// Returns the counters of the event time policy
+func (c *Collector) GetEventTimeStatus() *EventTimeStatus {
+	if c.eventTime == nil {
+		return &EventTimeStatus{&EventTimeCounters{}, &EventTimeCounters{}}
+	}
+	return &EventTimeStatus{c.eventTime.LateCounters.Get(), c.eventTime.FutureCounters.Get()}
+}
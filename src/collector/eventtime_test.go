@@ -0,0 +1,174 @@
+package collector
+
+import (
+	"github.com/wunderlist/hamustro/src/dialects"
+	"github.com/wunderlist/hamustro/src/dialects/file"
+	"github.com/wunderlist/hamustro/src/tracing"
+	"github.com/wunderlist/hamustro/src/wal"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Tests the event time policy's validation
+func TestFunctionNewEventTimePolicyValidation(t *testing.T) {
+	available := GetTestRoutingSinks("archive", "late")
+	cases := []*Config{
+		{LateEventPolicy: "drop"},
+		{FutureEventPolicy: "fix"},
+		{LateEventPolicy: EVENT_TIME_ROUTE},
+		{FutureEventPolicy: EVENT_TIME_ROUTE, LateEventSinks: []string{"unknown"}}}
+	for i, c := range cases {
+		if _, err := NewEventTimePolicy(c, available); err == nil {
+			t.Errorf("%d. event time policy should be invalid", i+1)
+		}
+	}
+
+	t.Log("Every event is accepted by default")
+	p, err := NewEventTimePolicy(&Config{}, available)
+	if err != nil {
+		t.Fatalf("Event time policy should be valid: %s", err.Error())
+	}
+	if p.Late != EVENT_TIME_ACCEPT || p.Future != EVENT_TIME_ACCEPT || p.LateAge != 24*time.Hour || p.FutureSkew != 10*time.Minute {
+		t.Errorf("Event time policy has unexpected defaults: %+v", p)
+	}
+}
+
+// Tests the outcomes of the late and the future events
+func TestEventTimePolicyApply(t *testing.T) {
+	now := time.Date(2016, 2, 5, 15, 0, 0, 0, time.UTC)
+	p := &EventTimePolicy{Late: EVENT_TIME_CLAMP, Future: EVENT_TIME_REJECT, LateAge: time.Hour, FutureSkew: time.Minute}
+
+	cases := []struct {
+		At         string
+		Expected   string
+		At2        string
+		OriginalAt string
+	}{
+		{"2016-02-05 14:30:00", EVENT_TIME_ACCEPT, "2016-02-05 14:30:00", ""},
+		{"2016-02-05 15:00:59", EVENT_TIME_ACCEPT, "2016-02-05 15:00:59", ""},
+		{"1970-01-01 00:00:00", EVENT_TIME_CLAMP, "2016-02-05 15:00:00", "1970-01-01 00:00:00"},
+		{"2016-02-05 13:59:59", EVENT_TIME_CLAMP, "2016-02-05 15:00:00", "2016-02-05 13:59:59"},
+		{"2036-02-05 15:00:00", EVENT_TIME_REJECT, "2036-02-05 15:00:00", ""},
+		{"yesterday", EVENT_TIME_ACCEPT, "yesterday", ""}}
+	for i, c := range cases {
+		event := &dialects.Event{At: c.At}
+		if outcome := p.Apply(event, now); outcome != c.Expected {
+			t.Errorf("%d. expected outcome was %s but it was %s instead", i+1, c.Expected, outcome)
+		}
+		if event.At != c.At2 || event.OriginalAt != c.OriginalAt {
+			t.Errorf("%d. expected event time was %s (%s) but it was %s (%s) instead", i+1, c.At2, c.OriginalAt, event.At, event.OriginalAt)
+		}
+		if b, _ := dialects.ConvertCSVWithOriginalAt(event); !strings.HasSuffix(b.String(), "\001"+c.OriginalAt+"\n") {
+			t.Errorf("%d. original event time %s is missing from the CSV line `%s`", i+1, c.OriginalAt, b.String())
+		}
+	}
+
+	t.Log("Testing the counters of the outcomes")
+	if late := p.LateCounters.Get(); late.Clamped != 2 || late.Accepted != 0 || late.Rejected != 0 {
+		t.Errorf("Late events have unexpected counters: %+v", late)
+	}
+	if future := p.FutureCounters.Get(); future.Rejected != 1 || future.Clamped != 0 {
+		t.Errorf("Future events have unexpected counters: %+v", future)
+	}
+}
+
+// Tests that the late events are published to the late event sinks
+// only and the future events are dropped
+func TestPublishEventWithEventTimePolicy(t *testing.T) {
+	sinks := GetTestRoutingSinks("archive", "late")
+	collector := sinks[0].Collector
+	collector.sinks = sinks
+	var err error
+	if collector.router, err = NewRouter(nil, []string{"archive"}, sinks); err != nil {
+		t.Fatal(err)
+	}
+	collector.eventTime, err = NewEventTimePolicy(&Config{LateEventPolicy: EVENT_TIME_ROUTE, FutureEventPolicy: EVENT_TIME_REJECT, LateEventSinks: []string{"late"}}, sinks)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	now := time.Now().UTC()
+	collector.PublishEvent(&dialects.Event{At: now.Format(EVENT_TIME_FORMAT)}, tracing.SpanContext{})
+	collector.PublishEvent(&dialects.Event{At: now.Add(-48 * time.Hour).Format(EVENT_TIME_FORMAT)}, tracing.SpanContext{})
+	collector.PublishEvent(&dialects.Event{At: now.Add(48 * time.Hour).Format(EVENT_TIME_FORMAT)}, tracing.SpanContext{})
+
+	if exp := 1; len(sinks[0].JobQueue) != exp {
+		t.Errorf("Expected queue length of archive was %d but it was %d instead", exp, len(sinks[0].JobQueue))
+	}
+	if exp := 1; len(sinks[1].JobQueue) != exp {
+		t.Errorf("Expected queue length of late was %d but it was %d instead", exp, len(sinks[1].JobQueue))
+	}
+	status := collector.GetEventTimeStatus()
+	if status.Late.Routed != 1 || status.Future.Rejected != 1 {
+		t.Errorf("Event time policy has unexpected counters: %+v %+v", status.Late, status.Future)
+	}
+
+	t.Log("The re-driven late events are not routed again")
+	collector.RedriveRecords([]*DeadLetterRecord{{Event: &dialects.Event{At: "2016-02-05 15:00:00"}, Sink: "removed"}})
+	if exp := 2; len(sinks[0].JobQueue) != exp {
+		t.Errorf("Expected queue length of archive was %d but it was %d instead", exp, len(sinks[0].JobQueue))
+	}
+}
+
+// Tests that the routed late events are saved into the late event path
+// of the routed sinks, also after they're replayed from the write-ahead log
+func TestLateEventPath(t *testing.T) {
+	config = &Config{}
+	collector := NewTestCollector()
+	log.SetOutput(ioutil.Discard)
+	dir, err := ioutil.TempDir("", "hamustro-late")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	options := &wal.Options{Dir: filepath.Join(dir, "wal"), Sync: wal.SYNC_ALWAYS}
+	sinkConfig := &SinkConfig{Name: "archive", MaxWorkerSize: 1, MaxQueueSize: 10, BufferSize: 10, Overflow: OVERFLOW_BLOCK}
+	newClient := func() *file.FileStorage {
+		return &file.FileStorage{FilePath: filepath.Join(dir, "events"), FileFormat: "json", BatchConverter: dialects.ConvertBatchJSON}
+	}
+
+	t.Log("Publishing a fresh and a late event without processing them")
+	crashed := collector.NewSinkWithClient(sinkConfig, newClient())
+	if _, err := crashed.OpenWAL(options); err != nil {
+		t.Fatal(err)
+	}
+	collector.sinks = []*Sink{crashed}
+	if collector.eventTime, err = NewEventTimePolicy(&Config{LateEventPolicy: EVENT_TIME_ROUTE, LateEventPath: filepath.Join(dir, "late")}, collector.sinks); err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now().UTC()
+	for _, at := range []time.Time{now, now.Add(-48 * time.Hour)} {
+		event := GetTestEvent(760)
+		event.At = at.Format(EVENT_TIME_FORMAT)
+		if err := collector.PublishEvent(event, tracing.SpanContext{}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	t.Log("Replaying the events and saving them into their paths")
+	restarted := collector.NewSinkWithClient(sinkConfig, newClient())
+	records, err := restarted.OpenWAL(options)
+	if err != nil {
+		t.Fatal(err)
+	}
+	collector.sinks = []*Sink{restarted}
+	restarted.Dispatcher.Run()
+	restarted.Replay(records)
+	collector.StopSinks()
+	if n := GetTestObjectCount(filepath.Join(dir, "events")); n != 1 {
+		t.Errorf("Expected number of normal objects was %d but it was %d instead", 1, n)
+	}
+	if n := GetTestObjectCount(filepath.Join(dir, "late")); n != 1 {
+		t.Errorf("Expected number of late objects was %d but it was %d instead", 1, n)
+	}
+
+	t.Log("Rejecting the route without late event sinks and path")
+	if _, err := NewEventTimePolicy(&Config{LateEventPolicy: EVENT_TIME_ROUTE}, collector.sinks); err == nil {
+		t.Errorf("Route without late event sinks and path should be rejected")
+	}
+}
@@ -0,0 +1,19 @@
+package collector
+
+import (
+	"net/http"
+)
+
+// Controller for `/api/flush`
+func (c *Collector) FlushHandler(w http.ResponseWriter, r *http.Request) {
+	if !c.AuthorizeMaintenance(w, r, "flush") {
+		return
+	}
+
+	c.logger.Component("dispatcher").Infof("Flush all workers ...")
+	for _, d := range c.GetDispatchers() {
+		d.Flush(&FlushOptions{Automatic: false})
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
@@ -0,0 +1,116 @@
+package collector
+
+import (
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// Correct and incorrect header generation functions
+type FlushHeaderFunction func(method string, uri string) map[string]string
+
+// Returns a signed maintenance header for the given key and time
+func GetSignedFlushHeader(keyName string, key string, method string, uri string, at time.Time) map[string]string {
+	rTime := strconv.FormatInt(at.Unix(), 10)
+	return map[string]string{
+		"X-Hamustro-Maintenance-Key-Id": keyName,
+		"X-Hamustro-Time":               rTime,
+		"X-Hamustro-Signature":          GetMaintenanceSignature(key, method, uri, rTime, []byte{})}
+}
+
+func GetMissingFlushHeader(method string, uri string) map[string]string {
+	return map[string]string{}
+}
+func GetFlushHeaderWithoutSignature(method string, uri string) map[string]string {
+	return map[string]string{"X-Hamustro-Time": strconv.FormatInt(time.Now().Unix(), 10)}
+}
+func GetFlushHeaderWithInvalidMaintenanceKey(method string, uri string) map[string]string {
+	return GetSignedFlushHeader("default", "fdsa43211", method, uri, time.Now())
+}
+func GetFlushHeaderWithUnknownKeyName(method string, uri string) map[string]string {
+	return GetSignedFlushHeader("intruder", "maintancekey", method, uri, time.Now())
+}
+func GetExpiredFlushHeader(method string, uri string) map[string]string {
+	return GetSignedFlushHeader("default", "maintancekey", method, uri, time.Now().Add(-1*time.Hour))
+}
+func GetValidFlushHeader(method string, uri string) map[string]string {
+	return GetSignedFlushHeader("default", "maintancekey", method, uri, time.Now())
+}
+func GetValidNamedFlushHeader(method string, uri string) map[string]string {
+	return GetSignedFlushHeader("ops", "opskey", method, uri, time.Now().Add(-1*time.Minute))
+}
+
+// Correct and incorrect config generation functions
+type FlushConfigFunction func() *Config
+
+func GetEmptyConfig() *Config {
+	return &Config{}
+}
+func GetConfigWithMaintenanceKey() *Config {
+	return &Config{MaintenanceKey: "maintancekey", MaintenanceKeys: map[string]string{"ops": "opskey"}}
+}
+
+// Input cases for the FlushHandler
+type FlushHeaderTestCase struct {
+	Method        string
+	GetHeader     FlushHeaderFunction
+	IsTerminating bool
+	ExpectedCode  int
+	GetConfig     FlushConfigFunction
+}
+
+// Executes the test cases for the given inputs
+func RunTestsOnFlushHeader(t *testing.T, collector *Collector, cases []*FlushHeaderTestCase) {
+	for _, c := range cases {
+		collector.config = c.GetConfig()
+		collector.SetTerminating(c.IsTerminating)
+
+		// Creates a new request
+		req, _ := http.NewRequest(c.Method, "/api/flush", nil)
+
+		// Set up the headers based on the predefined function
+		for key, value := range c.GetHeader(c.Method, "/api/flush") {
+			req.Header.Set(key, value)
+		}
+		resp := httptest.NewRecorder()
+
+		collector.FlushHandler(resp, req) // Calls the API
+
+		// Check the status code
+		if resp.Code != c.ExpectedCode {
+			t.Errorf("Non-expected status code %d with the following body `%s`, it should be %d", resp.Code, resp.Body, c.ExpectedCode)
+		}
+	}
+}
+
+// Tests the API
+func TestFlushHeader(t *testing.T) {
+	t.Log("Test flush header")
+	config = &Config{}                                                // Creates a config
+	storageClient = &BufferedStorageClient{}                          // Define the Buffered Storage as a storage
+	jobQueue = make(chan Job, 10)                                     // Creates a jobQueue
+	log.SetOutput(ioutil.Discard)                                     // Disable the logger
+	T, response, catched = t, nil, false                              // Set properties for the BufferedStorageClient
+	dispatcher := NewTestDispatcher(1, &WorkerOptions{BufferSize: 5}) // Creates a dispatcher
+	dispatcher.Run()
+
+	t.Log("Test flush headers with different setups")
+	RunTestsOnFlushHeader(t, dispatcher.GetCollector(),
+		[]*FlushHeaderTestCase{
+			{"POST", GetValidFlushHeader, false, http.StatusServiceUnavailable, GetEmptyConfig},
+			{"POST", GetValidFlushHeader, true, http.StatusServiceUnavailable, GetConfigWithMaintenanceKey},
+			{"GET", GetValidFlushHeader, false, http.StatusMethodNotAllowed, GetConfigWithMaintenanceKey},
+			{"POST", GetMissingFlushHeader, false, http.StatusMethodNotAllowed, GetConfigWithMaintenanceKey},
+			{"POST", GetFlushHeaderWithoutSignature, false, http.StatusMethodNotAllowed, GetConfigWithMaintenanceKey},
+			{"POST", GetFlushHeaderWithInvalidMaintenanceKey, false, http.StatusUnauthorized, GetConfigWithMaintenanceKey},
+			{"POST", GetFlushHeaderWithUnknownKeyName, false, http.StatusUnauthorized, GetConfigWithMaintenanceKey},
+			{"POST", GetExpiredFlushHeader, false, http.StatusMethodNotAllowed, GetConfigWithMaintenanceKey},
+			{"POST", GetValidFlushHeader, false, http.StatusOK, GetConfigWithMaintenanceKey},
+			{"POST", GetValidFlushHeader, false, http.StatusForbidden, GetConfigWithMaintenanceKey}, // Replayed request
+			{"POST", GetValidNamedFlushHeader, false, http.StatusOK, GetConfigWithMaintenanceKey},
+		})
+}
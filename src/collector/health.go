@@ -0,0 +1,55 @@
+package collector
+
+import (
+	"encoding/json"
+	"net/http"
+)
+
+type Health struct {
+	Up         bool              `json:"up"`
+	Ingestion  string            `json:"ingestion"`
+	Upload     string            `json:"upload"`
+	Sinks      []*SinkStatus     `json:"sinks,omitempty"`
+	DeadLetter *DeadLetterStatus `json:"dead_letter,omitempty"`
+}
+
+// Returns the current health of the collector
+func (c *Collector) GetHealth() *Health {
+	return &Health{
+		Up:         true,
+		Ingestion:  c.GetIngestionStateName(),
+		Upload:     c.GetUploadStateName(),
+		Sinks:      c.GetSinkStatuses(),
+		DeadLetter: c.GetDeadLetterStatus()}
+}
+
+// Writes the current health into the response
+func (c *Collector) WriteHealth(w http.ResponseWriter, r *http.Request) {
+	json, err := json.Marshal(c.GetHealth())
+	if err != nil {
+		c.BroadcastError(w, r, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(json)
+}
+
+// Controller for `/api/health`
+func (c *Collector) HealthHandler(w http.ResponseWriter, r *http.Request) {
+	c.WriteHealth(w, r)
+}
+
+// Controller for `/api/stats`, returns the counters of every sink and route
+func (c *Collector) StatsHandler(w http.ResponseWriter, r *http.Request) {
+	json, err := json.Marshal(map[string]interface{}{
+		"sinks":       c.GetSinkStatuses(),
+		"routes":      c.GetRouteStatuses(),
+		"event_time":  c.GetEventTimeStatus(),
+		"dead_letter": c.GetDeadLetterStatus()})
+	if err != nil {
+		c.BroadcastError(w, r, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(json)
+}
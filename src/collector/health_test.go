@@ -1,6 +1,7 @@
-package main
+package collector
 
 import (
+	"encoding/json"
 	"net/http"
 	"net/http/httptest"
 	"testing"
@@ -11,7 +12,7 @@ func TestHealthHandler(t *testing.T) {
 	req, _ := http.NewRequest("GET", "/api/health", nil)
 	resp := httptest.NewRecorder()
 
-	HealthHandler(resp, req)
+	NewTestCollector().HealthHandler(resp, req)
 
 	if code := resp.Code; code != http.StatusOK {
 		t.Errorf("Expected call to be successul. Got %d instead", code)
@@ -21,4 +22,12 @@ func TestHealthHandler(t *testing.T) {
 	if contentType != "application/json" {
 		t.Errorf("Expected application/json Content-Type. Got %s", contentType)
 	}
+
+	var health Health
+	if err := json.Unmarshal(resp.Body.Bytes(), &health); err != nil {
+		t.Errorf("Expected valid JSON response: %s", err.Error())
+	}
+	if !health.Up || health.Ingestion != "accepting" || health.Upload != "active" {
+		t.Errorf("Expected accepting and active states. Got %s and %s", health.Ingestion, health.Upload)
+	}
 }
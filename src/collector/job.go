@@ -0,0 +1,156 @@
+package collector
+
+import (
+	"github.com/wunderlist/hamustro/src/dialects"
+	"github.com/wunderlist/hamustro/src/tracing"
+	"sync"
+	"time"
+)
+
+// Define the known job's action types
+const ACTION_EVENT = 1
+const ACTION_FLUSH = 2
+const ACTION_STOP = 3
+const ACTION_RECONFIGURE = 4
+const ACTION_SHUTDOWN = 5
+
+// Define the interface for Jobs
+type Job interface {
+	GetAction() int
+	IsTargeted() bool
+	GetTargetWorkerID() int
+}
+
+// Job: Flush action
+type FlushAction struct {
+	TargetWorkerID int
+	Automatic      bool          // Skipped before the worker's next automatic flush
+	Done           chan struct{} // Closed after the flush (optional)
+}
+
+// Returns the name of the flush action
+func (a *FlushAction) GetAction() int {
+	return ACTION_FLUSH
+}
+
+// Returns that it's a targeted job
+func (a *FlushAction) IsTargeted() bool {
+	return true
+}
+
+// Returns the selected worker to do the action
+func (a *FlushAction) GetTargetWorkerID() int {
+	return a.TargetWorkerID
+}
+
+// Job: Stop action, the worker saves its buffer before it stops
+type StopAction struct {
+	TargetWorkerID int
+	WaitGroup      *sync.WaitGroup
+}
+
+// Returns the name of the stop action
+func (a *StopAction) GetAction() int {
+	return ACTION_STOP
+}
+
+// Returns that it's a targeted job
+func (a *StopAction) IsTargeted() bool {
+	return true
+}
+
+// Returns the selected worker to do the action
+func (a *StopAction) GetTargetWorkerID() int {
+	return a.TargetWorkerID
+}
+
+// Job: Reconfigure action, changes the worker's limits
+type ReconfigureAction struct {
+	TargetWorkerID int
+	BufferSize     int
+	MaxBytes       int64
+	MaxAge         time.Duration
+	MaxInFlight    int
+	RetryAttempt   int
+	FlushInterval  time.Duration
+	MemoryLimit    int64   // Memory ceiling of the worker in bytes
+	worker         *Worker // Target of the action that's sent after the dispatcher's lock is released
+}
+
+// Returns the name of the reconfigure action
+func (a *ReconfigureAction) GetAction() int {
+	return ACTION_RECONFIGURE
+}
+
+// Returns that it's a targeted job
+func (a *ReconfigureAction) IsTargeted() bool {
+	return true
+}
+
+// Returns the selected worker to do the action
+func (a *ReconfigureAction) GetTargetWorkerID() int {
+	return a.TargetWorkerID
+}
+
+// Job: Add new Event action
+type EventAction struct {
+	Event      *dialects.Event
+	Attempt    int
+	Trace      tracing.SpanContext // Span of the request that received the event
+	EnqueuedAt time.Time
+	ReceivedAt time.Time
+	WALSegment uint64 // Segment of the write-ahead log that contains the event
+}
+
+// Returns the name of the add event action
+func (a *EventAction) GetAction() int {
+	return ACTION_EVENT
+}
+
+// Returns that it's not a targeted job
+func (a *EventAction) IsTargeted() bool {
+	return false
+}
+
+// Returns 0 to choose the first available worker
+func (a *EventAction) GetTargetWorkerID() int {
+	return -1
+}
+
+// Returns the Event object we'd like to add
+func (a *EventAction) GetEvent() *dialects.Event {
+	return a.Event
+}
+
+// Mark this job as failed and put back into the given queue,
+// returns false if there are no more attempts
+func (a *EventAction) MarkAsFailedInQueue(queue chan Job, retryAttempt int) bool {
+	a.Attempt++
+	if a.Attempt <= retryAttempt {
+		a.EnqueuedAt = time.Now()
+		queue <- a
+		return true
+	}
+	return false
+}
+
+// Job: Shutdown action, it's put at the end of the job queue and stops
+// the dispatcher's workers after every preceding job is delivered
+type ShutdownAction struct {
+	Done chan struct{} // Closed after the workers are stopped
+}
+
+// Returns the name of the shutdown action
+func (a *ShutdownAction) GetAction() int {
+	return ACTION_SHUTDOWN
+}
+
+// Returns that it's handled by the dispatcher instead of a worker
+func (a *ShutdownAction) IsTargeted() bool {
+	return false
+}
+
+// Returns the selected worker to do the action
+func (a *ShutdownAction) GetTargetWorkerID() int {
+	return -1
+}
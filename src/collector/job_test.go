@@ -1,4 +1,4 @@
-package main
+package collector
 
 import (
 	"reflect"
@@ -8,12 +8,12 @@ import (
 // Testing the GetAction function's behaviour
 func TestFunctionGetAction(t *testing.T) {
 	t.Log("Testing event action")
-	eventAction := &EventAction{GetTestEvent(3423897841), 1}
+	eventAction := &EventAction{Event: GetTestEvent(3423897841), Attempt: 1}
 	if exp := 1; eventAction.GetAction() != exp {
 		t.Errorf("Expected action is %s but it was %s instead", exp, eventAction.GetAction())
 	}
 	t.Log("Testing flush action")
-	flushAction := &FlushAction{1}
+	flushAction := &FlushAction{TargetWorkerID: 1}
 	if exp := 2; flushAction.GetAction() != exp {
 		t.Errorf("Expected masked IP setting is %s but it was %s instead", exp, flushAction.GetAction())
 	}
@@ -22,12 +22,12 @@ func TestFunctionGetAction(t *testing.T) {
 // Testing the IsTargeted function's behaviour
 func TestFunctionIsTargeted(t *testing.T) {
 	t.Log("Testing event action")
-	eventAction := &EventAction{GetTestEvent(3423897841), 1}
+	eventAction := &EventAction{Event: GetTestEvent(3423897841), Attempt: 1}
 	if exp := false; eventAction.IsTargeted() != exp {
 		t.Errorf("Expected targeted is %s but it was %s instead", exp, eventAction.IsTargeted())
 	}
 	t.Log("Testing flush action")
-	flushAction := &FlushAction{3}
+	flushAction := &FlushAction{TargetWorkerID: 3}
 	if exp := true; flushAction.IsTargeted() != exp {
 		t.Errorf("Expected targeted is %s but it was %s instead", exp, flushAction.IsTargeted())
 	}
@@ -36,12 +36,12 @@ func TestFunctionIsTargeted(t *testing.T) {
 // Testing the GetTargetWorkerID function's behaviour
 func TestFunctionGetTargetWorkerID(t *testing.T) {
 	t.Log("Testing event action")
-	eventAction := &EventAction{GetTestEvent(3423897841), 1}
+	eventAction := &EventAction{Event: GetTestEvent(3423897841), Attempt: 1}
 	if exp := -1; eventAction.GetTargetWorkerID() != exp {
 		t.Errorf("Expected target worker id is %s but it was %s instead", exp, eventAction.GetTargetWorkerID())
 	}
 	t.Log("Testing flush action")
-	flushAction := &FlushAction{5}
+	flushAction := &FlushAction{TargetWorkerID: 5}
 	if exp := 5; flushAction.GetTargetWorkerID() != exp {
 		t.Errorf("Expected target worker id is %s but it was %s instead", exp, flushAction.GetTargetWorkerID())
 	}
@@ -51,17 +51,17 @@ func TestFunctionGetTargetWorkerID(t *testing.T) {
 func TestFunctionGetEvent(t *testing.T) {
 	t.Log("Testing event action's get event function")
 	event := GetTestEvent(3423897841)
-	eventAction := &EventAction{event, 1}
+	eventAction := &EventAction{Event: event, Attempt: 1}
 	if !reflect.DeepEqual(GetTestEvent(3423897841), eventAction.GetEvent()) {
 		t.Error("Not expected event was returned")
 	}
 }
 
-// Testing the MarkAsFailed function's behaviour
-func TestFunctionMarkAsFailed(t *testing.T) {
+// Testing the MarkAsFailedInQueue function's behaviour
+func TestFunctionMarkAsFailedInQueue(t *testing.T) {
 	t.Log("Testing mark as failed behaviour for jobs")
 	jobQueue = make(chan Job, 10)
-	job := &EventAction{GetTestEvent(3423897841), 1}
+	job := &EventAction{Event: GetTestEvent(3423897841), Attempt: 1}
 
 	cases := []struct {
 		ExpectedAttempt        int
@@ -73,7 +73,7 @@ func TestFunctionMarkAsFailed(t *testing.T) {
 
 	for i, c := range cases {
 		t.Logf("Evaluate %d. attempt", i+1)
-		job.MarkAsFailed(3)
+		job.MarkAsFailedInQueue(jobQueue, 3)
 		if job.Attempt != c.ExpectedAttempt {
 			t.Errorf("Expected job's attempt was %d but it was %d instead", c.ExpectedAttempt, job.Attempt)
 		}
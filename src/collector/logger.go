@@ -0,0 +1,32 @@
+package collector
+
+import (
+	"fmt"
+	"github.com/wunderlist/hamustro/src/logging"
+	"time"
+)
+
+// Creates the application's logger based on the configuration
+func NewLogger(c *Config, verbose bool) (*logging.Logger, error) {
+	level, err := logging.ParseLevel(c.GetLogLevel())
+	if err != nil {
+		return nil, err
+	}
+	if verbose {
+		level = logging.DEBUG
+	}
+	if !logging.IsValidFormat(c.GetLogFormat()) {
+		return nil, fmt.Errorf("Not supported `%s` log format (use `text`, `logfmt` or `json`)", c.GetLogFormat())
+	}
+
+	l := logging.New(nil, c.GetLogFormat(), level)
+	for component, name := range c.LogLevels {
+		componentLevel, err := logging.ParseLevel(name)
+		if err != nil {
+			return nil, err
+		}
+		l.SetComponentLevel(component, componentLevel)
+	}
+	l.SetRateLimiter(logging.NewRateLimiter(c.GetClientErrorLogLimit(), time.Minute))
+	return l.With(logging.Fields{"dialect": c.Dialect}), nil
+}
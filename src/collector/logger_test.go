@@ -0,0 +1,42 @@
+package collector
+
+import (
+	"github.com/wunderlist/hamustro/src/logging"
+	"testing"
+)
+
+// Testing the logger creation from the configuration
+func TestFunctionNewLogger(t *testing.T) {
+	cases := []struct {
+		Config        *Config
+		Verbose       bool
+		ExpectedLevel logging.Level
+		IsValid       bool
+	}{
+		{&Config{}, false, logging.INFO, true},
+		{&Config{}, true, logging.DEBUG, true},
+		{&Config{LogLevel: "error", LogFormat: "json"}, false, logging.ERROR, true},
+		{&Config{LogLevel: "loud"}, false, logging.INFO, false},
+		{&Config{LogFormat: "xml"}, false, logging.INFO, false},
+		{&Config{LogLevels: map[string]string{"worker": "noisy"}}, false, logging.INFO, false}}
+
+	for i, c := range cases {
+		l, err := NewLogger(c.Config, c.Verbose)
+		if (err == nil) != c.IsValid {
+			t.Errorf("%d. logger configuration validity should be %t", i+1, c.IsValid)
+			continue
+		}
+		if err == nil && l.Level != c.ExpectedLevel {
+			t.Errorf("Expected level was %s but it was %s instead", c.ExpectedLevel, l.Level)
+		}
+	}
+
+	t.Log("Testing the component level overrides")
+	l, _ := NewLogger(&Config{LogLevels: map[string]string{"worker": "debug"}}, false)
+	if exp := logging.DEBUG; l.Component("worker").Level != exp {
+		t.Errorf("Expected worker level was %s but it was %s instead", exp, l.Component("worker").Level)
+	}
+	if exp := logging.INFO; l.Component("http").Level != exp {
+		t.Errorf("Expected http level was %s but it was %s instead", exp, l.Component("http").Level)
+	}
+}
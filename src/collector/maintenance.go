@@ -0,0 +1,123 @@
+package collector
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Maximum size of a maintenance request's body in bytes
+const MAINTENANCE_MAX_BODY_SIZE = 1 << 20
+
+// Stores the recently used signatures to reject replayed requests
+type SignatureCache struct {
+	sync.Mutex
+	Signatures map[string]time.Time
+}
+
+// Creates a new signature cache
+func NewSignatureCache() *SignatureCache {
+	return &SignatureCache{Signatures: map[string]time.Time{}}
+}
+
+// Registers the signature until the given expiration time,
+// returns false if it was already registered
+func (c *SignatureCache) Register(signature string, expiration time.Time) bool {
+	c.Lock()
+	defer c.Unlock()
+
+	// Removes the expired signatures
+	now := time.Now()
+	for s, e := range c.Signatures {
+		if now.After(e) {
+			delete(c.Signatures, s)
+		}
+	}
+
+	if _, ok := c.Signatures[signature]; ok {
+		return false
+	}
+	c.Signatures[signature] = expiration
+	return true
+}
+
+// Rejects the maintenance request with an audit entry
+func (c *Collector) RejectMaintenance(w http.ResponseWriter, r *http.Request, keyName string, action string, err string, code int) bool {
+	c.Audit(r, keyName, action, "denied: "+err)
+	c.BroadcastError(w, r, err, code)
+	return false
+}
+
+// Validates the signed maintenance request, returns false if it was rejected
+func (c *Collector) AuthorizeMaintenance(w http.ResponseWriter, r *http.Request, action string) bool {
+	keys := c.GetConfig().GetMaintenanceKeys()
+	keyName := r.Header.Get("X-Hamustro-Maintenance-Key-Id")
+	if keyName == "" {
+		keyName = "default"
+	}
+
+	// Do not accept maintenance request if the key is not defined
+	if len(keys) == 0 {
+		return c.RejectMaintenance(w, r, keyName, action, "Please define maintanance key to access this feature", http.StatusServiceUnavailable)
+	}
+
+	// Do not accept maintenance request while the server is shutting down.
+	if c.IsTerminating() {
+		return c.RejectMaintenance(w, r, keyName, action, "Server is currenly shutting down", http.StatusServiceUnavailable)
+	}
+
+	// Ignore not POST messages.
+	if r.Method != "POST" {
+		return c.RejectMaintenance(w, r, keyName, action, "Sending method is not POST", http.StatusMethodNotAllowed)
+	}
+
+	// If the client did not send time or signature of the message, we ignore
+	if r.Header.Get("X-Hamustro-Time") == "" {
+		return c.RejectMaintenance(w, r, keyName, action, "X-Hamustro-Time header is missing", http.StatusMethodNotAllowed)
+	}
+	if r.Header.Get("X-Hamustro-Signature") == "" {
+		return c.RejectMaintenance(w, r, keyName, action, "X-Hamustro-Signature header is missing", http.StatusMethodNotAllowed)
+	}
+
+	// Checks the freshness of the request
+	timestamp, err := strconv.ParseInt(r.Header.Get("X-Hamustro-Time"), 10, 64)
+	if err != nil {
+		return c.RejectMaintenance(w, r, keyName, action, "X-Hamustro-Time header is invalid", http.StatusMethodNotAllowed)
+	}
+	window := time.Duration(c.GetConfig().GetMaintenanceWindow()) * time.Second
+	requestTime := time.Unix(timestamp, 0)
+	if requestTime.Before(time.Now().Add(-window)) || requestTime.After(time.Now().Add(window)) {
+		return c.RejectMaintenance(w, r, keyName, action, "X-Hamustro-Time header is outside of the accepted window", http.StatusMethodNotAllowed)
+	}
+
+	// Read the requests body and put it back for the handlers
+	var body []byte
+	if r.Body != nil {
+		body, err = ioutil.ReadAll(http.MaxBytesReader(w, r.Body, MAINTENANCE_MAX_BODY_SIZE))
+		if err != nil {
+			return c.RejectMaintenance(w, r, keyName, action, "Request body is too large or unreadable", http.StatusRequestEntityTooLarge)
+		}
+		r.Body = ioutil.NopCloser(bytes.NewReader(body))
+	}
+
+	// Compare signatures in constant time, an unknown key is rejected
+	// the same way as an invalid signature so the key names can't be
+	// discovered
+	key, ok := keys[keyName]
+	signature := GetMaintenanceSignature(key, r.Method, r.URL.RequestURI(), r.Header.Get("X-Hamustro-Time"), body)
+	if !hmac.Equal([]byte(r.Header.Get("X-Hamustro-Signature")), []byte(signature)) || !ok {
+		return c.RejectMaintenance(w, r, keyName, action, "X-Hamustro-Signature header is invalid", http.StatusUnauthorized)
+	}
+
+	// Every signature can be used only once
+	if !c.maintenanceSignatures.Register(signature, requestTime.Add(window)) {
+		return c.RejectMaintenance(w, r, keyName, action, "X-Hamustro-Signature header was already used", http.StatusForbidden)
+	}
+
+	c.Audit(r, keyName, action, "granted")
+	return true
+}
@@ -0,0 +1,83 @@
+package collector
+
+import (
+	"bytes"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// Testing the replay protection of the signature cache
+func TestFunctionSignatureCacheRegister(t *testing.T) {
+	t.Log("Registering signatures into the cache")
+	cache := NewSignatureCache()
+
+	if !cache.Register("first", time.Now().Add(time.Minute)) {
+		t.Errorf("New signature should be registered")
+	}
+	if cache.Register("first", time.Now().Add(time.Minute)) {
+		t.Errorf("Already used signature should be rejected")
+	}
+	if !cache.Register("second", time.Now().Add(-time.Second)) {
+		t.Errorf("New signature should be registered")
+	}
+
+	t.Log("Expired signatures are removed on the next registration")
+	if !cache.Register("second", time.Now().Add(time.Minute)) {
+		t.Errorf("Expired signature should be registered again")
+	}
+	if exp := 2; len(cache.Signatures) != exp {
+		t.Errorf("Expected cache size was %d but it was %d instead", exp, len(cache.Signatures))
+	}
+}
+
+// Tests that the unknown keys are rejected like the invalid signatures
+// and the large bodies are rejected before the signature is checked
+func TestMaintenanceRejections(t *testing.T) {
+	config = &Config{}
+	storageClient = &BufferedStorageClient{}
+	jobQueue = make(chan Job, 10)
+	log.SetOutput(ioutil.Discard)
+	collector := NewTestDispatcher(1, &WorkerOptions{BufferSize: 5}).GetCollector()
+	collector.config = GetConfigWithMaintenanceKey()
+
+	t.Log("Comparing the responses of an unknown key and an invalid signature")
+	bodies := []string{}
+	for _, header := range []FlushHeaderFunction{GetFlushHeaderWithUnknownKeyName, GetFlushHeaderWithInvalidMaintenanceKey} {
+		req, _ := http.NewRequest("POST", "/api/flush", nil)
+		for key, value := range header("POST", "/api/flush") {
+			req.Header.Set(key, value)
+		}
+		resp := httptest.NewRecorder()
+		collector.FlushHandler(resp, req)
+		if exp := http.StatusUnauthorized; resp.Code != exp {
+			t.Errorf("Expected status code was %d but it was %d instead", exp, resp.Code)
+		}
+		bodies = append(bodies, resp.Body.String())
+	}
+	if bodies[0] != bodies[1] {
+		t.Errorf("Unknown key should be rejected like an invalid signature: `%s` and `%s`", bodies[0], bodies[1])
+	}
+
+	t.Log("Rejecting a body above the limit")
+	req, _ := http.NewRequest("POST", "/api/flush", bytes.NewReader(make([]byte, MAINTENANCE_MAX_BODY_SIZE+1)))
+	for key, value := range GetValidFlushHeader("POST", "/api/flush") {
+		req.Header.Set(key, value)
+	}
+	resp := httptest.NewRecorder()
+	collector.FlushHandler(resp, req)
+	if exp := http.StatusRequestEntityTooLarge; resp.Code != exp {
+		t.Errorf("Expected status code was %d but it was %d instead", exp, resp.Code)
+	}
+
+	t.Log("Rejecting the conflicting default keys")
+	if c := (&Config{MaintenanceKey: "a", MaintenanceKeys: map[string]string{"default": "b"}}); c.IsValidMaintenanceKeys() {
+		t.Errorf("Legacy key and the named default key should conflict")
+	}
+	if c := GetConfigWithMaintenanceKey(); !c.IsValidMaintenanceKeys() {
+		t.Errorf("Legacy key and other named keys should not conflict")
+	}
+}
@@ -0,0 +1,263 @@
+package collector
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"github.com/wunderlist/hamustro/src/dialects"
+	"path"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Prefix of the markers of the completed windows
+const MANIFEST_SUCCESS = "_SUCCESS"
+
+// Interval of checking the completed time windows
+const MANIFEST_CHECK_INTERVAL = time.Second
+
+// Single object of a Redshift COPY manifest
+type ManifestEntry struct {
+	URL       string       `json:"url"`
+	Mandatory bool         `json:"mandatory"`
+	Meta      ManifestMeta `json:"meta"`
+}
+
+// Size and number of events of an object in the manifest
+type ManifestMeta struct {
+	ContentLength int64 `json:"content_length"`
+	RecordCount   int   `json:"record_count"`
+}
+
+// Objects of a partition that were taken in the same time window
+type ManifestWindow struct {
+	Dir     string
+	Start   time.Time
+	Entries []*ManifestEntry
+}
+
+// Returns the Redshift COPY manifest of the window's objects
+func (w *ManifestWindow) Marshal() (*bytes.Buffer, error) {
+	b, err := json.Marshal(map[string][]*ManifestEntry{"entries": w.Entries})
+	if err != nil {
+		return nil, err
+	}
+	return bytes.NewBuffer(b), nil
+}
+
+// Collects the saved objects of a sink per partition and time window.
+// A window is completed when it's over and every batch that was taken
+// within it is saved, then its manifest and its marker are written into
+// every partition of the window. The marker covers only the objects of
+// the window's manifest, the later batches may be saved into the same
+// partition within another window. The buffered events,
+// the failed batches and the spooled batches hold the window too.
+type Manifest struct {
+	Interval time.Duration
+	Name     string // Unique part of the manifests' names within the partition
+	Sink     *Sink
+	pending  map[interface{}]time.Time
+	holds    uint64
+	windows  map[string]*ManifestWindow
+	stop     chan struct{}
+	done     chan struct{}
+	sync.Mutex
+}
+
+// Creates the manifest of the sink's objects, the name separates
+// the manifests of the instances and their restarts
+func NewManifest(sink *Sink, interval time.Duration, name string) *Manifest {
+	return &Manifest{
+		Interval: interval,
+		Name:     name,
+		Sink:     sink,
+		pending:  map[interface{}]time.Time{},
+		windows:  map[string]*ManifestWindow{}}
+}
+
+// Returns the start of the time window
+func (m *Manifest) GetWindow(at time.Time) time.Time {
+	return at.UTC().Truncate(m.Interval)
+}
+
+// Registers a batch that is taken by a worker, its window
+// can't be completed until it's saved
+func (m *Manifest) Begin(batch *Batch) {
+	m.Hold(batch, batch.TakenAt)
+}
+
+// Removes the saved (or spilled) batch from the pending ones
+func (m *Manifest) End(batch *Batch) {
+	m.Release(batch)
+}
+
+// Holds the window of the given time until the key is released
+// (e.g. a worker's buffer since its oldest event)
+func (m *Manifest) Hold(key interface{}, at time.Time) {
+	if m == nil {
+		return
+	}
+	m.Lock()
+	m.pending[key] = at
+	m.Unlock()
+}
+
+// Removes the hold of the key
+func (m *Manifest) Release(key interface{}) {
+	if m == nil {
+		return
+	}
+	m.Lock()
+	delete(m.pending, key)
+	m.Unlock()
+}
+
+// Returns a new key of a hold that is stored outside of the memory
+// (e.g. with a spooled batch), it's empty without manifests
+func (m *Manifest) NewHoldKey() string {
+	if m == nil {
+		return ""
+	}
+	m.Lock()
+	defer m.Unlock()
+	m.holds++
+	return fmt.Sprintf("%s-%d", m.Name, m.holds)
+}
+
+// Adds the saved object to the window of the batch's time,
+// the objects without a path are skipped
+func (m *Manifest) Add(info *dialects.BatchInfo, at time.Time) {
+	if m == nil || info.Path == "" {
+		return
+	}
+	start := m.GetWindow(at)
+	dir := path.Dir(info.Path)
+	key := dir + "\n" + start.String()
+	m.Lock()
+	defer m.Unlock()
+	window, ok := m.windows[key]
+	if !ok {
+		window = &ManifestWindow{Dir: dir, Start: start}
+		m.windows[key] = window
+	}
+	window.Entries = append(window.Entries, &ManifestEntry{
+		URL:       info.URL,
+		Mandatory: true,
+		Meta:      ManifestMeta{ContentLength: info.Size, RecordCount: info.Events}})
+}
+
+// Returns the time before that every taken, buffered and spooled
+// event is saved
+func (m *Manifest) GetWatermark(now time.Time) time.Time {
+	m.Lock()
+	defer m.Unlock()
+	watermark := now
+	for _, at := range m.pending {
+		if at.Before(watermark) {
+			watermark = at
+		}
+	}
+	return watermark
+}
+
+// Writes the manifests and markers of the completed windows. With
+// `all` (on shutdown) the manifests of the open windows are written
+// too, but they are not marked as completed.
+func (m *Manifest) Complete(now time.Time, all bool) error {
+	if m == nil {
+		return nil
+	}
+	watermark := m.GetWatermark(now)
+	m.Lock()
+	keys := []string{}
+	for key, window := range m.windows {
+		if all || !window.Start.Add(m.Interval).After(watermark) {
+			keys = append(keys, key)
+		}
+	}
+	m.Unlock()
+	sort.Strings(keys)
+
+	var lastErr error
+	for _, key := range keys {
+		m.Lock()
+		window := m.windows[key]
+		m.Unlock()
+		completed := !window.Start.Add(m.Interval).After(watermark)
+		if err := m.Write(window, completed); err != nil {
+			lastErr = err
+			continue
+		}
+		m.Lock()
+		delete(m.windows, key)
+		m.Unlock()
+	}
+	return lastErr
+}
+
+// Writes the window's manifest (and its marker) with the sink's
+// storage client next to the window's objects
+func (m *Manifest) Write(window *ManifestWindow, marker bool) error {
+	client, ok := m.Sink.GetStorageClient().(dialects.ObjectStorageClient)
+	if !ok {
+		return fmt.Errorf("Storage client of `%s` sink can't write manifests", m.Sink.Name)
+	}
+	m.Lock()
+	msg, err := window.Marshal()
+	m.Unlock()
+	if err != nil {
+		return err
+	}
+	name := m.GetWindowName(window.Start)
+	if err := client.SaveObject(path.Join(window.Dir, "_manifest-"+name+".json"), msg); err != nil {
+		return err
+	}
+	if marker {
+		return client.SaveObject(path.Join(window.Dir, MANIFEST_SUCCESS+"-"+name), &bytes.Buffer{})
+	}
+	return nil
+}
+
+// Returns the unique part of the window's manifest and marker
+// names: the window's start and the manifest's name
+func (m *Manifest) GetWindowName(start time.Time) string {
+	return dialects.CompactEventTime(start.Format(EVENT_TIME_FORMAT)) + "-" + m.Name
+}
+
+// Starts writing the completed windows periodically
+func (m *Manifest) Run(interval time.Duration) {
+	m.stop = make(chan struct{})
+	m.done = make(chan struct{})
+	go func() {
+		defer close(m.done)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case now := <-ticker.C:
+				if err := m.Complete(now, false); err != nil {
+					m.Sink.GetLogger("manifest").RateLimit("write_failed|"+m.Sink.Name).Warnf("Writing manifests is failed: %s", err.Error())
+				}
+			case <-m.stop:
+				return
+			}
+		}
+	}()
+}
+
+// Stops the periodic writes and writes every window, it has to be
+// called after the workers are stopped
+func (m *Manifest) Stop() {
+	if m == nil {
+		return
+	}
+	if m.stop != nil {
+		close(m.stop)
+		<-m.done
+		m.stop = nil
+	}
+	if err := m.Complete(time.Now(), true); err != nil {
+		m.Sink.GetLogger("manifest").Errorf("Writing manifests is failed: %s", err.Error())
+	}
+}
@@ -0,0 +1,208 @@
+package collector
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/wunderlist/hamustro/src/dialects"
+	"github.com/wunderlist/hamustro/src/dialects/file"
+	"github.com/wunderlist/hamustro/src/spool"
+	"github.com/wunderlist/hamustro/src/tracing"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// Returns the manifests of the directory
+func GetTestManifests(t *testing.T, dir string) map[string]map[string][]*ManifestEntry {
+	paths, _ := filepath.Glob(filepath.Join(dir, "_manifest-*.json"))
+	manifests := map[string]map[string][]*ManifestEntry{}
+	for _, p := range paths {
+		b, err := ioutil.ReadFile(p)
+		if err != nil {
+			t.Fatal(err)
+		}
+		manifest := map[string][]*ManifestEntry{}
+		if err := json.Unmarshal(b, &manifest); err != nil {
+			t.Fatalf("Manifest %s is invalid: %s", p, err.Error())
+		}
+		manifests[filepath.Base(p)] = manifest
+	}
+	return manifests
+}
+
+// Tests that the window is completed after every batch taken
+// within it is saved
+func TestManifestComplete(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "hamustro-manifest")
+	defer os.RemoveAll(dir)
+	config = &Config{}
+	log.SetOutput(ioutil.Discard)
+	client := &file.FileStorage{FilePath: dir, FileFormat: "json", BatchConverter: dialects.ConvertBatchJSON}
+	sink := NewTestCollector().NewSinkWithClient(&SinkConfig{Name: "archive", MaxWorkerSize: 1, MaxQueueSize: 10}, client)
+	manifest := NewManifest(sink, time.Hour, "host-1")
+
+	t.Log("Saving an object of the window while another batch is uploading")
+	saved := &Batch{TakenAt: time.Date(2016, 2, 5, 10, 5, 0, 0, time.UTC)}
+	uploading := &Batch{TakenAt: time.Date(2016, 2, 5, 10, 50, 0, 0, time.UTC)}
+	manifest.Begin(saved)
+	manifest.Begin(uploading)
+	info := &dialects.BatchInfo{Events: 3}
+	info.SetObject(filepath.Join(dir, "a.json"), "file:///a.json", 120)
+	manifest.Add(info, saved.TakenAt)
+	manifest.End(saved)
+
+	if err := manifest.Complete(time.Date(2016, 2, 5, 11, 1, 0, 0, time.UTC), false); err != nil {
+		t.Fatal(err)
+	}
+	marker := filepath.Join(dir, MANIFEST_SUCCESS+"-20160205T100000-host-1")
+	if _, err := os.Stat(marker); err == nil || len(GetTestManifests(t, dir)) != 0 {
+		t.Errorf("Window should not be completed while its batch is uploading")
+	}
+
+	t.Log("Completing the window after the last batch is saved")
+	manifest.End(uploading)
+	if err := manifest.Complete(time.Date(2016, 2, 5, 10, 59, 0, 0, time.UTC), false); err != nil || len(GetTestManifests(t, dir)) != 0 {
+		t.Errorf("Window should not be completed before it's over")
+	}
+	if err := manifest.Complete(time.Date(2016, 2, 5, 11, 0, 0, 0, time.UTC), false); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(marker); err != nil {
+		t.Errorf("Marker of the completed window is missing: %s", err.Error())
+	}
+	manifests := GetTestManifests(t, dir)
+	entries := manifests["_manifest-20160205T100000-host-1.json"]["entries"]
+	if len(manifests) != 1 || len(entries) != 1 {
+		t.Fatalf("Expected a single manifest with a single entry but it was %v instead", manifests)
+	}
+	if e := entries[0]; e.URL != "file:///a.json" || !e.Mandatory || e.Meta.ContentLength != 120 || e.Meta.RecordCount != 3 {
+		t.Errorf("Manifest has unexpected entry: %+v", e)
+	}
+
+	t.Log("Saving a late batch into the same partition after the marker")
+	late := &Batch{TakenAt: time.Date(2016, 2, 5, 11, 20, 0, 0, time.UTC)}
+	manifest.Begin(late)
+	info = &dialects.BatchInfo{Events: 2}
+	info.SetObject(filepath.Join(dir, "b.json"), "file:///b.json", 80)
+	manifest.Add(info, late.TakenAt)
+	manifest.End(late)
+	if err := manifest.Complete(time.Date(2016, 2, 5, 12, 0, 0, 0, time.UTC), false); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, MANIFEST_SUCCESS+"-20160205T110000-host-1")); err != nil {
+		t.Errorf("Marker of the late batch's window is missing: %s", err.Error())
+	}
+	manifests = GetTestManifests(t, dir)
+	if entries := manifests["_manifest-20160205T100000-host-1.json"]["entries"]; len(entries) != 1 || entries[0].URL != "file:///a.json" {
+		t.Errorf("Completed manifest should not be changed by the late batch: %v", entries)
+	}
+	if entries := manifests["_manifest-20160205T110000-host-1.json"]["entries"]; len(entries) != 1 || entries[0].URL != "file:///b.json" {
+		t.Errorf("Late batch should be listed in its own window's manifest: %v", entries)
+	}
+}
+
+// Tests that the saved objects of the partitions are listed in
+// their manifests on shutdown without the markers
+func TestSinkManifestShutdown(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "hamustro-manifest")
+	defer os.RemoveAll(dir)
+	config = &Config{}
+	collector := NewTestCollector()
+	log.SetOutput(ioutil.Discard)
+	client := &file.FileStorage{FilePath: filepath.Join(dir, "env={env}"), FileFormat: "json", BatchConverter: dialects.ConvertBatchJSON}
+	sink := collector.NewSinkWithClient(&SinkConfig{Name: "archive", MaxWorkerSize: 1, MaxQueueSize: 10, BufferSize: 2, ManifestInterval: 60, Overflow: OVERFLOW_BLOCK}, client)
+	collector.sinks = []*Sink{sink}
+	sink.Dispatcher.Run()
+	sink.Manifest.Run(10 * time.Millisecond)
+
+	for i, env := range []string{"production", "production", "dev", "production"} {
+		event := GetTestEvent(uint32(650 + i))
+		event.Env = env
+		collector.PublishEvent(event, tracing.SpanContext{})
+	}
+	collector.StopSinks()
+
+	expected := map[string]int{"production": 3, "dev": 1}
+	for env, exp := range expected {
+		partition := filepath.Join(dir, "env="+env)
+		if markers, _ := filepath.Glob(filepath.Join(partition, MANIFEST_SUCCESS+"*")); len(markers) != 0 {
+			t.Errorf("Open window of %s should not be marked as completed", env)
+		}
+		manifests := GetTestManifests(t, partition)
+		if len(manifests) != 1 {
+			t.Fatalf("Expected a single manifest in %s but it was %d instead", env, len(manifests))
+		}
+		events := 0
+		for _, manifest := range manifests {
+			for _, entry := range manifest["entries"] {
+				if _, err := os.Stat(entry.URL); err != nil {
+					t.Errorf("Object of the manifest is missing: %s", entry.URL)
+				}
+				events += entry.Meta.RecordCount
+			}
+		}
+		if events != exp {
+			t.Errorf("Expected number of events in the manifest of %s was %d but it was %d instead", env, exp, events)
+		}
+	}
+}
+
+// Tests that the watermark is held by the buffered events, the failed
+// batches and the spooled batches until they're saved
+func TestManifestWatermarkHolds(t *testing.T) {
+	config = &Config{}
+	client := &CountingStorageClient{Buffered: true, Response: fmt.Errorf("S3 is not available")}
+	storageClient = client
+	jobQueue = make(chan Job, 10)
+	log.SetOutput(ioutil.Discard)
+	dir, err := ioutil.TempDir("", "hamustro-spool")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	worker := NewTestWorker(1, &WorkerOptions{BufferSize: 10}, make(chan *Worker, 1))
+	worker.Sink.Manifest = NewManifest(worker.Sink, time.Hour, "host-1")
+	if worker.Sink.Spool, err = spool.New(dir, 0, 0); err != nil {
+		t.Fatal(err)
+	}
+	later := time.Now().Add(time.Hour)
+
+	t.Log("Holding the watermark with the buffered events")
+	worker.AddEventToBuffer(GetTestEvent(740))
+	if watermark := worker.Sink.Manifest.GetWatermark(later); !watermark.Equal(worker.BufferedAt) {
+		t.Errorf("Expected watermark was %s but it was %s instead", worker.BufferedAt, watermark)
+	}
+
+	t.Log("Holding the watermark with the failed batch")
+	bufferedAt := worker.BufferedAt
+	if err := worker.SaveBatch(); err == nil {
+		t.Fatalf("Batch should not be saved while the storage is down")
+	}
+	if len(worker.Released) != 1 {
+		t.Fatalf("Failed batch should be pending in the buffer")
+	}
+	takenAt := worker.Released[0].TakenAt
+	if watermark := worker.Sink.Manifest.GetWatermark(later); !watermark.Equal(bufferedAt) {
+		t.Errorf("Expected watermark was %s but it was %s instead", bufferedAt, watermark)
+	}
+
+	t.Log("Holding the watermark with the spooled batch")
+	if err := worker.SpillBuffer(); err != nil {
+		t.Fatalf("Buffer should be spilled: %s", err.Error())
+	}
+	if watermark := worker.Sink.Manifest.GetWatermark(later); !watermark.Equal(takenAt) {
+		t.Errorf("Expected watermark was %s but it was %s instead", takenAt, watermark)
+	}
+
+	t.Log("Releasing the watermark after the spooled batch is saved")
+	client.SetResponse(nil)
+	if _, err := worker.GetSpool().Upload(worker.Sink.UploadSpooledBatch); err != nil {
+		t.Fatalf("Spooled batch should be uploaded: %s", err.Error())
+	}
+	if watermark := worker.Sink.Manifest.GetWatermark(later); !watermark.Equal(later) {
+		t.Errorf("Expected watermark was %s but it was %s instead", later, watermark)
+	}
+}
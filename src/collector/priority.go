@@ -0,0 +1,81 @@
+package collector
+
+import (
+	"github.com/wunderlist/hamustro/src/dialects"
+	"path"
+)
+
+// ID of the priority lane's first worker, the lane's workers have
+// their own IDs so their batches never get the names of the sink's
+const PRIORITY_FIRST_WORKER_ID = 1 << 30
+
+// Is the event name pattern well-formed (see path.Match)
+func IsValidPattern(pattern string) bool {
+	_, err := path.Match(pattern, "")
+	return err == nil
+}
+
+// Creates the priority lane of the sink that shares the sink's storage
+// client, counters, circuit breaker and manifest, but has its own
+// queue, so the priority events never wait behind the sink's large
+// buffers
+func (c *Collector) NewPrioritySink(s *Sink, sc *SinkConfig) *Sink {
+	p := c.NewSinkWithClient(sc.GetPriorityConfig(), s.GetStorageClient())
+	p.Stats = s.Stats
+	p.Breaker = s.Breaker
+	p.Manifest = s.Manifest
+	p.PathTemplate = sc.PriorityPath
+	p.Dispatcher.FirstWorkerID = PRIORITY_FIRST_WORKER_ID
+	return p
+}
+
+// Returns the priority lane's share of the sink's memory ceiling,
+// the lane's worker gets the same share as a worker of the sink
+func (s *Sink) GetPriorityMemoryLimit(limit int64) int64 {
+	return limit / int64(s.Dispatcher.GetMaxWorkers()+1)
+}
+
+// Is the event sent through the sink's priority lane
+func (s *Sink) IsPriority(event *dialects.Event) bool {
+	if s.Priority == nil {
+		return false
+	}
+	for _, pattern := range s.PriorityEvents {
+		if ok, _ := path.Match(pattern, event.Event); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// Returns the sink (or its priority lane) that handles the event
+func (s *Sink) GetLane(event *dialects.Event) *Sink {
+	if s.IsPriority(event) {
+		return s.Priority
+	}
+	return s
+}
+
+// Starts the workers of the sink and its priority lane, the lane
+// uses the sink's write-ahead log and spool
+func (s *Sink) Run() {
+	if s.Priority != nil {
+		s.Priority.WAL = s.WAL
+		s.Priority.Spool = s.Spool
+		s.Priority.Dispatcher.Run()
+	}
+	s.Dispatcher.Run()
+	if s.Manifest != nil {
+		s.Manifest.Run(MANIFEST_CHECK_INTERVAL)
+	}
+}
+
+// Stops the priority lane after its queued events and scheduled
+// retries are saved
+func (s *Sink) StopPriority() {
+	if s.Priority == nil {
+		return
+	}
+	s.Priority.DrainRetries()
+	s.Priority.Dispatcher.Shutdown()
+}
@@ -0,0 +1,165 @@
+package collector
+
+import (
+	"github.com/wunderlist/hamustro/src/dialects"
+	"github.com/wunderlist/hamustro/src/dialects/file"
+	"github.com/wunderlist/hamustro/src/tracing"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// Returns the number of saved objects in the directory
+func GetTestObjectCount(dir string) int {
+	paths, _ := filepath.Glob(filepath.Join(dir, "*.json"))
+	return len(paths)
+}
+
+// Tests the priority event patterns
+func TestFunctionIsPriority(t *testing.T) {
+	t.Log("Validating the patterns")
+	if !IsValidPattern("Purchase.*") || IsValidPattern("Purchase.[") {
+		t.Errorf("Patterns are not validated correctly")
+	}
+	config = &Config{Sinks: []*SinkConfig{{Name: "archive", PriorityEvents: []string{"["}}}}
+	if _, err := config.GetSinks(); err == nil {
+		t.Errorf("Malformed pattern should be rejected")
+	}
+
+	t.Log("Matching the events with the patterns")
+	config = &Config{}
+	collector := NewTestCollector()
+	log.SetOutput(ioutil.Discard)
+	client := &CountingStorageClient{Buffered: true}
+	sink := collector.NewSinkWithClient(&SinkConfig{Name: "archive", MaxWorkerSize: 1, MaxQueueSize: 10, BufferSize: 10, PriorityEvents: []string{"Purchase.*", "Client.Crash"}, Overflow: OVERFLOW_BLOCK}, client)
+	cases := []struct {
+		Event    string
+		Priority bool
+	}{
+		{"Purchase.Completed", true},
+		{"Purchase.Refunded", true},
+		{"Client.Crash", true},
+		{"Client.CrashReport", false},
+		{"Client.CreateUser", false},
+	}
+	for _, c := range cases {
+		event := &dialects.Event{Event: c.Event}
+		if p := sink.IsPriority(event); p != c.Priority {
+			t.Errorf("Expected priority of %s was %v but it was %v instead", c.Event, c.Priority, p)
+		}
+		if lane := sink.GetLane(event); (lane == sink.Priority) != c.Priority {
+			t.Errorf("%s is sent to the wrong lane", c.Event)
+		}
+	}
+
+	t.Log("Skipping the priority lane without buffering")
+	sink = collector.NewSinkWithClient(&SinkConfig{Name: "queue", MaxWorkerSize: 1, MaxQueueSize: 10, PriorityEvents: []string{"Purchase.*"}, Overflow: OVERFLOW_BLOCK}, &CountingStorageClient{})
+	if sink.Priority != nil || sink.IsPriority(&dialects.Event{Event: "Purchase.Completed"}) {
+		t.Errorf("Unbuffered sink should not have a priority lane")
+	}
+}
+
+// Tests that the priority events are saved within their latency into
+// their own path while the other events stay in the sink's buffer
+func TestSinkPriorityEvents(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "hamustro-priority")
+	defer os.RemoveAll(dir)
+	config = &Config{}
+	collector := NewTestCollector()
+	log.SetOutput(ioutil.Discard)
+	client := &file.FileStorage{FilePath: filepath.Join(dir, "events"), FileFormat: "json", BatchConverter: dialects.ConvertBatchJSON}
+	sink := collector.NewSinkWithClient(&SinkConfig{
+		Name:               "archive",
+		MaxWorkerSize:      1,
+		MaxQueueSize:       10,
+		BufferSize:         100,
+		PriorityEvents:     []string{"Purchase.*"},
+		PriorityBufferSize: 10,
+		PriorityMaxLatency: 1,
+		PriorityPath:       filepath.Join(dir, "priority", "{event}"),
+		Overflow:           OVERFLOW_BLOCK}, client)
+	collector.sinks = []*Sink{sink}
+	sink.Run()
+
+	t.Log("Publishing normal and priority events")
+	for i, name := range []string{"Client.CreateUser", "Purchase.Completed", "Client.CreateUser", "Purchase.Refunded"} {
+		event := GetTestEvent(uint32(700 + i))
+		event.Event = name
+		collector.PublishEvent(event, tracing.SpanContext{})
+	}
+
+	t.Log("Waiting for the priority events")
+	completed := filepath.Join(dir, "priority", "Purchase.Completed")
+	refunded := filepath.Join(dir, "priority", "Purchase.Refunded")
+	deadline := time.Now().Add(3 * time.Second)
+	for GetTestObjectCount(completed)+GetTestObjectCount(refunded) != 2 && time.Now().Before(deadline) {
+		time.Sleep(50 * time.Millisecond)
+	}
+	if n := GetTestObjectCount(completed); n != 1 {
+		t.Errorf("Expected number of Purchase.Completed objects was %d but it was %d instead", 1, n)
+	}
+	if n := GetTestObjectCount(refunded); n != 1 {
+		t.Errorf("Expected number of Purchase.Refunded objects was %d but it was %d instead", 1, n)
+	}
+	if n := GetTestObjectCount(filepath.Join(dir, "events")); n != 0 {
+		t.Errorf("Expected number of normal objects was %d but it was %d instead", 0, n)
+	}
+	if exp, buffered := 2, int(atomic.LoadInt64(&sink.Stats.Buffered)); buffered != exp {
+		t.Errorf("Expected number of buffered events was %d but it was %d instead", exp, buffered)
+	}
+
+	t.Log("Saving the normal events on shutdown")
+	collector.StopSinks()
+	if n := GetTestObjectCount(filepath.Join(dir, "events")); n != 1 {
+		t.Errorf("Expected number of normal objects was %d but it was %d instead", 1, n)
+	}
+	if exp, saved := 4, int(atomic.LoadInt64(&sink.Stats.Saved)); saved != exp {
+		t.Errorf("Expected number of saved events was %d but it was %d instead", exp, saved)
+	}
+	if unsaved := sink.GetUnsaved(); unsaved != 0 {
+		t.Errorf("Expected number of unsaved events was %d but it was %d instead", 0, unsaved)
+	}
+}
+
+// Tests that the priority lane gets a worker's share of the memory
+// ceiling and the reloaded configuration of the sink
+func TestSinkPriorityReconfigure(t *testing.T) {
+	config = &Config{}
+	collector := NewTestCollector()
+	log.SetOutput(ioutil.Discard)
+	sc := &SinkConfig{Name: "archive", MaxWorkerSize: 3, MaxQueueSize: 10, BufferSize: 100, PriorityEvents: []string{"Purchase.*"}, Overflow: OVERFLOW_BLOCK}
+	sink := collector.NewSinkWithClient(sc, &CountingStorageClient{Buffered: true})
+	collector.sinks = []*Sink{sink}
+	sink.Run()
+	defer collector.StopSinks()
+
+	t.Log("Sharing the memory ceiling between the workers and the lane")
+	sink.SetMemoryLimit(4000)
+	if exp := int64(1000); sink.Priority.MemoryLimit != exp {
+		t.Errorf("Expected memory limit of the priority lane was %d but it was %d instead", exp, sink.Priority.MemoryLimit)
+	}
+	if exp := int64(3000); sink.Dispatcher.WorkerOptions.MemoryLimit != exp {
+		t.Errorf("Expected memory limit of the workers was %d but it was %d instead", exp, sink.Dispatcher.WorkerOptions.MemoryLimit)
+	}
+
+	t.Log("Reconfiguring the priority lane with the sink")
+	reloaded := *sc
+	reloaded.MaxWorkerSize = 1
+	reloaded.PriorityBufferSize = 20
+	reloaded.RetryAttempt = 7
+	sink.Reconfigure(&reloaded, 0)
+	worker := sink.Priority.Dispatcher.GetWorkers()[0]
+	done := make(chan struct{})
+	sink.Priority.Dispatcher.Send(&FlushAction{TargetWorkerID: worker.ID, Done: done})
+	<-done
+	if worker.BufferSize != 20 || worker.RetryAttempt != 7 {
+		t.Errorf("Priority lane should be reconfigured but its buffer size was %d and its retry attempt was %d", worker.BufferSize, worker.RetryAttempt)
+	}
+	if exp := int64(2000); worker.GetMemoryLimit() != exp {
+		t.Errorf("Expected memory limit of the priority worker was %d but it was %d instead", exp, worker.GetMemoryLimit())
+	}
+}
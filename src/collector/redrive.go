@@ -0,0 +1,113 @@
+package collector
+
+import (
+	"bufio"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"github.com/wunderlist/hamustro/src/dialects"
+	"github.com/wunderlist/hamustro/src/tracing"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Extension of the dead-letter files that were re-driven
+const REDRIVEN_EXTENSION = ".redriven"
+
+// Returns the dead-letter files within the path (a single file or a directory)
+func GetDeadLetterFiles(path string) ([]string, error) {
+	files := []string{}
+	err := filepath.Walk(path, func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !info.IsDir() && !strings.HasSuffix(p, REDRIVEN_EXTENSION) {
+			files = append(files, p)
+		}
+		return nil
+	})
+	return files, err
+}
+
+// Reads the records of a dead-letter file, the gzipped files are decompressed
+func ReadDeadLetterFile(path string) ([]*DeadLetterRecord, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var reader io.Reader = f
+	if strings.HasSuffix(path, ".gz") {
+		gz, err := gzip.NewReader(f)
+		if err != nil {
+			return nil, err
+		}
+		defer gz.Close()
+		reader = gz
+	}
+
+	records := []*DeadLetterRecord{}
+	scanner := bufio.NewScanner(reader)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		line := scanner.Bytes()
+		if len(line) == 0 {
+			continue
+		}
+		record := &DeadLetterRecord{}
+		if err := json.Unmarshal(line, record); err != nil {
+			return nil, fmt.Errorf("Parsing %s is failed: %s", path, err.Error())
+		}
+		if record.Event != nil {
+			record.Event.Path = record.Path
+			records = append(records, record)
+		}
+	}
+	return records, scanner.Err()
+}
+
+// Publishes the dead-letter records again, the record goes to its
+// original sink if it still exists (otherwise to the routed sinks),
+// the event time policy is not applied again
+func (c *Collector) RedriveRecords(records []*DeadLetterRecord) error {
+	for _, record := range records {
+		var err error
+		if s := c.GetSinkByName(record.Sink); s != nil {
+			err = s.Publish([]*dialects.Event{record.Event}, tracing.SpanContext{})
+		} else {
+			err = c.publishEvents([]*dialects.Event{record.Event}, tracing.SpanContext{}, nil)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Re-drives every dead-letter file within the path, the processed
+// files are renamed so they won't be re-driven twice. Returns the
+// number of re-driven events.
+func (c *Collector) Redrive(path string) (int, error) {
+	files, err := GetDeadLetterFiles(path)
+	if err != nil {
+		return 0, err
+	}
+	redriven := 0
+	for _, file := range files {
+		records, err := ReadDeadLetterFile(file)
+		if err != nil {
+			return redriven, err
+		}
+		if err := c.RedriveRecords(records); err != nil {
+			return redriven, err
+		}
+		redriven += len(records)
+		if err := os.Rename(file, file+REDRIVEN_EXTENSION); err != nil {
+			return redriven, err
+		}
+	}
+	return redriven, nil
+}
@@ -0,0 +1,205 @@
+package collector
+
+import (
+	"fmt"
+	"github.com/wunderlist/hamustro/src/dialects"
+	"reflect"
+	"strings"
+	"time"
+)
+
+// Properties of the application configuration that are applied without a restart
+var reloadableProperties = map[string]bool{
+	"shared_secret":        true,
+	"signature":            true,
+	"masked_ip":            true,
+	"maintenance_key":      true,
+	"maintenance_keys":     true,
+	"maintenance_window":   true,
+	"max_worker_size":      true,
+	"retry_attempt":        true,
+	"buffer_size":          true,
+	"batch_max_bytes":      true,
+	"batch_max_age":        true,
+	"max_inflight_batches": true,
+	"spread_buffer_size":   true,
+	"priority_buffer_size": true,
+	"priority_max_latency": true,
+	"auto_flush_interval":  true,
+	"sinks":                true,
+	"aqs":                  true,
+	"sns":                  true,
+	"abs":                  true,
+	"s3":                   true,
+	"file":                 true}
+
+// Properties of a sink that are applied without a restart
+var reloadableSinkProperties = map[string]bool{
+	"max_worker_size":      true,
+	"retry_attempt":        true,
+	"buffer_size":          true,
+	"batch_max_bytes":      true,
+	"batch_max_age":        true,
+	"max_inflight_batches": true,
+	"spread_buffer_size":   true,
+	"priority_buffer_size": true,
+	"priority_max_latency": true,
+	"aqs":                  true,
+	"sns":                  true,
+	"abs":                  true,
+	"s3":                   true,
+	"file":                 true}
+
+// A validated change of a running sink
+type SinkReload struct {
+	Sink   *Sink
+	Config *SinkConfig
+	Client dialects.StorageClient // New client if the dialect's configuration is changed
+}
+
+// Returns the current configuration, it's the configuration
+// of the startup until the first reload
+func (c *Collector) GetConfig() *Config {
+	if live, ok := c.liveConfig.Load().(*Config); ok && live != nil {
+		return live
+	}
+	return c.config
+}
+
+// Returns the JSON names of the properties that are different
+// in the two structs (pointers of the same type)
+func GetChangedProperties(a interface{}, b interface{}) []string {
+	va, vb := reflect.ValueOf(a).Elem(), reflect.ValueOf(b).Elem()
+	changed := []string{}
+	for i := 0; i < va.NumField(); i++ {
+		field := va.Type().Field(i)
+		if field.PkgPath != "" {
+			continue
+		}
+		if !reflect.DeepEqual(va.Field(i).Interface(), vb.Field(i).Interface()) {
+			changed = append(changed, strings.Split(field.Tag.Get("json"), ",")[0])
+		}
+	}
+	return changed
+}
+
+// Returns the changed properties that can't be applied without a restart
+func GetRejectedProperties(changed []string, reloadable map[string]bool) []string {
+	rejected := []string{}
+	for _, name := range changed {
+		if !reloadable[name] {
+			rejected = append(rejected, "`"+name+"`")
+		}
+	}
+	return rejected
+}
+
+// Validates the new configuration against the current one and creates
+// the clients of the changed dialects, returns the changes of the sinks
+// or an error if something can't be changed without a restart
+func (c *Collector) PrepareReload(config *Config) ([]*SinkReload, error) {
+	if !config.IsValid() {
+		return nil, fmt.Errorf("Config is incomplete, please define `dialect` (or `sinks`) and `shared_secret` property")
+	}
+	if !config.IsValidMaintenanceKeys() {
+		return nil, fmt.Errorf("The `maintenance_key` and the `default` key of `maintenance_keys` can't be set at the same time")
+	}
+	current := c.GetConfig()
+	if rejected := GetRejectedProperties(GetChangedProperties(current, config), reloadableProperties); len(rejected) != 0 {
+		return nil, fmt.Errorf("%s can't be changed without a restart", strings.Join(rejected, ", "))
+	}
+
+	// The derived queue size is kept when the number of workers is changed
+	derived := *config
+	if derived.MaxQueueSize == 0 {
+		derived.MaxQueueSize = current.GetMaxQueueSize()
+	}
+	configs, err := derived.GetSinks()
+	if err != nil {
+		return nil, err
+	}
+	currentConfigs, err := current.GetSinks()
+	if err != nil {
+		return nil, err
+	}
+	if len(configs) != len(currentConfigs) {
+		return nil, fmt.Errorf("Sinks can't be added or removed without a restart")
+	}
+
+	changes := []*SinkReload{}
+	for i, sc := range configs {
+		if sc.Name != currentConfigs[i].Name {
+			return nil, fmt.Errorf("Sinks can't be renamed or reordered without a restart (`%s` instead of `%s`)", sc.Name, currentConfigs[i].Name)
+		}
+		if rejected := GetRejectedProperties(GetChangedProperties(currentConfigs[i], sc), reloadableSinkProperties); len(rejected) != 0 {
+			return nil, fmt.Errorf("%s of `%s` sink can't be changed without a restart", strings.Join(rejected, ", "), sc.Name)
+		}
+		sink := c.GetSinkByName(sc.Name)
+		if sink == nil {
+			continue
+		}
+		change := &SinkReload{Sink: sink, Config: sc}
+
+		// Creates a new client with the changed credentials
+		dialect, err := sc.DialectConfig()
+		if err != nil {
+			return nil, err
+		}
+		currentDialect, _ := currentConfigs[i].DialectConfig()
+		if !reflect.DeepEqual(dialect, currentDialect) {
+			if !dialect.IsValid() {
+				return nil, fmt.Errorf("Dialect configuration of `%s` sink is incorrect or incomplete", sc.Name)
+			}
+			if change.Client, err = dialect.NewClient(); err != nil {
+				return nil, fmt.Errorf("Client initialization of `%s` sink is failed: %s", sc.Name, err.Error())
+			}
+		}
+		changes = append(changes, change)
+	}
+	return changes, nil
+}
+
+// Applies the validated configuration, the request handlers
+// use the new configuration from now on
+func (c *Collector) ApplyReload(config *Config, changes []*SinkReload) {
+	for _, change := range changes {
+		if change.Client != nil {
+			change.Sink.SetStorageClient(change.Client)
+		}
+		change.Sink.Reconfigure(change.Config, time.Duration(config.AutoFlushInterval)*time.Second)
+	}
+	c.SetSignatureRequired(config.IsSignatureRequired())
+	c.liveConfig.Store(config)
+}
+
+// Applies the new configuration, nothing is changed if any of the
+// changes can't be applied. Returns the changed properties.
+func (c *Collector) Reload(config *Config) ([]string, error) {
+	c.reloadLock.Lock()
+	defer c.reloadLock.Unlock()
+
+	if c.signature != "" {
+		overridden := *config
+		overridden.Signature = c.signature
+		config = &overridden
+	}
+	changes, err := c.PrepareReload(config)
+	if err != nil {
+		c.logger.Component("config").Errorf("Reloading the configuration is failed: %s", err.Error())
+		return nil, err
+	}
+	changed := GetChangedProperties(c.GetConfig(), config)
+	c.ApplyReload(config, changes)
+	c.logger.Component("config").Infof("Configuration is reloaded with %d changed properties (%s)", len(changed), strings.Join(changed, ", "))
+	return changed, nil
+}
+
+// Reloads the configuration file, see Reload
+func (c *Collector) ReloadFile(filename string) ([]string, error) {
+	config, err := LoadConfig(filename)
+	if err != nil {
+		c.logger.Component("config").Errorf("Reloading the configuration is failed: %s", err.Error())
+		return nil, err
+	}
+	return c.Reload(config)
+}
@@ -0,0 +1,116 @@
+package collector
+
+import (
+	"fmt"
+	"github.com/wunderlist/hamustro/src/dialects/file"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Writes the configuration file of the reload tests
+func WriteReloadConfig(t *testing.T, filename string, content string) {
+	if err := ioutil.WriteFile(filename, []byte(content), 0600); err != nil {
+		t.Fatalf("Writing the configuration file is failed: %s", err.Error())
+	}
+}
+
+// Tests that the reloadable properties are applied on the running sink
+func TestReloadConfig(t *testing.T) {
+	log.SetOutput(ioutil.Discard)
+	dir, _ := ioutil.TempDir("", "hamustro-reload")
+	defer os.RemoveAll(dir)
+
+	filename := filepath.Join(dir, "config.json")
+	template := `{"shared_secret": "%s", "masked_ip": %t, "signature": "optional", "wal_dir": "%s",
+		"sinks": [{"name": "archive", "dialect": "file", "max_worker_size": %d, "max_queue_size": 10, "buffer_size": %d, "file": {"file_path": "%s", "file_format": "json"}}]}`
+	WriteReloadConfig(t, filename, fmt.Sprintf(template, "secret", false, "", 1, 10, dir))
+	config = NewConfig(filename)
+	configs, _ := config.GetSinks()
+	client := &CountingStorageClient{Buffered: true}
+	collector := NewTestCollector()
+	sink := collector.NewSinkWithClient(configs[0], client)
+	collector.sinks = []*Sink{sink}
+	sink.Dispatcher.Run()
+	defer collector.StopSinks()
+
+	t.Log("Reloading the secret, the masking, the workers, the buffer and the file path")
+	WriteReloadConfig(t, filename, fmt.Sprintf(template, "new-secret", true, "", 3, 50, filepath.Join(dir, "new")))
+	changed, err := collector.ReloadFile(filename)
+	if err != nil {
+		t.Fatalf("Reloading the configuration is failed: %s", err.Error())
+	}
+	if exp := "masked_ip, shared_secret, sinks"; strings.Join(changed, ", ") != exp {
+		t.Errorf("Expected changed properties were %s but it was %s instead", exp, strings.Join(changed, ", "))
+	}
+	if c := collector.GetConfig(); c.SharedSecret != "new-secret" || !c.IsMaskedIP() {
+		t.Errorf("Shared secret and masking should be changed")
+	}
+	if exp := 3; len(sink.Dispatcher.GetWorkers()) != exp {
+		t.Errorf("Expected number of workers was %d but it was %d instead", exp, len(sink.Dispatcher.GetWorkers()))
+	}
+	for _, w := range sink.Dispatcher.GetWorkers() {
+		done := make(chan struct{})
+		sink.Dispatcher.Send(&FlushAction{TargetWorkerID: w.ID, Done: done})
+		<-done
+		if exp := 50; w.BufferSize != exp {
+			t.Errorf("Expected %d worker's buffer size was %d but it was %d instead", w.ID, exp, w.BufferSize)
+		}
+	}
+	if c, ok := sink.GetStorageClient().(*file.FileStorage); !ok || c.FilePath != filepath.Join(dir, "new") {
+		t.Errorf("Storage client should be replaced with the new file path")
+	}
+
+	t.Log("Rejecting the properties that need a restart")
+	WriteReloadConfig(t, filename, fmt.Sprintf(template, "other-secret", true, dir, 3, 50, dir))
+	if _, err := collector.ReloadFile(filename); err == nil || err.Error() != "`wal_dir` can't be changed without a restart" {
+		t.Errorf("Reloading should be rejected because of the write-ahead log, it was %v instead", err)
+	}
+	if collector.GetConfig().SharedSecret != "new-secret" {
+		t.Errorf("Rejected reload should not change anything")
+	}
+}
+
+// Tests that the reloaded settings of the priority lane are applied
+// on the lane's running worker
+func TestReloadPriorityLane(t *testing.T) {
+	log.SetOutput(ioutil.Discard)
+	dir, _ := ioutil.TempDir("", "hamustro-reload")
+	defer os.RemoveAll(dir)
+
+	filename := filepath.Join(dir, "config.json")
+	template := `{"shared_secret": "secret", "priority_buffer_size": %d, "priority_max_latency": %d,
+		"sinks": [{"name": "archive", "dialect": "file", "max_worker_size": 1, "max_queue_size": 10, "buffer_size": 100, "priority_events": ["Purchase.*"], "file": {"file_path": "%s", "file_format": "json"}}]}`
+	WriteReloadConfig(t, filename, fmt.Sprintf(template, 10, 5, dir))
+	config = NewConfig(filename)
+	configs, _ := config.GetSinks()
+	collector := NewTestCollector()
+	sink := collector.NewSinkWithClient(configs[0], &CountingStorageClient{Buffered: true})
+	collector.sinks = []*Sink{sink}
+	sink.Run()
+	defer collector.StopSinks()
+
+	t.Log("Reloading the buffer size and the latency of the priority lane")
+	WriteReloadConfig(t, filename, fmt.Sprintf(template, 20, 2, dir))
+	changed, err := collector.ReloadFile(filename)
+	if err != nil {
+		t.Fatalf("Reloading the configuration is failed: %s", err.Error())
+	}
+	if exp := "priority_buffer_size, priority_max_latency"; strings.Join(changed, ", ") != exp {
+		t.Errorf("Expected changed properties were %s but it was %s instead", exp, strings.Join(changed, ", "))
+	}
+	worker := sink.Priority.Dispatcher.GetWorkers()[0]
+	done := make(chan struct{})
+	sink.Priority.Dispatcher.Send(&FlushAction{TargetWorkerID: worker.ID, Done: done})
+	<-done
+	if exp := 20; worker.BufferSize != exp {
+		t.Errorf("Expected buffer size of the priority worker was %d but it was %d instead", exp, worker.BufferSize)
+	}
+	if exp := 2 * time.Second; worker.MaxAge != exp {
+		t.Errorf("Expected maximum age of the priority worker was %s but it was %s instead", exp, worker.MaxAge)
+	}
+}
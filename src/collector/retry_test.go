@@ -0,0 +1,52 @@
+package collector
+
+import (
+	"fmt"
+	"github.com/wunderlist/hamustro/src/retry"
+	"github.com/wunderlist/hamustro/src/tracing"
+	"io/ioutil"
+	"log"
+	"testing"
+	"time"
+)
+
+// Tests that the failing storage opens the sink's circuit breaker and
+// the events are retried from the retry queue once the storage recovers
+func TestSinkCircuitBreaker(t *testing.T) {
+	config = &Config{RetryBackoff: 5, RetryMaxBackoff: 20, BreakerThreshold: 2, BreakerCooldown: 1}
+	collector := NewTestCollector()
+	log.SetOutput(ioutil.Discard)
+
+	client := &CountingStorageClient{Response: fmt.Errorf("AQS is not available")}
+	sink := collector.NewSinkWithClient(&SinkConfig{Name: "queue", Dialect: "aqs", MaxWorkerSize: 1, MaxQueueSize: 1, RetryAttempt: 100, Overflow: OVERFLOW_BLOCK}, client)
+	collector.sinks = []*Sink{sink}
+	sink.Dispatcher.Run()
+
+	t.Log("Publishing more events than the queue size while the storage is down")
+	for i := 0; i < 5; i++ {
+		collector.PublishEvent(GetTestEvent(uint32(800+i)), tracing.SpanContext{})
+	}
+	for i := 0; i < 100 && sink.Breaker.GetState() != retry.STATE_OPEN; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	status := sink.GetStatus()
+	if status.Circuit != retry.STATE_OPEN {
+		t.Errorf("Expected circuit state was %s but it was %s instead", retry.STATE_OPEN, status.Circuit)
+	}
+	if exp := int64(2); status.Failed != exp {
+		t.Errorf("Expected number of failed saves was %d but it was %d instead (the open circuit must fast-fail)", exp, status.Failed)
+	}
+
+	t.Log("Recovering the storage, the half-open circuit probes and closes")
+	client.SetResponse(nil)
+	for i := 0; i < 300 && client.GetSaved() != 5; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if exp := 5; client.GetSaved() != exp {
+		t.Errorf("Expected number of saved events was %d but it was %d instead", exp, client.GetSaved())
+	}
+	if status := sink.GetStatus(); status.Circuit != retry.STATE_CLOSED || status.Retrying != 0 {
+		t.Errorf("Sink should be closed without retries: %+v", status)
+	}
+	collector.StopSinks()
+}
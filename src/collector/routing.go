@@ -0,0 +1,181 @@
+package collector
+
+import (
+	"fmt"
+	"github.com/wunderlist/hamustro/src/dialects"
+	"regexp"
+	"strings"
+	"sync/atomic"
+)
+
+// Define the known operators of the routing rules
+const ROUTE_EQUALS = "equals"
+const ROUTE_NOT_EQUALS = "not_equals"
+const ROUTE_PREFIX = "prefix"
+const ROUTE_REGEX = "regex"
+const ROUTE_IN = "in"
+const ROUTE_NOT_IN = "not_in"
+
+// A compiled routing rule
+type Route struct {
+	Name     string
+	Field    int
+	Operator string
+	Value    string
+	Values   map[string]bool
+	Regexp   *regexp.Regexp
+	Sinks    []*Sink
+	Continue bool
+	Matched  int64
+}
+
+// Checks that the rule matches the event's values
+func (r *Route) IsMatching(values []string) bool {
+	value := values[r.Field]
+	switch r.Operator {
+	case ROUTE_EQUALS:
+		return value == r.Value
+	case ROUTE_NOT_EQUALS:
+		return value != r.Value
+	case ROUTE_PREFIX:
+		return strings.HasPrefix(value, r.Value)
+	case ROUTE_REGEX:
+		return r.Regexp.MatchString(value)
+	case ROUTE_IN:
+		return r.Values[value]
+	case ROUTE_NOT_IN:
+		return !r.Values[value]
+	}
+	return false
+}
+
+// Routing table, the first matching rule selects the sinks (the
+// evaluation continues if the rule is marked with `continue`),
+// the default route is used if no rule matched
+type Router struct {
+	Routes         []*Route
+	Default        []*Sink
+	DefaultMatched int64
+}
+
+// Returns the sinks for the given names
+func GetSinksByName(names []string, available []*Sink) ([]*Sink, error) {
+	result := []*Sink{}
+	for _, name := range names {
+		found := false
+		for _, s := range available {
+			if s.Name == name {
+				result = append(result, s)
+				found = true
+				break
+			}
+		}
+		if !found {
+			return nil, fmt.Errorf("Unknown `%s` sink in the routing table.", name)
+		}
+	}
+	return result, nil
+}
+
+// Compiles the routing table, the default route contains every
+// sink if it's not defined
+func NewRouter(routes []*RouteConfig, defaultRoute []string, available []*Sink) (*Router, error) {
+	router := &Router{Default: available}
+	if len(defaultRoute) != 0 {
+		sinks, err := GetSinksByName(defaultRoute, available)
+		if err != nil {
+			return nil, err
+		}
+		router.Default = sinks
+	}
+
+	for i, c := range routes {
+		route := &Route{Name: c.Name, Operator: c.Operator, Value: c.Value, Continue: c.Continue}
+		if route.Name == "" {
+			route.Name = fmt.Sprintf("route-%d", i+1)
+		}
+		field, ok := dialects.GetEventFieldIndex(c.Field)
+		if !ok {
+			return nil, fmt.Errorf("Unknown `%s` event field in `%s` route.", c.Field, route.Name)
+		}
+		route.Field = field
+		switch c.Operator {
+		case ROUTE_EQUALS, ROUTE_NOT_EQUALS, ROUTE_PREFIX:
+		case ROUTE_REGEX:
+			re, err := regexp.Compile(c.Value)
+			if err != nil {
+				return nil, fmt.Errorf("Invalid regex in `%s` route: %s", route.Name, err.Error())
+			}
+			route.Regexp = re
+		case ROUTE_IN, ROUTE_NOT_IN:
+			route.Values = map[string]bool{}
+			for _, v := range c.Values {
+				route.Values[v] = true
+			}
+		default:
+			return nil, fmt.Errorf("Not supported `%s` operator in `%s` route (use `equals`, `not_equals`, `prefix`, `regex`, `in` or `not_in`).", c.Operator, route.Name)
+		}
+		sinks, err := GetSinksByName(c.Sinks, available)
+		if err != nil {
+			return nil, err
+		}
+		if len(sinks) == 0 {
+			return nil, fmt.Errorf("Route `%s` has no sinks.", route.Name)
+		}
+		route.Sinks = sinks
+		router.Routes = append(router.Routes, route)
+	}
+	return router, nil
+}
+
+// Returns the sinks of the event, every sink is returned only once
+func (r *Router) Match(event *dialects.Event) []*Sink {
+	values := event.String()
+	matched := false
+	result := []*Sink{}
+	seen := map[*Sink]bool{}
+	for _, route := range r.Routes {
+		if !route.IsMatching(values) {
+			continue
+		}
+		atomic.AddInt64(&route.Matched, 1)
+		matched = true
+		for _, s := range route.Sinks {
+			if !seen[s] {
+				seen[s] = true
+				result = append(result, s)
+			}
+		}
+		if !route.Continue {
+			break
+		}
+	}
+	if !matched {
+		atomic.AddInt64(&r.DefaultMatched, 1)
+		return r.Default
+	}
+	return result
+}
+
+// Counters of a single route for the stats endpoint
+type RouteStatus struct {
+	Name    string `json:"name"`
+	Matched int64  `json:"matched"`
+}
+
+// Returns the counters of every route, the default route is the last one
+func (r *Router) GetStatuses() []*RouteStatus {
+	statuses := []*RouteStatus{}
+	for _, route := range r.Routes {
+		statuses = append(statuses, &RouteStatus{route.Name, atomic.LoadInt64(&route.Matched)})
+	}
+	return append(statuses, &RouteStatus{"default", atomic.LoadInt64(&r.DefaultMatched)})
+}
+
+// Returns the counters of the routing table
+func (c *Collector) GetRouteStatuses() []*RouteStatus {
+	if c.router == nil {
+		return []*RouteStatus{}
+	}
+	return c.router.GetStatuses()
+}
@@ -0,0 +1,109 @@
+package collector
+
+import (
+	"github.com/wunderlist/hamustro/src/dialects"
+	"github.com/wunderlist/hamustro/src/tracing"
+	"strings"
+	"testing"
+)
+
+// Returns sinks for the routing tests
+func GetTestRoutingSinks(names ...string) []*Sink {
+	collector := NewTestCollector()
+	result := []*Sink{}
+	for _, name := range names {
+		result = append(result, collector.NewSinkWithClient(&SinkConfig{Name: name, MaxWorkerSize: 1, MaxQueueSize: 10, Overflow: OVERFLOW_DROP}, &CountingStorageClient{}))
+	}
+	return result
+}
+
+// Returns the names of the sinks
+func GetSinkNames(sinks []*Sink) string {
+	names := []string{}
+	for _, s := range sinks {
+		names = append(names, s.Name)
+	}
+	return strings.Join(names, ",")
+}
+
+// Tests the routing table's validation
+func TestFunctionNewRouterValidation(t *testing.T) {
+	available := GetTestRoutingSinks("archive", "test")
+	cases := []struct {
+		Routes       []*RouteConfig
+		DefaultRoute []string
+	}{
+		{[]*RouteConfig{{Field: "tenant", Operator: ROUTE_EQUALS, Sinks: []string{"archive"}}}, nil},
+		{[]*RouteConfig{{Field: "env", Operator: "like", Sinks: []string{"archive"}}}, nil},
+		{[]*RouteConfig{{Field: "event", Operator: ROUTE_REGEX, Value: "Crash.(", Sinks: []string{"archive"}}}, nil},
+		{[]*RouteConfig{{Field: "env", Operator: ROUTE_EQUALS, Sinks: []string{"queue"}}}, nil},
+		{[]*RouteConfig{{Field: "env", Operator: ROUTE_EQUALS}}, nil},
+		{nil, []string{"queue"}}}
+
+	for i, c := range cases {
+		if _, err := NewRouter(c.Routes, c.DefaultRoute, available); err == nil {
+			t.Errorf("%d. routing table should be invalid", i+1)
+		}
+	}
+}
+
+// Tests the routing of the events
+func TestRouterMatch(t *testing.T) {
+	available := GetTestRoutingSinks("archive", "test", "crashes", "dedicated")
+	routes := []*RouteConfig{
+		{Name: "non-production", Field: "env", Operator: ROUTE_NOT_EQUALS, Value: "PRODUCTION", Sinks: []string{"test"}},
+		{Name: "crashes", Field: "event", Operator: ROUTE_REGEX, Value: "^Crash\\.", Sinks: []string{"crashes", "archive"}, Continue: true},
+		{Name: "tenants", Field: "tenant_id", Operator: ROUTE_IN, Values: []string{"t-1", "t-2"}, Sinks: []string{"dedicated", "archive"}},
+		{Name: "mobile", Field: "system", Operator: ROUTE_PREFIX, Value: "iOS", Sinks: []string{"dedicated"}}}
+	router, err := NewRouter(routes, []string{"archive"}, available)
+	if err != nil {
+		t.Fatalf("Routing table should be valid: %s", err.Error())
+	}
+
+	cases := []struct {
+		Event    *dialects.Event
+		Expected string
+	}{
+		{&dialects.Event{Env: "STAGING", Event: "Crash.App"}, "test"},
+		{&dialects.Event{Env: "PRODUCTION", Event: "Crash.App"}, "crashes,archive"},
+		{&dialects.Event{Env: "PRODUCTION", Event: "Crash.App", TenantID: "t-2"}, "crashes,archive,dedicated"},
+		{&dialects.Event{Env: "PRODUCTION", Event: "Login", TenantID: "t-1"}, "dedicated,archive"},
+		{&dialects.Event{Env: "PRODUCTION", Event: "Login", System: "iOS 9.2"}, "dedicated"},
+		{&dialects.Event{Env: "PRODUCTION", Event: "Login", TenantID: "t-3"}, "archive"}}
+
+	for i, c := range cases {
+		if names := GetSinkNames(router.Match(c.Event)); names != c.Expected {
+			t.Errorf("%d. expected sinks were %s but it was %s instead", i+1, c.Expected, names)
+		}
+	}
+
+	t.Log("Testing the counters of the rules")
+	expected := map[string]int64{"non-production": 1, "crashes": 2, "tenants": 2, "mobile": 1, "default": 1}
+	for _, s := range router.GetStatuses() {
+		if s.Matched != expected[s.Name] {
+			t.Errorf("Expected matches of %s was %d but it was %d instead", s.Name, expected[s.Name], s.Matched)
+		}
+	}
+}
+
+// Tests that the events are published only to the routed sinks
+func TestPublishEventWithRouter(t *testing.T) {
+	sinks := GetTestRoutingSinks("archive", "test")
+	collector := sinks[0].Collector
+	collector.sinks = sinks
+	var err error
+	collector.router, err = NewRouter([]*RouteConfig{{Field: "env", Operator: ROUTE_NOT_EQUALS, Value: "PRODUCTION", Sinks: []string{"test"}}}, nil, sinks)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	collector.PublishEvent(&dialects.Event{Env: "PRODUCTION"}, tracing.SpanContext{})
+	collector.PublishEvent(&dialects.Event{Env: "STAGING"}, tracing.SpanContext{})
+
+	if exp := 1; len(sinks[0].JobQueue) != exp {
+		t.Errorf("Expected queue length of archive was %d but it was %d instead", exp, len(sinks[0].JobQueue))
+	}
+	if exp := 2; len(sinks[1].JobQueue) != exp {
+		t.Errorf("Expected queue length of test was %d but it was %d instead", exp, len(sinks[1].JobQueue))
+	}
+}
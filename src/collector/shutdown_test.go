@@ -0,0 +1,69 @@
+package collector
+
+import (
+	"fmt"
+	"github.com/wunderlist/hamustro/src/tracing"
+	"io/ioutil"
+	"log"
+	"testing"
+	"time"
+)
+
+// Tests that stopping the sink delivers the queued events and the scheduled retries
+func TestSinkStopDrainsQueueAndRetries(t *testing.T) {
+	config = &Config{RetryBackoff: 60000, RetryMaxBackoff: 120000}
+	collector := NewTestCollector()
+	log.SetOutput(ioutil.Discard)
+
+	client := &CountingStorageClient{Response: fmt.Errorf("AQS is not available")}
+	sink := collector.NewSinkWithClient(&SinkConfig{Name: "queue", Dialect: "aqs", MaxWorkerSize: 2, MaxQueueSize: 10, RetryAttempt: 3, Overflow: OVERFLOW_BLOCK}, client)
+	collector.sinks = []*Sink{sink}
+	sink.Dispatcher.Run()
+
+	t.Log("Publishing events while the storage is down, their retries are scheduled after a minute")
+	for i := 0; i < 3; i++ {
+		collector.PublishEvent(GetTestEvent(uint32(700+i)), tracing.SpanContext{})
+	}
+	for i := 0; i < 100 && sink.GetStatus().Retrying != 3; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if exp := 3; sink.GetStatus().Retrying != exp {
+		t.Fatalf("Expected number of scheduled retries was %d but it was %d instead", exp, sink.GetStatus().Retrying)
+	}
+
+	t.Log("Stopping the sink after the storage recovered")
+	client.SetResponse(nil)
+	for i := 0; i < 2; i++ {
+		collector.PublishEvent(GetTestEvent(uint32(710+i)), tracing.SpanContext{})
+	}
+	collector.StopSinks()
+	if exp := 5; client.GetSaved() != exp {
+		t.Errorf("Expected number of saved events was %d but it was %d instead", exp, client.GetSaved())
+	}
+	if unsaved := collector.ReportUnsaved(); unsaved != 0 {
+		t.Errorf("Expected number of unsaved events was %d but it was %d instead", 0, unsaved)
+	}
+}
+
+// Tests that the events which couldn't be saved on stop are reported
+func TestSinkStopReportsUnsaved(t *testing.T) {
+	config = &Config{}
+	collector := NewTestCollector()
+	log.SetOutput(ioutil.Discard)
+
+	client := &CountingStorageClient{Buffered: true, Response: fmt.Errorf("ABS is not available")}
+	sink := collector.NewSinkWithClient(&SinkConfig{Name: "archive", MaxWorkerSize: 1, MaxQueueSize: 10, BufferSize: 10, Overflow: OVERFLOW_BLOCK}, client)
+	collector.sinks = []*Sink{sink}
+	sink.Dispatcher.Run()
+
+	for i := 0; i < 3; i++ {
+		collector.PublishEvent(GetTestEvent(uint32(720+i)), tracing.SpanContext{})
+	}
+	collector.StopSinks()
+	if exp := int64(3); sink.GetStatus().Buffered != exp {
+		t.Errorf("Expected number of buffered events was %d but it was %d instead", exp, sink.GetStatus().Buffered)
+	}
+	if exp, unsaved := 3, collector.ReportUnsaved(); unsaved != exp {
+		t.Errorf("Expected number of unsaved events was %d but it was %d instead", exp, unsaved)
+	}
+}
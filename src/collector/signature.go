@@ -0,0 +1,76 @@
+package collector
+
+import (
+	"crypto/hmac"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"github.com/wunderlist/hamustro/src/payload"
+	"io"
+	"strconv"
+	"sync/atomic"
+)
+
+// Is the signature required for the tracked events
+func (c *Collector) IsSignatureRequired() bool {
+	return atomic.LoadInt32(&c.signatureRequired) == 1
+}
+
+// Sets whether the signature is required for the tracked events,
+// it can be changed on reload
+func (c *Collector) SetSignatureRequired(value bool) {
+	if value {
+		atomic.StoreInt32(&c.signatureRequired, 1)
+	} else {
+		atomic.StoreInt32(&c.signatureRequired, 0)
+	}
+}
+
+// Returns the request's signature with the shared secret
+func GetSignature(secret string, body []byte, time string) string {
+	bodyHash := md5.New()
+	io.WriteString(bodyHash, string(body[:]))
+
+	requestHash := sha256.New()
+	io.WriteString(requestHash, time)
+	io.WriteString(requestHash, "|")
+	io.WriteString(requestHash, hex.EncodeToString(bodyHash.Sum(nil)))
+	io.WriteString(requestHash, "|")
+	io.WriteString(requestHash, secret)
+
+	return base64.StdEncoding.EncodeToString(requestHash.Sum(nil))
+}
+
+// Returns the maintenance request's signature, it is a HMAC-SHA256
+// of the method, the request URI, the time and the body's hash
+func GetMaintenanceSignature(key string, method string, uri string, time string, body []byte) string {
+	bodyHash := sha256.New()
+	bodyHash.Write(body)
+
+	requestHash := hmac.New(sha256.New, []byte(key))
+	io.WriteString(requestHash, method)
+	io.WriteString(requestHash, "|")
+	io.WriteString(requestHash, uri)
+	io.WriteString(requestHash, "|")
+	io.WriteString(requestHash, time)
+	io.WriteString(requestHash, "|")
+	io.WriteString(requestHash, hex.EncodeToString(bodyHash.Sum(nil)))
+
+	return base64.StdEncoding.EncodeToString(requestHash.Sum(nil))
+}
+
+// Returns the protobuf message's session
+func GetSession(c *payload.Collection) string {
+	session := md5.New()
+	io.WriteString(session, c.GetDeviceId())
+	io.WriteString(session, ":")
+	io.WriteString(session, c.GetClientId())
+	io.WriteString(session, ":")
+	io.WriteString(session, c.GetSystemVersion())
+	io.WriteString(session, ":")
+	io.WriteString(session, c.GetProductVersion())
+	io.WriteString(session, ":")
+	io.WriteString(session, strconv.Itoa(int(c.GetEnv())))
+	return hex.EncodeToString(session.Sum(nil))
+}
@@ -0,0 +1,45 @@
+package collector
+
+import (
+	"strconv"
+	"testing"
+)
+
+// Generates a signature for a given string and an EPOCH timestamp
+func TestFunctionGetSignature(t *testing.T) {
+	t.Log("Generating signature for a string.")
+	signature := GetSignature("ultrasafesecret", []byte("something"), strconv.Itoa(1454514088))
+	if exp := "DAfTAP+9T/K/N08k+nwRTWNpfacimS8DJcQG1I4+Moo="; exp != signature {
+		t.Errorf("Expected signature was %s and it was %s instead.", exp, signature)
+	}
+}
+
+// Generates a maintenance signature for a request
+func TestFunctionGetMaintenanceSignature(t *testing.T) {
+	t.Log("Generating maintenance signature for a request.")
+	signature := GetMaintenanceSignature("maintancekey", "POST", "/api/flush", "1454514088", []byte{})
+	if exp := "Dt/pWvbxMGjEwY2zUfqPEN7qxOD+pQdeuZsNbjRr65c="; exp != signature {
+		t.Errorf("Expected signature was %s and it was %s instead.", exp, signature)
+	}
+
+	t.Log("Every part of the request should change the signature.")
+	for _, s := range []string{
+		GetMaintenanceSignature("otherkey", "POST", "/api/flush", "1454514088", []byte{}),
+		GetMaintenanceSignature("maintancekey", "GET", "/api/flush", "1454514088", []byte{}),
+		GetMaintenanceSignature("maintancekey", "POST", "/api/ingest", "1454514088", []byte{}),
+		GetMaintenanceSignature("maintancekey", "POST", "/api/flush", "1454514089", []byte{}),
+		GetMaintenanceSignature("maintancekey", "POST", "/api/flush", "1454514088", []byte("body"))} {
+		if s == signature {
+			t.Errorf("Signature should be different for a different request")
+		}
+	}
+}
+
+// Generates a session identifier for a payload
+func TestFunctionGetSession(t *testing.T) {
+	t.Log("Generating a session for a payload's collection.")
+	collection := GetTestPayloadCollection(97421193, 1)
+	if exp := "0e350a2cc31648bb24ba61eb14be337c"; GetSession(collection) != exp {
+		t.Errorf("Expected session was %s and it was %s instead", exp, GetSession(collection))
+	}
+}
@@ -0,0 +1,606 @@
+package collector
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/wunderlist/hamustro/src/dialects"
+	"github.com/wunderlist/hamustro/src/logging"
+	"github.com/wunderlist/hamustro/src/retry"
+	"github.com/wunderlist/hamustro/src/spool"
+	"github.com/wunderlist/hamustro/src/tracing"
+	"github.com/wunderlist/hamustro/src/wal"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Define the queue overflow policies of the sinks
+const OVERFLOW_BLOCK = "block" // Waits for free space in the queue
+const OVERFLOW_DROP = "drop"   // Drops the event for the given sink
+
+// Maximum waiting time between two spool upload attempts
+const SPOOL_MAX_BACKOFF = 5 * time.Minute
+
+// Counters of a single sink
+type SinkStats struct {
+	sync.Mutex
+	Enqueued            int64
+	Dropped             int64
+	Saved               int64
+	Failed              int64
+	DeadLettered        int64
+	Rebalanced          int64
+	Uploading           int64
+	Buffered            int64
+	Unsaved             int64
+	ConsecutiveFailures int64
+	LastError           string
+	LastErrorAt         time.Time
+}
+
+// Counts an enqueued event
+func (s *SinkStats) AddEnqueued() {
+	if s != nil {
+		atomic.AddInt64(&s.Enqueued, 1)
+	}
+}
+
+// Counts a dropped event
+func (s *SinkStats) AddDropped() {
+	if s != nil {
+		atomic.AddInt64(&s.Dropped, 1)
+	}
+}
+
+// Counts the saved events
+func (s *SinkStats) AddSaved(n int) {
+	if s != nil {
+		atomic.AddInt64(&s.Saved, int64(n))
+		atomic.StoreInt64(&s.ConsecutiveFailures, 0)
+	}
+}
+
+// Counts the in-flight batches
+func (s *SinkStats) AddUploading(n int) {
+	if s != nil {
+		atomic.AddInt64(&s.Uploading, int64(n))
+	}
+}
+
+// Counts the events in the workers' buffers
+func (s *SinkStats) AddBuffered(n int) {
+	if s != nil {
+		atomic.AddInt64(&s.Buffered, int64(n))
+	}
+}
+
+// Counts the events that are abandoned during the shutdown
+func (s *SinkStats) AddUnsaved(n int) {
+	if s != nil {
+		atomic.AddInt64(&s.Unsaved, int64(n))
+	}
+}
+
+// Counts an event sent to the dead-letter
+func (s *SinkStats) AddDeadLettered() {
+	if s != nil {
+		atomic.AddInt64(&s.DeadLettered, 1)
+	}
+}
+
+// Counts an event given to a free worker because its
+// worker with affinity was busy
+func (s *SinkStats) AddRebalanced() {
+	if s != nil {
+		atomic.AddInt64(&s.Rebalanced, 1)
+	}
+}
+
+// Counts the failed events and keeps the last error
+func (s *SinkStats) AddFailed(n int, err error) {
+	if s == nil {
+		return
+	}
+	atomic.AddInt64(&s.Failed, int64(n))
+	atomic.AddInt64(&s.ConsecutiveFailures, 1)
+	s.Lock()
+	defer s.Unlock()
+	s.LastError = err.Error()
+	s.LastErrorAt = time.Now()
+}
+
+// Status of a sink for the health and stats endpoints
+type SinkStatus struct {
+	Name         string       `json:"name"`
+	Dialect      string       `json:"dialect"`
+	Status       string       `json:"status"`
+	Workers      int          `json:"workers"`
+	QueueLength  int          `json:"queue_length"`
+	QueueSize    int          `json:"queue_size"`
+	Enqueued     int64        `json:"enqueued"`
+	Dropped      int64        `json:"dropped"`
+	Saved        int64        `json:"saved"`
+	Failed       int64        `json:"failed"`
+	DeadLettered int64        `json:"dead_lettered"`
+	Rebalanced   int64        `json:"rebalanced"`
+	Circuit      string       `json:"circuit"`
+	Retrying     int          `json:"retrying"`
+	Uploading    int64        `json:"uploading"`
+	Buffered     int64        `json:"buffered"`
+	LastError    string       `json:"last_error,omitempty"`
+	LastErrorAt  string       `json:"last_error_at,omitempty"`
+	Spool        *spool.Stats `json:"spool,omitempty"`
+}
+
+// A storage target with its own queue, workers and counters,
+// so a failing sink doesn't stall the others
+type Sink struct {
+	Name           string
+	Dialect        string
+	Overflow       string
+	JobQueue       chan Job
+	Dispatcher     *Dispatcher
+	Stats          *SinkStats
+	WAL            *wal.Log     // Write-ahead log of the unsaved events (optional)
+	Spool          *spool.Spool // Local directory of the failed batches (optional)
+	Manifest       *Manifest    // Manifests and markers of the saved objects (optional)
+	MemoryLimit    int64        // Memory ceiling of the buffered events in bytes
+	RetryQueue     *retry.Queue
+	Backoff        *retry.Backoff
+	Breaker        *retry.Breaker
+	UploadSlots    chan struct{} // Limits the in-flight uploads of the sink (optional)
+	SortBatch      bool          // Sorts the batches by event time, session and number
+	Deterministic  bool          // Names the objects by the batch's sequence and content
+	BatchMetadata  bool          // Saves the batches' properties in the objects' names and metadata
+	PathTemplate   string        // Path of the objects instead of the storage's path (optional)
+	Priority       *Sink         // Low-latency lane of the priority events (optional)
+	PriorityEvents []string      // Name patterns of the priority events
+	Collector      *Collector
+	client         dialects.StorageClient
+	clientLock     sync.RWMutex
+}
+
+// Creates a new sink with its storage client and dispatcher
+func (c *Collector) NewSink(sc *SinkConfig) (*Sink, error) {
+	dialect, err := sc.DialectConfig()
+	if err != nil {
+		return nil, fmt.Errorf("Loading `%s` sink's dialect configuration is failed: %s", sc.Name, err.Error())
+	}
+	if !dialect.IsValid() {
+		return nil, fmt.Errorf("Dialect configuration of `%s` sink is incorrect or incomplete", sc.Name)
+	}
+	client, err := dialect.NewClient()
+	if err != nil {
+		return nil, fmt.Errorf("Client initialization of `%s` sink is failed: %s", sc.Name, err.Error())
+	}
+	return c.NewSinkWithClient(sc, client), nil
+}
+
+// Creates a new sink for an existing storage client
+func (c *Collector) NewSinkWithClient(sc *SinkConfig, client dialects.StorageClient) *Sink {
+	config := c.GetConfig()
+	s := &Sink{
+		Name:          sc.Name,
+		Dialect:       sc.Dialect,
+		Overflow:      sc.Overflow,
+		JobQueue:      make(chan Job, sc.MaxQueueSize),
+		Stats:         &SinkStats{},
+		Backoff:       config.GetRetryBackoff(),
+		Breaker:       retry.NewBreaker(config.GetBreakerThreshold(), time.Duration(config.GetBreakerCooldown())*time.Second),
+		Collector:     c,
+		SortBatch:     sc.SortBatch,
+		Deterministic: sc.DeterministicNaming,
+		BatchMetadata: sc.BatchMetadata,
+		client:        client}
+	if sc.MaxInflightUploads > 0 {
+		s.UploadSlots = make(chan struct{}, sc.MaxInflightUploads)
+	}
+	if sc.ManifestInterval > 0 {
+		if _, ok := client.(dialects.ObjectStorageClient); ok {
+			s.Manifest = NewManifest(s, time.Duration(sc.ManifestInterval)*time.Minute, fmt.Sprintf("%s-%d", config.GetInstanceID(), time.Now().Unix()))
+		} else {
+			s.GetLogger("manifest").Warnf("Manifests are not supported by `%s` sink's dialect", sc.Name)
+		}
+	}
+	if len(sc.PriorityEvents) != 0 {
+		if client.IsBufferedStorage() {
+			s.PriorityEvents = sc.PriorityEvents
+			s.Priority = c.NewPrioritySink(s, sc)
+		} else {
+			s.GetLogger("dispatcher").Warnf("Priority events are not buffered by `%s` sink's dialect", sc.Name)
+		}
+	}
+	s.RetryQueue = NewRetryQueue(s.JobQueue, config.GetRetryQueueSize(), s.Backoff.Min)
+	s.Dispatcher = NewDispatcher(sc.MaxWorkerSize, &WorkerOptions{
+		BufferSize:    sc.BufferSize,
+		MaxBytes:      int64(sc.BatchMaxBytes),
+		MaxAge:        time.Duration(sc.BatchMaxAge) * time.Second,
+		MaxInFlight:   sc.MaxInflightBatches,
+		RetryAttempt:  sc.RetryAttempt,
+		FlushInterval: time.Duration(config.AutoFlushInterval) * time.Second,
+		SpreadBuffer:  sc.SpreadBufferSize,
+		Affinity:      sc.WorkerAffinity,
+		Sink:          s})
+	if sc.IsScaling() {
+		s.Dispatcher.Scaling = &ScalingOptions{
+			MinWorkers:     sc.MinWorkerSize,
+			QueueThreshold: sc.GetScaleQueueSize(),
+			IdleTime:       time.Duration(config.GetScaleIdleTime()) * time.Second,
+			Cooldown:       time.Duration(config.GetScaleCooldown()) * time.Second}
+	}
+	return s
+}
+
+// Creates a retry queue that puts the actions back into the job queue
+// at their scheduled time, the action waits for the delay if the job
+// queue is full (so the workers never block on a retry)
+func NewRetryQueue(queue chan Job, size int, delay time.Duration) *retry.Queue {
+	q := retry.NewQueue(size)
+	q.Run(func(value interface{}) bool {
+		action := value.(*EventAction)
+		action.EnqueuedAt = time.Now()
+		select {
+		case queue <- action:
+			return true
+		default:
+			return false
+		}
+	}, delay)
+	return q
+}
+
+// Puts the event into the sink's queue based on the overflow policy
+func (s *Sink) Enqueue(action *EventAction) bool {
+	if s.Overflow == OVERFLOW_BLOCK {
+		s.JobQueue <- action
+		s.Stats.AddEnqueued()
+		return true
+	}
+	select {
+	case s.JobQueue <- action:
+		s.Stats.AddEnqueued()
+		return true
+	default:
+		s.Stats.AddDropped()
+		s.WAL.Ack(action.WALSegment, 1)
+		s.GetLogger("dispatcher").RateLimit("queue_full").Warnf("Queue is full, the event is dropped")
+		return false
+	}
+}
+
+// Returns the collector's logger with the component and the sink's name
+func (s *Sink) GetLogger(component string) *logging.Logger {
+	return s.Collector.GetLogger().Component(component).With(logging.Fields{"sink": s.Name})
+}
+
+// Opens the sink's write-ahead log, returns the unsaved records
+func (s *Sink) OpenWAL(options *wal.Options) ([]*wal.Record, error) {
+	log, records, err := wal.Open(options)
+	if err != nil {
+		return nil, fmt.Errorf("Opening `%s` sink's write-ahead log is failed: %s", s.Name, err.Error())
+	}
+	s.WAL = log
+	return records, nil
+}
+
+// Returns the sink's storage client
+func (s *Sink) GetStorageClient() dialects.StorageClient {
+	s.clientLock.RLock()
+	defer s.clientLock.RUnlock()
+	return s.client
+}
+
+// Replaces the sink's storage client (e.g. with new credentials),
+// the running uploads finish with the previous one
+func (s *Sink) SetStorageClient(client dialects.StorageClient) {
+	s.clientLock.Lock()
+	s.client = client
+	s.clientLock.Unlock()
+	if s.Priority != nil {
+		s.Priority.SetStorageClient(client)
+	}
+}
+
+// Changes the number of workers and their limits
+func (s *Sink) Reconfigure(c *SinkConfig, flushInterval time.Duration) {
+	s.Dispatcher.Reconfigure(c.MaxWorkerSize, &WorkerOptions{
+		BufferSize:    c.BufferSize,
+		MaxBytes:      int64(c.BatchMaxBytes),
+		MaxAge:        time.Duration(c.BatchMaxAge) * time.Second,
+		MaxInFlight:   c.MaxInflightBatches,
+		RetryAttempt:  c.RetryAttempt,
+		FlushInterval: flushInterval,
+		SpreadBuffer:  c.SpreadBufferSize})
+	if s.Priority != nil {
+		s.Priority.Reconfigure(c.GetPriorityConfig(), flushInterval)
+		s.SetMemoryLimit(s.MemoryLimit)
+	}
+}
+
+// Changes the memory ceiling of the sink's buffered events, it's
+// shared between the sink's workers and its priority lane
+func (s *Sink) SetMemoryLimit(limit int64) {
+	s.MemoryLimit = limit
+	if s.Priority != nil && limit != 0 {
+		share := s.GetPriorityMemoryLimit(limit)
+		s.Priority.SetMemoryLimit(share)
+		limit -= share
+	}
+	s.Dispatcher.SetMemoryLimit(limit)
+}
+
+// Sets the sink's spool and starts uploading the spooled batches
+// in the background with exponential backoff
+func (s *Sink) OpenSpool(sp *spool.Spool, memoryLimit int64, interval time.Duration) {
+	s.Spool = sp
+	s.Spool.OnDrop = s.DropSpooledBatch
+	s.SetMemoryLimit(memoryLimit)
+	s.Spool.Run(s.UploadSpooledBatch, interval, SPOOL_MAX_BACKOFF, func(err error) {
+		s.GetLogger("spool").RateLimit("upload_failed|"+s.Name).Warnf("Uploading spooled batches is failed: %s", err.Error())
+	})
+}
+
+// Record of the write-ahead log: the event with its own path
+type WALEvent struct {
+	*dialects.Event
+	Path string `json:"_path,omitempty"`
+}
+
+// Writes the events into the write-ahead log and puts them into the queue
+func (s *Sink) Publish(events []*dialects.Event, trace tracing.SpanContext) error {
+	segment, err := s.AppendToWAL(events)
+	if err != nil {
+		return err
+	}
+	s.EnqueueEvents(events, trace, segment)
+	return nil
+}
+
+// Writes the events into the write-ahead log, returns the segment
+// of their records (0 without a write-ahead log)
+func (s *Sink) AppendToWAL(events []*dialects.Event) (uint64, error) {
+	if s.WAL == nil {
+		return 0, nil
+	}
+	records := make([][]byte, len(events))
+	for i, event := range events {
+		b, err := json.Marshal(&WALEvent{event, event.Path})
+		if err != nil {
+			return 0, err
+		}
+		records[i] = b
+	}
+	return s.WAL.Append(records...)
+}
+
+// Puts the events into the queue with the segment of their records
+func (s *Sink) EnqueueEvents(events []*dialects.Event, trace tracing.SpanContext, segment uint64) {
+	for _, event := range events {
+		now := time.Now()
+		s.GetLane(event).Enqueue(&EventAction{Event: event, Attempt: 1, Trace: trace, EnqueuedAt: now, ReceivedAt: now, WALSegment: segment})
+	}
+}
+
+// Puts the unsaved events of the write-ahead log back into the queue,
+// returns the number of replayed events
+func (s *Sink) Replay(records []*wal.Record) int {
+	replayed := 0
+	for _, r := range records {
+		record := &WALEvent{Event: &dialects.Event{}}
+		if err := json.Unmarshal(r.Data, record); err != nil {
+			s.WAL.Ack(r.Segment, 1)
+			continue
+		}
+		event := record.Event
+		event.Path = record.Path
+		s.GetLane(event).JobQueue <- &EventAction{Event: event, Attempt: 1, EnqueuedAt: time.Now(), WALSegment: r.Segment}
+		s.Stats.AddEnqueued()
+		replayed++
+	}
+	return replayed
+}
+
+// Puts the scheduled retries back into the job queue for a last attempt,
+// the retries that fail again are abandoned (see Worker.Abandon)
+func (s *Sink) DrainRetries() {
+	for _, value := range s.RetryQueue.Stop() {
+		action := value.(*EventAction)
+		action.EnqueuedAt = time.Now()
+		s.JobQueue <- action
+	}
+}
+
+// Stops the sink after every queued event and scheduled retry is
+// delivered to the workers and the workers saved their buffers
+func (s *Sink) Stop() {
+	s.StopPriority()
+	s.DrainRetries()
+	s.Dispatcher.Shutdown()
+	s.Manifest.Stop()
+	s.Spool.Stop()
+	if err := s.WAL.Close(); err != nil {
+		s.Dispatcher.GetLogger().Errorf("Closing the write-ahead log is failed: %s", err.Error())
+	}
+}
+
+// Returns the number of events that are not saved yet
+func (s *Sink) GetUnsaved() int {
+	if s.Priority != nil {
+		return len(s.Priority.JobQueue) + s.Priority.RetryQueue.Len() + s.getUnsaved()
+	}
+	return s.getUnsaved()
+}
+
+// Returns the number of events that are not saved yet without the
+// priority lane's queues
+func (s *Sink) getUnsaved() int {
+	return len(s.JobQueue) + s.Dispatcher.GetAffinityLength() + s.RetryQueue.Len() + int(atomic.LoadInt64(&s.Stats.Buffered)) + int(atomic.LoadInt64(&s.Stats.Unsaved))
+}
+
+// Logs the events that are not saved, returns their number
+func (s *Sink) ReportUnsaved() int {
+	unsaved := s.GetUnsaved()
+	uploading := atomic.LoadInt64(&s.Stats.Uploading)
+	if unsaved == 0 && uploading == 0 {
+		return 0
+	}
+	kept := "they are lost"
+	if s.WAL != nil {
+		kept = "they are replayed from the write-ahead log on the next start"
+	}
+	s.Dispatcher.GetLogger().Errorf("%d events are not saved and %d batches are still uploading, %s", unsaved, uploading, kept)
+	return unsaved
+}
+
+// Returns the sink's current status
+func (s *Sink) GetStatus() *SinkStatus {
+	status := &SinkStatus{
+		Name:         s.Name,
+		Dialect:      s.Dialect,
+		Status:       "ok",
+		Workers:      len(s.Dispatcher.GetWorkers()),
+		QueueLength:  len(s.JobQueue),
+		QueueSize:    cap(s.JobQueue),
+		Enqueued:     atomic.LoadInt64(&s.Stats.Enqueued),
+		Dropped:      atomic.LoadInt64(&s.Stats.Dropped),
+		Saved:        atomic.LoadInt64(&s.Stats.Saved),
+		Failed:       atomic.LoadInt64(&s.Stats.Failed),
+		Circuit:      s.Breaker.GetState(),
+		Retrying:     s.RetryQueue.Len(),
+		Uploading:    atomic.LoadInt64(&s.Stats.Uploading),
+		Buffered:     atomic.LoadInt64(&s.Stats.Buffered),
+		DeadLettered: atomic.LoadInt64(&s.Stats.DeadLettered),
+		Rebalanced:   atomic.LoadInt64(&s.Stats.Rebalanced)}
+	if s.Priority != nil {
+		status.QueueLength += len(s.Priority.JobQueue)
+		status.Retrying += s.Priority.RetryQueue.Len()
+	}
+	if atomic.LoadInt64(&s.Stats.ConsecutiveFailures) != 0 {
+		status.Status = "failing"
+	}
+	if s.Spool != nil {
+		status.Spool = s.Spool.GetStats()
+	}
+	s.Stats.Lock()
+	defer s.Stats.Unlock()
+	if s.Stats.LastError != "" {
+		status.LastError = s.Stats.LastError
+		status.LastErrorAt = s.Stats.LastErrorAt.UTC().Format(time.RFC3339)
+	}
+	return status
+}
+
+// Returns the status of every sink
+func (c *Collector) GetSinkStatuses() []*SinkStatus {
+	statuses := []*SinkStatus{}
+	for _, s := range c.sinks {
+		statuses = append(statuses, s.GetStatus())
+	}
+	return statuses
+}
+
+// Returns the registered sink with the given name
+func (c *Collector) GetSinkByName(name string) *Sink {
+	for _, s := range c.sinks {
+		if s.Name == name {
+			return s
+		}
+	}
+	return nil
+}
+
+// Returns every sink's dispatcher
+func (c *Collector) GetDispatchers() []*Dispatcher {
+	dispatchers := []*Dispatcher{}
+	for _, s := range c.sinks {
+		dispatchers = append(dispatchers, s.Dispatcher)
+		if s.Priority != nil {
+			dispatchers = append(dispatchers, s.Priority.Dispatcher)
+		}
+	}
+	return dispatchers
+}
+
+// Sends the event to the sinks selected by the routing table
+func (c *Collector) PublishEvent(event *dialects.Event, trace tracing.SpanContext) error {
+	return c.PublishEvents([]*dialects.Event{event}, trace)
+}
+
+// Sends the events to the sinks selected by the routing table (every
+// sink without routes), every sink gets its own job so the attempts
+// are counted separately. The late and the future events are handled
+// by the event time policy. The error means that the events could not
+// be written into a write-ahead log, none of them is queued then.
+func (c *Collector) PublishEvents(events []*dialects.Event, trace tracing.SpanContext) error {
+	return c.publishEvents(events, trace, c.eventTime)
+}
+
+// Sends the events to their sinks with the event time policy (every
+// event is accepted without it)
+func (c *Collector) publishEvents(events []*dialects.Event, trace tracing.SpanContext, policy *EventTimePolicy) error {
+	grouped := map[*Sink][]*dialects.Event{}
+	now := time.Now()
+	for _, event := range events {
+		targets := c.sinks
+		outcome := policy.Apply(event, now)
+		switch {
+		case outcome == EVENT_TIME_REJECT:
+			continue
+		case outcome == EVENT_TIME_ROUTE && len(policy.Sinks) != 0:
+			targets = policy.Sinks
+		case c.router != nil:
+			targets = c.router.Match(event)
+		}
+		for _, s := range targets {
+			grouped[s] = append(grouped[s], event)
+		}
+	}
+
+	// Every sink's write-ahead log is written before an event is queued,
+	// so the events are not queued in some of the sinks when the request
+	// fails (its retry would save them twice), the records of the other
+	// sinks are acknowledged then
+	targets := []*Sink{}
+	segments := map[*Sink]uint64{}
+	for _, s := range c.sinks {
+		if len(grouped[s]) == 0 {
+			continue
+		}
+		segment, err := s.AppendToWAL(grouped[s])
+		if err != nil {
+			for _, t := range targets {
+				t.WAL.Ack(segments[t], len(grouped[t]))
+			}
+			return err
+		}
+		targets = append(targets, s)
+		segments[s] = segment
+	}
+	for _, s := range targets {
+		s.EnqueueEvents(grouped[s], trace, segments[s])
+	}
+	return nil
+}
+
+// Stops every sink at the same time, the queued events and the
+// scheduled retries are delivered to the workers before they stop
+func (c *Collector) StopSinks() {
+	var wg sync.WaitGroup
+	for _, s := range c.sinks {
+		wg.Add(1)
+		go func(s *Sink) {
+			defer wg.Done()
+			s.Stop()
+		}(s)
+	}
+	wg.Wait()
+}
+
+// Logs the unsaved events of every sink, returns their total number
+func (c *Collector) ReportUnsaved() int {
+	unsaved := 0
+	for _, s := range c.sinks {
+		unsaved += s.ReportUnsaved()
+	}
+	return unsaved
+}
@@ -0,0 +1,151 @@
+package collector
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/wunderlist/hamustro/src/dialects"
+	"github.com/wunderlist/hamustro/src/tracing"
+	"io/ioutil"
+	"log"
+	"sync"
+	"testing"
+	"time"
+)
+
+// Storage Client for the sink tests that counts the saved events
+type CountingStorageClient struct {
+	sync.Mutex
+	Buffered bool
+	Response error
+	Block    chan struct{}
+	Saved    int
+}
+
+func (c *CountingStorageClient) IsBufferedStorage() bool {
+	return c.Buffered
+}
+func (c *CountingStorageClient) GetConverter() dialects.Converter {
+	return dialects.ConvertJSON
+}
+func (c *CountingStorageClient) GetBatchConverter() dialects.BatchConverter {
+	return dialects.ConvertBatchJSON
+}
+func (c *CountingStorageClient) Save(msg *bytes.Buffer) error {
+	if c.Block != nil {
+		<-c.Block
+	}
+	c.Lock()
+	defer c.Unlock()
+	if c.Response != nil {
+		return c.Response
+	}
+	c.Saved += bytes.Count(msg.Bytes(), []byte("\n"))
+	return nil
+}
+func (c *CountingStorageClient) SetResponse(err error) {
+	c.Lock()
+	defer c.Unlock()
+	c.Response = err
+}
+func (c *CountingStorageClient) GetSaved() int {
+	c.Lock()
+	defer c.Unlock()
+	return c.Saved
+}
+
+// Testing the sink configuration
+func TestFunctionGetSinks(t *testing.T) {
+	t.Log("Testing the default sink when no sinks are defined")
+	config := &Config{Dialect: "s3", MaxWorkerSize: 3, MaxQueueSize: 30, BufferSize: 100}
+	sinks, err := config.GetSinks()
+	if err != nil || len(sinks) != 1 {
+		t.Fatalf("Expected a single default sink")
+	}
+	if s := sinks[0]; s.Name != "default" || s.Dialect != "s3" || s.MaxWorkerSize != 3 || s.MaxQueueSize != 30 || s.BufferSize != 100 || s.Overflow != OVERFLOW_BLOCK {
+		t.Errorf("Default sink has unexpected properties: %+v", s)
+	}
+
+	t.Log("Testing the inherited properties of the named sinks")
+	config = &Config{MaxWorkerSize: 3, MinWorkerSize: 1, MaxQueueSize: 30, BufferSize: 100, RetryAttempt: 2, Sinks: []*SinkConfig{
+		&SinkConfig{Name: "archive", Dialect: "s3"},
+		&SinkConfig{Name: "realtime", Dialect: "sns", MaxWorkerSize: 8, ScaleQueueSize: 5, RetryAttempt: 5, Overflow: OVERFLOW_BLOCK}}}
+	sinks, err = config.GetSinks()
+	if err != nil || len(sinks) != 2 {
+		t.Fatalf("Expected two sinks")
+	}
+	if s := sinks[0]; s.MaxWorkerSize != 3 || s.MaxQueueSize != 30 || s.RetryAttempt != 2 || s.Overflow != OVERFLOW_DROP {
+		t.Errorf("Archive sink has unexpected properties: %+v", s)
+	}
+	if s := sinks[1]; s.MaxWorkerSize != 8 || s.MaxQueueSize != 30 || s.RetryAttempt != 5 || s.Overflow != OVERFLOW_BLOCK {
+		t.Errorf("Realtime sink has unexpected properties: %+v", s)
+	}
+	if s := sinks[0]; !s.IsScaling() || s.MinWorkerSize != 1 || s.GetScaleQueueSize() != 15 {
+		t.Errorf("Archive sink should be scaled from 1 worker above 15 queued events: %+v", s)
+	}
+	if s := sinks[1]; !s.IsScaling() || s.GetScaleQueueSize() != 5 {
+		t.Errorf("Realtime sink should be scaled above 5 queued events: %+v", s)
+	}
+	if config.Sinks[0].MaxWorkerSize != 0 {
+		t.Errorf("The configuration should not be modified")
+	}
+
+	t.Log("Testing the invalid sink configurations")
+	cases := [][]*SinkConfig{
+		{&SinkConfig{Dialect: "s3"}},
+		{&SinkConfig{Name: "a", Dialect: "s3"}, &SinkConfig{Name: "a", Dialect: "sns"}},
+		{&SinkConfig{Name: "a", Dialect: "s3", Overflow: "spill"}},
+		{&SinkConfig{Name: "a", Dialect: "s3", MaxWorkerSize: 2, MinWorkerSize: 4}}}
+	for i, c := range cases {
+		if _, err := (&Config{Sinks: c}).GetSinks(); err == nil {
+			t.Errorf("%d. sink configuration should be invalid", i+1)
+		}
+	}
+}
+
+// Tests that a stalled sink doesn't block the other sinks
+func TestSinkFanOutIsolation(t *testing.T) {
+	config = &Config{}
+	collector := NewTestCollector()
+	log.SetOutput(ioutil.Discard)
+
+	archive := &CountingStorageClient{Buffered: true}
+	stalled := &CountingStorageClient{Block: make(chan struct{})}
+	failing := &CountingStorageClient{Response: fmt.Errorf("SNS is not available")}
+	collector.sinks = []*Sink{
+		collector.NewSinkWithClient(&SinkConfig{Name: "archive", MaxWorkerSize: 1, MaxQueueSize: 10, BufferSize: 10, Overflow: OVERFLOW_DROP}, archive),
+		collector.NewSinkWithClient(&SinkConfig{Name: "stalled", MaxWorkerSize: 1, MaxQueueSize: 2, RetryAttempt: 1, Overflow: OVERFLOW_DROP}, stalled),
+		collector.NewSinkWithClient(&SinkConfig{Name: "failing", MaxWorkerSize: 1, MaxQueueSize: 10, RetryAttempt: 1, Overflow: OVERFLOW_DROP}, failing)}
+	for _, s := range collector.sinks {
+		s.Dispatcher.Run()
+	}
+
+	for i := 0; i < 10; i++ {
+		collector.PublishEvent(GetTestEvent(uint32(1000+i)), tracing.SpanContext{})
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	t.Log("Archive sink saves every event while the stalled one is blocked")
+	for i := 0; i < 100 && archive.GetSaved() != 10; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if exp := 10; archive.GetSaved() != exp {
+		t.Errorf("Expected saved events of the archive sink was %d but it was %d instead", exp, archive.GetSaved())
+	}
+
+	statuses := map[string]*SinkStatus{}
+	for _, s := range collector.GetSinkStatuses() {
+		statuses[s.Name] = s
+	}
+	if s := statuses["archive"]; s.Status != "ok" || s.Saved != 10 || s.Dropped != 0 {
+		t.Errorf("Archive sink has unexpected status: %+v", s)
+	}
+	if s := statuses["stalled"]; s.Dropped == 0 || s.Enqueued+s.Dropped != 10 {
+		t.Errorf("Stalled sink should drop the events when the queue is full: %+v", s)
+	}
+	if s := statuses["failing"]; s.Status != "failing" || s.Failed == 0 || s.LastError != "SNS is not available" {
+		t.Errorf("Failing sink has unexpected status: %+v", s)
+	}
+
+	close(stalled.Block)
+	collector.StopSinks()
+}
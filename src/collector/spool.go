@@ -0,0 +1,75 @@
+package collector
+
+import (
+	"bytes"
+	"encoding/json"
+	"github.com/wunderlist/hamustro/src/dialects"
+	"github.com/wunderlist/hamustro/src/retry"
+	"time"
+)
+
+// Prefix of the spooled batches that are saved with their properties,
+// the batches spooled by the earlier versions have only their events
+const SPOOL_RECORD_PREFIX = "#hamustro-batch "
+
+// Partition of a failed batch in the sink's spool with the
+// properties of its object
+type SpooledBatch struct {
+	Info    *dialects.BatchInfo `json:"info"`
+	TakenAt time.Time           `json:"taken_at"` // Time when the batch was taken first
+	Hold    string              `json:"hold"`     // Key of the batch's hold in the manifest
+	Message []byte              `json:"-"`        // Converted events
+}
+
+// Returns the spooled batch's record: the prefix and the
+// properties in the first line, then the converted events
+func (b *SpooledBatch) Marshal() ([]byte, error) {
+	properties, err := json.Marshal(b)
+	if err != nil {
+		return nil, err
+	}
+	record := make([]byte, 0, len(SPOOL_RECORD_PREFIX)+len(properties)+1+len(b.Message))
+	record = append(record, SPOOL_RECORD_PREFIX...)
+	record = append(record, properties...)
+	record = append(record, '\n')
+	return append(record, b.Message...), nil
+}
+
+// Parses the spooled batch's record, the record without
+// properties is returned with its events only
+func UnmarshalSpooledBatch(record []byte) *SpooledBatch {
+	if !bytes.HasPrefix(record, []byte(SPOOL_RECORD_PREFIX)) {
+		return &SpooledBatch{Message: record}
+	}
+	end := bytes.IndexByte(record, '\n')
+	batch := &SpooledBatch{}
+	if end < 0 || json.Unmarshal(record[len(SPOOL_RECORD_PREFIX):end], batch) != nil {
+		return &SpooledBatch{Message: record}
+	}
+	batch.Message = record[end+1:]
+	return batch
+}
+
+// Saves the spooled batch with its properties (into its partition and
+// with its name and metadata), adds its object to the manifest and
+// releases its window
+func (s *Sink) UploadSpooledBatch(record []byte) error {
+	if !s.Breaker.Allow() {
+		return retry.ErrOpen
+	}
+	batch := UnmarshalSpooledBatch(record)
+	err := dialects.SaveBatchWithSpan(s.GetStorageClient(), bytes.NewBuffer(batch.Message), batch.Info, nil)
+	s.Breaker.Record(err)
+	if err == nil && batch.Info != nil {
+		s.Manifest.Add(batch.Info, batch.TakenAt)
+	}
+	if err == nil {
+		s.Manifest.Release(batch.Hold)
+	}
+	return err
+}
+
+// Releases the manifest's hold of a dropped or expired spooled batch
+func (s *Sink) DropSpooledBatch(record []byte) {
+	s.Manifest.Release(UnmarshalSpooledBatch(record).Hold)
+}
@@ -0,0 +1,273 @@
+package collector
+
+import (
+	"fmt"
+	"github.com/wunderlist/hamustro/src/dialects"
+	"github.com/wunderlist/hamustro/src/spool"
+	"github.com/wunderlist/hamustro/src/tracing"
+	"io/ioutil"
+	"log"
+	"os"
+	"testing"
+	"time"
+)
+
+// Tests that the failed batches beyond the memory ceiling are spilled
+// to the spool and uploaded once the storage recovers
+func TestSinkSpillover(t *testing.T) {
+	config = &Config{}
+	collector := NewTestCollector()
+	log.SetOutput(ioutil.Discard)
+	dir, err := ioutil.TempDir("", "hamustro-spool")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	client := &CountingStorageClient{Buffered: true, Response: fmt.Errorf("S3 is not available")}
+	sink := collector.NewSinkWithClient(&SinkConfig{Name: "archive", MaxWorkerSize: 1, MaxQueueSize: 10, BufferSize: 2, Overflow: OVERFLOW_BLOCK}, client)
+	sp, err := spool.New(dir, 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sink.OpenSpool(sp, 1, 10*time.Millisecond)
+	collector.sinks = []*Sink{sink}
+	sink.Dispatcher.Run()
+
+	t.Log("Publishing events while the storage is down")
+	for i := 0; i < 4; i++ {
+		collector.PublishEvent(GetTestEvent(uint32(700+i)), tracing.SpanContext{})
+	}
+	for i := 0; i < 100 && sp.GetStats().Files != 2; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if exp := 2; sp.GetStats().Files != exp {
+		t.Errorf("Expected number of spooled batches was %d but it was %d instead", exp, sp.GetStats().Files)
+	}
+	if status := sink.GetStatus(); status.Spool == nil || status.Spool.Files == 0 {
+		t.Errorf("Sink status should contain the spool: %+v", status)
+	}
+
+	t.Log("Uploading the spooled batches after the storage recovered")
+	client.SetResponse(nil)
+	for i := 0; i < 100 && client.GetSaved() != 4; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if exp := 4; client.GetSaved() != exp {
+		t.Errorf("Expected number of saved events was %d but it was %d instead", exp, client.GetSaved())
+	}
+	if exp := 0; sp.GetStats().Files != exp {
+		t.Errorf("Expected number of spooled batches was %d but it was %d instead", exp, sp.GetStats().Files)
+	}
+	collector.StopSinks()
+}
+
+// Tests that a batch without converted events is converted before
+// it's spilled and the batch that can't be converted is kept
+func TestSpillBatchWithoutMessage(t *testing.T) {
+	config = &Config{}
+	storageClient = &PoisonStorageClient{CountingStorageClient{Buffered: true}}
+	jobQueue = make(chan Job, 10)
+	log.SetOutput(ioutil.Discard)
+	dir, err := ioutil.TempDir("", "hamustro-spool")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	worker := NewTestWorker(1, &WorkerOptions{BufferSize: 10}, make(chan *Worker, 1))
+	if worker.Sink.Spool, err = spool.New(dir, 0, 0); err != nil {
+		t.Fatal(err)
+	}
+
+	t.Log("Spilling a batch without its converted events")
+	batch := &Batch{Events: []*dialects.Event{GetTestEvent(710), GetTestEvent(711)}, WAL: []uint64{0, 0}}
+	if err := worker.SpillBatch(batch); err != nil {
+		t.Errorf("Batch should be spilled: %s", err.Error())
+	}
+	if exp := 1; worker.GetSpool().GetStats().Files != exp {
+		t.Errorf("Expected number of spooled batches was %d but it was %d instead", exp, worker.GetSpool().GetStats().Files)
+	}
+
+	t.Log("Keeping the batch that can't be converted")
+	poison := GetTestEvent(712)
+	poison.Event = "Poison"
+	if err := worker.SpillBatch(&Batch{Events: []*dialects.Event{poison}, WAL: []uint64{0}}); err == nil {
+		t.Errorf("Batch that can't be converted should not be spilled")
+	}
+	if exp := 1; worker.GetSpool().GetStats().Files != exp {
+		t.Errorf("Expected number of spooled batches was %d but it was %d instead", exp, worker.GetSpool().GetStats().Files)
+	}
+}
+
+// Tests that the spilled batches are saved per partition with their
+// properties and the spooled events without properties are still saved
+func TestSpooledBatchProperties(t *testing.T) {
+	config = &Config{}
+	collector := NewTestCollector()
+	log.SetOutput(ioutil.Discard)
+	dir, err := ioutil.TempDir("", "hamustro-spool")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	client := &PartitionedStorageClient{Path: "events/env={env}"}
+	client.Buffered = true
+	client.Response = fmt.Errorf("S3 is not available")
+	sink := collector.NewSinkWithClient(&SinkConfig{Name: "archive", MaxWorkerSize: 1, MaxQueueSize: 10, BufferSize: 3, BatchMetadata: true, Overflow: OVERFLOW_BLOCK}, client)
+	sp, err := spool.New(dir, 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sink.OpenSpool(sp, 1, 10*time.Millisecond)
+	collector.sinks = []*Sink{sink}
+	sink.Dispatcher.Run()
+
+	t.Log("Spilling the partitions of a batch while the storage is down")
+	for i, env := range []string{"production", "dev", "production"} {
+		event := GetTestEvent(uint32(720 + i))
+		event.Env = env
+		collector.PublishEvent(event, tracing.SpanContext{})
+	}
+	for i := 0; i < 100 && sp.GetStats().Files != 2; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if exp := 2; sp.GetStats().Files != exp {
+		t.Errorf("Expected number of spooled batches was %d but it was %d instead", exp, sp.GetStats().Files)
+	}
+	if err := sp.Write([]byte("{\"event\":\"Legacy\"}\n")); err != nil {
+		t.Fatal(err)
+	}
+
+	t.Log("Uploading the spooled batches after the storage recovered")
+	client.Lock()
+	failed := len(client.Infos)
+	client.Unlock()
+	client.SetResponse(nil)
+	for i := 0; i < 100 && client.GetSaved() != 4; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	collector.StopSinks()
+	if exp := 4; client.GetSaved() != exp {
+		t.Errorf("Expected number of saved events was %d but it was %d instead", exp, client.GetSaved())
+	}
+	saved := map[string]int{}
+	for _, info := range client.Infos[failed:] {
+		if !info.Metadata {
+			t.Errorf("Spooled batch should be saved with its metadata")
+		}
+		saved[dialects.ResolveEventPath(client.Path, info.Partition)] += info.Events
+	}
+	expected := map[string]int{"events/env=production": 2, "events/env=dev": 1}
+	for partition, exp := range expected {
+		if saved[partition] != exp {
+			t.Errorf("Expected number of saved events in %s was %d but it was %d instead", partition, exp, saved[partition])
+		}
+	}
+}
+
+// Tests that the restored batch is spilled alone and it's uploaded
+// from the spool with the same name as its failed attempt
+func TestSpillRestoredBatch(t *testing.T) {
+	config = &Config{InstanceID: "host-1"}
+	client := &BatchInfoStorageClient{CountingStorageClient: CountingStorageClient{Buffered: true, Response: fmt.Errorf("S3 timed out")}}
+	storageClient = client
+	jobQueue = make(chan Job, 10)
+	log.SetOutput(ioutil.Discard)
+	dir, err := ioutil.TempDir("", "hamustro-spool")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	worker := NewTestWorker(1, &WorkerOptions{BufferSize: 10}, make(chan *Worker, 1))
+	worker.Sink.Deterministic = true
+	if worker.Sink.Spool, err = spool.New(dir, 0, 0); err != nil {
+		t.Fatal(err)
+	}
+
+	t.Log("Spilling a failed batch with a new event behind it")
+	worker.AddEventToBuffer(GetTestEvent(730))
+	worker.AddEventToBuffer(GetTestEvent(731))
+	if err := worker.SaveBatch(); err == nil {
+		t.Fatalf("Batch should not be saved while the storage times out")
+	}
+	worker.AddEventToBuffer(GetTestEvent(732))
+	if err := worker.SpillBuffer(); err != nil {
+		t.Fatalf("Buffer should be spilled: %s", err.Error())
+	}
+	if exp := 2; worker.GetSpool().GetStats().Files != exp {
+		t.Errorf("Expected number of spooled batches was %d but it was %d instead", exp, worker.GetSpool().GetStats().Files)
+	}
+
+	t.Log("Uploading the spooled batches with their names")
+	client.SetResponse(nil)
+	if _, err := worker.GetSpool().Upload(worker.Sink.UploadSpooledBatch); err != nil {
+		t.Fatalf("Spooled batches should be uploaded: %s", err.Error())
+	}
+	if exp := 3; len(client.Infos) != exp {
+		t.Fatalf("Expected number of save attempts was %d but it was %d instead", exp, len(client.Infos))
+	}
+	first, retry, next := client.Infos[0], client.Infos[1], client.Infos[2]
+	if name := dialects.GetBatchFileName("json", true, first); name != dialects.GetBatchFileName("json", true, retry) {
+		t.Errorf("Spooled batch should have the same name %s but it was %s", name, dialects.GetBatchFileName("json", true, retry))
+	}
+	if retry.Events != 2 || next.Events != 1 || next.Sequence == first.Sequence {
+		t.Errorf("Spooled batches have unexpected properties: %+v %+v", retry, next)
+	}
+}
+
+// Tests that the restored batch spilled by a removed worker and the
+// batches of the worker started in its place never share a name
+func TestSpillRestoredBatchAfterResize(t *testing.T) {
+	config = &Config{InstanceID: "host-1"}
+	client := &BatchInfoStorageClient{CountingStorageClient: CountingStorageClient{Buffered: true, Response: fmt.Errorf("S3 timed out")}}
+	storageClient = client
+	jobQueue = make(chan Job, 10)
+	log.SetOutput(ioutil.Discard)
+	dir, err := ioutil.TempDir("", "hamustro-spool")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	dispatcher := NewTestDispatcher(2, &WorkerOptions{BufferSize: 10})
+	sink := dispatcher.WorkerOptions.Sink
+	sink.Deterministic = true
+	if sink.Spool, err = spool.New(dir, 0, 0); err != nil {
+		t.Fatal(err)
+	}
+	dispatcher.Start()
+	save := func(worker *Worker) {
+		worker.JobChannel <- &EventAction{Event: GetTestEvent(740), Attempt: 1}
+		done := make(chan struct{})
+		dispatcher.Send(&FlushAction{TargetWorkerID: worker.ID, Done: done})
+		<-done
+	}
+
+	t.Log("Spilling the restored batch of the second worker while it's removed")
+	save(dispatcher.GetWorkers()[1])
+	dispatcher.Reconfigure(1, &WorkerOptions{BufferSize: 10})
+	if exp := 1; sink.Spool.GetStats().Files != exp {
+		t.Fatalf("Expected number of spooled batches was %d but it was %d instead", exp, sink.Spool.GetStats().Files)
+	}
+	failed := len(client.Infos)
+
+	t.Log("Saving the same event with the worker started in its place")
+	dispatcher.Reconfigure(2, &WorkerOptions{BufferSize: 10})
+	client.SetResponse(nil)
+	save(dispatcher.GetWorkers()[1])
+	if _, err := sink.Spool.Upload(sink.UploadSpooledBatch); err != nil {
+		t.Fatalf("Spooled batches should be uploaded: %s", err.Error())
+	}
+	dispatcher.Stop()
+
+	if exp := failed + 2; len(client.Infos) != exp {
+		t.Fatalf("Expected number of save attempts was %d but it was %d instead", exp, len(client.Infos))
+	}
+	first, next, spooled := client.Infos[0], client.Infos[failed], client.Infos[failed+1]
+	if name := dialects.GetBatchFileName("json", true, first); name != dialects.GetBatchFileName("json", true, spooled) {
+		t.Errorf("Spooled batch should have the name of its failed attempt %s but it was %s", name, dialects.GetBatchFileName("json", true, spooled))
+	}
+	if name := dialects.GetBatchFileName("json", true, next); name == dialects.GetBatchFileName("json", true, spooled) || next.WorkerID == spooled.WorkerID {
+		t.Errorf("Batch of the new worker should not get the name of the spooled batch: %s", name)
+	}
+}
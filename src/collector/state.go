@@ -0,0 +1,99 @@
+package collector
+
+import (
+	"sync/atomic"
+)
+
+// Define the known ingestion states
+const INGESTION_ACCEPTING = 0
+const INGESTION_PAUSED = 1
+const INGESTION_DRAINING = 2
+
+// Define the known upload states
+const UPLOAD_ACTIVE = 0
+const UPLOAD_PAUSED = 1
+
+// Names of the ingestion states used by the admin and health endpoints
+var ingestionStateNames = map[int32]string{
+	INGESTION_ACCEPTING: "accepting",
+	INGESTION_PAUSED:    "paused",
+	INGESTION_DRAINING:  "draining"}
+
+// Names of the upload states used by the admin and health endpoints
+var uploadStateNames = map[int32]string{
+	UPLOAD_ACTIVE: "active",
+	UPLOAD_PAUSED: "paused"}
+
+// Returns the current ingestion state
+func (c *Collector) GetIngestionState() int32 {
+	return atomic.LoadInt32(&c.ingestionState)
+}
+
+// Sets the ingestion state
+func (c *Collector) SetIngestionState(state int32) {
+	atomic.StoreInt32(&c.ingestionState, state)
+}
+
+// Returns the name of the current ingestion state
+func (c *Collector) GetIngestionStateName() string {
+	return ingestionStateNames[c.GetIngestionState()]
+}
+
+// Returns the ingestion state for a given name
+func ParseIngestionState(name string) (int32, bool) {
+	for state, n := range ingestionStateNames {
+		if n == name {
+			return state, true
+		}
+	}
+	return 0, false
+}
+
+// Are we accepting new events
+func (c *Collector) IsAcceptingEvents() bool {
+	return c.GetIngestionState() == INGESTION_ACCEPTING
+}
+
+// Returns the current upload state
+func (c *Collector) GetUploadState() int32 {
+	return atomic.LoadInt32(&c.uploadState)
+}
+
+// Sets the upload state
+func (c *Collector) SetUploadState(state int32) {
+	atomic.StoreInt32(&c.uploadState, state)
+}
+
+// Returns the name of the current upload state
+func (c *Collector) GetUploadStateName() string {
+	return uploadStateNames[c.GetUploadState()]
+}
+
+// Returns the upload state for a given name
+func ParseUploadState(name string) (int32, bool) {
+	for state, n := range uploadStateNames {
+		if n == name {
+			return state, true
+		}
+	}
+	return 0, false
+}
+
+// Are the uploads paused
+func (c *Collector) IsUploadPaused() bool {
+	return c.GetUploadState() == UPLOAD_PAUSED
+}
+
+// Is the server shutting down
+func (c *Collector) IsTerminating() bool {
+	return atomic.LoadInt32(&c.terminating) == 1
+}
+
+// Sets whether the server is shutting down
+func (c *Collector) SetTerminating(value bool) {
+	if value {
+		atomic.StoreInt32(&c.terminating, 1)
+	} else {
+		atomic.StoreInt32(&c.terminating, 0)
+	}
+}
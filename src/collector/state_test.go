@@ -0,0 +1,68 @@
+package collector
+
+import (
+	"testing"
+)
+
+// Testing the ingestion state transitions
+func TestFunctionIngestionState(t *testing.T) {
+	t.Log("Testing the ingestion state")
+	collector := NewTestCollector()
+
+	cases := []struct {
+		Name              string
+		ExpectedAccepting bool
+	}{
+		{"paused", false},
+		{"draining", false},
+		{"accepting", true}}
+
+	for _, c := range cases {
+		state, ok := ParseIngestionState(c.Name)
+		if !ok {
+			t.Errorf("Ingestion state %s should be known", c.Name)
+		}
+		collector.SetIngestionState(state)
+		if collector.GetIngestionStateName() != c.Name {
+			t.Errorf("Expected ingestion state was %s but it was %s instead", c.Name, collector.GetIngestionStateName())
+		}
+		if collector.IsAcceptingEvents() != c.ExpectedAccepting {
+			t.Errorf("Expected accepting events was %t but it was %t instead", c.ExpectedAccepting, collector.IsAcceptingEvents())
+		}
+	}
+
+	if _, ok := ParseIngestionState("sleeping"); ok {
+		t.Errorf("Not existing ingestion state should not be parsed")
+	}
+}
+
+// Testing the upload state transitions
+func TestFunctionUploadState(t *testing.T) {
+	t.Log("Testing the upload state")
+	collector := NewTestCollector()
+
+	cases := []struct {
+		Name           string
+		ExpectedPaused bool
+	}{
+		{"paused", true},
+		{"active", false}}
+
+	for _, c := range cases {
+		state, ok := ParseUploadState(c.Name)
+		if !ok {
+			t.Errorf("Upload state %s should be known", c.Name)
+		}
+		collector.SetUploadState(state)
+		if collector.GetUploadStateName() != c.Name {
+			t.Errorf("Expected upload state was %s but it was %s instead", c.Name, collector.GetUploadStateName())
+		}
+		if collector.IsUploadPaused() != c.ExpectedPaused {
+			t.Errorf("Expected paused uploads was %t but it was %t instead", c.ExpectedPaused, collector.IsUploadPaused())
+		}
+	}
+
+	if _, ok := ParseUploadState("draining"); ok {
+		t.Errorf("Not existing upload state should not be parsed")
+	}
+}
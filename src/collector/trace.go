@@ -0,0 +1,53 @@
+package collector
+
+import (
+	"fmt"
+	"github.com/wunderlist/hamustro/src/tracing"
+	"net/http"
+)
+
+// Records the response's status for the request's span
+type TracedResponseWriter struct {
+	http.ResponseWriter
+	Span   *tracing.Span
+	Status int
+}
+
+// Creates a response writer that finishes the span with the response's status
+func NewTracedResponseWriter(w http.ResponseWriter, r *http.Request, span *tracing.Span) *TracedResponseWriter {
+	span.SetAttribute("http.method", r.Method)
+	span.SetAttribute("http.target", r.URL.Path)
+	return &TracedResponseWriter{ResponseWriter: w, Span: span}
+}
+
+func (w *TracedResponseWriter) WriteHeader(code int) {
+	if w.Status == 0 {
+		w.Status = code
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *TracedResponseWriter) Write(b []byte) (int, error) {
+	if w.Status == 0 {
+		w.Status = http.StatusOK
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+// Returns the wrapped response writer
+func (w *TracedResponseWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// Finishes the request's span
+func (w *TracedResponseWriter) Finish() {
+	if w.Status == 0 {
+		w.Status = http.StatusOK
+	}
+	w.Span.SetAttribute("http.status_code", w.Status)
+	if w.Status >= 500 {
+		w.Span.FinishWithError(fmt.Errorf("Request is failed with status %d", w.Status))
+		return
+	}
+	w.Span.Finish()
+}
@@ -0,0 +1,95 @@
+package collector
+
+import (
+	"bytes"
+	"encoding/json"
+	"github.com/wunderlist/hamustro/src/tracing"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+// Tests the spans from the request to the batch upload
+func TestTrackHandlerTracing(t *testing.T) {
+	var mutex sync.Mutex
+	spans := map[string]map[string]interface{}{}
+	exporter := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		var request struct {
+			ResourceSpans []struct {
+				ScopeSpans []struct {
+					Spans []map[string]interface{} `json:"spans"`
+				} `json:"scopeSpans"`
+			} `json:"resourceSpans"`
+		}
+		json.Unmarshal(body, &request)
+		mutex.Lock()
+		defer mutex.Unlock()
+		for _, s := range request.ResourceSpans[0].ScopeSpans[0].Spans {
+			spans[s["name"].(string)] = s
+		}
+	}))
+	defer exporter.Close()
+
+	config = &Config{SharedSecret: "ultrasafesecret"}
+	storageClient = &BufferedStorageClientWithoutExpected{}
+	jobQueue = make(chan Job, 10)
+	log.SetOutput(ioutil.Discard)
+	T, response, catched = t, nil, false
+	dispatcher := NewTestDispatcher(1, &WorkerOptions{BufferSize: 2})
+	collector := dispatcher.GetCollector()
+	tracer := tracing.NewTracer(exporter.URL, "hamustro", 100)
+	collector.tracer = tracer
+	tracer.Run(time.Hour)
+
+	body, jobs := GetTestProtobufCollectionBody(54321, 2)
+	req, _ := http.NewRequest("POST", "/api/v1/track", bytes.NewBuffer(body.Collection))
+	req.Header.Set("Content-Type", "application/protobuf")
+	req.Header.Set("traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	resp := httptest.NewRecorder()
+	collector.TrackHandler(resp, req)
+	if resp.Code != http.StatusOK {
+		t.Fatalf("Expected status code was %d but it was %d instead", http.StatusOK, resp.Code)
+	}
+
+	t.Log("Processing the queued events with a single worker")
+	worker := NewTestWorker(0, &WorkerOptions{BufferSize: len(jobs), Sink: dispatcher.WorkerOptions.Sink}, dispatcher.WorkerPool)
+	for range jobs {
+		action := (<-jobQueue).(*EventAction)
+		dispatcher.TraceQueueWait(action)
+		if err := worker.Work(action); err != nil {
+			t.Errorf("Work is failed: %s", err.Error())
+		}
+	}
+	if err := tracer.Shutdown(); err != nil {
+		t.Fatalf("Exporting is failed: %s", err.Error())
+	}
+
+	for _, name := range []string{"TrackHandler", "TrackHandler.decode", "Dispatcher.queue_wait", "Worker.SaveBatch", "Worker.SaveBatch.convert", "StorageClient.Save"} {
+		if _, ok := spans[name]; !ok {
+			t.Errorf("Span %s was not exported", name)
+		}
+	}
+
+	request := spans["TrackHandler"]
+	if request == nil || spans["Worker.SaveBatch"] == nil || spans["Dispatcher.queue_wait"] == nil {
+		t.FailNow()
+	}
+	if exp := "4bf92f3577b34da6a3ce929d0e0e4736"; request["traceId"] != exp {
+		t.Errorf("Expected trace ID was %s but it was %v instead", exp, request["traceId"])
+	}
+	if spans["Dispatcher.queue_wait"]["parentSpanId"] != request["spanId"] {
+		t.Errorf("Queue wait span should be the child of the request span")
+	}
+	links, _ := spans["Worker.SaveBatch"]["links"].([]interface{})
+	if exp := 1; len(links) != exp {
+		t.Fatalf("Expected number of links was %d but it was %d instead", exp, len(links))
+	}
+	if links[0].(map[string]interface{})["spanId"] != request["spanId"] {
+		t.Errorf("Batch span should be linked to the request span")
+	}
+}
@@ -0,0 +1,164 @@
+package collector
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/bfaludi/remoteip"
+	"github.com/golang/protobuf/jsonpb"
+	"github.com/golang/protobuf/proto"
+	"github.com/wunderlist/hamustro/src/logging"
+	"github.com/wunderlist/hamustro/src/payload"
+	"github.com/wunderlist/hamustro/src/tracing"
+	"io/ioutil"
+	"mime"
+	"net/http"
+)
+
+// Returns the client's address
+func GetRemoteAddress(r *http.Request) string {
+	if remoteAddress := remoteip.GetIPv4Address(r); remoteAddress != "" {
+		return remoteAddress
+	}
+	return r.RemoteAddr
+}
+
+// Prints the error messages.
+func (c *Collector) BroadcastError(w http.ResponseWriter, r *http.Request, err string, code int) {
+	c.BroadcastErrorWithFields(w, r, err, code, nil)
+}
+
+// Prints the error messages with additional log fields, client errors
+// are rate limited per remote address to avoid flooding the log.
+func (c *Collector) BroadcastErrorWithFields(w http.ResponseWriter, r *http.Request, err string, code int, fields logging.Fields) {
+	remoteAddress := GetRemoteAddress(r)
+	l := c.logger.Component("http").With(logging.Fields{
+		"remote_ip": remoteAddress,
+		"path":      r.URL.Path,
+		"status":    code}).With(fields)
+	if code >= 500 {
+		l.Errorf("%s", err)
+	} else {
+		l.RateLimit(remoteAddress).Warnf("%s", err)
+	}
+
+	if c.verbose {
+		w.Header().Set("Content-Type", "application/json")
+	}
+	w.WriteHeader(code)
+	if c.verbose {
+		fmt.Fprintf(w, `{"error":%q}`, err)
+	}
+}
+
+// Controller for `/api/v1/track`
+func (c *Collector) TrackHandler(w http.ResponseWriter, r *http.Request) {
+	// Trace the request, continue the client's trace if it's given
+	span := c.tracer.Start("TrackHandler", tracing.SPAN_KIND_SERVER, tracing.ParseTraceParent(r.Header.Get("traceparent")))
+	if span != nil {
+		tw := NewTracedResponseWriter(w, r, span)
+		defer tw.Finish()
+		w = tw
+	}
+
+	// Do not accept new events while the server is shutting down.
+	if c.IsTerminating() {
+		c.BroadcastError(w, r, "Server is currenly shutting down", http.StatusServiceUnavailable)
+		return
+	}
+
+	// Do not accept new events while the ingestion is paused or draining.
+	if !c.IsAcceptingEvents() {
+		c.BroadcastError(w, r, fmt.Sprintf("Server is not accepting new events (%s)", c.GetIngestionStateName()), http.StatusServiceUnavailable)
+		return
+	}
+
+	// Ignore not POST messages.
+	if r.Method != "POST" {
+		c.BroadcastError(w, r, "Sending method is not POST", http.StatusMethodNotAllowed)
+		return
+	}
+
+	// Checks that the client want to send signature or not
+	definedSignature := r.Header.Get("X-Hamustro-Time") != "" || r.Header.Get("X-Hamustro-Signature") != ""
+
+	// If the client did not send time, we ignore
+	if (c.IsSignatureRequired() || definedSignature) && r.Header.Get("X-Hamustro-Time") == "" {
+		c.BroadcastError(w, r, "X-Hamustro-Time header is missing", http.StatusMethodNotAllowed)
+		return
+	}
+
+	// If the client did not send signature of the message, we ignore
+	if (c.IsSignatureRequired() || definedSignature) && r.Header.Get("X-Hamustro-Signature") == "" {
+		c.BroadcastError(w, r, "X-Hamustro-Signature header is missing", http.StatusMethodNotAllowed)
+		return
+	}
+
+	// Read the requests body into a variable.
+	body, _ := ioutil.ReadAll(r.Body)
+
+	// Calculate the request's signature
+	if (c.IsSignatureRequired() || definedSignature) && r.Header.Get("X-Hamustro-Signature") != GetSignature(c.GetConfig().SharedSecret, body, r.Header.Get("X-Hamustro-Time")) {
+		c.BroadcastError(w, r, "X-Hamustro-Signature header is invalid", http.StatusMethodNotAllowed)
+		return
+	}
+
+	collection := &payload.Collection{}
+	contentType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	decode := span.Child("TrackHandler.decode", tracing.SPAN_KIND_INTERNAL)
+	decode.SetAttribute("content_type", contentType)
+	decode.SetAttribute("bytes", len(body))
+	switch contentType {
+	case "application/json":
+		if err := jsonpb.Unmarshal(bytes.NewBuffer(body), collection); err != nil {
+			decode.FinishWithError(err)
+			c.BroadcastError(w, r, fmt.Sprintf("Unmarshaling json collection is failed: %s", err.Error()), http.StatusBadRequest)
+			return
+		}
+		if !collection.IsValid() {
+			decode.FinishWithError(fmt.Errorf("Required field not set"))
+			c.BroadcastError(w, r, fmt.Sprintf("Unmarshaled json collection is failed: required field not set"), http.StatusBadRequest)
+			return
+		}
+	case "application/protobuf":
+		if err := proto.Unmarshal(body, collection); err != nil {
+			decode.FinishWithError(err)
+			c.BroadcastError(w, r, fmt.Sprintf("Unmarshaling protobuf is failed: %s", err.Error()), http.StatusBadRequest)
+			return
+		}
+	default:
+		decode.FinishWithError(fmt.Errorf("Unsupported Content-Type"))
+		c.BroadcastError(w, r, "Unsupported or missing Content-Type", http.StatusBadRequest)
+		return
+	}
+	decode.Finish()
+
+	AnnotateAccessLog(w, collection.GetClientId(), len(collection.GetPayloads()))
+	span.SetAttribute("client_id", collection.GetClientId())
+	span.SetAttribute("payload_count", len(collection.GetPayloads()))
+
+	// Checks the session information
+	if GetSession(collection) != collection.GetSession() {
+		c.BroadcastErrorWithFields(w, r, "Collection's session attribute is invalid", http.StatusBadRequest, logging.Fields{"client_id": collection.GetClientId()})
+		return
+	}
+
+	// Stop if no payload information was received
+	if !collection.HasPayloads() {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	c.logger.Component("http").With(logging.Fields{
+		"client_id":     collection.GetClientId(),
+		"payload_count": len(collection.GetPayloads())}).Debugf("Received a collection")
+
+	// Creates a Job for every sink and put into their JobQueue for processing.
+	events := c.NewEvents(collection, remoteip.GetIPv4Address(r))
+	if err := c.PublishEvents(events, span.SpanContext()); err != nil {
+		c.BroadcastError(w, r, fmt.Sprintf("Writing the write-ahead log is failed: %s", err.Error()), http.StatusServiceUnavailable)
+		return
+	}
+
+	// Returns with 200.
+	w.WriteHeader(http.StatusOK)
+}
@@ -1,4 +1,4 @@
-package main
+package collector
 
 import (
 	"bytes"
@@ -37,7 +37,7 @@ func GetIncompleteCollectionBody(t *TrackBodyCollection) []byte {
 func GetJobsFromCollection(collection *payload.Collection) []*EventAction {
 	var jobs []*EventAction
 	for _, payload := range collection.GetPayloads() {
-		jobs = append(jobs, &EventAction{dialects.NewEvent(collection, payload), 1})
+		jobs = append(jobs, &EventAction{Event: dialects.NewEvent(collection, payload), Attempt: 1})
 	}
 	return jobs
 }
@@ -88,7 +88,7 @@ func MaskJobs(jobs []*EventAction) []*EventAction {
 	for _, j := range jobs {
 		event := *j.GetEvent()
 		event.TruncateIPv4LastOctet()
-		returnJobs = append(returnJobs, &EventAction{&event, j.Attempt})
+		returnJobs = append(returnJobs, &EventAction{Event: &event, Attempt: j.Attempt})
 	}
 	return returnJobs
 }
@@ -112,26 +112,26 @@ func GetMissingHeader(t *TrackHandlerInput, fn BodyFunction) map[string]string {
 	return map[string]string{}
 }
 func GetHeaderWithoutTime(t *TrackHandlerInput, fn BodyFunction) map[string]string {
-	return map[string]string{"X-Hamustro-Signature": GetSignature(fn(t.BodyCollection), t.Time), "Content-Type": t.ContentType}
+	return map[string]string{"X-Hamustro-Signature": GetSignature(config.SharedSecret, fn(t.BodyCollection), t.Time), "Content-Type": t.ContentType}
 }
 func GetHeaderWithoutSignature(t *TrackHandlerInput, fn BodyFunction) map[string]string {
 	return map[string]string{"X-Hamustro-Time": t.Time, "Content-Type": t.ContentType}
 }
 func GetHeaderWithInvalidSignature(t *TrackHandlerInput, fn BodyFunction) map[string]string {
-	return map[string]string{"X-Hamustro-Time": t.Time, "X-Hamustro-Signature": GetSignature(fn(t.BodyCollection), t.Time) + "x", "Content-Type": t.ContentType}
+	return map[string]string{"X-Hamustro-Time": t.Time, "X-Hamustro-Signature": GetSignature(config.SharedSecret, fn(t.BodyCollection), t.Time) + "x", "Content-Type": t.ContentType}
 }
 func GetHeaderWithoutContentType(t *TrackHandlerInput, fn BodyFunction) map[string]string {
-	return map[string]string{"X-Hamustro-Time": t.Time, "X-Hamustro-Signature": GetSignature(fn(t.BodyCollection), t.Time)}
+	return map[string]string{"X-Hamustro-Time": t.Time, "X-Hamustro-Signature": GetSignature(config.SharedSecret, fn(t.BodyCollection), t.Time)}
 }
 func GetHeaderWithInvalidContentType(t *TrackHandlerInput, fn BodyFunction) map[string]string {
-	return map[string]string{"X-Hamustro-Time": t.Time, "X-Hamustro-Signature": GetSignature(fn(t.BodyCollection), t.Time), "Content-Type": "not-existing"}
+	return map[string]string{"X-Hamustro-Time": t.Time, "X-Hamustro-Signature": GetSignature(config.SharedSecret, fn(t.BodyCollection), t.Time), "Content-Type": "not-existing"}
 }
 func GetHeaderWithWrongContentType(t *TrackHandlerInput, fn BodyFunction) map[string]string {
 	wContentType := map[string]string{"application/json": "application/protobuf", "application/protobuf": "application/json"}[t.ContentType]
-	return map[string]string{"X-Hamustro-Time": t.Time, "X-Hamustro-Signature": GetSignature(fn(t.BodyCollection), t.Time), "Content-Type": wContentType}
+	return map[string]string{"X-Hamustro-Time": t.Time, "X-Hamustro-Signature": GetSignature(config.SharedSecret, fn(t.BodyCollection), t.Time), "Content-Type": wContentType}
 }
 func GetValidHeader(t *TrackHandlerInput, fn BodyFunction) map[string]string {
-	return map[string]string{"X-Hamustro-Time": t.Time, "X-Hamustro-Signature": GetSignature(fn(t.BodyCollection), t.Time), "Content-Type": t.ContentType}
+	return map[string]string{"X-Hamustro-Time": t.Time, "X-Hamustro-Signature": GetSignature(config.SharedSecret, fn(t.BodyCollection), t.Time), "Content-Type": t.ContentType}
 }
 
 // Signature values
@@ -151,23 +151,23 @@ type TrackHandlerTestCase struct {
 }
 
 // Executes the test cases for the given inputs
-func RunBatchTestOnTrackHandler(t *testing.T, cases []*TrackHandlerTestCase, inputs []*TrackHandlerInput) {
+func RunBatchTestOnTrackHandler(t *testing.T, collector *Collector, cases []*TrackHandlerTestCase, inputs []*TrackHandlerInput) {
 	for i, c := range cases {
-		isTerminating = c.IsTerminating
+		collector.SetTerminating(c.IsTerminating)
 		for _, signature := range map[int][]bool{Optional: []bool{false}, Required: []bool{true}, Any: []bool{true, false}}[c.Signature] {
-			signatureRequired = signature
+			collector.SetSignatureRequired(signature)
 			for _, masked := range []bool{false, true} {
 				config.MaskedIP = masked
 				for _, isVerbose := range []bool{true, false} {
-					verbose = isVerbose         // Sets the verbose mode
-					exp = map[string]struct{}{} // Resets the expectations dict
+					collector.verbose = isVerbose // Sets the verbose mode
+					exp = map[string]struct{}{}   // Resets the expectations dict
 
 					for j, b := range inputs {
 						if b.MaxTestCase <= i {
 							continue
 						}
 						t.Logf("Working on %d/%d test case in %s %s mode %s", i+1, j+1,
-							map[bool]string{true: "verbose", false: "production"}[verbose],
+							map[bool]string{true: "verbose", false: "production"}[collector.verbose],
 							map[bool]string{true: "masked", false: "unmasked"}[masked],
 							map[bool]string{true: "with signature", false: "without signature"}[signature])
 
@@ -194,7 +194,7 @@ func RunBatchTestOnTrackHandler(t *testing.T, cases []*TrackHandlerTestCase, inp
 								SetEventExpectation([]*EventAction{job}, false, false)
 							}
 						}
-						TrackHandler(resp, req) // Calls the API
+						collector.TrackHandler(resp, req) // Calls the API
 
 						// If we're expecting some output, we'll wait for the results
 						if b.Jobs != nil && c.CheckResults {
@@ -204,7 +204,7 @@ func RunBatchTestOnTrackHandler(t *testing.T, cases []*TrackHandlerTestCase, inp
 						}
 
 						// Log the output to double-check the test case vs reality (debug)
-						if verbose && resp.Body.Len() != 0 {
+						if collector.verbose && resp.Body.Len() != 0 {
 							t.Logf("- Response's body was %s", resp.Body)
 						}
 
@@ -221,14 +221,13 @@ func RunBatchTestOnTrackHandler(t *testing.T, cases []*TrackHandlerTestCase, inp
 // Tests the API
 func TestTrackHandlerRequiredSignature(t *testing.T) {
 	t.Log("Creating new workers")
-	config = &Config{}                                              // Create an empty config
-	storageClient = &SimpleStorageClient{}                          // Define the Simple Storage as a storage
-	jobQueue = make(chan Job, 10)                                   // Creates a jobQueue
-	log.SetOutput(ioutil.Discard)                                   // Disable the logger
-	T, response, catched = t, nil, false                            // Set properties for the SimpleStorageClient
-	dispatcher := NewDispatcher(2, &WorkerOptions{RetryAttempt: 5}) // Creates a dispatcher
-	dispatcher.Run()                                                // Starts the dispatcher
-	config = &Config{SharedSecret: "ultrasafesecret"}               // Creates a config
+	config = &Config{SharedSecret: "ultrasafesecret"}                   // Creates a config
+	storageClient = &SimpleStorageClient{}                              // Define the Simple Storage as a storage
+	jobQueue = make(chan Job, 10)                                       // Creates a jobQueue
+	log.SetOutput(ioutil.Discard)                                       // Disable the logger
+	T, response, catched = t, nil, false                                // Set properties for the SimpleStorageClient
+	dispatcher := NewTestDispatcher(2, &WorkerOptions{RetryAttempt: 5}) // Creates a dispatcher
+	dispatcher.Run()                                                    // Starts the dispatcher
 
 	if exp := 2; len(dispatcher.Workers) != exp {
 		t.Errorf("Expected worker's count was %d but it was %d instead", exp, len(dispatcher.Workers))
@@ -244,7 +243,7 @@ func TestTrackHandlerRequiredSignature(t *testing.T) {
 		rTime                                  = "1454514088"
 	)
 
-	RunBatchTestOnTrackHandler(t,
+	RunBatchTestOnTrackHandler(t, dispatcher.GetCollector(),
 		[]*TrackHandlerTestCase{
 			{"GET", GetMissingHeader, GetCollectionBody, true, Any, http.StatusServiceUnavailable, false},                        // 1. Service is shutting down
 			{"GET", GetMissingHeader, GetCollectionBody, false, Any, http.StatusMethodNotAllowed, false},                         // 2. GET is not supported
@@ -271,7 +270,7 @@ func TestTrackHandlerRequiredSignature(t *testing.T) {
 			{jsonMultipleBody, rTime, "application/json", jsonMultipleBodyJobs, 16},
 		})
 
-	RunBatchTestOnTrackHandler(t,
+	RunBatchTestOnTrackHandler(t, dispatcher.GetCollector(),
 		[]*TrackHandlerTestCase{
 			{"POST", GetValidHeader, GetCollectionBody, false, Any, http.StatusNoContent, false},                      // 1. Valid message without content
 			{"POST", GetValidHeaderWithoutSignature, GetCollectionBody, false, Optional, http.StatusNoContent, false}, // 2. Valid message without content without signature
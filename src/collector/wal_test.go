@@ -0,0 +1,113 @@
+package collector
+
+import (
+	"github.com/wunderlist/hamustro/src/tracing"
+	"github.com/wunderlist/hamustro/src/wal"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// Tests that the unsaved events are replayed after a crash
+// and the write-ahead log is emptied once they are saved
+func TestSinkWriteAheadLog(t *testing.T) {
+	config = &Config{}
+	collector := NewTestCollector()
+	log.SetOutput(ioutil.Discard)
+	dir, err := ioutil.TempDir("", "hamustro-wal")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	options := &wal.Options{Dir: dir, Sync: wal.SYNC_ALWAYS}
+	sinkConfig := &SinkConfig{Name: "archive", MaxWorkerSize: 1, MaxQueueSize: 10, BufferSize: 4, Overflow: OVERFLOW_BLOCK}
+
+	t.Log("Publishing events without processing them (crash before saving)")
+	crashed := collector.NewSinkWithClient(sinkConfig, &CountingStorageClient{Buffered: true})
+	if _, err := crashed.OpenWAL(options); err != nil {
+		t.Fatal(err)
+	}
+	collector.sinks = []*Sink{crashed}
+	for i := 0; i < 4; i++ {
+		if err := collector.PublishEvent(GetTestEvent(uint32(500+i)), tracing.SpanContext{}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	t.Log("Restarting the sink and replaying the write-ahead log")
+	client := &CountingStorageClient{Buffered: true}
+	restarted := collector.NewSinkWithClient(sinkConfig, client)
+	records, err := restarted.OpenWAL(options)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if exp := 4; len(records) != exp {
+		t.Fatalf("Expected number of unsaved records was %d but it was %d instead", exp, len(records))
+	}
+	collector.sinks = []*Sink{restarted}
+	restarted.Dispatcher.Run()
+	restarted.Replay(records)
+
+	for i := 0; i < 100 && client.GetSaved() != 4; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if exp := 4; client.GetSaved() != exp {
+		t.Errorf("Expected number of saved events was %d but it was %d instead", exp, client.GetSaved())
+	}
+	if exp := 1; restarted.WAL.GetSegmentCount() != exp {
+		t.Errorf("Only the current segment should be kept but it was %d segments", restarted.WAL.GetSegmentCount())
+	}
+	collector.StopSinks()
+	if files, _ := ioutil.ReadDir(dir); len(files) != 0 {
+		t.Errorf("Every segment should be removed after the shutdown but %d remained", len(files))
+	}
+}
+
+// Tests that the events are not queued in any sink if the write-ahead
+// log of a sink fails, so the retried request doesn't duplicate them
+func TestPublishEventsWithFailingWriteAheadLog(t *testing.T) {
+	config = &Config{}
+	collector := NewTestCollector()
+	log.SetOutput(ioutil.Discard)
+	dir, err := ioutil.TempDir("", "hamustro-wal")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	sinks := []*Sink{}
+	for _, name := range []string{"archive", "backup"} {
+		sink := collector.NewSinkWithClient(&SinkConfig{Name: name, MaxWorkerSize: 1, MaxQueueSize: 10, BufferSize: 4, Overflow: OVERFLOW_BLOCK}, &CountingStorageClient{Buffered: true})
+		if _, err := sink.OpenWAL(&wal.Options{Dir: filepath.Join(dir, name), Sync: wal.SYNC_ALWAYS}); err != nil {
+			t.Fatal(err)
+		}
+		sinks = append(sinks, sink)
+	}
+	collector.sinks = sinks
+
+	t.Log("Publishing an event while the second sink's write-ahead log fails")
+	sinks[1].WAL.Close()
+	if err := collector.PublishEvent(GetTestEvent(510), tracing.SpanContext{}); err == nil {
+		t.Fatalf("Publishing should fail when a write-ahead log fails")
+	}
+	for _, sink := range sinks {
+		if exp := 0; len(sink.JobQueue) != exp {
+			t.Errorf("Expected number of queued events of `%s` sink was %d but it was %d instead", sink.Name, exp, len(sink.JobQueue))
+		}
+	}
+
+	t.Log("Retrying the request after the write-ahead log recovered")
+	if _, err := sinks[1].OpenWAL(&wal.Options{Dir: filepath.Join(dir, "backup"), Sync: wal.SYNC_ALWAYS}); err != nil {
+		t.Fatal(err)
+	}
+	if err := collector.PublishEvent(GetTestEvent(510), tracing.SpanContext{}); err != nil {
+		t.Fatal(err)
+	}
+	for _, sink := range sinks {
+		if exp := 1; len(sink.JobQueue) != exp {
+			t.Errorf("Expected number of queued events of `%s` sink was %d but it was %d instead", sink.Name, exp, len(sink.JobQueue))
+		}
+	}
+}
@@ -0,0 +1,622 @@
+package collector
+
+import (
+	"fmt"
+	"github.com/wunderlist/hamustro/src/dialects"
+	"github.com/wunderlist/hamustro/src/logging"
+	"github.com/wunderlist/hamustro/src/retry"
+	"github.com/wunderlist/hamustro/src/spool"
+	"github.com/wunderlist/hamustro/src/tracing"
+	"github.com/wunderlist/hamustro/src/wal"
+	"sync"
+	"time"
+)
+
+// Maximum number of requests linked to a single batch
+const MAX_TRACE_LINKS = 128
+
+// Number of targeted jobs waiting for a worker
+const CONTROL_CHANNEL_SIZE = 4
+
+// Worker that executes the job.
+type Worker struct {
+	ID              int
+	WorkerPool      chan *Worker
+	JobChannel      chan Job // Event jobs from the shared worker pool
+	ControlChannel  chan Job // Targeted jobs (flush, stop, reconfigure)
+	AffinityChannel chan Job // Events of the worker's sessions or devices (optional)
+	BufferSize      int
+	BufferedEvents  []*dialects.Event
+	BufferedWAL     []uint64 // Write-ahead log segments of the buffered events
+	BufferedBytes   int64    // Uncompressed size of the buffered events
+	BufferedAt      time.Time
+	MaxBytes        int64         // Maximum uncompressed size of a batch (optional)
+	MaxAge          time.Duration // Maximum age of a batch (optional)
+	MaxInFlight     int           // Maximum number of uploading batches, 0 uploads synchronously
+	InFlight        int
+	Penalty         float32
+	RetryAttempt    int
+	LastSave        time.Time
+	FlushInterval   time.Duration // Interval of the automatic flushes, 0 disables them
+	MemoryLimit     int64         // Worker's share of the sink's memory ceiling in bytes
+	TraceLinks      []tracing.SpanContext
+	Sequence        uint64   // Sequence of the last batch
+	Restored        []*Batch // Failed batches at the front of the buffer, the last one is the first
+	Released        []*Batch // Failed batches merged into the buffer, they're pending until it's taken
+	Sink            *Sink
+	uploads         chan *UploadResult
+	done            chan struct{} // Closed after the worker stopped
+	logger          *logging.Logger
+}
+
+// Options for worker creation
+type WorkerOptions struct {
+	BufferSize    int
+	MaxBytes      int64
+	MaxAge        time.Duration
+	MaxInFlight   int
+	RetryAttempt  int
+	FlushInterval time.Duration
+	MemoryLimit   int64 // Memory ceiling of the buffered events in bytes (of the sink for a dispatcher)
+	SpreadBuffer  bool
+	Affinity      string // Selects the worker by the event's session or device (optional)
+	Sink          *Sink
+}
+
+// Creates a new worker
+func NewWorker(id int, options *WorkerOptions, workerPool chan *Worker) *Worker {
+	return &Worker{
+		ID:              id,
+		WorkerPool:      workerPool,
+		JobChannel:      make(chan Job),
+		ControlChannel:  make(chan Job, CONTROL_CHANNEL_SIZE),
+		AffinityChannel: NewAffinityChannel(options.Affinity),
+		BufferSize:      options.BufferSize,
+		MaxBytes:        options.MaxBytes,
+		MaxAge:          options.MaxAge,
+		MaxInFlight:     options.MaxInFlight,
+		uploads:         make(chan *UploadResult, options.MaxInFlight),
+		BufferedEvents:  []*dialects.Event{},
+		Penalty:         1.0,
+		RetryAttempt:    options.RetryAttempt,
+		LastSave:        time.Now(),
+		FlushInterval:   options.FlushInterval,
+		MemoryLimit:     options.MemoryLimit,
+		Sink:            options.Sink,
+		done:            make(chan struct{}),
+		logger:          NewWorkerLogger(id, options.Sink)}
+}
+
+// Creates the channel of the events with affinity,
+// it's nil if the affinity is disabled
+func NewAffinityChannel(affinity string) chan Job {
+	if affinity == "" || affinity == AFFINITY_NONE {
+		return nil
+	}
+	return make(chan Job, AFFINITY_CHANNEL_SIZE)
+}
+
+// Returns a logger with the worker's and the sink's fields
+func NewWorkerLogger(id int, sink *Sink) *logging.Logger {
+	return sink.GetLogger("worker").With(logging.Fields{"worker_id": id})
+}
+
+// Returns the worker's logger
+func (w *Worker) GetLogger() *logging.Logger {
+	if w.logger == nil {
+		w.logger = NewWorkerLogger(w.ID, w.Sink)
+	}
+	return w.logger
+}
+
+// Returns the storage client of the worker's sink
+func (w *Worker) GetStorageClient() dialects.StorageClient {
+	return w.Sink.GetStorageClient()
+}
+
+// Returns the job queue of the worker's sink
+func (w *Worker) GetJobQueue() chan Job {
+	return w.Sink.JobQueue
+}
+
+// Returns the collector of the worker's sink
+func (w *Worker) GetCollector() *Collector {
+	return w.Sink.Collector
+}
+
+// Returns the write-ahead log of the worker's sink
+func (w *Worker) GetWAL() *wal.Log {
+	return w.Sink.WAL
+}
+
+// Returns the retry queue of the worker's sink
+func (w *Worker) GetRetryQueue() *retry.Queue {
+	return w.Sink.RetryQueue
+}
+
+// Returns the retry backoff of the worker's sink
+func (w *Worker) GetBackoff() *retry.Backoff {
+	return w.Sink.Backoff
+}
+
+// Returns the circuit breaker of the worker's sink
+func (w *Worker) GetBreaker() *retry.Breaker {
+	return w.Sink.Breaker
+}
+
+// Returns the sink's semaphore of the in-flight uploads
+func (w *Worker) GetUploadSlots() chan struct{} {
+	return w.Sink.UploadSlots
+}
+
+// Returns the spool of the worker's sink
+func (w *Worker) GetSpool() *spool.Spool {
+	return w.Sink.Spool
+}
+
+// Returns the worker's share of the sink's memory ceiling
+func (w *Worker) GetMemoryLimit() int64 {
+	return w.MemoryLimit
+}
+
+// Returns the counters of the worker's sink
+func (w *Worker) GetStats() *SinkStats {
+	if w.Sink != nil {
+		return w.Sink.Stats
+	}
+	return nil
+}
+
+// Returns the manifest of the worker's sink
+func (w *Worker) GetManifest() *Manifest {
+	if w.Sink != nil {
+		return w.Sink.Manifest
+	}
+	return nil
+}
+
+// Start method starts the run loop for the worker.
+// The worker is registered into the worker pool for the event jobs
+// and listens for the targeted jobs on its control channel.
+func (w *Worker) Start() {
+	if w.GetStorageClient().IsBufferedStorage() {
+		w.GetLogger().Infof("Started with %d buffer", w.BufferSize)
+	} else {
+		w.GetLogger().Infof("Started")
+	}
+	go func() {
+		defer close(w.done)
+
+		// Checks the age of the batch in every second
+		var expiry <-chan time.Time
+		if w.GetStorageClient().IsBufferedStorage() {
+			ticker := time.NewTicker(time.Second)
+			defer ticker.Stop()
+			expiry = ticker.C
+		}
+
+		registered := false
+		for {
+			// Register the current worker into the worker queue,
+			// the targeted jobs are handled while the pool is full
+			var pool chan *Worker
+			if !registered {
+				pool = w.WorkerPool
+			}
+
+			var job Job
+			select {
+			case pool <- w:
+				registered = true
+				continue
+			case job = <-w.JobChannel:
+				registered = false
+			case job = <-w.ControlChannel:
+			case job = <-w.AffinityChannel:
+			case result := <-w.uploads:
+				w.HandleUploadResult(result)
+				continue
+			case <-expiry:
+				if w.IsBufferExpired() && !w.GetCollector().IsUploadPaused() {
+					if err := w.Upload(); err != nil {
+						w.GetLogger().Errorf("%s", err)
+					}
+				}
+				continue
+			}
+			if !w.Handle(job) {
+				return
+			}
+		}
+	}()
+}
+
+// Handles a single job, returns false if the worker is stopped
+func (w *Worker) Handle(job Job) bool {
+	switch job.GetAction() {
+	case ACTION_EVENT:
+		w.GetLogger().Debugf("Received an add new event request!")
+		if err := w.Work(job.(*EventAction)); err != nil {
+			w.GetLogger().Errorf("%s", err)
+		}
+	case ACTION_FLUSH:
+		w.GetLogger().Debugf("Received a flush request!")
+		if !job.(*FlushAction).Automatic || w.IsAutomaticFlushDue() {
+			if err := w.Flush(); err != nil {
+				w.GetLogger().Errorf("%s", err)
+			}
+		}
+		if done := job.(*FlushAction).Done; done != nil {
+			close(done)
+		}
+	case ACTION_RECONFIGURE:
+		w.Reconfigure(job.(*ReconfigureAction))
+	case ACTION_STOP:
+		defer job.(*StopAction).WaitGroup.Done()
+		w.HandleAffinityEvents()
+		if err := w.Rescue(); err != nil {
+			w.GetLogger().Errorf("%s", err)
+		}
+		return false
+	}
+	return true
+}
+
+// Handles the events that are waiting for the worker because of
+// their affinity, the dispatcher doesn't send more before it stops
+func (w *Worker) HandleAffinityEvents() {
+	for {
+		select {
+		case job := <-w.AffinityChannel:
+			w.Handle(job)
+		default:
+			return
+		}
+	}
+}
+
+// Sends a targeted job to the worker's control channel, returns false
+// if the channel is full (the job is not sent)
+func (w *Worker) Control(job Job) bool {
+	select {
+	case w.ControlChannel <- job:
+		return true
+	default:
+		return false
+	}
+}
+
+// Sends a targeted job to the worker's control channel, it waits for
+// a free slot unless the worker is stopped (the job is dropped then)
+func (w *Worker) Deliver(job Job) {
+	select {
+	case w.ControlChannel <- job:
+	case <-w.done:
+	}
+}
+
+// Changes the worker's limits, the in-flight batches are
+// finished first if their limit is changed
+func (w *Worker) Reconfigure(action *ReconfigureAction) {
+	w.GetLogger().Infof("Reconfigured with %d buffer and %d retry attempt", action.BufferSize, action.RetryAttempt)
+	w.BufferSize = action.BufferSize
+	w.MaxBytes = action.MaxBytes
+	w.MaxAge = action.MaxAge
+	w.RetryAttempt = action.RetryAttempt
+	w.FlushInterval = action.FlushInterval
+	w.MemoryLimit = action.MemoryLimit
+	if action.MaxInFlight != w.MaxInFlight {
+		w.WaitForUploads()
+		w.MaxInFlight = action.MaxInFlight
+		w.uploads = make(chan *UploadResult, action.MaxInFlight)
+	}
+}
+
+// Work on a single job
+func (w *Worker) Work(action *EventAction) error {
+	if w.GetCollector().IsUploadPaused() {
+		// Keep every message in the buffer until the uploads are resumed
+		w.AddActionToBuffer(action)
+		return nil
+	}
+
+	if !w.GetStorageClient().IsBufferedStorage() {
+		// Save messages
+		if err := w.Save(action); err != nil {
+			return err
+		}
+
+	} else {
+		// Add message to the buffer if the storge is a buffered writer
+		w.AddActionToBuffer(action)
+
+		// Continue if the buffer is not full or too old
+		if !w.IsBufferFull() && !w.IsBufferExpired() {
+			return nil
+		}
+
+		w.GetLogger().With(logging.Fields{"batch_size": len(w.BufferedEvents)}).Debugf("Saving buffered messages started")
+
+		// Save messages
+		if err := w.Upload(); err != nil {
+			return err
+		}
+
+		w.GetLogger().Debugf("Saving buffered messages was finished")
+	}
+	return nil
+}
+
+// Save messages
+func (w *Worker) Save(action *EventAction) (err error) {
+	span := w.GetCollector().GetTracer().Start("Worker.Save", tracing.SPAN_KIND_INTERNAL, action.Trace)
+	span.SetAttribute("worker_id", w.ID)
+	span.SetAttribute("attempt", action.Attempt)
+	defer func() { span.FinishWithError(err) }()
+
+	// Convert messages to string
+	convert := span.Child("Worker.Save.convert", tracing.SPAN_KIND_INTERNAL)
+	msg, err := w.GetStorageClient().GetConverter()(action.GetEvent())
+	convert.FinishWithError(err)
+	if err != nil {
+		// The encoding won't succeed on the next attempt either
+		rerr := fmt.Errorf("(%d worker) Encoding message is failed (%d attempt): %s", w.ID, action.Attempt, err.Error())
+		w.GetStats().AddFailed(1, err)
+		w.SendToDeadLetter(action.GetEvent(), action.Attempt, action.ReceivedAt, err)
+		w.GetWAL().Ack(action.WALSegment, 1)
+		return rerr
+	}
+
+	// Fast-fail without an attempt while the circuit is open
+	if !w.GetBreaker().Allow() {
+		w.GetLogger().RateLimit("circuit_open").Warnf("Saving message is postponed: %s", retry.ErrOpen.Error())
+		w.Postpone(action, w.GetBackoff().Duration(action.Attempt), retry.ErrOpen)
+		return nil
+	}
+
+	// Save message immediately.
+	save := span.Child("StorageClient.Save", tracing.SPAN_KIND_INTERNAL)
+	err = dialects.SaveWithSpan(w.GetStorageClient(), msg, save)
+	save.FinishWithError(err)
+	w.GetBreaker().Record(err)
+	if err != nil {
+		rerr := fmt.Errorf("(%d worker) Saving message is failed (%d attempt): %s", w.ID, action.Attempt, err.Error())
+		w.GetStats().AddFailed(1, err)
+		w.Retry(action, err)
+		return rerr
+	}
+	w.GetStats().AddSaved(1)
+	w.GetWAL().Ack(action.WALSegment, 1)
+	w.UpdateLastSave()
+	return nil
+}
+
+// Schedules the failed action into the retry queue with exponential
+// backoff, the event is given up without more attempts
+func (w *Worker) Retry(action *EventAction, err error) {
+	action.Attempt++
+	if action.Attempt > w.RetryAttempt {
+		w.GiveUp(action, err)
+		return
+	}
+	w.Postpone(action, w.GetBackoff().Duration(action.Attempt-1), err)
+}
+
+// Puts the action into the retry queue after the delay, the event is
+// abandoned during the shutdown and given up if the retry queue is full
+func (w *Worker) Postpone(action *EventAction, delay time.Duration, err error) {
+	queue := w.GetRetryQueue()
+	if queue != nil && queue.IsStopped() {
+		w.Abandon(action, err)
+		return
+	}
+	if queue == nil || !queue.Schedule(action, time.Now().Add(delay)) {
+		w.GiveUp(action, fmt.Errorf("Retry queue is full: %s", err.Error()))
+	}
+}
+
+// Leaves the action's event in the write-ahead log for the next start
+// when the sink is stopped (or sends it to the dead-letter without it)
+func (w *Worker) Abandon(action *EventAction, err error) {
+	if w.GetWAL() != nil {
+		w.GetStats().AddUnsaved(1)
+		return
+	}
+	w.SendToDeadLetter(action.GetEvent(), action.Attempt-1, action.ReceivedAt, fmt.Errorf("Stopped before the retry: %s", err.Error()))
+}
+
+// Sends the action's event to the dead-letter and
+// acknowledges it in the write-ahead log
+func (w *Worker) GiveUp(action *EventAction, err error) {
+	w.SendToDeadLetter(action.GetEvent(), action.Attempt-1, action.ReceivedAt, err)
+	w.GetWAL().Ack(action.WALSegment, 1)
+}
+
+// Sends a permanently failed event to the dead-letter,
+// the event is dropped if the dead-letter is not configured
+func (w *Worker) SendToDeadLetter(event *dialects.Event, attempts int, receivedAt time.Time, reason error) {
+	w.GetStats().AddDeadLettered()
+	if !w.GetCollector().GetDeadLetter().Add(NewDeadLetterRecord(event, attempts, receivedAt, w.Sink, reason)) {
+		w.GetLogger().RateLimit("dropped").Warnf("Event is dropped after %d attempts: %s", attempts, reason.Error())
+	}
+}
+
+// Save messages that were buffered for a not buffered storage
+// while the uploads were paused
+func (w *Worker) SaveBufferedEvents() error {
+	events := make([]*dialects.Event, len(w.BufferedEvents))
+	copy(events, w.BufferedEvents)
+	segments := make([]uint64, len(w.BufferedWAL))
+	copy(segments, w.BufferedWAL)
+	w.ResetBuffer()
+
+	failed := 0
+	for i, event := range events {
+		if err := w.Save(&EventAction{Event: event, Attempt: 1, WALSegment: segments[i]}); err != nil {
+			failed++
+		}
+	}
+	if failed != 0 {
+		return fmt.Errorf("(%d worker) Saving paused messages is failed for %d of %d records", w.ID, failed, len(events))
+	}
+	return nil
+}
+
+// Before the worker will be stopped it tries to rescue all ongoing job
+func (w *Worker) Rescue() error {
+	w.GetLogger().Infof("Received a signal to stop")
+
+	// Try to save everything even if the uploads are paused,
+	// the failed batch is spilled to the spool if it's possible
+	if err := w.ForceFlush(); err != nil {
+		if !w.GetStorageClient().IsBufferedStorage() || w.GetSpool() == nil {
+			return err
+		}
+		w.GetLogger().Errorf("%s", err)
+		if len(w.BufferedEvents) != 0 {
+			if err := w.SpillBuffer(); err != nil {
+				return fmt.Errorf("(%d worker) Spilling buffered messages is failed: %s", w.ID, err.Error())
+			}
+		}
+	}
+
+	w.GetLogger().Infof("Stopped successfully")
+	return nil
+}
+
+// Flushing a worker
+func (w *Worker) Flush() error {
+	// Do not upload anything while the uploads are paused
+	if w.GetCollector().IsUploadPaused() {
+		w.GetLogger().Debugf("Flush is skipped because uploads are paused")
+		return nil
+	}
+	return w.ForceFlush()
+}
+
+// Flushing a worker regardless of the upload state,
+// it waits for the in-flight batches first
+func (w *Worker) ForceFlush() error {
+	w.WaitForUploads()
+	if len(w.BufferedEvents) == 0 {
+		w.UpdateLastSave()
+		return nil
+	}
+
+	w.GetLogger().With(logging.Fields{"batch_size": len(w.BufferedEvents)}).Infof("Flushing %d buffered messages", len(w.BufferedEvents))
+
+	// Save messages
+	if !w.GetStorageClient().IsBufferedStorage() {
+		return w.SaveBufferedEvents()
+	}
+	return w.SaveBatch()
+}
+
+// Stop signals the worker to stop listening for work requests.
+func (w *Worker) Stop(wg *sync.WaitGroup) {
+	w.GetLogger().Infof("Sending stop signal to worker")
+	w.ControlChannel <- &StopAction{TargetWorkerID: w.ID, WaitGroup: wg}
+}
+
+// Increase the value of the penalty attribute
+func (w *Worker) IncreasePenalty() {
+	w.Penalty *= 1.5
+}
+
+// Returns the current buffer size with the current penalty
+func (w *Worker) GetBufferSize() int {
+	return int(float32(w.BufferSize) * w.Penalty)
+}
+
+// Returns the current maximum uncompressed size of a batch with
+// the current penalty, it's 0 if the size is not limited
+func (w *Worker) GetMaxBytes() int64 {
+	return int64(float32(w.MaxBytes) * w.Penalty)
+}
+
+// Checks the state of the buffer, it's full if it reached the
+// number of events or the uncompressed size of a batch
+func (w *Worker) IsBufferFull() bool {
+	if max := w.GetMaxBytes(); max != 0 && w.BufferedBytes >= max {
+		return true
+	}
+	return len(w.BufferedEvents) >= w.GetBufferSize()
+}
+
+// Checks whether the oldest buffered event reached the maximum age
+// of a batch, the age is extended by the penalty after failures
+func (w *Worker) IsBufferExpired() bool {
+	if w.MaxAge == 0 || len(w.BufferedEvents) == 0 {
+		return false
+	}
+	return time.Since(w.BufferedAt) >= time.Duration(float32(w.MaxAge)*w.Penalty)
+}
+
+// Resets the buffer
+func (w *Worker) ResetBuffer() {
+	w.GetStats().AddBuffered(-len(w.BufferedEvents))
+	w.BufferedEvents = w.BufferedEvents[:0]
+	w.BufferedWAL = w.BufferedWAL[:0]
+	w.TraceLinks = w.TraceLinks[:0]
+	w.BufferedBytes = 0
+	for _, batch := range append(w.Restored, w.Released...) {
+		w.GetManifest().End(batch)
+	}
+	w.Restored, w.Released = nil, nil
+	w.GetManifest().Release(w)
+	w.Penalty = 1.0
+}
+
+// Adds a message to the buffer
+func (w *Worker) AddEventToBuffer(event *dialects.Event) {
+	if len(w.BufferedEvents) == 0 {
+		w.BufferedAt = time.Now()
+		w.GetManifest().Hold(w, w.BufferedAt)
+	}
+	w.BufferedEvents = append(w.BufferedEvents, event)
+	w.BufferedWAL = append(w.BufferedWAL, 0)
+	w.GetStats().AddBuffered(1)
+	w.BufferedBytes += int64(event.Size())
+}
+
+// Adds the action's message to the buffer with its write-ahead log
+// segment and its request's span
+func (w *Worker) AddActionToBuffer(action *EventAction) {
+	w.AddEventToBuffer(action.GetEvent())
+	w.BufferedWAL[len(w.BufferedWAL)-1] = action.WALSegment
+	w.AddTraceLink(action.Trace)
+}
+
+// Links the batch to the request that received the event,
+// the same request is linked only once
+func (w *Worker) AddTraceLink(c tracing.SpanContext) {
+	if w.GetCollector().GetTracer() == nil || !c.IsValid() || len(w.TraceLinks) >= MAX_TRACE_LINKS {
+		return
+	}
+	for _, link := range w.TraceLinks {
+		if link == c {
+			return
+		}
+	}
+	w.TraceLinks = append(w.TraceLinks, c)
+}
+
+// Update last save time
+func (w *Worker) UpdateLastSave() {
+	w.LastSave = time.Now()
+}
+
+// Returns next possible automatic flush time
+func (w *Worker) GetNextAutomaticFlush() time.Time {
+	return w.LastSave.Add(w.FlushInterval)
+}
+
+// Is the automatic flush enabled and due
+func (w *Worker) IsAutomaticFlushDue() bool {
+	return w.FlushInterval != 0 && !time.Now().Before(w.GetNextAutomaticFlush())
+}
+
+// Returns the worker's ID
+func (w *Worker) GetId() int {
+	return w.ID
+}
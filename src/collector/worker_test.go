@@ -1,9 +1,10 @@
-package main
+package collector
 
 import (
 	"bytes"
 	"fmt"
 	"github.com/wunderlist/hamustro/src/dialects"
+	"github.com/wunderlist/hamustro/src/tracing"
 	"io/ioutil"
 	"log"
 	"sync"
@@ -108,7 +109,7 @@ func TestFunctionGetIdAndNewWorker(t *testing.T) {
 
 	t.Log("Creating a worker with 312 id")
 	pool := make(chan *Worker, 1)
-	worker := NewWorker(312, &WorkerOptions{}, pool)
+	worker := NewTestWorker(312, &WorkerOptions{}, pool)
 
 	if worker.GetId() != 312 {
 		t.Errorf("Expected worker's ID was %d but it was %d instead.", 312, worker.GetId())
@@ -171,6 +172,69 @@ func TestFunctionBufferFullAndAddEventToBuffer(t *testing.T) {
 	}
 }
 
+// Tests the size and age limits of the buffer
+func TestFunctionBufferSizeAndAgeLimits(t *testing.T) {
+	event := GetTestEvent(2158942)
+	worker := &Worker{BufferSize: 100, Penalty: 1.0, MaxBytes: int64(2 * event.Size()), MaxAge: 50 * time.Millisecond}
+
+	t.Log("Buffer is full after reaching the maximum bytes")
+	worker.AddEventToBuffer(event)
+	if worker.IsBufferFull() || worker.IsBufferExpired() {
+		t.Errorf("Buffer should not be full or expired with a single event")
+	}
+	worker.AddEventToBuffer(event)
+	if exp := int64(2 * event.Size()); worker.BufferedBytes != exp {
+		t.Errorf("Expected buffered bytes was %d but it was %d instead", exp, worker.BufferedBytes)
+	}
+	if !worker.IsBufferFull() {
+		t.Errorf("Buffer should be full after %d bytes", worker.BufferedBytes)
+	}
+
+	t.Log("Penalty extends the maximum bytes and the maximum age")
+	worker.IncreasePenalty()
+	if worker.IsBufferFull() {
+		t.Errorf("Buffer should not be full with 1.5x penalty")
+	}
+	time.Sleep(60 * time.Millisecond)
+	if worker.IsBufferExpired() {
+		t.Errorf("Buffer should not be expired with 1.5x penalty")
+	}
+	time.Sleep(20 * time.Millisecond)
+	if !worker.IsBufferExpired() {
+		t.Errorf("Buffer should be expired after %s", time.Since(worker.BufferedAt))
+	}
+
+	worker.ResetBuffer()
+	if worker.IsBufferFull() || worker.IsBufferExpired() || worker.BufferedBytes != 0 {
+		t.Errorf("Empty buffer should not be full or expired")
+	}
+}
+
+// Tests that an idle worker saves the batch after its maximum age
+func TestWorkerBatchMaxAge(t *testing.T) {
+	config = &Config{}
+	collector := NewTestCollector()
+	log.SetOutput(ioutil.Discard)
+
+	client := &CountingStorageClient{Buffered: true}
+	sink := collector.NewSinkWithClient(&SinkConfig{Name: "archive", MaxWorkerSize: 1, MaxQueueSize: 10, BufferSize: 100, BatchMaxAge: 1, Overflow: OVERFLOW_BLOCK}, client)
+	collector.sinks = []*Sink{sink}
+	sink.Dispatcher.Run()
+	collector.PublishEvent(GetTestEvent(1), tracing.SpanContext{})
+
+	time.Sleep(500 * time.Millisecond)
+	if client.GetSaved() != 0 {
+		t.Errorf("Batch should not be saved before its maximum age")
+	}
+	for i := 0; i < 200 && client.GetSaved() != 1; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if exp := 1; client.GetSaved() != exp {
+		t.Errorf("Expected number of saved events was %d but it was %d instead", exp, client.GetSaved())
+	}
+	collector.StopSinks()
+}
+
 // Returns an Event for testing purposes
 func GetTestEvent(userId uint32) *dialects.Event {
 	return &dialects.Event{
@@ -218,6 +282,13 @@ func SendEventActionToJobChannel(workerPool chan *Worker, worker *Worker, action
 	return worker
 }
 
+// Waits until the retry queue releases the failed actions into the jobQueue
+func WaitForJobQueue(n int) {
+	for i := 0; i < 100 && len(jobQueue) < n; i++ {
+		time.Sleep(time.Millisecond)
+	}
+}
+
 // Validates the previous sending
 func ValidateSending() {
 	if !catched {
@@ -239,7 +310,7 @@ func SetSendValidate(workerPool chan *Worker, worker *Worker, actions []*EventAc
 // Sends a single action to action channel
 func SendFlushActionToJobChannel(workerPool chan *Worker, worker *Worker) *Worker {
 	lastSave := worker.LastSave
-	worker.JobChannel <- &FlushAction{worker.ID}
+	worker.JobChannel <- &FlushAction{TargetWorkerID: worker.ID}
 	worker = <-workerPool
 	time.Sleep(200 * time.Millisecond)
 	if lastSave == worker.LastSave {
@@ -255,9 +326,13 @@ func TestSimpleStorageClientWorker(t *testing.T) {
 	log.SetOutput(ioutil.Discard)          // Disable the logger
 	T, response, catched = t, nil, false   // Set properties
 
-	t.Log("Creating a single worker")
+	t.Log("Creating a single worker with a retry queue and 1ms backoff")
+	config = &Config{RetryBackoff: 1, RetryMaxBackoff: 1}
 	pool := make(chan *Worker, 1)
-	worker := NewWorker(1, &WorkerOptions{RetryAttempt: 2}, pool)
+	worker := NewTestWorker(1, &WorkerOptions{RetryAttempt: 2}, pool)
+	retryQueue := NewRetryQueue(jobQueue, 10, time.Millisecond)
+	worker.Sink.RetryQueue = retryQueue
+	defer retryQueue.Stop()
 	worker.Start()
 
 	t.Log("Set up to stop the worker on the end")
@@ -269,28 +344,30 @@ func TestSimpleStorageClientWorker(t *testing.T) {
 	worker = <-pool
 
 	t.Log("Creating a single action and send it to the worker")
-	worker = SetSendValidate(pool, worker, []*EventAction{&EventAction{GetTestEvent(3423543), 1}}, false, true)
+	worker = SetSendValidate(pool, worker, []*EventAction{&EventAction{Event: GetTestEvent(3423543), Attempt: 1}}, false, true)
 
 	t.Log("Creating an another single action and send it to the worker")
-	worker = SetSendValidate(pool, worker, []*EventAction{&EventAction{GetTestEvent(1321), 1}}, false, true)
+	worker = SetSendValidate(pool, worker, []*EventAction{&EventAction{Event: GetTestEvent(1321), Attempt: 1}}, false, true)
 
 	t.Log("Send something that will fail and raise an error")
-	action := &EventAction{GetTestEvent(43233), 1}
+	action := &EventAction{Event: GetTestEvent(43233), Attempt: 1}
 	worker = SetSendValidate(pool, worker, []*EventAction{action}, true, true)
 	if action.Attempt != 2 {
 		t.Errorf("Job attempt number should be %d and it was %d instead", 2, action.Attempt)
 	}
 
-	t.Log("This failed message must be in the jobQueue, try again.")
+	t.Log("This failed message must be in the jobQueue after the backoff, try again.")
+	WaitForJobQueue(1)
 	if len(jobQueue) != 1 {
 		t.Errorf("worker doesn't contain the previous action")
 	}
 	worker = SetSendValidate(pool, worker, []*EventAction{(<-jobQueue).(*EventAction)}, false, true)
 
 	t.Log("Send something that will fail and raise an error again")
-	worker = SetSendValidate(pool, worker, []*EventAction{&EventAction{GetTestEvent(43254534), 1}}, true, true)
+	worker = SetSendValidate(pool, worker, []*EventAction{&EventAction{Event: GetTestEvent(43254534), Attempt: 1}}, true, true)
 
-	t.Log("This failed message must be in the jobQueue, but let it fail again.")
+	t.Log("This failed message must be in the jobQueue after the backoff, but let it fail again.")
+	WaitForJobQueue(1)
 	if len(jobQueue) != 1 {
 		t.Errorf("jobQueue doesn't contain the previous action")
 	}
@@ -300,7 +377,7 @@ func TestSimpleStorageClientWorker(t *testing.T) {
 	if action.Attempt != 3 {
 		t.Errorf("Job attempt number should be %d and it was %d instead", 3, action.Attempt)
 	}
-	if len(jobQueue) != 0 {
+	if len(jobQueue) != 0 || retryQueue.Len() != 0 {
 		t.Errorf("jobQueue have to be empty because it was dropped after the 2nd attempt")
 	}
 
@@ -331,14 +408,14 @@ func TestBufferedStorageClientWorker(t *testing.T) {
 
 	t.Log("Creating a single worker with buffer size: 4")
 	pool := make(chan *Worker, 1)
-	worker := NewWorker(1, &WorkerOptions{BufferSize: 4}, pool)
+	worker := NewTestWorker(1, &WorkerOptions{BufferSize: 4}, pool)
 	worker.Start()
 
 	t.Log("Grab the worker from the pool")
 	worker = <-pool
 
 	t.Log("Creating 4 actions and send it to the worker")
-	actions := []*EventAction{&EventAction{GetTestEvent(54354353), 1}, &EventAction{GetTestEvent(543), 1}, &EventAction{GetTestEvent(765342), 1}, &EventAction{GetTestEvent(1), 1}}
+	actions := []*EventAction{&EventAction{Event: GetTestEvent(54354353), Attempt: 1}, &EventAction{Event: GetTestEvent(543), Attempt: 1}, &EventAction{Event: GetTestEvent(765342), Attempt: 1}, &EventAction{Event: GetTestEvent(1), Attempt: 1}}
 	SetEventExpectation(actions, false, true)
 	for i, action := range actions {
 		if len(worker.BufferedEvents) != i {
@@ -350,11 +427,11 @@ func TestBufferedStorageClientWorker(t *testing.T) {
 	CheckResultsForBufferedStorage(worker, 0, 1.0, 4)
 
 	t.Log("Creating 6 actions and send it to the worker, during the process it'll fail after the 4th and will be accepted after the 6th")
-	actions = []*EventAction{&EventAction{GetTestEvent(423), 1}, &EventAction{GetTestEvent(654645), 1}, &EventAction{GetTestEvent(123123), 1}, &EventAction{GetTestEvent(16548), 1}}
+	actions = []*EventAction{&EventAction{Event: GetTestEvent(423), Attempt: 1}, &EventAction{Event: GetTestEvent(654645), Attempt: 1}, &EventAction{Event: GetTestEvent(123123), Attempt: 1}, &EventAction{Event: GetTestEvent(16548), Attempt: 1}}
 	SetSendValidate(pool, worker, actions, true, true)
 	CheckResultsForBufferedStorage(worker, 4, 1.5, 6)
 
-	actions = append(actions, []*EventAction{&EventAction{GetTestEvent(64562), 1}, &EventAction{GetTestEvent(13127), 1}}...)
+	actions = append(actions, []*EventAction{&EventAction{Event: GetTestEvent(64562), Attempt: 1}, &EventAction{Event: GetTestEvent(13127), Attempt: 1}}...)
 	SetEventExpectation(actions, false, true)
 	for _, action := range actions[4:] {
 		worker = SendEventActionToJobChannel(pool, worker, action)
@@ -363,7 +440,7 @@ func TestBufferedStorageClientWorker(t *testing.T) {
 	CheckResultsForBufferedStorage(worker, 0, 1.0, 4)
 
 	t.Log("Creating a single action and send it to the worker that will stay in the buffer until the worker stops")
-	action := &EventAction{GetTestEvent(9843211), 1}
+	action := &EventAction{Event: GetTestEvent(9843211), Attempt: 1}
 	SetEventExpectation([]*EventAction{action}, false, true)
 	worker = SendEventActionToJobChannel(pool, worker, action)
 	CheckResultsForBufferedStorage(worker, 1, 1.0, 4)
@@ -377,14 +454,14 @@ func TestBufferedStorageClientWorker(t *testing.T) {
 
 	t.Log("Creating a single worker again with buffer size: 4")
 	pool = make(chan *Worker, 1)
-	worker = NewWorker(1, &WorkerOptions{BufferSize: 4}, pool)
+	worker = NewTestWorker(1, &WorkerOptions{BufferSize: 4}, pool)
 	worker.Start()
 
 	t.Log("Grab a free worker again from the pool")
 	worker = <-pool
 
 	t.Log("Creating a single action and send it to the worker that will stay in the buffer until the worker stops")
-	action = &EventAction{GetTestEvent(5435), 1}
+	action = &EventAction{Event: GetTestEvent(5435), Attempt: 1}
 	SetEventExpectation([]*EventAction{action}, true, true)
 	worker = SendEventActionToJobChannel(pool, worker, action)
 	CheckResultsForBufferedStorage(worker, 1, 1.0, 4)
@@ -398,14 +475,14 @@ func TestBufferedStorageClientWorker(t *testing.T) {
 
 	t.Log("Creating a single worker again with buffer size: 4")
 	pool = make(chan *Worker, 1)
-	worker = NewWorker(1, &WorkerOptions{BufferSize: 4}, pool)
+	worker = NewTestWorker(1, &WorkerOptions{BufferSize: 4}, pool)
 	worker.Start()
 
 	t.Log("Grab a free worker again from the pool")
 	worker = <-pool
 
 	t.Log("Creating 3 actions and send it to the worker, during the process the worker gets a flush action")
-	actions = []*EventAction{&EventAction{GetTestEvent(4223), 1}, &EventAction{GetTestEvent(66666), 1}, &EventAction{GetTestEvent(969482), 1}}
+	actions = []*EventAction{&EventAction{Event: GetTestEvent(4223), Attempt: 1}, &EventAction{Event: GetTestEvent(66666), Attempt: 1}, &EventAction{Event: GetTestEvent(969482), Attempt: 1}}
 	SetEventExpectation(actions, false, true)
 	for _, action := range actions {
 		worker = SendEventActionToJobChannel(pool, worker, action)
@@ -423,19 +500,19 @@ func TestBufferedStorageClientWorker(t *testing.T) {
 
 	t.Log("Creating a single worker again with buffer size: 3")
 	pool = make(chan *Worker, 1)
-	worker = NewWorker(1, &WorkerOptions{BufferSize: 3}, pool)
+	worker = NewTestWorker(1, &WorkerOptions{BufferSize: 3}, pool)
 	worker.Start()
 
 	t.Log("Grab a free worker again from the pool")
 	worker = <-pool
 
 	t.Log("Creating 3 actions and send it to the worker, it will be saved successfully")
-	actions = []*EventAction{&EventAction{GetTestEvent(7632), 1}, &EventAction{GetTestEvent(3423), 1}, &EventAction{GetTestEvent(23), 1}}
+	actions = []*EventAction{&EventAction{Event: GetTestEvent(7632), Attempt: 1}, &EventAction{Event: GetTestEvent(3423), Attempt: 1}, &EventAction{Event: GetTestEvent(23), Attempt: 1}}
 	SetSendValidate(pool, worker, actions, false, true)
 	CheckResultsForBufferedStorage(worker, 0, 1.0, 3)
 
 	t.Log("Create a single action and send it to the worker")
-	action = &EventAction{GetTestEvent(7532233), 1}
+	action = &EventAction{Event: GetTestEvent(7532233), Attempt: 1}
 	SetEventExpectation([]*EventAction{action}, false, true)
 	worker = SendEventActionToJobChannel(pool, worker, action)
 	CheckResultsForBufferedStorage(worker, 1, 1.0, 3)
@@ -455,17 +532,17 @@ func TestSimpleStorageClientMultipleWorker(t *testing.T) {
 
 	t.Log("Creating two workers to compete with each other")
 	pool := make(chan *Worker, 2)
-	w1 := NewWorker(1, &WorkerOptions{RetryAttempt: 3}, pool)
+	w1 := NewTestWorker(1, &WorkerOptions{RetryAttempt: 3}, pool)
 	w1.Start()
 
-	w2 := NewWorker(2, &WorkerOptions{RetryAttempt: 3}, pool)
+	w2 := NewTestWorker(2, &WorkerOptions{RetryAttempt: 3}, pool)
 	w2.Start()
 
 	t.Log("Create two actions")
-	action1 := EventAction{GetTestEvent(1262473173), 1}
+	action1 := EventAction{Event: GetTestEvent(1262473173), Attempt: 1}
 	expBuffer1, _ := dialects.ConvertJSON(action1.Event)
 
-	action2 := EventAction{GetTestEvent(53484332), 1}
+	action2 := EventAction{Event: GetTestEvent(53484332), Attempt: 1}
 	expBuffer2, _ := dialects.ConvertJSON(action2.Event)
 
 	exp = map[string]struct{}{expBuffer1.String(): {}, expBuffer2.String(): {}}
@@ -501,15 +578,15 @@ func TestBufferedStorageClientMultipleWorker(t *testing.T) {
 	t.Log("Creating two worker with buffer size 2 and 3")
 	pool := make(chan *Worker, 2)
 
-	worker1 := NewWorker(1, &WorkerOptions{BufferSize: 2}, pool)
+	worker1 := NewTestWorker(1, &WorkerOptions{BufferSize: 2}, pool)
 	worker1.Start()
 
-	worker2 := NewWorker(2, &WorkerOptions{BufferSize: 3}, pool)
+	worker2 := NewTestWorker(2, &WorkerOptions{BufferSize: 3}, pool)
 	worker2.Start()
 
 	t.Log("Creating 2 actions")
-	action1 := EventAction{GetTestEvent(5541289), 1}
-	action2 := EventAction{GetTestEvent(7851126), 1}
+	action1 := EventAction{Event: GetTestEvent(5541289), Attempt: 1}
+	action2 := EventAction{Event: GetTestEvent(7851126), Attempt: 1}
 
 	t.Log("Set up all of the possible results")
 	expBuffer1, _ := storageClient.GetBatchConverter()([]*dialects.Event{action1.GetEvent()})
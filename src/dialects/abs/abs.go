@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"github.com/Azure/azure-sdk-for-go/storage"
 	"github.com/wunderlist/hamustro/src/dialects"
+	"github.com/wunderlist/hamustro/src/tracing"
 )
 
 // Azure Queue Storage configuration file.
@@ -13,6 +14,7 @@ type Config struct {
 	Container  string `json:"container"`
 	BlobPath   string `json:"blob_path"`
 	FileFormat string `json:"file_format"`
+	OriginalAt bool   `json:"original_at_column"` // Adds the `original_at` column to the CSV rows
 }
 
 // Checks is it valid or not
@@ -26,7 +28,7 @@ func (c *Config) NewClient() (dialects.StorageClient, error) {
 	if err != nil {
 		return nil, err
 	}
-	converterFunction, err := dialects.GetBatchConverterFunction(c.FileFormat)
+	converterFunction, err := dialects.GetBatchConverterFunctionWithOriginalAt(c.FileFormat, c.OriginalAt)
 	if err != nil {
 		return nil, err
 	}
@@ -66,15 +68,51 @@ func (c *BlobStorage) GetBatchConverter() dialects.BatchConverter {
 	return c.BatchConverter
 }
 
+// Returns the path with its placeholders
+func (c *BlobStorage) GetPathTemplate() string {
+	return c.BlobPath
+}
+
 // Send a single Event into the Azure Queue Storage.
 func (c *BlobStorage) Save(msg *bytes.Buffer) error {
-	buffer, err := dialects.Compress(msg)
+	return c.SaveTraced(msg, nil)
+}
+
+// Send a batch into the Azure Blob Storage and trace the compression and the upload.
+func (c *BlobStorage) SaveTraced(msg *bytes.Buffer, span *tracing.Span) error {
+	return c.SaveBatch(msg, nil, span)
+}
+
+// Send a batch into the Azure Blob Storage into the batch's partition
+// with the batch's properties in the blob's name and metadata, and
+// trace the compression and the upload.
+func (c *BlobStorage) SaveBatch(msg *bytes.Buffer, info *dialects.BatchInfo, span *tracing.Span) error {
+	buffer, err := dialects.CompressWithSpan(msg, span)
 	if err != nil {
 		return err
 	}
-	if err := c.Client.CreateBlockBlobFromReader(c.Container, dialects.GetRandomPath(c.BlobPath, c.FileFormat, true),
-		uint64(buffer.Len()), bytes.NewReader(buffer.Bytes()), nil); err != nil {
+	var headers map[string]string
+	if info.GetMetadata() != nil {
+		headers = map[string]string{}
+		for key, value := range info.GetMetadata() {
+			headers["x-ms-meta-"+key] = value
+		}
+	}
+	child := span.Child("abs.CreateBlockBlob", tracing.SPAN_KIND_CLIENT)
+	child.SetAttribute("bytes", buffer.Len())
+	name := dialects.GetBatchPath(c.BlobPath, c.FileFormat, true, info)
+	err = c.Client.CreateBlockBlobFromReader(c.Container, name,
+		uint64(buffer.Len()), bytes.NewReader(buffer.Bytes()), headers)
+	child.FinishWithError(err)
+	if err != nil {
 		return err
 	}
+	info.SetObject(name, "https://"+c.Account+".blob.core.windows.net/"+c.Container+"/"+name, buffer.Len())
 	return nil
 }
+
+// Send a single blob (e.g. a manifest) into the Azure Blob Storage under the given name.
+func (c *BlobStorage) SaveObject(name string, msg *bytes.Buffer) error {
+	return c.Client.CreateBlockBlobFromReader(c.Container, name,
+		uint64(msg.Len()), bytes.NewReader(msg.Bytes()), nil)
+}
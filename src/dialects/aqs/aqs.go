@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"github.com/Azure/azure-sdk-for-go/storage"
 	"github.com/wunderlist/hamustro/src/dialects"
+	"github.com/wunderlist/hamustro/src/tracing"
 )
 
 // Azure Queue Storage configuration file.
@@ -56,7 +57,15 @@ func (c *QueueStorage) GetBatchConverter() dialects.BatchConverter {
 
 // Send a single Event into the Azure Queue Storage.
 func (c *QueueStorage) Save(msg *bytes.Buffer) error {
-	if err := c.Client.PutMessage(c.QueueName, msg.String(), storage.PutMessageParameters{}); err != nil {
+	return c.SaveTraced(msg, nil)
+}
+
+// Send a single Event into the Azure Queue Storage and trace the call.
+func (c *QueueStorage) SaveTraced(msg *bytes.Buffer, span *tracing.Span) error {
+	child := span.Child("aqs.PutMessage", tracing.SPAN_KIND_CLIENT)
+	err := c.Client.PutMessage(c.QueueName, msg.String(), storage.PutMessageParameters{})
+	child.FinishWithError(err)
+	if err != nil {
 		return err
 	}
 	return nil
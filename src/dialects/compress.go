@@ -3,6 +3,7 @@ package dialects
 import (
 	"bytes"
 	"compress/gzip"
+	"github.com/wunderlist/hamustro/src/tracing"
 )
 
 // Compress the given string
@@ -20,3 +21,13 @@ func Compress(msg *bytes.Buffer) (*bytes.Buffer, error) {
 	}
 	return b, nil
 }
+
+// Compress the given string within a child span
+func CompressWithSpan(msg *bytes.Buffer, span *tracing.Span) (*bytes.Buffer, error) {
+	child := span.Child("dialects.Compress", tracing.SPAN_KIND_INTERNAL)
+	child.SetAttribute("bytes_in", msg.Len())
+	b, err := Compress(msg)
+	child.SetAttribute("bytes_out", b.Len())
+	child.FinishWithError(err)
+	return b, err
+}
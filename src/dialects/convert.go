@@ -41,6 +41,16 @@ func GetBatchConverterFunction(fileFormat string) (BatchConverter, error) {
 	return nil, fmt.Errorf("Unsupported output `%s` file format (use `json` or `csv`)", fileFormat)
 }
 
+// Returns a batch event converter function based on file extension,
+// with `originalAt` the CSV rows get the `original_at` column as their
+// last column (the JSON objects have it without the option)
+func GetBatchConverterFunctionWithOriginalAt(fileFormat string, originalAt bool) (BatchConverter, error) {
+	if originalAt && fileFormat == "csv" {
+		return ConvertBatchCSVWithOriginalAt, nil
+	}
+	return GetBatchConverterFunction(fileFormat)
+}
+
 // Dumps the Event into a JSON string
 func ConvertJSON(event *Event) (*bytes.Buffer, error) {
 	b := new(bytes.Buffer)
@@ -60,6 +70,16 @@ func ConvertCSV(event *Event) (*bytes.Buffer, error) {
 	return b, nil
 }
 
+// Dumps the Event into a CSV string with the `original_at` column
+func ConvertCSVWithOriginalAt(event *Event) (*bytes.Buffer, error) {
+	b := new(bytes.Buffer)
+	writer := csv.NewWriter(b)
+	writer.Comma = '\001'
+	writer.Write(append(event.String(), event.OriginalAt))
+	writer.Flush()
+	return b, nil
+}
+
 // Converts multiple events into JSON string
 func ConvertBatchJSON(events []*Event) (*bytes.Buffer, error) {
 	b := new(bytes.Buffer)
@@ -84,3 +104,15 @@ func ConvertBatchCSV(events []*Event) (*bytes.Buffer, error) {
 	writer.Flush()
 	return b, nil
 }
+
+// Converts to CSV string for list of events with the `original_at` column
+func ConvertBatchCSVWithOriginalAt(events []*Event) (*bytes.Buffer, error) {
+	b := new(bytes.Buffer)
+	writer := csv.NewWriter(b)
+	writer.Comma = '\001'
+	for _, event := range events {
+		writer.Write(append(event.String(), event.OriginalAt))
+	}
+	writer.Flush()
+	return b, nil
+}
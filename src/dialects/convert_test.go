@@ -164,3 +164,36 @@ func TestFunctionConvertBatchJSON(t *testing.T) {
 		}
 	}
 }
+
+// Converting multiple events to CSV with the original event time
+func TestFunctionConvertBatchCSVWithOriginalAt(t *testing.T) {
+	t.Log("Adding the original_at column only with the option")
+	plain, _ := GetBatchConverterFunctionWithOriginalAt("csv", false)
+	if reflect.ValueOf(ConvertBatchCSV).Pointer() != reflect.ValueOf(plain).Pointer() {
+		t.Errorf("CSV without the option should keep its columns")
+	}
+	withOriginalAt, _ := GetBatchConverterFunctionWithOriginalAt("csv", true)
+	if reflect.ValueOf(ConvertBatchCSVWithOriginalAt).Pointer() != reflect.ValueOf(withOriginalAt).Pointer() {
+		t.Errorf("CSV with the option should have the original_at column")
+	}
+	json, _ := GetBatchConverterFunctionWithOriginalAt("json", true)
+	if reflect.ValueOf(ConvertBatchJSON).Pointer() != reflect.ValueOf(json).Pointer() {
+		t.Errorf("JSON should not be changed by the option")
+	}
+
+	clamped, accepted := GetTestEvent(1), GetTestEvent(2)
+	clamped.OriginalAt = "1970-01-01 00:00:00"
+	b, err := ConvertBatchCSVWithOriginalAt([]*Event{clamped, accepted})
+	if err != nil {
+		t.Errorf("Batch CSV conversion is failed: %s", err.Error())
+	}
+	for i, exp := range []string{"\001" + clamped.OriginalAt + "\n", "\001\n"} {
+		line, _ := b.ReadString('\n')
+		if n := strings.Count(line, "\001"); n != len(EventFieldNames) {
+			t.Errorf("Expected number of separators was %d but it was %d instead", len(EventFieldNames), n)
+		}
+		if !strings.HasSuffix(line, exp) {
+			t.Errorf("%d. line should end with the original event time: `%s`", i+1, line)
+		}
+	}
+}
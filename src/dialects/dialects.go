@@ -2,6 +2,7 @@ package dialects
 
 import (
 	"bytes"
+	"github.com/wunderlist/hamustro/src/tracing"
 )
 
 // Interface for processing events
@@ -12,6 +13,55 @@ type StorageClient interface {
 	Save(*bytes.Buffer) error
 }
 
+// Storage client that records its internal steps (compression,
+// client calls) as child spans of the given span
+type TracedStorageClient interface {
+	SaveTraced(msg *bytes.Buffer, span *tracing.Span) error
+}
+
+// Saves the message, the steps are traced if the client supports it
+func SaveWithSpan(client StorageClient, msg *bytes.Buffer, span *tracing.Span) error {
+	if traced, ok := client.(TracedStorageClient); ok && span != nil {
+		return traced.SaveTraced(msg, span)
+	}
+	return client.Save(msg)
+}
+
+// Storage client that names the batch's object and
+// saves the batch's properties with it
+type BatchStorageClient interface {
+	SaveBatch(msg *bytes.Buffer, info *BatchInfo, span *tracing.Span) error
+}
+
+// Storage client whose path may contain the placeholders
+// of the event's properties
+type PartitionedStorageClient interface {
+	GetPathTemplate() string
+}
+
+// Returns the client's path with its placeholders, it's empty
+// if the client has no such path
+func GetPathTemplate(client StorageClient) string {
+	if partitioned, ok := client.(PartitionedStorageClient); ok {
+		return partitioned.GetPathTemplate()
+	}
+	return ""
+}
+
+// Storage client that saves named objects next to the batches
+// (e.g. manifests and markers)
+type ObjectStorageClient interface {
+	SaveObject(name string, msg *bytes.Buffer) error
+}
+
+// Saves the batch with its properties if the client supports it
+func SaveBatchWithSpan(client StorageClient, msg *bytes.Buffer, info *BatchInfo, span *tracing.Span) error {
+	if batch, ok := client.(BatchStorageClient); ok && info != nil {
+		return batch.SaveBatch(msg, info, span)
+	}
+	return SaveWithSpan(client, msg, span)
+}
+
 // Dialect interface for create StorageQueue from Configuration
 type Dialect interface {
 	IsValid() bool
@@ -41,6 +41,8 @@ type Event struct {
 	IP              string `json:"ip,omitempty"`
 	Country         string `json:"country,omitempty"`
 	Parameters      string `json:"parameters,omitempty"`
+	OriginalAt      string `json:"original_at,omitempty"` // Client's event time of a clamped event
+	Path            string `json:"-"`                     // Path of the event instead of the sink's path (optional)
 }
 
 // Creates a new event based on the collection and a single payload
@@ -109,6 +111,55 @@ func (event *Event) String() []string {
 		event.Parameters}
 }
 
+// Returns the size of the event's values in bytes, it's the
+// uncompressed size of the event within a batch without the
+// format's overhead (e.g. separators, keys)
+func (event *Event) Size() int {
+	size := 4 // nr
+	for i, value := range event.String() {
+		if i != 3 {
+			size += len(value)
+		}
+	}
+	return size
+}
+
+// Names of the event's fields in the same order as String() returns them
+var EventFieldNames = []string{
+	"device_id",
+	"client_id",
+	"session",
+	"nr",
+	"env",
+	"system_version",
+	"product_version",
+	"at",
+	"timezone",
+	"event",
+	"device_make",
+	"device_model",
+	"system",
+	"system_language",
+	"browser",
+	"browser_version",
+	"product_git_hash",
+	"product_language",
+	"user_id",
+	"tenant_id",
+	"ip",
+	"country",
+	"parameters"}
+
+// Returns the position of the field within String()
+func GetEventFieldIndex(name string) (int, bool) {
+	for i, n := range EventFieldNames {
+		if n == name {
+			return i, true
+		}
+	}
+	return -1, false
+}
+
 var regexpIP *regexp.Regexp
 
 func init() {
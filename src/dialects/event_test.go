@@ -191,3 +191,42 @@ func TestNewEventCreation(t *testing.T) {
 		t.Errorf("Expected ProductLanguage was %s but it was %s instead", exp, e.ProductLanguage)
 	}
 }
+
+// Tests that the field names are in the same order as the values
+func TestFunctionGetEventFieldIndex(t *testing.T) {
+	event := &Event{Env: "PRODUCTION", Event: "Crash.App", TenantID: "t-1", Parameters: "{}"}
+	values := event.String()
+	if len(values) != len(EventFieldNames) {
+		t.Fatalf("Expected number of field names was %d but it was %d instead", len(values), len(EventFieldNames))
+	}
+
+	cases := []struct {
+		Name     string
+		Expected string
+		IsValid  bool
+	}{
+		{"env", "PRODUCTION", true},
+		{"event", "Crash.App", true},
+		{"tenant_id", "t-1", true},
+		{"parameters", "{}", true},
+		{"tenant", "", false}}
+
+	for _, c := range cases {
+		i, ok := GetEventFieldIndex(c.Name)
+		if ok != c.IsValid {
+			t.Errorf("Field %s validity should be %t", c.Name, c.IsValid)
+			continue
+		}
+		if ok && values[i] != c.Expected {
+			t.Errorf("Expected %s was %s but it was %s instead", c.Name, c.Expected, values[i])
+		}
+	}
+}
+
+// Tests the approximate memory usage of an event
+func TestFunctionSize(t *testing.T) {
+	event := &Event{DeviceID: "a73b1c37", Nr: 12345, Env: "PRODUCTION", Event: "Client.Start"}
+	if exp := 4 + 8 + 10 + 12; event.Size() != exp {
+		t.Errorf("Expected size was %d but it was %d instead", exp, event.Size())
+	}
+}
@@ -3,7 +3,10 @@ package file
 import (
 	"bytes"
 	"github.com/wunderlist/hamustro/src/dialects"
+	"github.com/wunderlist/hamustro/src/tracing"
+	"io/ioutil"
 	"os"
+	"path/filepath"
 )
 
 // Local file configuration
@@ -11,6 +14,7 @@ type Config struct {
 	FilePath   string `json:"file_path"`
 	FileFormat string `json:"file_format"`
 	Compress   bool   `json:"compress"`
+	OriginalAt bool   `json:"original_at_column"` // Adds the `original_at` column to the CSV rows
 }
 
 // Checks is it valid or not
@@ -20,7 +24,7 @@ func (c *Config) IsValid() bool {
 
 // Create a new StorageClient object based on a configuration file.
 func (c *Config) NewClient() (dialects.StorageClient, error) {
-	converterFunction, err := dialects.GetBatchConverterFunction(c.FileFormat)
+	converterFunction, err := dialects.GetBatchConverterFunctionWithOriginalAt(c.FileFormat, c.OriginalAt)
 	if err != nil {
 		return nil, err
 	}
@@ -55,9 +59,19 @@ func (c *FileStorage) GetBatchConverter() dialects.BatchConverter {
 	return c.BatchConverter
 }
 
+// Returns the path with its placeholders
+func (c *FileStorage) GetPathTemplate() string {
+	return c.FilePath
+}
+
 func (c *FileStorage) GetBuffer(msg *bytes.Buffer) (*bytes.Buffer, error) {
+	return c.GetTracedBuffer(msg, nil)
+}
+
+// Returns the buffer to write and traces the compression
+func (c *FileStorage) GetTracedBuffer(msg *bytes.Buffer, span *tracing.Span) (*bytes.Buffer, error) {
 	if c.Compress {
-		buffer, err := dialects.Compress(msg)
+		buffer, err := dialects.CompressWithSpan(msg, span)
 		if err != nil {
 			return nil, err
 		}
@@ -68,17 +82,29 @@ func (c *FileStorage) GetBuffer(msg *bytes.Buffer) (*bytes.Buffer, error) {
 
 // Write a single local file with multiple records
 func (c *FileStorage) Save(msg *bytes.Buffer) error {
-	buffer, err := c.GetBuffer(msg)
+	return c.SaveTraced(msg, nil)
+}
+
+// Write a single local file with multiple records and trace the steps
+func (c *FileStorage) SaveTraced(msg *bytes.Buffer, span *tracing.Span) error {
+	return c.SaveBatch(msg, nil, span)
+}
+
+// Write a single local file with multiple records into the batch's
+// partition, the batch's properties are added to the file's name,
+// and trace the steps
+func (c *FileStorage) SaveBatch(msg *bytes.Buffer, info *dialects.BatchInfo, span *tracing.Span) error {
+	buffer, err := c.GetTracedBuffer(msg, span)
 	if err != nil {
 		return err
 	}
 
-	basepath := dialects.ResolvePath(c.FilePath)
+	basepath := dialects.ResolveBatchPath(c.FilePath, info)
 	if err := os.MkdirAll(basepath, os.ModePerm); err != nil {
 		return err
 	}
 
-	path := dialects.GetRandomPath(basepath, c.FileFormat, c.Compress)
+	path := filepath.Join(basepath, dialects.GetBatchFileName(c.FileFormat, c.Compress, info))
 	f, err := os.Create(path)
 	if err != nil {
 		return err
@@ -86,8 +112,25 @@ func (c *FileStorage) Save(msg *bytes.Buffer) error {
 	defer f.Close()
 
 	data := buffer.Bytes()
-	if _, err := f.Write(data); err != nil {
+	child := span.Child("file.Write", tracing.SPAN_KIND_CLIENT)
+	child.SetAttribute("bytes", len(data))
+	_, err = f.Write(data)
+	child.FinishWithError(err)
+	if err != nil {
 		return err
 	}
+	url, err := filepath.Abs(path)
+	if err != nil {
+		url = path
+	}
+	info.SetObject(path, url, len(data))
 	return nil
 }
+
+// Write a single local file (e.g. a manifest) with the given path
+func (c *FileStorage) SaveObject(name string, msg *bytes.Buffer) error {
+	if err := os.MkdirAll(filepath.Dir(name), os.ModePerm); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(name, msg.Bytes(), 0644)
+}
@@ -1,6 +1,9 @@
 package dialects
 
 import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
 	"fmt"
 	"math/rand"
 	"path"
@@ -11,6 +14,11 @@ import (
 
 const letterBytes = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ"
 
+// Every process generates different random names
+func init() {
+	rand.Seed(time.Now().UnixNano())
+}
+
 // Generates an `n` length random string.
 func RandStringBytes(n int) string {
 	b := make([]byte, n)
@@ -20,8 +28,13 @@ func RandStringBytes(n int) string {
 	return string(b)
 }
 
-// Resolves custom names in the path
+// Resolves custom names in the path with the current time
 func ResolvePath(basePath string) string {
+	return ResolvePathAt(basePath, time.Now())
+}
+
+// Resolves custom names in the path with the given time
+func ResolvePathAt(basePath string, at time.Time) string {
 	rules := []struct {
 		Keyword string
 		Format  string
@@ -37,20 +50,210 @@ func ResolvePath(basePath string) string {
 	newPath := basePath
 	for _, rule := range rules {
 		if strings.Contains(newPath, rule.Keyword) {
-			newPath = strings.Replace(newPath, rule.Keyword, time.Now().UTC().Format(rule.Format), -1)
+			newPath = strings.Replace(newPath, rule.Keyword, at.UTC().Format(rule.Format), -1)
 		}
 	}
 	return newPath
 }
 
+// Resolves the placeholders of the event's properties in the path,
+// every placeholder is resolved to `unknown` if there is no event
+func ResolveEventPath(basePath string, event *Event) string {
+	if !strings.Contains(basePath, "{") {
+		return basePath
+	}
+	newPath := basePath
+	for _, rule := range eventPathRules {
+		if strings.Contains(newPath, rule.Keyword) {
+			value := ""
+			if event != nil {
+				value = rule.Value(event)
+			}
+			newPath = strings.Replace(newPath, rule.Keyword, GetPartitionValue(value), -1)
+		}
+	}
+	return newPath
+}
+
+// Placeholders of the event's properties
+var eventPathRules = []struct {
+	Keyword string
+	Value   func(e *Event) string
+}{
+	{"{event_date}", func(e *Event) string { return GetEventTime(e.At, "2006-01-02") }},
+	{"{event_year}", func(e *Event) string { return GetEventTime(e.At, "2006") }},
+	{"{event_month}", func(e *Event) string { return GetEventTime(e.At, "01") }},
+	{"{event_day}", func(e *Event) string { return GetEventTime(e.At, "02") }},
+	{"{event_hour}", func(e *Event) string { return GetEventTime(e.At, "15") }},
+	{"{env}", func(e *Event) string { return e.Env }},
+	{"{client_id}", func(e *Event) string { return e.ClientID }},
+	{"{tenant_id}", func(e *Event) string { return e.TenantID }},
+	{"{event}", func(e *Event) string { return e.Event }}}
+
+// Partition of the events without the property
+const UNKNOWN_PARTITION = "unknown"
+
+// Is there any placeholder of the event's properties in the path
+func HasEventPlaceholders(basePath string) bool {
+	for _, rule := range eventPathRules {
+		if strings.Contains(basePath, rule.Keyword) {
+			return true
+		}
+	}
+	return false
+}
+
+// Returns the event time (`2006-01-02 15:04:05`) in the given format,
+// it's empty if the event time is invalid
+func GetEventTime(at string, format string) string {
+	t, err := time.Parse("2006-01-02 15:04:05", at)
+	if err != nil {
+		return ""
+	}
+	return t.Format(format)
+}
+
+// Returns the value that is safe to use as a single directory (and
+// in a Hive-style `key=value` directory) of the path
+func GetPartitionValue(value string) string {
+	if value == "" {
+		return UNKNOWN_PARTITION
+	}
+	b := []byte(value)
+	for i, c := range b {
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '-' || c == '_' || c == '.') {
+			b[i] = '_'
+		}
+	}
+	return string(b)
+}
+
+// Resolves the placeholders of the path (or the batch's own path) with
+// the batch's partition and the batch's creation time (the current
+// time by default)
+func ResolveBatchPath(basePath string, info *BatchInfo) string {
+	var event *Event
+	at := time.Now()
+	if info != nil {
+		if info.BasePath != "" {
+			basePath = info.BasePath
+		}
+		event = info.Partition
+		if !info.CreatedAt.IsZero() {
+			at = info.CreatedAt
+		}
+	}
+	return ResolvePathAt(ResolveEventPath(basePath, event), at)
+}
+
 // Get a random name for the blob
 func GetRandomPath(basePath string, extension string, compress bool) string {
+	return path.Join(ResolveBatchPath(basePath, nil), GetBatchFileName(extension, compress, nil))
+}
+
+// Get a name for the batch's blob in its partition
+func GetBatchPath(basePath string, extension string, compress bool, info *BatchInfo) string {
+	return path.Join(ResolveBatchPath(basePath, info), GetBatchFileName(extension, compress, info))
+}
+
+// Get a random file name for the batch, with the batch's metadata it
+// contains the event time range, the number of events and the
+// worker's ID, e.g.
+// `1454684704-20160205T150504-20160205T151012-1000-w3-<random>.json.gz`.
+// The deterministic name is made of the instance, the worker, the
+// batch's sequence and its content hash instead of the timestamp and
+// the random string, e.g. `host-1-w3-000000000042-<hash>.json.gz`
+func GetBatchFileName(extension string, compress bool, info *BatchInfo) string {
 	timestamp := strconv.Itoa(int(time.Now().Unix()))
 	compressedExtension := ""
 	if compress {
 		compressedExtension = ".gz"
 	}
-	fileName := fmt.Sprintf("%s-%s.%s%s", timestamp, RandStringBytes(20),
+	if info != nil && info.Hash != "" {
+		name := fmt.Sprintf("%s-w%d-%012d-%s", info.Instance, info.WorkerID, info.Sequence, info.Hash)
+		if info.Metadata {
+			name = fmt.Sprintf("%s-%s-%d-%s", CompactEventTime(info.MinAt), CompactEventTime(info.MaxAt), info.Events, name)
+		}
+		return fmt.Sprintf("%s.%s%s", name, extension, compressedExtension)
+	}
+	if info == nil || !info.Metadata {
+		return fmt.Sprintf("%s-%s.%s%s", timestamp, RandStringBytes(20),
+			extension, compressedExtension)
+	}
+	return fmt.Sprintf("%s-%s-%s-%d-w%d-%s.%s%s", timestamp, CompactEventTime(info.MinAt),
+		CompactEventTime(info.MaxAt), info.Events, info.WorkerID, RandStringBytes(10),
 		extension, compressedExtension)
-	return path.Join(ResolvePath(basePath), fileName)
+}
+
+// Returns the event time without separators for the file names,
+// `2016-02-05 15:05:04` becomes `20160205T150504`
+func CompactEventTime(at string) string {
+	return strings.NewReplacer("-", "", ":", "", " ", "T").Replace(at)
+}
+
+// Returns the hash of the batch's content for the deterministic names
+func GetContentHash(msg *bytes.Buffer) string {
+	sum := sha256.Sum256(msg.Bytes())
+	return hex.EncodeToString(sum[:8])
+}
+
+// Properties of a batch for the object's name and metadata
+type BatchInfo struct {
+	WorkerID  int
+	Events    int
+	MinAt     string    // Event time of the earliest event (`2006-01-02 15:04:05`)
+	MaxAt     string    // Event time of the latest event
+	Partition *Event    // Any event of the batch that resolves the path's placeholders
+	BasePath  string    // Path of the batch instead of the client's path (optional)
+	Metadata  bool      // Are the properties saved in the object's name and metadata
+	Instance  string    // ID of the collector's instance
+	Sequence  uint64    // Sequence of the batch within the worker
+	Hash      string    // Hash of the batch's content, the name is deterministic with it
+	CreatedAt time.Time // Time of the batch's first upload, it resolves the path's time placeholders
+	Path      string    // Path of the saved object (set by the storage client)
+	URL       string    // URL of the saved object (set by the storage client)
+	Size      int64     // Size of the saved object in bytes (set by the storage client)
+}
+
+// Records the saved object's location and size
+func (b *BatchInfo) SetObject(path string, url string, size int) {
+	if b != nil {
+		b.Path, b.URL, b.Size = path, url, int64(size)
+	}
+}
+
+// Collects the properties of the batch's events
+func NewBatchInfo(workerID int, events []*Event) *BatchInfo {
+	info := &BatchInfo{WorkerID: workerID, Events: len(events)}
+	for _, event := range events {
+		if info.MinAt == "" || event.At < info.MinAt {
+			info.MinAt = event.At
+		}
+		if event.At > info.MaxAt {
+			info.MaxAt = event.At
+		}
+	}
+	if len(events) != 0 {
+		info.Partition = events[0]
+	}
+	return info
+}
+
+// Returns the metadata of the batch's object, it's empty
+// if the metadata is not saved
+func (b *BatchInfo) GetMetadata() map[string]string {
+	if b == nil || !b.Metadata {
+		return nil
+	}
+	metadata := map[string]string{
+		"worker_id":      strconv.Itoa(b.WorkerID),
+		"event_count":    strconv.Itoa(b.Events),
+		"min_event_time": b.MinAt,
+		"max_event_time": b.MaxAt}
+	if b.Hash != "" {
+		metadata["instance_id"] = b.Instance
+		metadata["batch_sequence"] = strconv.FormatUint(b.Sequence, 10)
+		metadata["content_hash"] = b.Hash
+	}
+	return metadata
 }
@@ -1,6 +1,8 @@
 package dialects
 
 import (
+	"bytes"
+	"strings"
 	"testing"
 	"time"
 )
@@ -77,3 +79,99 @@ func TestFunctionGetRandomPath(t *testing.T) {
 		t.Errorf("Expected directory path was dir/to/path/ but it was %s instead", dir)
 	}
 }
+
+// Collects the event time range of a batch for its name and metadata
+func TestFunctionNewBatchInfoAndGetBatchPath(t *testing.T) {
+	events := []*Event{&Event{At: "2016-02-05 15:05:04"}, &Event{At: "2016-02-05 14:00:00"}, &Event{At: "2016-02-06 01:02:03"}}
+	info := NewBatchInfo(3, events)
+	if info.GetMetadata() != nil {
+		t.Errorf("Metadata should be empty if it's not saved")
+	}
+	info.Metadata = true
+	if info.MinAt != "2016-02-05 14:00:00" || info.MaxAt != "2016-02-06 01:02:03" || info.Events != 3 || info.WorkerID != 3 {
+		t.Errorf("Batch has unexpected properties: %+v", info)
+	}
+	if m := info.GetMetadata(); m["min_event_time"] != info.MinAt || m["max_event_time"] != info.MaxAt || m["event_count"] != "3" || m["worker_id"] != "3" {
+		t.Errorf("Batch has unexpected metadata: %v", m)
+	}
+
+	t.Log("Adds the event time range, the number of events and the worker to the file name")
+	p := GetBatchPath("dir", "json", true, info)
+	if !strings.HasPrefix(p, "dir/") || !strings.Contains(p, "-20160205T140000-20160206T010203-3-w3-") || !strings.HasSuffix(p, ".json.gz") {
+		t.Errorf("Batch path has unexpected format: %s", p)
+	}
+	if p := GetBatchPath("", "csv", false, nil); len(p) != 35 {
+		t.Errorf("Batch path without properties should be random but it was %s instead", p)
+	}
+}
+
+// Replacing the placeholders of the event's properties in the filepath
+func TestFunctionResolveEventPath(t *testing.T) {
+	event := &Event{At: "2016-02-05 15:05:04", Env: "production", ClientID: "web/1", Event: "Client.CreateUser"}
+	cases := []struct {
+		Path     string
+		Expected string
+	}{
+		{"directory/subdirectory", "directory/subdirectory"},
+		{"directory/{event_date}/{event_hour}", "directory/2016-02-05/15"},
+		{"directory/{event_year}/{event_month}/{event_day}", "directory/2016/02/05"},
+		{"env={env}/client_id={client_id}/event={event}", "env=production/client_id=web_1/event=Client.CreateUser"},
+		{"tenant_id={tenant_id}/{date}", "tenant_id=unknown/{date}"}}
+
+	t.Log("Detecting placeholders of the event's properties in the file path")
+	for _, c := range cases {
+		if p := ResolveEventPath(c.Path, event); p != c.Expected {
+			t.Errorf("Expected path was %s but it was %s instead", c.Expected, p)
+		}
+		if exp := c.Path != "directory/subdirectory"; HasEventPlaceholders(c.Path) != exp {
+			t.Errorf("Expected event placeholders of %s was %t but it wasn't", c.Path, exp)
+		}
+	}
+
+	t.Log("Invalid event time and missing event are resolved to the unknown partition")
+	if p := ResolveEventPath("dt={event_date}/{env}", &Event{At: "yesterday", Env: "dev"}); p != "dt=unknown/dev" {
+		t.Errorf("Expected path was dt=unknown/dev but it was %s instead", p)
+	}
+	if p := ResolveEventPath("dt={event_date}/{env}", nil); p != "dt=unknown/unknown" {
+		t.Errorf("Expected path was dt=unknown/unknown but it was %s instead", p)
+	}
+
+	t.Log("The batch's path is resolved with the event of its partition")
+	info := NewBatchInfo(0, []*Event{event})
+	if p := GetBatchPath("dt={event_date}/{env}/{year}", "json", true, info); !strings.HasPrefix(p, "dt=2016-02-05/production/"+time.Now().UTC().Format("2006")+"/") {
+		t.Errorf("Batch path has unexpected directory: %s", p)
+	}
+}
+
+// Generates the same name for the same batch and content
+func TestFunctionGetDeterministicBatchFileName(t *testing.T) {
+	info := &BatchInfo{WorkerID: 3, Events: 2, MinAt: "2016-02-05 14:00:00", MaxAt: "2016-02-05 15:05:04", Instance: "host-1", Sequence: 42, Hash: GetContentHash(bytes.NewBufferString("{}\n{}\n"))}
+	if len(info.Hash) != 16 {
+		t.Errorf("Expected content hash length was 16 but it was %s instead", info.Hash)
+	}
+	if info.Hash == GetContentHash(bytes.NewBufferString("{}\n")) {
+		t.Errorf("Different contents should have different hashes")
+	}
+
+	t.Log("Names the batch by the instance, the worker, the sequence and the content")
+	name := GetBatchFileName("json", true, info)
+	if exp := "host-1-w3-000000000042-" + info.Hash + ".json.gz"; name != exp {
+		t.Errorf("Expected name was %s but it was %s instead", exp, name)
+	}
+	if again := GetBatchFileName("json", true, info); again != name {
+		t.Errorf("Expected name was %s again but it was %s instead", name, again)
+	}
+	info.Metadata = true
+	if exp := "20160205T140000-20160205T150504-2-host-1-w3-000000000042-" + info.Hash + ".json.gz"; GetBatchFileName("json", true, info) != exp {
+		t.Errorf("Expected name was %s but it was %s instead", exp, GetBatchFileName("json", true, info))
+	}
+	if m := info.GetMetadata(); m["instance_id"] != "host-1" || m["batch_sequence"] != "42" || m["content_hash"] != info.Hash {
+		t.Errorf("Batch has unexpected metadata: %v", m)
+	}
+
+	t.Log("The time placeholders are resolved with the batch's creation time")
+	info.CreatedAt = time.Date(2016, 2, 5, 23, 59, 59, 0, time.UTC)
+	if p := ResolveBatchPath("dir/{date}/{hour}", info); p != "dir/2016-02-05/23" {
+		t.Errorf("Expected path was dir/2016-02-05/23 but it was %s instead", p)
+	}
+}
@@ -7,6 +7,7 @@ import (
 	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/s3"
 	"github.com/wunderlist/hamustro/src/dialects"
+	"github.com/wunderlist/hamustro/src/tracing"
 	"net/http"
 )
 
@@ -19,6 +20,7 @@ type Config struct {
 	Endpoint        string `json:"endpoint"`
 	Region          string `json:"region"`
 	FileFormat      string `json:"file_format"`
+	OriginalAt      bool   `json:"original_at_column"` // Adds the `original_at` column to the CSV rows
 }
 
 // Checks is it valid or not
@@ -33,7 +35,7 @@ func (c *Config) NewClient() (dialects.StorageClient, error) {
 	if err != nil {
 		return nil, err
 	}
-	converterFunction, err := dialects.GetBatchConverterFunction(c.FileFormat)
+	converterFunction, err := dialects.GetBatchConverterFunctionWithOriginalAt(c.FileFormat, c.OriginalAt)
 	if err != nil {
 		return nil, err
 	}
@@ -81,24 +83,65 @@ func (c *S3Storage) GetBatchConverter() dialects.BatchConverter {
 	return c.BatchConverter
 }
 
+// Returns the path with its placeholders
+func (c *S3Storage) GetPathTemplate() string {
+	return c.BlobPath
+}
+
 // Publish a batched Events to S$.
 func (c *S3Storage) Save(msg *bytes.Buffer) error {
-	buffer, err := dialects.Compress(msg)
+	return c.SaveTraced(msg, nil)
+}
+
+// Publish a batched Events to S3 and trace the compression and the upload.
+func (c *S3Storage) SaveTraced(msg *bytes.Buffer, span *tracing.Span) error {
+	return c.SaveBatch(msg, nil, span)
+}
+
+// Publish a batched Events to S3 into the batch's partition with the
+// batch's properties in the object's name and metadata, and trace the
+// compression and the upload.
+func (c *S3Storage) SaveBatch(msg *bytes.Buffer, info *dialects.BatchInfo, span *tracing.Span) error {
+	buffer, err := dialects.CompressWithSpan(msg, span)
 	if err != nil {
 		return err
 	}
 	fileSize := buffer.Len()
 	fileBytes := buffer.Bytes()
+	metadata := map[string]*string{"Key": aws.String("MetadataValue")}
+	if info.GetMetadata() != nil {
+		metadata = map[string]*string{}
+		for key, value := range info.GetMetadata() {
+			metadata[key] = aws.String(value)
+		}
+	}
+	key := dialects.GetBatchPath(c.BlobPath, c.FileFormat, true, info)
 	params := &s3.PutObjectInput{
 		Bucket:        &c.Bucket,
-		Key:           aws.String(dialects.GetRandomPath(c.BlobPath, c.FileFormat, true)),
+		Key:           aws.String(key),
 		Body:          bytes.NewReader(fileBytes),
 		ContentLength: aws.Int64(int64(fileSize)),
 		ContentType:   aws.String(http.DetectContentType(fileBytes)),
-		Metadata:      map[string]*string{"Key": aws.String("MetadataValue")}}
+		Metadata:      metadata}
+	child := span.Child("s3.PutObject", tracing.SPAN_KIND_CLIENT)
+	child.SetAttribute("bytes", fileSize)
 	_, err = c.Client.PutObject(params)
+	child.FinishWithError(err)
 	if err != nil {
 		return err
 	}
+	info.SetObject(key, "s3://"+c.Bucket+"/"+key, fileSize)
 	return nil
 }
+
+// Publish a single object (e.g. a manifest) to S3 under the given key.
+func (c *S3Storage) SaveObject(name string, msg *bytes.Buffer) error {
+	fileBytes := msg.Bytes()
+	_, err := c.Client.PutObject(&s3.PutObjectInput{
+		Bucket:        &c.Bucket,
+		Key:           aws.String(name),
+		Body:          bytes.NewReader(fileBytes),
+		ContentLength: aws.Int64(int64(len(fileBytes))),
+		ContentType:   aws.String(http.DetectContentType(fileBytes))})
+	return err
+}
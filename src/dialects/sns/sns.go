@@ -7,6 +7,7 @@ import (
 	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/sns"
 	"github.com/wunderlist/hamustro/src/dialects"
+	"github.com/wunderlist/hamustro/src/tracing"
 )
 
 // Amazon SNS configuration file.
@@ -62,10 +63,17 @@ func (c *SNSStorage) GetBatchConverter() dialects.BatchConverter {
 
 // Publish a single Event to SNS topic.
 func (c *SNSStorage) Save(msg *bytes.Buffer) error {
+	return c.SaveTraced(msg, nil)
+}
+
+// Publish a single Event to SNS and trace the call.
+func (c *SNSStorage) SaveTraced(msg *bytes.Buffer, span *tracing.Span) error {
 	params := &sns.PublishInput{
 		Message:  aws.String(msg.String()),
 		TopicArn: &c.TopicArn}
+	child := span.Child("sns.Publish", tracing.SPAN_KIND_CLIENT)
 	_, err := c.Client.Publish(params)
+	child.FinishWithError(err)
 	if err != nil {
 		return err
 	}
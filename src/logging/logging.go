@@ -0,0 +1,234 @@
+package logging
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Logging levels
+type Level int
+
+const (
+	DEBUG Level = iota
+	INFO
+	WARN
+	ERROR
+	DISABLED
+)
+
+// Names of the logging levels
+var levelNames = map[Level]string{
+	DEBUG: "debug",
+	INFO:  "info",
+	WARN:  "warn",
+	ERROR: "error"}
+
+// Returns the level's name
+func (l Level) String() string {
+	return levelNames[l]
+}
+
+// Returns the level for a given name
+func ParseLevel(name string) (Level, error) {
+	for level, n := range levelNames {
+		if n == strings.ToLower(name) {
+			return level, nil
+		}
+	}
+	return INFO, fmt.Errorf("Not supported `%s` log level (use `debug`, `info`, `warn` or `error`)", name)
+}
+
+// Supported output formats
+const FORMAT_TEXT = "text"
+const FORMAT_LOGFMT = "logfmt"
+const FORMAT_JSON = "json"
+
+// Checks the output format
+func IsValidFormat(format string) bool {
+	return format == FORMAT_TEXT || format == FORMAT_LOGFMT || format == FORMAT_JSON
+}
+
+// Additional fields of a log entry
+type Fields map[string]interface{}
+
+// Shared options of the loggers created from the same root
+type options struct {
+	Output  *log.Logger
+	Format  string
+	Levels  map[string]Level
+	Limiter *RateLimiter
+}
+
+// Leveled logger with structured fields
+type Logger struct {
+	Level   Level
+	Name    string
+	Fields  Fields
+	options *options
+}
+
+// Creates a new root logger, it writes through the standard
+// logger if the output is not defined
+func New(output *log.Logger, format string, level Level) *Logger {
+	if !IsValidFormat(format) {
+		format = FORMAT_TEXT
+	}
+	return &Logger{
+		Level:   level,
+		Fields:  Fields{},
+		options: &options{Output: output, Format: format, Levels: map[string]Level{}}}
+}
+
+// Overrides the level of a component
+func (l *Logger) SetComponentLevel(component string, level Level) {
+	l.options.Levels[component] = level
+}
+
+// Sets the rate limiter used by RateLimit
+func (l *Logger) SetRateLimiter(limiter *RateLimiter) {
+	l.options.Limiter = limiter
+}
+
+// Returns a logger for the given component
+func (l *Logger) Component(component string) *Logger {
+	level := l.Level
+	if override, ok := l.options.Levels[component]; ok {
+		level = override
+	}
+	return &Logger{Level: level, Name: component, Fields: l.Fields, options: l.options}
+}
+
+// Returns a logger with additional fields
+func (l *Logger) With(fields Fields) *Logger {
+	merged := Fields{}
+	for k, v := range l.Fields {
+		merged[k] = v
+	}
+	for k, v := range fields {
+		merged[k] = v
+	}
+	return &Logger{Level: l.Level, Name: l.Name, Fields: merged, options: l.options}
+}
+
+// Returns a logger that writes at most a limited number of entries
+// for the given key, the number of dropped entries is added to the next one
+func (l *Logger) RateLimit(key string) *Logger {
+	if l.options.Limiter == nil {
+		return l
+	}
+	allowed, suppressed := l.options.Limiter.Allow(l.Name + "|" + key)
+	if !allowed {
+		return &Logger{Level: DISABLED, Name: l.Name, Fields: l.Fields, options: l.options}
+	}
+	if suppressed != 0 {
+		return l.With(Fields{"suppressed": suppressed})
+	}
+	return l
+}
+
+// Checks that the level is enabled
+func (l *Logger) IsEnabled(level Level) bool {
+	return level >= l.Level
+}
+
+// Logs a debug message
+func (l *Logger) Debugf(format string, v ...interface{}) {
+	l.write(DEBUG, format, v...)
+}
+
+// Logs an info message
+func (l *Logger) Infof(format string, v ...interface{}) {
+	l.write(INFO, format, v...)
+}
+
+// Logs a warning message
+func (l *Logger) Warnf(format string, v ...interface{}) {
+	l.write(WARN, format, v...)
+}
+
+// Logs an error message
+func (l *Logger) Errorf(format string, v ...interface{}) {
+	l.write(ERROR, format, v...)
+}
+
+// Formats and writes the entry
+func (l *Logger) write(level Level, format string, v ...interface{}) {
+	if !l.IsEnabled(level) {
+		return
+	}
+	line := l.Format(level, fmt.Sprintf(format, v...), time.Now())
+	if l.options.Output != nil {
+		l.options.Output.Print(line)
+	} else {
+		log.Print(line)
+	}
+}
+
+// Returns the sorted field names
+func (l *Logger) keys() []string {
+	keys := make([]string, 0, len(l.Fields))
+	for k := range l.Fields {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// Formats a single entry
+func (l *Logger) Format(level Level, msg string, at time.Time) string {
+	switch l.options.Format {
+	case FORMAT_JSON:
+		entry := map[string]interface{}{}
+		for k, v := range l.Fields {
+			entry[k] = v
+		}
+		entry["time"] = at.UTC().Format(time.RFC3339Nano)
+		entry["level"] = level.String()
+		entry["msg"] = msg
+		if l.Name != "" {
+			entry["component"] = l.Name
+		}
+		b, err := json.Marshal(entry)
+		if err != nil {
+			return fmt.Sprintf(`{"level":"error","msg":%q}`, err.Error())
+		}
+		return string(b)
+	case FORMAT_LOGFMT:
+		b := new(bytes.Buffer)
+		fmt.Fprintf(b, "time=%s level=%s", at.UTC().Format(time.RFC3339Nano), level)
+		if l.Name != "" {
+			fmt.Fprintf(b, " component=%s", formatValue(l.Name))
+		}
+		fmt.Fprintf(b, " msg=%s", formatValue(msg))
+		for _, k := range l.keys() {
+			fmt.Fprintf(b, " %s=%s", k, formatValue(l.Fields[k]))
+		}
+		return b.String()
+	default:
+		b := new(bytes.Buffer)
+		fmt.Fprintf(b, "[%s]", strings.ToUpper(level.String()))
+		if l.Name != "" {
+			fmt.Fprintf(b, " %s:", l.Name)
+		}
+		fmt.Fprintf(b, " %s", msg)
+		for _, k := range l.keys() {
+			fmt.Fprintf(b, " %s=%s", k, formatValue(l.Fields[k]))
+		}
+		return b.String()
+	}
+}
+
+// Formats a value for logfmt, quotes it if it's necessary
+func formatValue(v interface{}) string {
+	s := fmt.Sprint(v)
+	if s == "" || strings.ContainsAny(s, " =\"\t\n") {
+		return strconv.Quote(s)
+	}
+	return s
+}
@@ -0,0 +1,125 @@
+package logging
+
+import (
+	"bytes"
+	"encoding/json"
+	"log"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Returns a logger that writes into a buffer
+func GetTestLogger(format string, level Level) (*Logger, *bytes.Buffer) {
+	b := new(bytes.Buffer)
+	return New(log.New(b, "", 0), format, level), b
+}
+
+// Tests the level parsing
+func TestFunctionParseLevel(t *testing.T) {
+	cases := []struct {
+		Name     string
+		Expected Level
+		IsValid  bool
+	}{
+		{"debug", DEBUG, true},
+		{"info", INFO, true},
+		{"WARN", WARN, true},
+		{"error", ERROR, true},
+		{"verbose", INFO, false}}
+
+	for _, c := range cases {
+		level, err := ParseLevel(c.Name)
+		if (err == nil) != c.IsValid {
+			t.Errorf("Level %s validity should be %t", c.Name, c.IsValid)
+		}
+		if level != c.Expected {
+			t.Errorf("Expected level was %s but it was %s instead", c.Expected, level)
+		}
+	}
+}
+
+// Tests the level filtering and the component overrides
+func TestLoggerLevels(t *testing.T) {
+	t.Log("Debug entries are not written on info level")
+	l, b := GetTestLogger(FORMAT_TEXT, INFO)
+	l.Debugf("hidden")
+	l.Infof("visible")
+	if strings.Contains(b.String(), "hidden") || !strings.Contains(b.String(), "visible") {
+		t.Errorf("Expected only the info entry but it was `%s` instead", b.String())
+	}
+
+	t.Log("Component level overrides the default level")
+	b.Reset()
+	l.SetComponentLevel("worker", DEBUG)
+	l.SetComponentLevel("http", ERROR)
+	l.Component("worker").Debugf("worker debug")
+	l.Component("http").Warnf("http warning")
+	l.Component("dispatcher").Debugf("dispatcher debug")
+	if out := b.String(); !strings.Contains(out, "worker debug") || strings.Contains(out, "http warning") || strings.Contains(out, "dispatcher debug") {
+		t.Errorf("Component levels are not respected: `%s`", out)
+	}
+}
+
+// Tests the text, logfmt and json formats
+func TestLoggerFormat(t *testing.T) {
+	at := time.Date(2016, 2, 5, 14, 5, 4, 0, time.UTC)
+
+	l, _ := GetTestLogger(FORMAT_TEXT, INFO)
+	l = l.Component("worker").With(Fields{"worker_id": 3, "batch_size": 10})
+	if exp := "[INFO] worker: Flushing batch_size=10 worker_id=3"; l.Format(INFO, "Flushing", at) != exp {
+		t.Errorf("Expected text entry was `%s` but it was `%s` instead", exp, l.Format(INFO, "Flushing", at))
+	}
+
+	l, _ = GetTestLogger(FORMAT_LOGFMT, INFO)
+	l = l.Component("worker").With(Fields{"worker_id": 3, "client_id": "a b"})
+	if exp := `time=2016-02-05T14:05:04Z level=warn component=worker msg="Saving is failed" client_id="a b" worker_id=3`; l.Format(WARN, "Saving is failed", at) != exp {
+		t.Errorf("Expected logfmt entry was `%s` but it was `%s` instead", exp, l.Format(WARN, "Saving is failed", at))
+	}
+
+	l, _ = GetTestLogger(FORMAT_JSON, INFO)
+	l = l.Component("http").With(Fields{"client_id": "bce44f67"})
+	var entry map[string]interface{}
+	if err := json.Unmarshal([]byte(l.Format(ERROR, "Invalid", at)), &entry); err != nil {
+		t.Errorf("JSON entry is invalid: %s", err.Error())
+	}
+	exp := map[string]interface{}{"time": "2016-02-05T14:05:04Z", "level": "error", "msg": "Invalid", "component": "http", "client_id": "bce44f67"}
+	for k, v := range exp {
+		if entry[k] != v {
+			t.Errorf("Expected JSON field %s was %v but it was %v instead", k, v, entry[k])
+		}
+	}
+}
+
+// Tests that the fields are not shared between the child loggers
+func TestLoggerWith(t *testing.T) {
+	l, _ := GetTestLogger(FORMAT_TEXT, INFO)
+	parent := l.With(Fields{"dialect": "s3"})
+	child := parent.With(Fields{"worker_id": 1})
+	if len(parent.Fields) != 1 || len(child.Fields) != 2 {
+		t.Errorf("Child logger should not modify the parent's fields")
+	}
+}
+
+// Tests the rate limited logging
+func TestLoggerRateLimit(t *testing.T) {
+	l, b := GetTestLogger(FORMAT_LOGFMT, INFO)
+	limiter := NewRateLimiter(2, 50*time.Millisecond)
+	l.SetRateLimiter(limiter)
+
+	for i := 0; i < 5; i++ {
+		l.RateLimit("10.0.0.1|invalid").Warnf("invalid signature")
+	}
+	l.RateLimit("10.0.0.2|invalid").Warnf("invalid signature")
+	if exp, n := 3, strings.Count(b.String(), "invalid signature"); n != exp {
+		t.Errorf("Expected %d entries but it was %d instead", exp, n)
+	}
+
+	t.Log("The next entry contains the number of suppressed entries")
+	time.Sleep(60 * time.Millisecond)
+	b.Reset()
+	l.RateLimit("10.0.0.1|invalid").Warnf("invalid signature")
+	if !strings.Contains(b.String(), "suppressed=3") {
+		t.Errorf("Expected suppressed count in `%s`", b.String())
+	}
+}
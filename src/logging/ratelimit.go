@@ -0,0 +1,82 @@
+package logging
+
+import (
+	"container/list"
+	"sync"
+	"time"
+)
+
+// Maximum number of keys of a rate limiter, the least recently
+// used key is forgotten beyond it
+const RATE_LIMITER_MAX_KEYS = 10000
+
+// State of a single key in the rate limiter
+type rateEntry struct {
+	Key        string
+	Start      time.Time
+	Count      int
+	Suppressed int
+}
+
+// Limits the number of entries per key within an interval
+type RateLimiter struct {
+	sync.Mutex
+	Limit    int
+	Interval time.Duration
+	MaxKeys  int
+	entries  map[string]*list.Element
+	recent   *list.List // Keys from the most recently used one
+}
+
+// Creates a new rate limiter
+func NewRateLimiter(limit int, interval time.Duration) *RateLimiter {
+	return &RateLimiter{
+		Limit:    limit,
+		Interval: interval,
+		MaxKeys:  RATE_LIMITER_MAX_KEYS,
+		entries:  map[string]*list.Element{},
+		recent:   list.New()}
+}
+
+// Returns the number of tracked keys
+func (r *RateLimiter) Len() int {
+	r.Lock()
+	defer r.Unlock()
+	return len(r.entries)
+}
+
+// Returns that the entry is allowed and the number of
+// entries that were suppressed since the last allowed one
+func (r *RateLimiter) Allow(key string) (bool, int) {
+	r.Lock()
+	defer r.Unlock()
+
+	now := time.Now()
+	element, ok := r.entries[key]
+	if !ok {
+		// Forget the least recently used keys to keep the memory bounded
+		for r.MaxKeys > 0 && len(r.entries) >= r.MaxKeys {
+			oldest := r.recent.Back()
+			r.recent.Remove(oldest)
+			delete(r.entries, oldest.Value.(*rateEntry).Key)
+		}
+		r.entries[key] = r.recent.PushFront(&rateEntry{Key: key, Start: now, Count: 1})
+		return true, 0
+	}
+	r.recent.MoveToFront(element)
+
+	entry := element.Value.(*rateEntry)
+	if now.Sub(entry.Start) >= r.Interval {
+		suppressed := entry.Suppressed
+		entry.Start, entry.Count, entry.Suppressed = now, 1, 0
+		return true, suppressed
+	}
+	if entry.Count >= r.Limit {
+		entry.Suppressed++
+		return false, 0
+	}
+	entry.Count++
+	suppressed := entry.Suppressed
+	entry.Suppressed = 0
+	return true, suppressed
+}
@@ -0,0 +1,56 @@
+package logging
+
+import (
+	"testing"
+	"time"
+)
+
+// Tests the rate limiter's counters
+func TestFunctionRateLimiterAllow(t *testing.T) {
+	limiter := NewRateLimiter(2, 50*time.Millisecond)
+
+	cases := []struct {
+		ExpectedAllowed    bool
+		ExpectedSuppressed int
+	}{
+		{true, 0},
+		{true, 0},
+		{false, 0},
+		{false, 0}}
+
+	for i, c := range cases {
+		allowed, suppressed := limiter.Allow("key")
+		if allowed != c.ExpectedAllowed || suppressed != c.ExpectedSuppressed {
+			t.Errorf("%d. call expected %t/%d but it was %t/%d instead", i+1, c.ExpectedAllowed, c.ExpectedSuppressed, allowed, suppressed)
+		}
+	}
+
+	if allowed, _ := limiter.Allow("other"); !allowed {
+		t.Errorf("Different keys should have different limits")
+	}
+
+	time.Sleep(60 * time.Millisecond)
+	if allowed, suppressed := limiter.Allow("key"); !allowed || suppressed != 2 {
+		t.Errorf("After the interval it should be allowed with 2 suppressed but it was %t/%d instead", allowed, suppressed)
+	}
+}
+
+// Tests that the least recently used keys are forgotten beyond the cap
+func TestFunctionRateLimiterMaxKeys(t *testing.T) {
+	limiter := NewRateLimiter(1, time.Minute)
+	limiter.MaxKeys = 2
+
+	limiter.Allow("first")
+	limiter.Allow("second")
+	limiter.Allow("first") // The second key is the least recently used
+	limiter.Allow("third")
+	if exp := 2; limiter.Len() != exp {
+		t.Errorf("Expected number of keys was %d but it was %d instead", exp, limiter.Len())
+	}
+	if allowed, _ := limiter.Allow("first"); allowed {
+		t.Errorf("Recently used key should be kept and limited")
+	}
+	if allowed, _ := limiter.Allow("second"); !allowed {
+		t.Errorf("Least recently used key should be forgotten")
+	}
+}
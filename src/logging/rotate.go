@@ -0,0 +1,89 @@
+package logging
+
+import (
+	"fmt"
+	"os"
+	"sync"
+)
+
+// File writer that rotates the file when it reaches the maximum size,
+// the rotated files are named as `filename.1`, `filename.2`, ...
+type RotatingFile struct {
+	sync.Mutex
+	Filename   string
+	MaxSize    int64
+	MaxBackups int
+	file       *os.File
+	size       int64
+}
+
+// Creates a new rotating file and opens it for appending
+func NewRotatingFile(filename string, maxSize int64, maxBackups int) (*RotatingFile, error) {
+	f := &RotatingFile{Filename: filename, MaxSize: maxSize, MaxBackups: maxBackups}
+	if err := f.open(); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+// Opens the file and reads its current size
+func (f *RotatingFile) open() error {
+	file, err := os.OpenFile(f.Filename, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+	f.file = file
+	f.size = info.Size()
+	return nil
+}
+
+// Returns the name of the nth backup
+func (f *RotatingFile) backupName(n int) string {
+	return fmt.Sprintf("%s.%d", f.Filename, n)
+}
+
+// Closes the current file, shifts the backups and opens a new file
+func (f *RotatingFile) rotate() error {
+	if err := f.file.Close(); err != nil {
+		return err
+	}
+	if f.MaxBackups > 0 {
+		os.Remove(f.backupName(f.MaxBackups))
+		for n := f.MaxBackups - 1; n > 0; n-- {
+			os.Rename(f.backupName(n), f.backupName(n+1))
+		}
+		if err := os.Rename(f.Filename, f.backupName(1)); err != nil {
+			return err
+		}
+	} else if err := os.Remove(f.Filename); err != nil {
+		return err
+	}
+	return f.open()
+}
+
+// Writes into the file, rotates it before the write if it would exceed
+// the maximum size
+func (f *RotatingFile) Write(p []byte) (int, error) {
+	f.Lock()
+	defer f.Unlock()
+	if f.MaxSize > 0 && f.size > 0 && f.size+int64(len(p)) > f.MaxSize {
+		if err := f.rotate(); err != nil {
+			return 0, err
+		}
+	}
+	n, err := f.file.Write(p)
+	f.size += int64(n)
+	return n, err
+}
+
+// Closes the file
+func (f *RotatingFile) Close() error {
+	f.Lock()
+	defer f.Unlock()
+	return f.file.Close()
+}
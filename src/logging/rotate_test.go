@@ -0,0 +1,47 @@
+package logging
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// Tests the size based rotation
+func TestRotatingFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "hamustro-rotate")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	filename := filepath.Join(dir, "access.log")
+	f, err := NewRotatingFile(filename, 10, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, line := range []string{"aaaaaaaa\n", "bbbbbbbb\n", "cccccccc\n", "dddddddd\n"} {
+		if _, err := f.Write([]byte(line)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	f.Close()
+
+	cases := []struct {
+		Filename string
+		Expected string
+	}{
+		{filename, "dddddddd\n"},
+		{filename + ".1", "cccccccc\n"},
+		{filename + ".2", "bbbbbbbb\n"}}
+
+	for _, c := range cases {
+		content, _ := ioutil.ReadFile(c.Filename)
+		if string(content) != c.Expected {
+			t.Errorf("Expected content of %s was %q but it was %q instead", c.Filename, c.Expected, content)
+		}
+	}
+	if _, err := os.Stat(filename + ".3"); !os.IsNotExist(err) {
+		t.Errorf("Only 2 backups should be kept")
+	}
+}
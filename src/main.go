@@ -1,31 +1,28 @@
 package main
 
 import (
+	"context"
 	"flag"
 	"fmt"
-	"github.com/wunderlist/hamustro/src/dialects"
+	"github.com/wunderlist/hamustro/src/collector"
+	"github.com/wunderlist/hamustro/src/logging"
 	"log"
 	"net/http"
+	_ "net/http/pprof"
 	"os"
 	"os/signal"
 	"syscall"
 	"time"
 )
 
-var config *Config
-var jobQueue chan Job
-var storageClient dialects.StorageClient
-var verbose bool
-var isTerminating = false
-var signatureRequired bool
-var dispatcher *Dispatcher
 var Version string = "1.0" // Current version
 
 // Runs before the program starts
 func main() {
 	// Parse the CLI's attributes
 	var filename = flag.String("config", "", "configuration `file` for the dialect")
-	flag.BoolVar(&verbose, "verbose", false, "verbose mode for debugging")
+	var verbose = flag.Bool("verbose", false, "verbose mode for debugging")
+	var redrivePath = flag.String("redrive", "", "re-drives the dead-letter `path` (file or directory) and exits")
 	flag.Parse()
 
 	if *filename == "" {
@@ -37,48 +34,66 @@ func main() {
 	log.SetPrefix(fmt.Sprintf("hamustro-%s ", Version))
 
 	// Read and parse the configuration file
-	config = NewConfig(*filename)
+	config := collector.NewConfig(*filename)
 	if !config.IsValid() {
-		log.Fatalf("Config is incomplete, please define `dialect` and `shared_secret` property")
+		log.Fatalf("Config is incomplete, please define `dialect` (or `sinks`) and `shared_secret` property")
 	}
 
-	// Set the signatureRequired variable
-	signatureRequired = config.IsSignatureRequired()
-
-	dialect, err := config.DialectConfig()
+	// Creates the leveled logger
+	logger, err := collector.NewLogger(config, *verbose)
 	if err != nil {
-		log.Fatalf("Loading dialect configuration is failed: %s", err.Error())
+		log.Fatalf("Logger initialization is failed: %s", err.Error())
 	}
-	if !dialect.IsValid() {
-		log.Fatalf("Dialect configuration is incorrect or incomplete")
+	if config.GetLogFormat() != logging.FORMAT_TEXT {
+		// Structured entries contain their own timestamp
+		log.SetPrefix("")
+		log.SetFlags(0)
 	}
 
-	// Construct the dialect's client
-	storageClient, err = dialect.NewClient()
-	if err != nil {
-		log.Fatalf("Client initialization is failed: %s", err.Error())
+	options := &collector.Options{Config: config, ConfigFile: *filename, Logger: logger, Verbose: *verbose}
+
+	// Set the audit log's output for the admin actions
+	if config.AuditLogFile != "" {
+		auditFile, err := os.OpenFile(config.AuditLogFile, os.O_CREATE|os.O_RDWR|os.O_APPEND, 0600)
+		if err != nil {
+			log.Fatalf("Can't open audit logfile %s", err.Error())
+		}
+		defer auditFile.Close()
+		options.AuditLogger = log.New(auditFile, fmt.Sprintf("hamustro-%s audit ", Version), log.LstdFlags)
 	}
 
-	// Creates a worker options
-	options := &WorkerOptions{
-		BufferSize:   config.GetBufferSize(),
-		RetryAttempt: config.GetRetryAttempt(),
-		SpreadBuffer: config.IsSpreadBuffer()}
+	// Set the access log's output with size based rotation
+	if config.AccessLogFile != "" {
+		if !collector.IsValidAccessLogFormat(config.GetAccessLogFormat()) {
+			log.Fatalf("Not supported `%s` access log format (use `combined` or `json`)", config.GetAccessLogFormat())
+		}
+		accessFile, err := logging.NewRotatingFile(config.AccessLogFile, int64(config.GetAccessLogMaxSize())*1024*1024, config.GetAccessLogMaxBackups())
+		if err != nil {
+			log.Fatalf("Can't open access logfile %s", err.Error())
+		}
+		defer accessFile.Close()
+		options.AccessLogger = collector.NewAccessLogger(accessFile, config.GetAccessLogFormat(), config.GetAccessLogSample())
+	}
 
-	// Create the background workers
-	jobQueue = make(chan Job, config.GetMaxQueueSize())
-	dispatcher = NewDispatcher(config.GetMaxWorkerSize(), options)
-	dispatcher.Run()
+	// Creates the collector with the sinks, then starts the workers
+	c, err := collector.New(options)
+	if err != nil {
+		log.Fatalf("%s", err.Error())
+	}
+	if err := c.Start(); err != nil {
+		log.Fatalf("%s", err.Error())
+	}
 
-	// Capture SIGINT and SIGTERM events to finish the ongoing work
-	signalChannel := make(chan os.Signal, 1)
-	signal.Notify(signalChannel, os.Interrupt)
-	signal.Notify(signalChannel, syscall.SIGTERM)
-	go func() {
-		<-signalChannel
-		cleanup()
-		os.Exit(1)
-	}()
+	// Publishes the dead-letter records again and waits until they are saved
+	if *redrivePath != "" {
+		n, err := c.Redrive(*redrivePath)
+		shutdown(nil, c, config)
+		if err != nil {
+			log.Fatalf("Re-driving dead-letter is failed after %d events: %s", n, err.Error())
+		}
+		logger.Infof("Re-driven %d events from %s", n, *redrivePath)
+		os.Exit(0)
+	}
 
 	// Set the log's output
 	if config.LogFile != "" {
@@ -90,30 +105,61 @@ func main() {
 		log.SetOutput(logFile)
 	}
 
-	// Start the server
-	log.Printf("Starting server at %s", config.GetAddress())
-	http.HandleFunc("/api/v1/track", TrackHandler)
-	http.HandleFunc("/api/health", HealthHandler)
-	http.HandleFunc("/api/flush", FlushHandler)
-	if err := http.ListenAndServe(config.GetAddress(), nil); err != nil {
-		log.Fatal(err)
+	// Start the server, the profiler stays on the default mux
+	logger.Infof("Starting server at %s", config.GetAddress())
+	http.Handle("/api/", c.Handler())
+	server := &http.Server{Addr: config.GetAddress()}
+	go func() {
+		if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			log.Fatal(err)
+		}
+	}()
+
+	// Reload the configuration file on SIGHUP
+	reloadChannel := make(chan os.Signal, 1)
+	signal.Notify(reloadChannel, syscall.SIGHUP)
+	go func() {
+		for range reloadChannel {
+			c.ReloadFile(*filename)
+		}
+	}()
+
+	// Capture SIGINT and SIGTERM events to finish the ongoing work
+	signalChannel := make(chan os.Signal, 1)
+	signal.Notify(signalChannel, os.Interrupt)
+	signal.Notify(signalChannel, syscall.SIGTERM)
+	<-signalChannel
+	if !shutdown(server, c, config) {
+		os.Exit(1)
 	}
 }
 
-// Runs after the server was shut down
-func cleanup() {
+// Stops the server in order within the configured deadline: the new
+// connections are refused, the running requests are finished, then the
+// collector is drained, returns false if some events are not saved
+func shutdown(server *http.Server, c *collector.Collector, config *collector.Config) bool {
 	// Do not accept new requests
-	isTerminating = true
-	log.Println("Shutting down server ...")
-
-	// Set a timeout interval to force stop (avoid hanging out)
-	go func() {
-		c := time.Tick(90 * time.Second)
-		for range c {
-			log.Fatal("Server shut down is taking too long, force quit immediately.")
+	c.SetTerminating(true)
+	c.GetLogger().Infof("Shutting down server ...")
+
+	// Set a deadline to force stop (avoid hanging out)
+	timeout := time.Duration(config.GetShutdownTimeout()) * time.Second
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	// Wait for the running requests, they may enqueue events
+	if server != nil {
+		if err := server.Shutdown(ctx); err != nil {
+			c.GetLogger().Errorf("Closing the HTTP server is failed: %s", err.Error())
 		}
-	}()
+	}
 
-	// Try to stop every worker
-	dispatcher.Stop()
+	if err := c.Shutdown(ctx); err != nil {
+		if err == context.DeadlineExceeded {
+			c.GetLogger().Errorf("Server shut down is taking longer than %s, force quit immediately.", timeout)
+		}
+		return false
+	}
+	c.GetLogger().Infof("Server is stopped, every event is saved")
+	return true
 }
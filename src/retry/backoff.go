@@ -0,0 +1,29 @@
+package retry
+
+import (
+	"math/rand"
+	"time"
+)
+
+// Exponential backoff with jitter
+type Backoff struct {
+	Min time.Duration
+	Max time.Duration
+}
+
+// Returns the waiting time before the given retry (starts from 1), it's
+// doubled after every attempt up to the maximum and the second half of
+// it is randomized to spread the retries
+func (b *Backoff) Duration(attempt int) time.Duration {
+	d := b.Min
+	for i := 1; i < attempt && d < b.Max; i++ {
+		d *= 2
+	}
+	if d > b.Max {
+		d = b.Max
+	}
+	if half := int64(d / 2); half > 0 {
+		return time.Duration(half + rand.Int63n(half+1))
+	}
+	return d
+}
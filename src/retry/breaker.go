@@ -0,0 +1,80 @@
+package retry
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+// Define the states of the circuit breaker
+const STATE_CLOSED = "closed"       // Requests are allowed
+const STATE_OPEN = "open"           // Requests are rejected
+const STATE_HALF_OPEN = "half_open" // A single request probes the recovery
+
+// Returned instead of calling a storage while the circuit is open
+var ErrOpen = errors.New("Circuit breaker is open")
+
+// Circuit breaker that opens after consecutive failures and
+// half-opens after the cooldown to probe the recovery
+type Breaker struct {
+	sync.Mutex
+	Threshold int
+	Cooldown  time.Duration
+	state     string
+	failures  int
+	openedAt  time.Time
+}
+
+// Creates a new closed circuit breaker
+func NewBreaker(threshold int, cooldown time.Duration) *Breaker {
+	return &Breaker{Threshold: threshold, Cooldown: cooldown, state: STATE_CLOSED}
+}
+
+// Returns true if the request is allowed, only a single
+// probe is allowed after the cooldown
+func (b *Breaker) Allow() bool {
+	if b == nil {
+		return true
+	}
+	b.Lock()
+	defer b.Unlock()
+	if b.state == STATE_CLOSED {
+		return true
+	}
+	// Another probe is allowed if the previous one didn't finish in time
+	if time.Since(b.openedAt) < b.Cooldown {
+		return false
+	}
+	b.state = STATE_HALF_OPEN
+	b.openedAt = time.Now()
+	return true
+}
+
+// Records the result of an allowed request
+func (b *Breaker) Record(err error) {
+	if b == nil {
+		return
+	}
+	b.Lock()
+	defer b.Unlock()
+	if err == nil {
+		b.state = STATE_CLOSED
+		b.failures = 0
+		return
+	}
+	b.failures++
+	if b.state == STATE_HALF_OPEN || b.failures >= b.Threshold {
+		b.state = STATE_OPEN
+		b.openedAt = time.Now()
+	}
+}
+
+// Returns the current state
+func (b *Breaker) GetState() string {
+	if b == nil {
+		return STATE_CLOSED
+	}
+	b.Lock()
+	defer b.Unlock()
+	return b.state
+}
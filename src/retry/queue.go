@@ -0,0 +1,151 @@
+package retry
+
+import (
+	"container/heap"
+	"sync"
+	"time"
+)
+
+// A scheduled item
+type item struct {
+	Value interface{}
+	At    time.Time
+}
+
+// Min-heap of the scheduled items by their time
+type items []*item
+
+func (h items) Len() int            { return len(h) }
+func (h items) Less(i, j int) bool  { return h[i].At.Before(h[j].At) }
+func (h items) Swap(i, j int)       { h[i], h[j] = h[j], h[i] }
+func (h *items) Push(x interface{}) { *h = append(*h, x.(*item)) }
+func (h *items) Pop() interface{} {
+	old := *h
+	n := len(old)
+	x := old[n-1]
+	*h = old[:n-1]
+	return x
+}
+
+// Bounded queue that releases the items at their scheduled time
+type Queue struct {
+	sync.Mutex
+	Size    int
+	items   items
+	stopped bool
+	wake    chan struct{}
+	quit    chan struct{}
+	done    chan struct{}
+}
+
+// Creates a new queue with the maximum number of items
+func NewQueue(size int) *Queue {
+	return &Queue{
+		Size: size,
+		wake: make(chan struct{}, 1),
+		quit: make(chan struct{}),
+		done: make(chan struct{})}
+}
+
+// Schedules the item, returns false if the queue is full or stopped
+func (q *Queue) Schedule(value interface{}, at time.Time) bool {
+	q.Lock()
+	if q.stopped || (q.Size > 0 && len(q.items) >= q.Size) {
+		q.Unlock()
+		return false
+	}
+	heap.Push(&q.items, &item{value, at})
+	q.Unlock()
+
+	select {
+	case q.wake <- struct{}{}:
+	default:
+	}
+	return true
+}
+
+// Returns the number of the scheduled items
+func (q *Queue) Len() int {
+	if q == nil {
+		return 0
+	}
+	q.Lock()
+	defer q.Unlock()
+	return len(q.items)
+}
+
+// Removes the items that are due, returns the waiting time until the next one
+func (q *Queue) popDue(now time.Time) ([]interface{}, time.Duration) {
+	q.Lock()
+	defer q.Unlock()
+	due := []interface{}{}
+	for len(q.items) != 0 && !q.items[0].At.After(now) {
+		due = append(due, heap.Pop(&q.items).(*item).Value)
+	}
+	if len(q.items) == 0 {
+		return due, time.Hour
+	}
+	return due, q.items[0].At.Sub(now)
+}
+
+// Releases the due items in the background, an item is scheduled again
+// after the delay if the release function couldn't deliver it
+func (q *Queue) Run(release func(interface{}) bool, delay time.Duration) {
+	go func() {
+		defer close(q.done)
+		for {
+			due, wait := q.popDue(time.Now())
+			for _, value := range due {
+				if !release(value) {
+					q.Lock()
+					heap.Push(&q.items, &item{value, time.Now().Add(delay)})
+					q.Unlock()
+				}
+			}
+			if len(due) != 0 {
+				continue
+			}
+			timer := time.NewTimer(wait)
+			select {
+			case <-timer.C:
+			case <-q.wake:
+				timer.Stop()
+			case <-q.quit:
+				timer.Stop()
+				return
+			}
+		}
+	}()
+}
+
+// Is the queue stopped
+func (q *Queue) IsStopped() bool {
+	q.Lock()
+	defer q.Unlock()
+	return q.stopped
+}
+
+// Stops the background releases and returns the remaining items,
+// the queue doesn't accept new items after it
+func (q *Queue) Stop() []interface{} {
+	if q == nil {
+		return []interface{}{}
+	}
+	q.Lock()
+	if q.stopped {
+		q.Unlock()
+		return []interface{}{}
+	}
+	q.stopped = true
+	q.Unlock()
+
+	close(q.quit)
+	<-q.done
+	q.Lock()
+	defer q.Unlock()
+	remaining := []interface{}{}
+	for len(q.items) != 0 {
+		remaining = append(remaining, heap.Pop(&q.items).(*item).Value)
+	}
+	return remaining
+}
@@ -0,0 +1,129 @@
+package retry
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+// Tests that the backoff is doubled up to the maximum with jitter
+func TestBackoffDuration(t *testing.T) {
+	b := &Backoff{Min: 100 * time.Millisecond, Max: time.Second}
+	cases := []struct {
+		Attempt int
+		Max     time.Duration
+	}{
+		{1, 100 * time.Millisecond},
+		{2, 200 * time.Millisecond},
+		{3, 400 * time.Millisecond},
+		{5, time.Second},
+		{50, time.Second}}
+
+	for _, c := range cases {
+		for i := 0; i < 20; i++ {
+			if d := b.Duration(c.Attempt); d < c.Max/2 || d > c.Max {
+				t.Errorf("Expected backoff of the %d. attempt was between %s and %s but it was %s instead", c.Attempt, c.Max/2, c.Max, d)
+			}
+		}
+	}
+}
+
+// Tests that the items are released in their scheduled order
+func TestQueueSchedule(t *testing.T) {
+	q := NewQueue(3)
+	released := make(chan interface{}, 3)
+	q.Run(func(value interface{}) bool {
+		released <- value
+		return true
+	}, time.Millisecond)
+
+	now := time.Now()
+	q.Schedule("third", now.Add(30*time.Millisecond))
+	q.Schedule("first", now)
+	q.Schedule("second", now.Add(10*time.Millisecond))
+	if q.Schedule("fourth", now) {
+		t.Errorf("Full queue should not accept more items")
+	}
+
+	for _, exp := range []string{"first", "second", "third"} {
+		select {
+		case value := <-released:
+			if value.(string) != exp {
+				t.Errorf("Expected released item was %s but it was %s instead", exp, value)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("Item %s was not released", exp)
+		}
+	}
+	if remaining := q.Stop(); len(remaining) != 0 {
+		t.Errorf("Expected number of remaining items was %d but it was %d instead", 0, len(remaining))
+	}
+}
+
+// Tests that the undelivered items are kept and returned on stop
+func TestQueueRedelivery(t *testing.T) {
+	q := NewQueue(0)
+	attempts := make(chan struct{}, 10)
+	q.Run(func(value interface{}) bool {
+		attempts <- struct{}{}
+		return false
+	}, 5*time.Millisecond)
+	q.Schedule("undelivered", time.Now())
+	q.Schedule("later", time.Now().Add(time.Hour))
+
+	<-attempts
+	<-attempts
+	if remaining := q.Stop(); len(remaining) != 2 {
+		t.Errorf("Expected number of remaining items was %d but it was %d instead", 2, len(remaining))
+	}
+
+	t.Log("Stopped queue doesn't accept new items")
+	if q.Schedule("stopped", time.Now()) || !q.IsStopped() {
+		t.Errorf("Stopped queue should not accept more items")
+	}
+	if remaining := q.Stop(); len(remaining) != 0 {
+		t.Errorf("Expected number of remaining items was %d but it was %d instead", 0, len(remaining))
+	}
+}
+
+// Tests the closed, open and half-open states of the breaker
+func TestBreakerStates(t *testing.T) {
+	b := NewBreaker(2, 20*time.Millisecond)
+	failure := errors.New("Unavailable")
+
+	t.Log("Opening the circuit after 2 consecutive failures")
+	b.Record(failure)
+	if !b.Allow() || b.GetState() != STATE_CLOSED {
+		t.Errorf("Breaker should be closed after a single failure")
+	}
+	b.Record(failure)
+	if b.Allow() || b.GetState() != STATE_OPEN {
+		t.Errorf("Breaker should be open after 2 failures but it was %s", b.GetState())
+	}
+
+	t.Log("Probing once after the cooldown and opening again on failure")
+	time.Sleep(25 * time.Millisecond)
+	if !b.Allow() || b.GetState() != STATE_HALF_OPEN {
+		t.Errorf("Breaker should allow a probe after the cooldown but it was %s", b.GetState())
+	}
+	if b.Allow() {
+		t.Errorf("Breaker should allow only a single probe")
+	}
+	b.Record(failure)
+	if b.GetState() != STATE_OPEN {
+		t.Errorf("Failed probe should open the breaker but it was %s", b.GetState())
+	}
+
+	t.Log("Closing the circuit after a successful probe")
+	time.Sleep(25 * time.Millisecond)
+	b.Allow()
+	b.Record(nil)
+	if !b.Allow() || b.GetState() != STATE_CLOSED {
+		t.Errorf("Successful probe should close the breaker but it was %s", b.GetState())
+	}
+
+	var disabled *Breaker
+	if !disabled.Allow() || disabled.GetState() != STATE_CLOSED {
+		t.Errorf("Missing breaker should allow every request")
+	}
+}
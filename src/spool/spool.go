@@ -0,0 +1,215 @@
+package spool
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// A spooled batch on the disk
+type File struct {
+	Path    string
+	Size    int64
+	ModTime time.Time
+}
+
+// Sortable list of files, the oldest is the first
+type files []*File
+
+func (f files) Len() int           { return len(f) }
+func (f files) Less(i, j int) bool { return f[i].Path < f[j].Path }
+func (f files) Swap(i, j int)      { f[i], f[j] = f[j], f[i] }
+
+// Current state of the spool
+type Stats struct {
+	Files     int   `json:"files"`
+	Bytes     int64 `json:"bytes"`
+	OldestAge int64 `json:"oldest_age"` // in seconds
+	Dropped   int64 `json:"dropped"`
+	Expired   int64 `json:"expired"`
+	Uploaded  int64 `json:"uploaded"`
+}
+
+// Local directory of the batches that failed to upload, the oldest
+// batches are dropped if the spool exceeds its maximum size or age
+type Spool struct {
+	sync.Mutex
+	Dir      string
+	MaxSize  int64
+	MaxAge   time.Duration
+	OnDrop   func(data []byte) // Called with the dropped and expired batches (optional)
+	dropped  int64
+	expired  int64
+	uploaded int64
+	sequence uint64
+	quit     chan struct{}
+}
+
+// Creates the spool directory
+func New(dir string, maxSize int64, maxAge time.Duration) (*Spool, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+	return &Spool{Dir: dir, MaxSize: maxSize, MaxAge: maxAge, quit: make(chan struct{})}, nil
+}
+
+// Returns the spooled batches, the oldest is the first
+func (s *Spool) Files() ([]*File, error) {
+	infos, err := ioutil.ReadDir(s.Dir)
+	if err != nil {
+		return nil, err
+	}
+	result := files{}
+	for _, info := range infos {
+		if !strings.HasSuffix(info.Name(), ".spool") {
+			continue
+		}
+		result = append(result, &File{filepath.Join(s.Dir, info.Name()), info.Size(), info.ModTime()})
+	}
+	sort.Sort(result)
+	return result, nil
+}
+
+// Writes a batch into the spool, drops the oldest batches if the
+// spool would exceed its maximum size
+func (s *Spool) Write(data []byte) error {
+	s.Lock()
+	defer s.Unlock()
+
+	if s.MaxSize > 0 && int64(len(data)) > s.MaxSize {
+		atomic.AddInt64(&s.dropped, 1)
+		return fmt.Errorf("Batch with %d bytes is larger than the spool's maximum size", len(data))
+	}
+	existing, err := s.Files()
+	if err != nil {
+		return err
+	}
+	var size int64
+	for _, f := range existing {
+		size += f.Size
+	}
+	for len(existing) != 0 && s.MaxSize > 0 && size+int64(len(data)) > s.MaxSize {
+		if s.remove(existing[0].Path) {
+			atomic.AddInt64(&s.dropped, 1)
+		}
+		size -= existing[0].Size
+		existing = existing[1:]
+	}
+
+	s.sequence++
+	name := fmt.Sprintf("%020d-%06d", time.Now().UnixNano(), s.sequence%1000000)
+	tmp := filepath.Join(s.Dir, name+".tmp")
+	if err := ioutil.WriteFile(tmp, data, 0600); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return os.Rename(tmp, filepath.Join(s.Dir, name+".spool"))
+}
+
+// Removes a dropped or expired batch, its content is passed
+// to the OnDrop callback
+func (s *Spool) remove(path string) bool {
+	var data []byte
+	if s.OnDrop != nil {
+		data, _ = ioutil.ReadFile(path)
+	}
+	if err := os.Remove(path); err != nil {
+		return false
+	}
+	if s.OnDrop != nil {
+		s.OnDrop(data)
+	}
+	return true
+}
+
+// Uploads the spooled batches from the oldest one, stops at the first
+// failure. The expired batches are removed without uploading.
+func (s *Spool) Upload(save func([]byte) error) (int, error) {
+	s.Lock()
+	defer s.Unlock()
+
+	existing, err := s.Files()
+	if err != nil {
+		return 0, err
+	}
+	uploaded := 0
+	for _, f := range existing {
+		if s.MaxAge > 0 && time.Since(f.ModTime) > s.MaxAge {
+			if s.remove(f.Path) {
+				atomic.AddInt64(&s.expired, 1)
+			}
+			continue
+		}
+		data, err := ioutil.ReadFile(f.Path)
+		if err != nil {
+			return uploaded, err
+		}
+		if err := save(data); err != nil {
+			return uploaded, err
+		}
+		if err := os.Remove(f.Path); err != nil {
+			return uploaded, err
+		}
+		atomic.AddInt64(&s.uploaded, 1)
+		uploaded++
+	}
+	return uploaded, nil
+}
+
+// Uploads the spooled batches in the background, the interval is
+// doubled after every failure up to the maximum backoff
+func (s *Spool) Run(save func([]byte) error, interval time.Duration, maxBackoff time.Duration, onError func(error)) {
+	go func() {
+		wait := interval
+		for {
+			select {
+			case <-time.After(wait):
+			case <-s.quit:
+				return
+			}
+			if _, err := s.Upload(save); err != nil {
+				if onError != nil {
+					onError(err)
+				}
+				if wait *= 2; wait > maxBackoff {
+					wait = maxBackoff
+				}
+				continue
+			}
+			wait = interval
+		}
+	}()
+}
+
+// Stops the background uploads
+func (s *Spool) Stop() {
+	if s != nil {
+		close(s.quit)
+	}
+}
+
+// Returns the current state of the spool
+func (s *Spool) GetStats() *Stats {
+	stats := &Stats{
+		Dropped:  atomic.LoadInt64(&s.dropped),
+		Expired:  atomic.LoadInt64(&s.expired),
+		Uploaded: atomic.LoadInt64(&s.uploaded)}
+	existing, err := s.Files()
+	if err != nil {
+		return stats
+	}
+	stats.Files = len(existing)
+	for _, f := range existing {
+		stats.Bytes += f.Size
+	}
+	if len(existing) != 0 {
+		stats.OldestAge = int64(time.Since(existing[0].ModTime).Seconds())
+	}
+	return stats
+}
@@ -0,0 +1,138 @@
+package spool
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+)
+
+// Returns a temporary spool
+func GetTestSpool(t *testing.T, maxSize int64, maxAge time.Duration) *Spool {
+	dir, err := ioutil.TempDir("", "hamustro-spool")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, err := New(dir, maxSize, maxAge)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+// Tests that the oldest batches are dropped beyond the maximum size
+func TestSpoolMaxSize(t *testing.T) {
+	s := GetTestSpool(t, 10, 0)
+	defer os.RemoveAll(s.Dir)
+	dropped := []string{}
+	s.OnDrop = func(data []byte) { dropped = append(dropped, string(data)) }
+
+	t.Log("Writing three batches into a spool that fits only two")
+	for _, data := range []string{"first", "secnd", "third"} {
+		if err := s.Write([]byte(data)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	stats := s.GetStats()
+	if exp := 2; stats.Files != exp {
+		t.Errorf("Expected number of files was %d but it was %d instead", exp, stats.Files)
+	}
+	if exp := int64(10); stats.Bytes != exp {
+		t.Errorf("Expected size was %d but it was %d instead", exp, stats.Bytes)
+	}
+	if exp := int64(1); stats.Dropped != exp {
+		t.Errorf("Expected number of dropped batches was %d but it was %d instead", exp, stats.Dropped)
+	}
+	if len(dropped) != 1 || dropped[0] != "first" {
+		t.Errorf("Oldest batch should be passed to the callback but it was %v", dropped)
+	}
+
+	t.Log("Batch larger than the spool is rejected")
+	if err := s.Write([]byte("larger than ten")); err == nil {
+		t.Errorf("Writing a batch larger than the spool should fail")
+	}
+}
+
+// Tests that the batches are uploaded in order and the upload stops at the first failure
+func TestSpoolUpload(t *testing.T) {
+	s := GetTestSpool(t, 0, 0)
+	defer os.RemoveAll(s.Dir)
+	for _, data := range []string{"first", "second", "third"} {
+		if err := s.Write([]byte(data)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	t.Log("Failing at the second batch")
+	saved := []string{}
+	n, err := s.Upload(func(data []byte) error {
+		if string(data) == "second" {
+			return errors.New("Unavailable")
+		}
+		saved = append(saved, string(data))
+		return nil
+	})
+	if err == nil {
+		t.Errorf("Upload should return the storage's error")
+	}
+	if exp := 1; n != exp {
+		t.Errorf("Expected number of uploaded batches was %d but it was %d instead", exp, n)
+	}
+	if exp := 2; s.GetStats().Files != exp {
+		t.Errorf("Expected number of files was %d but it was %d instead", exp, s.GetStats().Files)
+	}
+
+	t.Log("Uploading the rest after the storage recovered")
+	n, err = s.Upload(func(data []byte) error {
+		saved = append(saved, string(data))
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if exp := 2; n != exp {
+		t.Errorf("Expected number of uploaded batches was %d but it was %d instead", exp, n)
+	}
+	for i, exp := range []string{"first", "second", "third"} {
+		if saved[i] != exp {
+			t.Errorf("Expected %d. batch was %s but it was %s instead", i, exp, saved[i])
+		}
+	}
+	if stats := s.GetStats(); stats.Files != 0 || stats.Uploaded != 3 {
+		t.Errorf("Spool should be empty with 3 uploaded batches but it was %d files and %d uploads", stats.Files, stats.Uploaded)
+	}
+}
+
+// Tests that the expired batches are removed without uploading
+func TestSpoolMaxAge(t *testing.T) {
+	s := GetTestSpool(t, 0, time.Hour)
+	defer os.RemoveAll(s.Dir)
+	expired := ""
+	s.OnDrop = func(data []byte) { expired = string(data) }
+	if err := s.Write([]byte("expired")); err != nil {
+		t.Fatal(err)
+	}
+	files, _ := s.Files()
+	old := time.Now().Add(-2 * time.Hour)
+	if err := os.Chtimes(files[0].Path, old, old); err != nil {
+		t.Fatal(err)
+	}
+	if age := s.GetStats().OldestAge; age < 7200 {
+		t.Errorf("Oldest batch should be at least 7200 seconds old but it was %d", age)
+	}
+
+	n, err := s.Upload(func(data []byte) error {
+		t.Errorf("Expired batch should not be uploaded")
+		return nil
+	})
+	if err != nil || n != 0 {
+		t.Errorf("Nothing should be uploaded but it was %d batches (%v)", n, err)
+	}
+	if stats := s.GetStats(); stats.Files != 0 || stats.Expired != 1 {
+		t.Errorf("Spool should be empty with 1 expired batch but it was %d files and %d expired", stats.Files, stats.Expired)
+	}
+	if expired != "expired" {
+		t.Errorf("Expired batch should be passed to the callback but it was %q", expired)
+	}
+}
@@ -0,0 +1,125 @@
+package tracing
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+)
+
+// OTLP status codes
+const STATUS_OK = 1
+const STATUS_ERROR = 2
+
+// OTLP/JSON structures of the `ExportTraceServiceRequest`
+type otlpRequest struct {
+	ResourceSpans []otlpResourceSpans `json:"resourceSpans"`
+}
+
+type otlpResourceSpans struct {
+	Resource   otlpResource     `json:"resource"`
+	ScopeSpans []otlpScopeSpans `json:"scopeSpans"`
+}
+
+type otlpResource struct {
+	Attributes []otlpAttribute `json:"attributes"`
+}
+
+type otlpScopeSpans struct {
+	Scope otlpScope  `json:"scope"`
+	Spans []otlpSpan `json:"spans"`
+}
+
+type otlpScope struct {
+	Name string `json:"name"`
+}
+
+type otlpSpan struct {
+	TraceID           string          `json:"traceId"`
+	SpanID            string          `json:"spanId"`
+	ParentSpanID      string          `json:"parentSpanId,omitempty"`
+	Name              string          `json:"name"`
+	Kind              int             `json:"kind"`
+	StartTimeUnixNano string          `json:"startTimeUnixNano"`
+	EndTimeUnixNano   string          `json:"endTimeUnixNano"`
+	Attributes        []otlpAttribute `json:"attributes,omitempty"`
+	Links             []otlpLink      `json:"links,omitempty"`
+	Status            otlpStatus      `json:"status"`
+}
+
+type otlpLink struct {
+	TraceID string `json:"traceId"`
+	SpanID  string `json:"spanId"`
+}
+
+type otlpStatus struct {
+	Code    int    `json:"code"`
+	Message string `json:"message,omitempty"`
+}
+
+type otlpAttribute struct {
+	Key   string                 `json:"key"`
+	Value map[string]interface{} `json:"value"`
+}
+
+// Converts an attribute into OTLP's any value
+func otlpValue(v interface{}) map[string]interface{} {
+	switch value := v.(type) {
+	case bool:
+		return map[string]interface{}{"boolValue": value}
+	case int:
+		return map[string]interface{}{"intValue": strconv.FormatInt(int64(value), 10)}
+	case int64:
+		return map[string]interface{}{"intValue": strconv.FormatInt(value, 10)}
+	case float64:
+		return map[string]interface{}{"doubleValue": value}
+	case string:
+		return map[string]interface{}{"stringValue": value}
+	}
+	return map[string]interface{}{"stringValue": fmt.Sprint(v)}
+}
+
+// Converts the attributes in a stable order
+func otlpAttributes(attributes map[string]interface{}) []otlpAttribute {
+	keys := make([]string, 0, len(attributes))
+	for k := range attributes {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	result := make([]otlpAttribute, 0, len(keys))
+	for _, k := range keys {
+		result = append(result, otlpAttribute{k, otlpValue(attributes[k])})
+	}
+	return result
+}
+
+// Encodes the spans into an OTLP/JSON export request
+func EncodeOTLP(serviceName string, spans []*Span) ([]byte, error) {
+	encoded := make([]otlpSpan, 0, len(spans))
+	for _, s := range spans {
+		s.Lock()
+		span := otlpSpan{
+			TraceID:           s.Context.TraceIDString(),
+			SpanID:            s.Context.SpanIDString(),
+			Name:              s.Name,
+			Kind:              s.Kind,
+			StartTimeUnixNano: strconv.FormatInt(s.Start.UnixNano(), 10),
+			EndTimeUnixNano:   strconv.FormatInt(s.End.UnixNano(), 10),
+			Attributes:        otlpAttributes(s.Attributes),
+			Status:            otlpStatus{Code: STATUS_OK}}
+		if s.Parent.IsValid() {
+			span.ParentSpanID = s.Parent.SpanIDString()
+		}
+		for _, l := range s.Links {
+			span.Links = append(span.Links, otlpLink{l.TraceIDString(), l.SpanIDString()})
+		}
+		if s.Error != nil {
+			span.Status = otlpStatus{STATUS_ERROR, s.Error.Error()}
+		}
+		s.Unlock()
+		encoded = append(encoded, span)
+	}
+	return json.Marshal(&otlpRequest{[]otlpResourceSpans{{
+		Resource:   otlpResource{otlpAttributes(map[string]interface{}{"service.name": serviceName})},
+		ScopeSpans: []otlpScopeSpans{{otlpScope{"hamustro"}, encoded}}}}})
+}
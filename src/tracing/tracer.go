@@ -0,0 +1,144 @@
+package tracing
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Collects the finished spans and exports them in batches
+// with OTLP/HTTP (JSON encoding) to `{endpoint}/v1/traces`
+type Tracer struct {
+	sync.Mutex
+	Endpoint    string
+	ServiceName string
+	BatchSize   int
+	Client      *http.Client
+	OnError     func(error)
+	spans       []*Span
+	quit        chan struct{}
+	wg          sync.WaitGroup
+}
+
+// Creates a new tracer
+func NewTracer(endpoint string, serviceName string, batchSize int) *Tracer {
+	return &Tracer{
+		Endpoint:    strings.TrimRight(endpoint, "/"),
+		ServiceName: serviceName,
+		BatchSize:   batchSize,
+		Client:      &http.Client{Timeout: 10 * time.Second},
+		quit:        make(chan struct{})}
+}
+
+// Starts a new span, the span is a root span if the parent is invalid
+func (t *Tracer) Start(name string, kind int, parent SpanContext) *Span {
+	return t.StartAt(name, kind, parent, time.Now())
+}
+
+// Starts a new span with a given start time
+func (t *Tracer) StartAt(name string, kind int, parent SpanContext, start time.Time) *Span {
+	if t == nil {
+		return nil
+	}
+	s := &Span{
+		Name:       name,
+		Kind:       kind,
+		Parent:     parent,
+		Start:      start,
+		Attributes: map[string]interface{}{},
+		tracer:     t}
+	if parent.IsValid() {
+		s.Context.TraceID = parent.TraceID
+	} else {
+		randomID(s.Context.TraceID[:])
+	}
+	randomID(s.Context.SpanID[:])
+	return s
+}
+
+// Adds a finished span to the batch, exports the batch when it's full
+func (t *Tracer) enqueue(s *Span) {
+	t.Lock()
+	t.spans = append(t.spans, s)
+	if len(t.spans) < t.BatchSize {
+		t.Unlock()
+		return
+	}
+	spans := t.spans
+	t.spans = nil
+	t.Unlock()
+
+	t.wg.Add(1)
+	go func() {
+		defer t.wg.Done()
+		t.report(t.Export(spans))
+	}()
+}
+
+// Reports an export error
+func (t *Tracer) report(err error) {
+	if err != nil && t.OnError != nil {
+		t.OnError(err)
+	}
+}
+
+// Exports every finished span
+func (t *Tracer) Flush() error {
+	if t == nil {
+		return nil
+	}
+	t.Lock()
+	spans := t.spans
+	t.spans = nil
+	t.Unlock()
+	if len(spans) == 0 {
+		return nil
+	}
+	return t.Export(spans)
+}
+
+// Exports the finished spans periodically
+func (t *Tracer) Run(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	go func() {
+		for {
+			select {
+			case <-ticker.C:
+				t.report(t.Flush())
+			case <-t.quit:
+				ticker.Stop()
+				return
+			}
+		}
+	}()
+}
+
+// Stops the periodic export and exports the remaining spans
+func (t *Tracer) Shutdown() error {
+	if t == nil {
+		return nil
+	}
+	close(t.quit)
+	t.wg.Wait()
+	return t.Flush()
+}
+
+// Sends the spans to the collector
+func (t *Tracer) Export(spans []*Span) error {
+	body, err := EncodeOTLP(t.ServiceName, spans)
+	if err != nil {
+		return err
+	}
+	resp, err := t.Client.Post(t.Endpoint+"/v1/traces", "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("Exporting %d spans is failed with status %d", len(spans), resp.StatusCode)
+	}
+	return nil
+}
@@ -0,0 +1,132 @@
+package tracing
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Kinds of the spans (same values as in OTLP)
+const SPAN_KIND_INTERNAL = 1
+const SPAN_KIND_SERVER = 2
+const SPAN_KIND_CLIENT = 3
+
+// Identifies a span within a trace
+type SpanContext struct {
+	TraceID [16]byte
+	SpanID  [8]byte
+}
+
+// Checks that the context is set
+func (c SpanContext) IsValid() bool {
+	return c.TraceID != [16]byte{} && c.SpanID != [8]byte{}
+}
+
+// Returns the trace ID in hex
+func (c SpanContext) TraceIDString() string {
+	return hex.EncodeToString(c.TraceID[:])
+}
+
+// Returns the span ID in hex
+func (c SpanContext) SpanIDString() string {
+	return hex.EncodeToString(c.SpanID[:])
+}
+
+// Returns the W3C `traceparent` header value
+func (c SpanContext) TraceParent() string {
+	return fmt.Sprintf("00-%s-%s-01", c.TraceIDString(), c.SpanIDString())
+}
+
+// Parses the W3C `traceparent` header, returns an invalid context
+// if the header is missing or malformed
+func ParseTraceParent(header string) SpanContext {
+	var c SpanContext
+	parts := strings.Split(strings.TrimSpace(header), "-")
+	if len(parts) != 4 || len(parts[0]) != 2 || len(parts[1]) != 32 || len(parts[2]) != 16 {
+		return SpanContext{}
+	}
+	if _, err := hex.Decode(c.TraceID[:], []byte(parts[1])); err != nil {
+		return SpanContext{}
+	}
+	if _, err := hex.Decode(c.SpanID[:], []byte(parts[2])); err != nil {
+		return SpanContext{}
+	}
+	return c
+}
+
+// Returns random bytes for the identifiers
+func randomID(b []byte) {
+	rand.Read(b)
+}
+
+// A single timed operation, every method is safe to call
+// on a nil span (when tracing is disabled)
+type Span struct {
+	sync.Mutex
+	Name       string
+	Kind       int
+	Context    SpanContext
+	Parent     SpanContext
+	Start      time.Time
+	End        time.Time
+	Attributes map[string]interface{}
+	Links      []SpanContext
+	Error      error
+	tracer     *Tracer
+}
+
+// Returns the span's context, it's invalid for a nil span
+func (s *Span) SpanContext() SpanContext {
+	if s == nil {
+		return SpanContext{}
+	}
+	return s.Context
+}
+
+// Creates a child span
+func (s *Span) Child(name string, kind int) *Span {
+	if s == nil {
+		return nil
+	}
+	return s.tracer.Start(name, kind, s.Context)
+}
+
+// Sets an attribute on the span
+func (s *Span) SetAttribute(key string, value interface{}) {
+	if s == nil {
+		return
+	}
+	s.Lock()
+	defer s.Unlock()
+	s.Attributes[key] = value
+}
+
+// Links the span to another one (e.g. batches to the requests)
+func (s *Span) AddLink(c SpanContext) {
+	if s == nil || !c.IsValid() {
+		return
+	}
+	s.Lock()
+	defer s.Unlock()
+	s.Links = append(s.Links, c)
+}
+
+// Finishes the span and hands it over to the exporter
+func (s *Span) Finish() {
+	s.FinishWithError(nil)
+}
+
+// Finishes the span with an error status
+func (s *Span) FinishWithError(err error) {
+	if s == nil {
+		return
+	}
+	s.Lock()
+	s.End = time.Now()
+	s.Error = err
+	s.Unlock()
+	s.tracer.enqueue(s)
+}
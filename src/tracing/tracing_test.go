@@ -0,0 +1,150 @@
+package tracing
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+// Local OTLP/HTTP collector stand-in that keeps the received spans
+type TestCollector struct {
+	sync.Mutex
+	Server *httptest.Server
+	Spans  []map[string]interface{}
+}
+
+// Starts a new collector stand-in
+func NewTestCollector(t *testing.T) *TestCollector {
+	c := &TestCollector{}
+	c.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/traces" || r.Header.Get("Content-Type") != "application/json" {
+			t.Errorf("Unexpected export request to %s", r.URL.Path)
+		}
+		body, _ := ioutil.ReadAll(r.Body)
+		var request struct {
+			ResourceSpans []struct {
+				ScopeSpans []struct {
+					Spans []map[string]interface{} `json:"spans"`
+				} `json:"scopeSpans"`
+			} `json:"resourceSpans"`
+		}
+		if err := json.Unmarshal(body, &request); err != nil {
+			t.Errorf("Export request is invalid: %s", err.Error())
+		}
+		c.Lock()
+		defer c.Unlock()
+		for _, rs := range request.ResourceSpans {
+			for _, ss := range rs.ScopeSpans {
+				c.Spans = append(c.Spans, ss.Spans...)
+			}
+		}
+	}))
+	return c
+}
+
+// Returns the received span by name
+func (c *TestCollector) Get(name string) map[string]interface{} {
+	c.Lock()
+	defer c.Unlock()
+	for _, s := range c.Spans {
+		if s["name"] == name {
+			return s
+		}
+	}
+	return nil
+}
+
+// Tests the W3C traceparent header parsing
+func TestFunctionParseTraceParent(t *testing.T) {
+	cases := []struct {
+		Header  string
+		IsValid bool
+	}{
+		{"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01", true},
+		{"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7", false},
+		{"00-4bf92f3577b34da6a3ce929d0e0e47zz-00f067aa0ba902b7-01", false},
+		{"00-00000000000000000000000000000000-0000000000000000-01", false},
+		{"", false}}
+
+	for _, c := range cases {
+		ctx := ParseTraceParent(c.Header)
+		if ctx.IsValid() != c.IsValid {
+			t.Errorf("Header %q validity should be %t", c.Header, c.IsValid)
+		}
+		if c.IsValid && ctx.TraceParent() != c.Header {
+			t.Errorf("Expected traceparent was %s but it was %s instead", c.Header, ctx.TraceParent())
+		}
+	}
+}
+
+// Tests that the disabled tracer is a no-op
+func TestNilTracer(t *testing.T) {
+	var tracer *Tracer
+	span := tracer.Start("noop", SPAN_KIND_SERVER, SpanContext{})
+	span.SetAttribute("key", "value")
+	span.AddLink(SpanContext{})
+	span.Child("child", SPAN_KIND_INTERNAL).Finish()
+	span.FinishWithError(fmt.Errorf("error"))
+	if span.SpanContext().IsValid() {
+		t.Errorf("Disabled tracer should not create valid spans")
+	}
+	if err := tracer.Shutdown(); err != nil {
+		t.Errorf("Shutting down the disabled tracer should not fail: %s", err.Error())
+	}
+}
+
+// Tests the export into the collector stand-in
+func TestTracerExport(t *testing.T) {
+	collector := NewTestCollector(t)
+	defer collector.Server.Close()
+
+	tracer := NewTracer(collector.Server.URL+"/", "hamustro-test", 100)
+	tracer.Run(time.Hour)
+
+	parent := ParseTraceParent("00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	request := tracer.Start("TrackHandler", SPAN_KIND_SERVER, parent)
+	request.SetAttribute("payload_count", 3)
+	request.Child("TrackHandler.decode", SPAN_KIND_INTERNAL).Finish()
+	request.Finish()
+
+	batch := tracer.Start("Worker.SaveBatch", SPAN_KIND_INTERNAL, SpanContext{})
+	batch.AddLink(request.SpanContext())
+	batch.FinishWithError(fmt.Errorf("upload failed"))
+
+	if err := tracer.Shutdown(); err != nil {
+		t.Fatalf("Exporting is failed: %s", err.Error())
+	}
+
+	if exp := 3; len(collector.Spans) != exp {
+		t.Fatalf("Expected number of spans was %d but it was %d instead", exp, len(collector.Spans))
+	}
+
+	t.Log("Request span continues the client's trace")
+	s := collector.Get("TrackHandler")
+	if s["traceId"] != parent.TraceIDString() || s["parentSpanId"] != parent.SpanIDString() {
+		t.Errorf("Request span should be the child of the client's span: %v", s)
+	}
+	if s["kind"] != float64(SPAN_KIND_SERVER) {
+		t.Errorf("Expected kind was %d but it was %v instead", SPAN_KIND_SERVER, s["kind"])
+	}
+
+	t.Log("Child span belongs to the request span")
+	if s := collector.Get("TrackHandler.decode"); s["parentSpanId"] != request.SpanContext().SpanIDString() {
+		t.Errorf("Decode span should be the child of the request span: %v", s)
+	}
+
+	t.Log("Batch span is linked to the request span")
+	s = collector.Get("Worker.SaveBatch")
+	links, _ := s["links"].([]interface{})
+	if len(links) != 1 || links[0].(map[string]interface{})["spanId"] != request.SpanContext().SpanIDString() {
+		t.Errorf("Batch span should be linked to the request span: %v", s)
+	}
+	if status := s["status"].(map[string]interface{}); status["code"] != float64(STATUS_ERROR) || status["message"] != "upload failed" {
+		t.Errorf("Batch span should have an error status: %v", status)
+	}
+}
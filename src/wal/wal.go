@@ -0,0 +1,322 @@
+package wal
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Define the fsync policies
+const SYNC_ALWAYS = "always"     // Every append is synced before the ack
+const SYNC_INTERVAL = "interval" // Synced periodically in the background
+const SYNC_NEVER = "never"       // Left to the operating system
+
+// Size of a record's header: length and CRC32 checksum
+const HEADER_SIZE = 8
+
+// Options of the write-ahead log
+type Options struct {
+	Dir          string
+	Sync         string
+	SyncInterval time.Duration
+	SegmentSize  int64
+}
+
+// Checks the fsync policy
+func IsValidSync(policy string) bool {
+	return policy == SYNC_ALWAYS || policy == SYNC_INTERVAL || policy == SYNC_NEVER
+}
+
+// A record read back from an unsaved segment
+type Record struct {
+	Segment uint64
+	Data    []byte
+}
+
+// Number of appended and acknowledged records of a segment
+type segment struct {
+	Appended int
+	Acked    int
+	Sealed   bool
+}
+
+// Append-only log split into segment files, a segment is removed
+// once it's sealed and every record within it is acknowledged
+type Log struct {
+	sync.Mutex
+	Options     *Options
+	segments    map[uint64]*segment
+	current     *os.File
+	currentID   uint64
+	currentSize int64
+	dirty       bool
+	quit        chan struct{}
+}
+
+// Returns the segment's filename
+func (l *Log) segmentPath(id uint64) string {
+	return filepath.Join(l.Options.Dir, fmt.Sprintf("%020d.wal", id))
+}
+
+// Opens the log and returns the records of the unsaved segments,
+// these segments are kept until the records are acknowledged
+func Open(options *Options) (*Log, []*Record, error) {
+	if err := os.MkdirAll(options.Dir, 0700); err != nil {
+		return nil, nil, err
+	}
+	l := &Log{Options: options, segments: map[uint64]*segment{}, quit: make(chan struct{})}
+
+	ids, err := l.listSegments()
+	if err != nil {
+		return nil, nil, err
+	}
+	records := []*Record{}
+	for _, id := range ids {
+		data, err := ReadSegment(l.segmentPath(id))
+		if err != nil {
+			return nil, nil, err
+		}
+		for _, d := range data {
+			records = append(records, &Record{id, d})
+		}
+		l.segments[id] = &segment{Appended: len(data), Sealed: true}
+		l.currentID = id
+		l.removeIfDone(id)
+	}
+
+	if err := l.openSegment(l.currentID + 1); err != nil {
+		return nil, nil, err
+	}
+	return l, records, nil
+}
+
+// Returns the IDs of the existing segments in order
+func (l *Log) listSegments() ([]uint64, error) {
+	files, err := ioutil.ReadDir(l.Options.Dir)
+	if err != nil {
+		return nil, err
+	}
+	ids := []uint64{}
+	for _, f := range files {
+		if !strings.HasSuffix(f.Name(), ".wal") {
+			continue
+		}
+		id, err := strconv.ParseUint(strings.TrimSuffix(f.Name(), ".wal"), 10, 64)
+		if err != nil {
+			continue
+		}
+		ids = append(ids, id)
+	}
+	sort.Sort(segmentIDs(ids))
+	return ids, nil
+}
+
+// Sortable list of segment IDs
+type segmentIDs []uint64
+
+func (s segmentIDs) Len() int           { return len(s) }
+func (s segmentIDs) Less(i, j int) bool { return s[i] < s[j] }
+func (s segmentIDs) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
+
+// Reads every valid record of a segment, the reading stops at the
+// first incomplete or corrupted record (e.g. torn write after a crash)
+func ReadSegment(path string) ([][]byte, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	r := bufio.NewReader(f)
+	records := [][]byte{}
+	header := make([]byte, HEADER_SIZE)
+	for {
+		if _, err := io.ReadFull(r, header); err != nil {
+			return records, nil
+		}
+		data := make([]byte, binary.BigEndian.Uint32(header[0:4]))
+		if _, err := io.ReadFull(r, data); err != nil {
+			return records, nil
+		}
+		if crc32.ChecksumIEEE(data) != binary.BigEndian.Uint32(header[4:8]) {
+			return records, nil
+		}
+		records = append(records, data)
+	}
+}
+
+// Creates a new segment file and makes it the current one
+func (l *Log) openSegment(id uint64) error {
+	f, err := os.OpenFile(l.segmentPath(id), os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+	l.current = f
+	l.currentID = id
+	l.currentSize = 0
+	l.segments[id] = &segment{}
+	return nil
+}
+
+// Seals the current segment and opens the next one
+func (l *Log) rotate() error {
+	if err := l.syncCurrent(); err != nil {
+		return err
+	}
+	if err := l.current.Close(); err != nil {
+		return err
+	}
+	id := l.currentID
+	l.segments[id].Sealed = true
+	if err := l.openSegment(id + 1); err != nil {
+		return err
+	}
+	l.removeIfDone(id)
+	return nil
+}
+
+// Removes the segment if it's sealed and every record is acknowledged
+func (l *Log) removeIfDone(id uint64) {
+	s, ok := l.segments[id]
+	if !ok || !s.Sealed || s.Acked < s.Appended {
+		return
+	}
+	os.Remove(l.segmentPath(id))
+	delete(l.segments, id)
+}
+
+// Appends the records into the current segment, every record of the
+// call is written into the same segment. Returns the segment's ID.
+func (l *Log) Append(records ...[]byte) (uint64, error) {
+	if l == nil {
+		return 0, nil
+	}
+	l.Lock()
+	defer l.Unlock()
+
+	if l.Options.SegmentSize > 0 && l.currentSize >= l.Options.SegmentSize {
+		if err := l.rotate(); err != nil {
+			return 0, err
+		}
+	}
+
+	size := 0
+	for _, r := range records {
+		size += HEADER_SIZE + len(r)
+	}
+	b := make([]byte, 0, size)
+	header := make([]byte, HEADER_SIZE)
+	for _, r := range records {
+		binary.BigEndian.PutUint32(header[0:4], uint32(len(r)))
+		binary.BigEndian.PutUint32(header[4:8], crc32.ChecksumIEEE(r))
+		b = append(b, header...)
+		b = append(b, r...)
+	}
+	n, err := l.current.Write(b)
+	l.currentSize += int64(n)
+	if err != nil {
+		return 0, err
+	}
+	l.segments[l.currentID].Appended += len(records)
+	l.dirty = true
+
+	if l.Options.Sync == SYNC_ALWAYS {
+		if err := l.syncCurrent(); err != nil {
+			return 0, err
+		}
+	}
+	return l.currentID, nil
+}
+
+// Acknowledges saved records of a segment
+func (l *Log) Ack(id uint64, n int) {
+	if l == nil || id == 0 || n == 0 {
+		return
+	}
+	l.Lock()
+	defer l.Unlock()
+	if s, ok := l.segments[id]; ok {
+		s.Acked += n
+		l.removeIfDone(id)
+	}
+}
+
+// Returns the number of segments that are not removed yet
+func (l *Log) GetSegmentCount() int {
+	if l == nil {
+		return 0
+	}
+	l.Lock()
+	defer l.Unlock()
+	return len(l.segments)
+}
+
+// Syncs the current segment if it was modified
+func (l *Log) syncCurrent() error {
+	if !l.dirty {
+		return nil
+	}
+	l.dirty = false
+	return l.current.Sync()
+}
+
+// Syncs the current segment
+func (l *Log) Sync() error {
+	if l == nil {
+		return nil
+	}
+	l.Lock()
+	defer l.Unlock()
+	return l.syncCurrent()
+}
+
+// Syncs the log periodically with the interval policy
+func (l *Log) Run(onError func(error)) {
+	if l.Options.Sync != SYNC_INTERVAL {
+		return
+	}
+	ticker := time.NewTicker(l.Options.SyncInterval)
+	go func() {
+		for {
+			select {
+			case <-ticker.C:
+				if err := l.Sync(); err != nil && onError != nil {
+					onError(err)
+				}
+			case <-l.quit:
+				ticker.Stop()
+				return
+			}
+		}
+	}()
+}
+
+// Syncs and closes the log, the current segment is removed
+// if every record is acknowledged
+func (l *Log) Close() error {
+	if l == nil {
+		return nil
+	}
+	close(l.quit)
+	l.Lock()
+	defer l.Unlock()
+	if err := l.syncCurrent(); err != nil {
+		return err
+	}
+	if err := l.current.Close(); err != nil {
+		return err
+	}
+	l.segments[l.currentID].Sealed = true
+	l.removeIfDone(l.currentID)
+	return nil
+}
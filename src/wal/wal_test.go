@@ -0,0 +1,94 @@
+package wal
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+// Returns the options of a temporary log
+func GetTestOptions(t *testing.T, segmentSize int64) *Options {
+	dir, err := ioutil.TempDir("", "hamustro-wal")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &Options{Dir: dir, Sync: SYNC_ALWAYS, SegmentSize: segmentSize}
+}
+
+// Tests the append, acknowledge and replay cycle
+func TestLogReplay(t *testing.T) {
+	options := GetTestOptions(t, 20)
+	defer os.RemoveAll(options.Dir)
+
+	l, records, err := Open(options)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 0 {
+		t.Errorf("New log should not have records")
+	}
+
+	t.Log("Appending records into multiple segments")
+	first, _ := l.Append([]byte("first-event-1"), []byte("first-event-2"))
+	second, _ := l.Append([]byte("second-event"))
+	third, _ := l.Append([]byte("third-event"))
+	if first == second || second == third {
+		t.Errorf("Segments should be rotated after %d bytes", options.SegmentSize)
+	}
+
+	t.Log("Fully acknowledged sealed segments are removed")
+	l.Ack(first, 2)
+	l.Ack(third, 1)
+	if exp := 2; l.GetSegmentCount() != exp {
+		t.Errorf("Expected number of segments was %d but it was %d instead", exp, l.GetSegmentCount())
+	}
+
+	t.Log("Reopening the log without closing (crash)")
+	l, records, err = Open(options)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if exp := 2; len(records) != exp {
+		t.Fatalf("Expected number of replayed records was %d but it was %d instead", exp, len(records))
+	}
+	if string(records[0].Data) != "second-event" || string(records[1].Data) != "third-event" {
+		t.Errorf("Unexpected replayed records: %s, %s", records[0].Data, records[1].Data)
+	}
+
+	t.Log("Acknowledging the replayed records removes their segments")
+	for _, r := range records {
+		l.Ack(r.Segment, 1)
+	}
+	if err := l.Close(); err != nil {
+		t.Fatal(err)
+	}
+	files, _ := ioutil.ReadDir(options.Dir)
+	if len(files) != 0 {
+		t.Errorf("Every segment should be removed but %d remained", len(files))
+	}
+}
+
+// Tests that the corrupted tail of a segment is ignored
+func TestFunctionReadSegmentWithTornWrite(t *testing.T) {
+	options := GetTestOptions(t, 0)
+	defer os.RemoveAll(options.Dir)
+
+	l, _, _ := Open(options)
+	segment, _ := l.Append([]byte("complete"), []byte("torn"))
+	path := l.segmentPath(segment)
+
+	t.Log("Cutting the last record in half")
+	info, _ := os.Stat(path)
+	os.Truncate(path, info.Size()-2)
+	if records, _ := ReadSegment(path); len(records) != 1 || string(records[0]) != "complete" {
+		t.Errorf("Only the complete record should be read but it was %d records", len(records))
+	}
+
+	t.Log("Flipping a byte of the first record")
+	data, _ := ioutil.ReadFile(path)
+	data[HEADER_SIZE] ^= 0xff
+	ioutil.WriteFile(path, data, 0600)
+	if records, _ := ReadSegment(path); len(records) != 0 {
+		t.Errorf("Corrupted record should not be read")
+	}
+}